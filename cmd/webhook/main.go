@@ -17,21 +17,33 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/golang/glog"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/logging"
 	netcache "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/tools"
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/userdefinedinjections"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/version"
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/webhook"
 )
 
@@ -50,9 +62,24 @@ func main() {
 	cert := flag.String("tls-cert-file", "cert.pem", "File containing the default x509 Certificate for HTTPS.")
 	key := flag.String("tls-private-key-file", "key.pem", "File containing the default x509 private key matching --tls-cert-file.")
 	insecure := flag.Bool("insecure", false, "Disable adding client CA to server TLS endpoint --insecure")
+	tlsMinVersion := flag.String("tls-min-version", webhook.DefaultTLSMinVersion, "Minimal TLS version accepted by the webhook server, either 1.2 or 1.3.")
+	tlsCipherSuites := flag.String("tls-cipher-suites", "", "Comma separated TLS 1.2 cipher suites accepted by the webhook server, secure ECDHE AES GCM cipher suites when empty.")
 	flag.Var(&clientCAPaths, "client-ca", "File containing client CA. This flag is repeatable if more than one client CA needs to be added to server")
 	healthCheckPort := flag.Int("health-check-port", 8444, "The port to use for health check monitoring")
+	adminPort := flag.Int("admin-port", 0, "The port serving admin endpoints over TLS with client CA authentication, disabled when 0.")
 	enableHTTP2 := flag.Bool("enable-http2", false, "If HTTP/2 should be enabled for the webhook server.")
+	logFormat := flag.String("log-format", logging.FormatGlog, "Format of the webhook logs, either glog or json.")
+	nadCacheNamespaces := flag.String("nad-cache-namespaces", "", "Comma separated namespaces whose net-attach-defs are cached, all namespaces when empty.")
+	nadCacheResyncPeriod := flag.Duration("nad-cache-resync-period", 0, "Period of full resync of net-attach-def cache, resync is disabled when 0.")
+	shutdownGracePeriod := flag.Duration("shutdown-grace-period", 20*time.Second, "Time given to in-flight requests to complete on SIGTERM before the webhook server is stopped.")
+	tracingEndpoint := flag.String("tracing-endpoint", "", "host:port of the OTLP/HTTP collector receiving traces of admission requests, tracing is disabled when empty.")
+	preflight := flag.Bool("preflight", false, "Check the configuration, API server and net-attach-def CRD, report the results and exit, non-zero when any check fails.")
+	tracingInsecure := flag.Bool("tracing-insecure", false, "Export traces to the OTLP/HTTP collector without TLS.")
+	dumpConfig := flag.Bool("dump-config", false, "Print the effective configuration as JSON and exit.")
+	kubeAPIQPS := flag.Float64("kube-api-qps", webhook.DefaultClientQPS, "Queries per second the API client is allowed to send to the API server on average.")
+	kubeAPIBurst := flag.Int("kube-api-burst", webhook.DefaultClientBurst, "Queries the API client is allowed to send to the API server at once above its QPS.")
+	kubeAPIProtobuf := flag.Bool("kube-api-protobuf", false, "Request built-in resources from the API server as protobuf instead of JSON.")
+	printVersion := flag.Bool("version", false, "Print the version and build information and exit.")
 
 	// do initialization of control switches flags
 	controlSwitches := controlswitches.SetupControlSwitchesFlags()
@@ -60,10 +87,61 @@ func main() {
 	// at the end when all flags are declared parse it
 	flag.Parse()
 
+	if *printVersion {
+		fmt.Println(version.Get())
+		return
+	}
+
+	logger, err := logging.New(*logFormat)
+	if err != nil {
+		glog.Fatalf("invalid log format: %v", err)
+	}
+	webhook.SetLogger(logger)
+
 	// initialize all control switches structures
 	controlSwitches.InitControlSwitches()
 	glog.Infof("controlSwitches: %+v", *controlSwitches)
 
+	if namespace = os.Getenv("NAMESPACE"); namespace == "" {
+		namespace = "kube-system"
+	}
+
+	cacheNamespaces, err := parseNamespaces(*nadCacheNamespaces)
+	if err != nil {
+		glog.Fatalf("invalid net-attach-def cache namespaces: %v", err)
+	}
+	if *nadCacheResyncPeriod < 0 {
+		glog.Fatalf("net-attach-def cache resync period must not be negative")
+	}
+
+	clientOptions := webhook.ClientOptions{QPS: float32(*kubeAPIQPS), Burst: *kubeAPIBurst, Protobuf: *kubeAPIProtobuf}
+	if err := clientOptions.Validate(); err != nil {
+		glog.Fatalf("invalid API client options: %v", err)
+	}
+
+	/* configuration is printed before it is validated, so the invalid one can be inspected as well */
+	if *dumpConfig {
+		if err := writeConfig(os.Stdout, controlSwitches, cacheNamespaces, *nadCacheResyncPeriod); err != nil {
+			glog.Fatalf("error dumping configuration: %v", err)
+		}
+		return
+	}
+
+	/* all checks are reported at once, instead of failing on the first invalid argument */
+	if *preflight {
+		webhook.SetControlSwitches(controlSwitches)
+		webhook.SetupInClusterClient(clientOptions)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		report, passed := webhook.PreflightReport(webhook.Preflight(ctx, namespace, controlSwitchesConfigMap))
+		cancel()
+		fmt.Print(report)
+		if !passed {
+			glog.Flush()
+			os.Exit(1)
+		}
+		return
+	}
+
 	if !isValidPort(*port) {
 		glog.Fatalf("invalid port number. Choose between 1024 and 65535")
 	}
@@ -72,6 +150,22 @@ func main() {
 		glog.Fatalf("Input argument for resourceName cannot be empty.")
 	}
 
+	if err := controlSwitches.ValidateControlSwitches(); err != nil {
+		glog.Fatalf("invalid control switches: %v", err)
+	}
+
+	minVersion, err := webhook.ParseTLSMinVersion(*tlsMinVersion)
+	if err != nil {
+		glog.Fatalf("invalid TLS minimal version: %v", err)
+	}
+	cipherSuites, err := webhook.ParseTLSCipherSuites(*tlsCipherSuites)
+	if err != nil {
+		glog.Fatalf("invalid TLS cipher suites: %v", err)
+	}
+	if minVersion == tls.VersionTLS13 && *tlsCipherSuites != "" {
+		glog.Warningf("TLS cipher suites are not used, cipher suites of TLS 1.3 are not configurable")
+	}
+
 	if *address == "" || *cert == "" || *key == "" {
 		glog.Fatalf("input argument(s) not defined correctly")
 	}
@@ -80,10 +174,6 @@ func main() {
 		clientCAPaths = append(clientCAPaths, defaultClientCa)
 	}
 
-	if namespace = os.Getenv("NAMESPACE"); namespace == "" {
-		namespace = "kube-system"
-	}
-
 	if !isValidPort(*healthCheckPort) {
 		glog.Fatalf("Invalid health check port number. Choose between 1024 and 65535")
 	} else if *healthCheckPort == *port {
@@ -103,7 +193,20 @@ func main() {
 		}()
 	}
 
-	glog.Infof("starting mutating admission controller for network resources injection")
+	if *adminPort != 0 && (!isValidPort(*adminPort) || *adminPort == *port || *adminPort == *healthCheckPort) {
+		glog.Fatalf("Invalid admin port number. Choose between 1024 and 65535, different from port and health check port")
+	}
+
+	glog.Infof("starting mutating admission controller for network resources injection, %s", version.Get())
+
+	shutdownTracing := func(context.Context) error { return nil }
+	if *tracingEndpoint != "" {
+		shutdownTracing, err = webhook.SetupTracing(context.Background(), *tracingEndpoint, *tracingInsecure)
+		if err != nil {
+			glog.Fatalf("error setting up tracing: %v", err)
+		}
+		glog.Infof("exporting traces to %s", *tracingEndpoint)
+	}
 
 	keyPair, err := webhook.NewTlsKeypairReloader(*cert, *key)
 	if err != nil {
@@ -116,73 +219,135 @@ func main() {
 	}
 
 	/* init API client */
-	clientset := webhook.SetupInClusterClient()
+	clientset := webhook.SetupInClusterClient(clientOptions)
 
 	// initialize webhook with controlSwitches
 	webhook.SetControlSwitches(controlSwitches)
 
-	//initialize webhook with cache
-	netAnnotationCache := netcache.Create()
+	//initialize webhook with cache, net-attach-defs out of the cached namespaces are retrieved from API server
+	netAnnotationCache := netcache.Create(cacheNamespaces, *nadCacheResyncPeriod,
+		controlSwitches.GetAnnotationDomain()+"/"+webhook.NadAliasAnnotation)
 	netAnnotationCache.Start()
 	webhook.SetNetAttachDefCache(netAnnotationCache)
 
+	ownerCache := netcache.CreateOwnerCache(clientset, controlSwitches.GetOwnerKinds())
+	ownerCache.Start()
+	webhook.SetOwnerCache(ownerCache)
+
+	/* events are emitted only when denial events feature is enabled, it can be toggled in runtime */
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	webhook.SetEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "network-resources-injector"}))
+
+	var namespaceCache netcache.NamespaceCacheService
+	if controlSwitches.GetNamespaceLabel() != "" {
+		namespaceCache = netcache.CreateNamespaceCache(clientset)
+		namespaceCache.Start()
+		webhook.SetNamespaceCache(namespaceCache)
+	}
+
 	userInjections := userdefinedinjections.CreateUserInjectionsStructure()
 	webhook.SetUserInjectionStructure(userInjections)
 
-	go func() {
-		/* register handlers */
-		var httpServer *http.Server
+	/* config map changes are applied as soon as they are watched, periodic polling covers missed watch events */
+	configMapWatcher := netcache.CreateConfigMapWatcher(clientset, namespace, controlSwitchesConfigMap)
+	configMapWatcher.Start()
 
-		http.HandleFunc("/mutate", func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path != "/mutate" {
-				http.NotFound(w, r)
-				return
-			}
-			if r.Method != http.MethodPost {
-				http.Error(w, "Invalid HTTP verb requested", 405)
-				return
-			}
-			webhook.MutateHandler(w, r)
-		})
-
-		/* start serving */
-		httpServer = &http.Server{
-			Addr:              fmt.Sprintf("%s:%d", *address, *port),
-			ReadTimeout:       5 * time.Second,
-			WriteTimeout:      10 * time.Second,
-			MaxHeaderBytes:    1 << 20,
-			ReadHeaderTimeout: 1 * time.Second,
-			TLSConfig: &tls.Config{
-				ClientAuth:               webhook.GetClientAuth(*insecure),
-				MinVersion:               tls.VersionTLS12,
-				CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384},
-				ClientCAs:                clientCaPool.GetCertPool(),
-				PreferServerCipherSuites: true,
-				InsecureSkipVerify:       false,
-				CipherSuites: []uint16{
-					// tls 1.2
-					tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-					tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-					tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-					tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-					// tls 1.3 configuration not supported
-				},
-				GetCertificate: keyPair.GetCertificateFunc(),
-			},
-			// CVE-2023-39325 https://github.com/golang/go/issues/63417
-			TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+	/* register handlers */
+	http.HandleFunc("/mutate", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mutate" {
+			http.NotFound(w, r)
+			return
 		}
-
-		if *enableHTTP2 {
-			httpServer.TLSNextProto = nil
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid HTTP verb requested", 405)
+			return
+		}
+		webhook.MutateHandler(w, r)
+	})
+	/* served on the webhook port, so it is available only to clients trusted by the client CA */
+	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid HTTP verb requested", 405)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := writeConfig(w, controlSwitches, cacheNamespaces, *nadCacheResyncPeriod); err != nil {
+			glog.Errorf("error writing configuration: %v", err)
+		}
+	})
+	http.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid HTTP verb requested", 405)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(version.Get()); err != nil {
+			glog.Errorf("error writing version: %v", err)
 		}
+	})
+
+	/* start serving */
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", *address, *port),
+		ReadTimeout:       5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		ReadHeaderTimeout: 1 * time.Second,
+		TLSConfig: &tls.Config{
+			ClientAuth:               webhook.GetClientAuth(*insecure),
+			MinVersion:               minVersion,
+			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384},
+			ClientCAs:                clientCaPool.GetCertPool(),
+			PreferServerCipherSuites: true,
+			InsecureSkipVerify:       false,
+			CipherSuites:             cipherSuites,
+			GetCertificate:           keyPair.GetCertificateFunc(),
+		},
+		// CVE-2023-39325 https://github.com/golang/go/issues/63417
+		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+	}
+
+	if *enableHTTP2 {
+		httpServer.TLSNextProto = nil
+	}
 
+	go func() {
 		err := httpServer.ListenAndServeTLS("", "")
-		if err != nil {
+		if err != nil && err != http.ErrServerClosed {
 			glog.Fatalf("error starting web server: %v", err)
 		}
 	}()
 
+	/* admin endpoints are served with the TLS configuration of the webhook server, only resync modifies state */
+	var adminServer *http.Server
+	if *adminPort != 0 {
+		adminMux := http.NewServeMux()
+		adminMux.HandleFunc("/cache/net-attach-defs", webhook.CacheHandler)
+		adminMux.HandleFunc("/cache/net-attach-defs/resync", webhook.CacheResyncHandler)
+		adminMux.HandleFunc("/metrics", webhook.MetricsHandler)
+		adminServer = &http.Server{
+			Addr:              fmt.Sprintf("%s:%d", *address, *adminPort),
+			Handler:           adminMux,
+			ReadTimeout:       5 * time.Second,
+			WriteTimeout:      10 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ReadHeaderTimeout: 1 * time.Second,
+			TLSConfig:         httpServer.TLSConfig.Clone(),
+			TLSNextProto:      make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
+		}
+		go func() {
+			err := adminServer.ListenAndServeTLS("", "")
+			if err != nil && err != http.ErrServerClosed {
+				glog.Fatalf("error starting admin server: %v", err)
+			}
+		}()
+	}
+
+	/* stop gracefully on termination, letting in-flight requests complete */
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
 	/* watch the cert file and restart http sever if the file updated. */
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -226,6 +391,36 @@ func main() {
 				continue
 			}
 			glog.Infof("watcher error: %v", err)
+		case sig := <-stop:
+			glog.Infof("received %s signal, shutting down webhook server", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), *shutdownGracePeriod)
+			if err := httpServer.Shutdown(ctx); err != nil {
+				glog.Warningf("webhook server did not shut down gracefully: %v", err)
+			}
+			if adminServer != nil {
+				if err := adminServer.Shutdown(ctx); err != nil {
+					glog.Warningf("admin server did not shut down gracefully: %v", err)
+				}
+			}
+			/* spans of the completed requests are flushed within the remaining grace period */
+			if err := shutdownTracing(ctx); err != nil {
+				glog.Warningf("traces were not flushed: %v", err)
+			}
+			cancel()
+
+			netAnnotationCache.Stop()
+			ownerCache.Stop()
+			configMapWatcher.Stop()
+			eventBroadcaster.Shutdown()
+			if namespaceCache != nil {
+				namespaceCache.Stop()
+			}
+			glog.Infof("webhook server stopped")
+			glog.Flush()
+			return
+		case cm := <-configMapWatcher.Updates():
+			controlSwitches.ProcessControlSwitchesConfigMap(cm)
+			userInjections.SetUserDefinedInjections(cm)
 		case <-time.After(30 * time.Second):
 			cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(
 				context.Background(), controlSwitchesConfigMap, metav1.GetOptions{})
@@ -240,9 +435,42 @@ func main() {
 			userInjections.SetUserDefinedInjections(cm)
 		}
 	}
+}
+
+// effectiveConfig is the configuration of the webhook reported by --dump-config and /config endpoint
+type effectiveConfig struct {
+	ControlSwitches controlswitches.Config `json:"controlSwitches"`
+	NadCache        nadCacheConfig         `json:"nadCache"`
+}
+
+type nadCacheConfig struct {
+	Namespaces   []string `json:"namespaces"`
+	ResyncPeriod string   `json:"resyncPeriod"`
+}
 
-	// TODO: find a way to stop cache, should we run the above block in a go routine and make main module
-	// to respond to terminate singal ?
+// writeConfig writes the effective configuration as indented JSON
+func writeConfig(w io.Writer, controlSwitches *controlswitches.ControlSwitches, cacheNamespaces []string, resyncPeriod time.Duration) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(effectiveConfig{
+		ControlSwitches: controlSwitches.GetConfig(),
+		NadCache:        nadCacheConfig{Namespaces: cacheNamespaces, ResyncPeriod: resyncPeriod.String()},
+	})
+}
+
+// parseNamespaces returns namespaces of the comma separated list, empty list when none is given
+func parseNamespaces(list string) ([]string, error) {
+	var namespaces []string
+	for _, namespace := range strings.Split(list, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace '%s': %s", namespace, strings.Join(errs, ", "))
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, nil
 }
 
 func isValidPort(port int) bool {
@@ -18,10 +18,21 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/golang/glog"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
 )
@@ -34,60 +45,1360 @@ const (
 	enableHugePageDownAPIKey = "enableHugePageDownApi"
 	// enableHonorExistingResourcesKey feature name
 	enableHonorExistingResourcesKey = "enableHonorExistingResources"
+	// injectIntoInitContainersKey feature name
+	injectIntoInitContainersKey = "injectIntoInitContainers"
+	// enableResourceNameOverrideKey feature name
+	enableResourceNameOverrideKey = "enableResourceNameOverride"
+	// enableWorkloadControllersKey feature name
+	enableWorkloadControllersKey = "enableWorkloadControllers"
+	// enableInjectedResourcesAnnotationKey feature name
+	enableInjectedResourcesAnnotationKey = "enableInjectedResourcesAnnotation"
+	// enableSkipReasonWarningsKey feature name
+	enableSkipReasonWarningsKey = "enableSkipReasonWarnings"
+	// enableTopologyHintsKey feature name
+	enableTopologyHintsKey = "enableTopologyHints"
+	// enableNetworkDeduplicationKey feature name
+	enableNetworkDeduplicationKey = "enableNetworkDeduplication"
+	// denyUnresolvedNamespaceKey feature name
+	denyUnresolvedNamespaceKey = "denyUnresolvedNamespace"
+	// enableDenialEventsKey feature name
+	enableDenialEventsKey = "enableDenialEvents"
+	// injectIntoEphemeralContainersKey feature name
+	injectIntoEphemeralContainersKey = "injectIntoEphemeralContainers"
+	// enableStrategicMergePatchKey feature name
+	enableStrategicMergePatchKey = "enableStrategicMergePatch"
+	// enableSourceNadsAnnotationKey feature name
+	enableSourceNadsAnnotationKey = "enableSourceNadsAnnotation"
+	// enableValidatePatchKey feature name
+	enableValidatePatchKey = "enableValidatePatch"
+	// enableResourceClaimsKey feature name
+	enableResourceClaimsKey = "enableResourceClaims"
+	// injectDownwardAPIVolumeKey feature name
+	injectDownwardAPIVolumeKey = "injectDownwardAPIVolume"
+	// enableConfigResourceNameKey feature name
+	enableConfigResourceNameKey = "enableConfigResourceName"
+	// enableIdempotencyKey feature name
+	enableIdempotencyKey = "enableIdempotency"
+	// enableNodeAffinityAnnotationKey feature name
+	enableNodeAffinityAnnotationKey = "enableNodeAffinityAnnotation"
+	// skipUnresolvedNamespaceKey feature name
+	skipUnresolvedNamespaceKey = "skipUnresolvedNamespace"
+	// requireOptInKey feature name
+	requireOptInKey = "requireOptIn"
+	// downwardAPIMountResourceContainersOnlyKey feature name
+	downwardAPIMountResourceContainersOnlyKey = "downwardAPIMountResourceContainersOnly"
+	// ownerNetworkAnnotationKey feature name
+	ownerNetworkAnnotationKey = "ownerNetworkAnnotation"
+	// injectHugepageVolumeKey feature name
+	injectHugepageVolumeKey = "injectHugepageVolume"
+	// removeResourcesKey feature name
+	removeResourcesKey = "removeResources"
+	// podLevelResourcesKey feature name
+	podLevelResourcesKey = "podLevelResources"
+	// injectIntoAllContainersKey feature name
+	injectIntoAllContainersKey = "injectIntoAllContainers"
+	// denyUserDefinedInjectionFailureKey feature name
+	denyUserDefinedInjectionFailureKey = "denyUserDefinedInjectionFailure"
+	// bestEffortInjectionKey feature name
+	bestEffortInjectionKey = "bestEffortInjection"
+	// createResourcesIfAbsentKey feature name
+	createResourcesIfAbsentKey = "createResourcesIfAbsent"
+	// resolveNetworkAliasesKey feature name
+	resolveNetworkAliasesKey = "resolveNetworkAliases"
 )
 
+const (
+	// ExtendedResourcePatchModeBoth - inject resource into both requests and limits
+	ExtendedResourcePatchModeBoth = "both"
+	// ExtendedResourcePatchModeLimitsOnly - inject resource into limits only, API server defaults requests to limits
+	ExtendedResourcePatchModeLimitsOnly = "limits-only"
+	// ExtendedResourcePatchModeRequestsOnly - inject resource into requests only
+	ExtendedResourcePatchModeRequestsOnly = "requests-only"
+)
+
+const (
+	// DefaultRequestBodyLimit - maximal size of AdmissionReview request body read into memory
+	DefaultRequestBodyLimit int64 = 1 << 20
+	// DefaultStreamedRequestBodyLimit - maximal size of AdmissionReview request body decoded as a stream
+	DefaultStreamedRequestBodyLimit int64 = 4 << 20
+)
+
+const (
+	// DefaultNadLookupRetries - number of retries of net-attach-def lookup failed with transient API server error
+	DefaultNadLookupRetries = 3
+	// DefaultNadLookupRetryDelay - delay before the first retry, doubled with every next retry
+	DefaultNadLookupRetryDelay = 100 * time.Millisecond
+	// DefaultLookupRateBurst - number of API server lookups per source allowed at once when lookups are throttled
+	DefaultLookupRateBurst = 10
+	// DefaultLookupWarningFraction - fraction of the webhook timeout spent in net-attach-def lookups which is logged
+	DefaultLookupWarningFraction = 0.5
+)
+
+const (
+	// DisallowedResourceSkip - network requesting resource outside of allowed prefixes is treated as network without resource
+	DisallowedResourceSkip = "skip"
+	// DisallowedResourceDeny - pod using network requesting resource outside of allowed prefixes is denied
+	DisallowedResourceDeny = "deny"
+)
+
+const (
+	// ResourceCapClamp - resource requested more times than allowed is requested the maximal allowed count
+	ResourceCapClamp = "clamp"
+	// ResourceCapDeny - deny pod requesting resource more times than allowed
+	ResourceCapDeny = "deny"
+)
+
+const (
+	// ServerErrorDeny - deny pod which cannot be processed because of the webhook or API server failure
+	ServerErrorDeny = "deny"
+	// ServerErrorFail - fail the admission call with server error, so the webhook failure policy is applied
+	ServerErrorFail = "fail"
+)
+
+const (
+	// PartialResourcesComplete - add the missing request or limit of resource set only partially by the user
+	PartialResourcesComplete = "complete"
+	// PartialResourcesDeny - deny pod setting only request or only limit of resource requested by its networks
+	PartialResourcesDeny = "deny"
+)
+
+const (
+	// NodeSelectorConflictOverride - label of net-attach-def node selector replaces the value set by the pod
+	NodeSelectorConflictOverride = "override"
+	// NodeSelectorConflictPreserve - keep value of the label set by the pod
+	NodeSelectorConflictPreserve = "preserve"
+	// NodeSelectorConflictDeny - deny pod setting label of net-attach-def node selector to other value
+	NodeSelectorConflictDeny = "deny"
+)
+
+const (
+	// NoContainersAllow - admit pod without containers unchanged
+	NoContainersAllow = "allow"
+	// NoContainersDeny - deny pod without containers
+	NoContainersDeny = "deny"
+)
+
+const (
+	// DefaultNetworksDeny - deny pod whose default network annotation selects more networks
+	DefaultNetworksDeny = "deny"
+	// DefaultNetworksFirst - inject resources of the first network selected by default network annotation only
+	DefaultNetworksFirst = "first"
+)
+
+// DefaultFallbackNamespace - namespace of pod used when it cannot be determined from the request
+const DefaultFallbackNamespace = "default"
+
+// DefaultAnnotationDomain - domain prefix of annotations owned by the injector
+const DefaultAnnotationDomain = "network-resources-injector.io"
+
+// SkipFieldSelectorFields - pod fields supported by the skip field selector
+var SkipFieldSelectorFields = []string{"spec.restartPolicy", "spec.schedulerName", "spec.serviceAccountName",
+	"spec.priorityClassName", "spec.runtimeClassName", "spec.hostNetwork"}
+
+// SupportedOwnerKinds - kinds of pod owners namespace of the pod can be resolved from
+var SupportedOwnerKinds = []string{"ReplicaSet", "DaemonSet", "StatefulSet", "ReplicationController", "Job"}
+
+const (
+	// HonorResourcesPolicySum - inject resources on top of the ones already requested by the target container
+	HonorResourcesPolicySum = "sum"
+	// HonorResourcesPolicyMax - target container requests the larger of the injected and already requested resources
+	HonorResourcesPolicyMax = "max"
+	// HonorResourcesPolicyTopUp - top up the target container only when all containers request less than injected
+	HonorResourcesPolicyTopUp = "topup"
+)
+
+const (
+	// PodNetInfoConflictRename - inject Downward API volume under different name when pod defines other podnetinfo volume
+	PodNetInfoConflictRename = "rename"
+	// PodNetInfoConflictDeny - deny pod defining podnetinfo volume which is not a Downward API volume
+	PodNetInfoConflictDeny = "deny"
+	// PodNetInfoConflictSkip - do not inject Downward API volume when pod defines other podnetinfo volume
+	PodNetInfoConflictSkip = "skip"
+	// DefaultDownwardAPIVolumeName - name of the injected Downward API volume
+	DefaultDownwardAPIVolumeName = "podnetinfo"
+	// DefaultHugepageMountPath - path at which the injected hugepage volume is mounted into containers
+	DefaultHugepageMountPath = "/hugepages"
+	// DefaultTokenMountPath - path at which the injected service account token volume is mounted into containers
+	DefaultTokenMountPath = "/var/run/secrets/cni"
+	// DefaultTokenExpiration - requested lifetime of the injected service account tokens
+	DefaultTokenExpiration = time.Hour
+	// MinTokenExpiration - shortest lifetime of service account token API server accepts
+	MinTokenExpiration = 10 * time.Minute
+	// DefaultNadNotFoundMessage - template of the message denying pod selecting net-attach-def which does not exist
+	DefaultNadNotFoundMessage = "could not find network attachment definition '{{.Namespace}}/{{.Name}}': {{.Error}}"
+	// renamedDownwardAPIVolumeSuffix - suffix of the Downward API volume name used on conflict with pod volume
+	renamedDownwardAPIVolumeSuffix = "-nri"
+)
+
+// CompanionResource - resource requested in lockstep with another resource
+type CompanionResource struct {
+	// ResourceName - name of the companion resource
+	ResourceName string `json:"resourceName"`
+	// Ratio - number of companion resources requested per one requested resource
+	Ratio int64 `json:"ratio"`
+}
+
+const (
+	// RuntimeClassSkipDownwardAPIVolume - option of runtime class override, Downward API volume is not injected
+	RuntimeClassSkipDownwardAPIVolume = "skip-downward-api-volume"
+	// RuntimeClassTargetContainers - option of runtime class override, followed by =<regex> of containers resources
+	// are injected into instead of the first container
+	RuntimeClassTargetContainers = "target-containers"
+)
+
+// RuntimeClassOverride - injection behavior of pods running with a runtime class
+type RuntimeClassOverride struct {
+	// SkipDownwardAPIVolume - Downward API volume is not injected into pods of the runtime class
+	SkipDownwardAPIVolume bool `json:"skipDownwardAPIVolume"`
+	// TargetContainers - regular expression matching whole name of the container resources of networks not targeting
+	// containers by themselves are injected into, the first container when empty
+	TargetContainers string `json:"targetContainers,omitempty"`
+}
+
+// LabelSelectorNetwork - network implicitly selected by pods matching the label selector
+type LabelSelectorNetwork struct {
+	// Selector - label selector of pods selecting the network
+	Selector string `json:"selector"`
+	// Network - [namespace/]network selected by the pods
+	Network  string `json:"network"`
+	selector labels.Selector
+}
+
+// Config - effective configuration of the control switches, durations are formatted as Go duration strings
+type Config struct {
+	Features                  map[string]bool                 `json:"features"`
+	ResourceNameKeys          []string                        `json:"resourceNameKeys"`
+	NetworkAnnotationKeys     []string                        `json:"networkAnnotationKeys"`
+	AnnotationDomain          string                          `json:"annotationDomain"`
+	ExtendedResourcePatchMode string                          `json:"extendedResourcePatchMode"`
+	AllowedCNITypes           []string                        `json:"allowedCNITypes"`
+	AllowedResourcePrefixes   []string                        `json:"allowedResourcePrefixes"`
+	DisallowedResourceAction  string                          `json:"disallowedResourceAction"`
+	PartialResourcesAction    string                          `json:"partialResourcesAction"`
+	NodeSelectorConflict      string                          `json:"nodeSelectorConflict"`
+	NoContainersAction        string                          `json:"noContainersAction"`
+	DefaultNetworksAction     string                          `json:"defaultNetworksAction"`
+	MaxResourceCount          int64                           `json:"maxResourceCount"`
+	ResourceCapAction         string                          `json:"resourceCapAction"`
+	ServerErrorAction         string                          `json:"serverErrorAction"`
+	SkippedOwners             []string                        `json:"skippedOwners"`
+	IgnoredNamespaces         []string                        `json:"ignoredNamespaces"`
+	OwnerKinds                []string                        `json:"ownerKinds"`
+	SkipFieldSelector         string                          `json:"skipFieldSelector"`
+	VirtualNodeSelector       string                          `json:"virtualNodeSelector"`
+	VirtualNodeTolerations    []corev1.Toleration             `json:"virtualNodeTolerations"`
+	VirtualNodePrefixes       []string                        `json:"virtualNodePrefixes"`
+	NamespaceLabel            string                          `json:"namespaceLabel"`
+	FallbackNamespace         string                          `json:"fallbackNamespace"`
+	PodNetInfoConflict        string                          `json:"podNetInfoConflict"`
+	DownwardAPIVolumeName     string                          `json:"downwardAPIVolumeName"`
+	DownwardAPIMountPath      string                          `json:"downwardAPIMountPath"`
+	WindowsDownwardAPIPath    string                          `json:"windowsDownwardAPIPath"`
+	HugepageMountPath         string                          `json:"hugepageMountPath"`
+	TokenMountPath            string                          `json:"tokenMountPath"`
+	TokenExpiration           string                          `json:"tokenExpiration"`
+	NadNotFoundMessage        string                          `json:"nadNotFoundMessage"`
+	StreamRequestBody         bool                            `json:"streamRequestBody"`
+	RequestBodyLimit          int64                           `json:"requestBodyLimit"`
+	NadLookupRetries          int                             `json:"nadLookupRetries"`
+	NadLookupRetryDelay       string                          `json:"nadLookupRetryDelay"`
+	LookupTimeout             string                          `json:"lookupTimeout"`
+	LookupRateLimit           float64                         `json:"lookupRateLimit"`
+	LookupRateBurst           int                             `json:"lookupRateBurst"`
+	LookupWarningFraction     float64                         `json:"lookupWarningFraction"`
+	MaxConcurrentAdmissions   int                             `json:"maxConcurrentAdmissions"`
+	AdmissionQueueTimeout     string                          `json:"admissionQueueTimeout"`
+	CompanionResources        map[string][]CompanionResource  `json:"companionResources"`
+	ResourceQuantityFormat    string                          `json:"resourceQuantityFormat"`
+	ResourceClaimNetworks     map[string]string               `json:"resourceClaimNetworks"`
+	LabelSelectorNetworks     []LabelSelectorNetwork          `json:"labelSelectorNetworks"`
+	RuntimeClassOverrides     map[string]RuntimeClassOverride `json:"runtimeClassOverrides"`
+	TargetContainerImages     []string                        `json:"targetContainerImages"`
+	Tolerations               []corev1.Toleration             `json:"tolerations"`
+	InjectionFinalizer        string                          `json:"injectionFinalizer"`
+	HonorResourcesPolicy      string                          `json:"honorResourcesPolicy"`
+}
+
 // controlSwitchesStates - depicts possible feature states
 type controlSwitchesStates struct {
 	active  bool
 	initial bool
 }
 
-// setActiveToInitialState - set active state to the initial state set during initialization
-func (state *controlSwitchesStates) setActiveToInitialState() {
-	state.active = state.initial
-}
+// setActiveToInitialState - set active state to the initial state set during initialization
+func (state *controlSwitchesStates) setActiveToInitialState() {
+	state.active = state.initial
+}
+
+// setActiveState - set active state to the passed value
+func (state *controlSwitchesStates) setActiveState(value bool) {
+	state.active = value
+}
+
+type ControlSwitches struct {
+	// pointers to command line arguments
+	injectHugepageDownAPI         *bool
+	resourceNameKeysFlag          *string
+	resourcesHonorFlag            *bool
+	injectIntoInitContainers      *bool
+	resourceNameOverrideFlag      *bool
+	workloadControllersFlag       *bool
+	injectedResourcesAnnotFlag    *bool
+	skipReasonWarningsFlag        *bool
+	topologyHintsFlag             *bool
+	dedupNetworksFlag             *bool
+	denyUnresolvedNamespaceFlag   *bool
+	denialEventsFlag              *bool
+	injectIntoEphemeralContainers *bool
+	strategicMergePatchFlag       *bool
+	sourceNadsAnnotFlag           *bool
+	validatePatchFlag             *bool
+	resourceClaimsFlag            *bool
+	injectDownwardAPIVolumeFlag   *bool
+	configResourceNameFlag        *bool
+	idempotencyFlag               *bool
+	nodeAffinityAnnotationFlag    *bool
+	skipUnresolvedNamespaceFlag   *bool
+	requireOptInFlag              *bool
+	resourceContainersMountFlag   *bool
+	ownerNetworkAnnotationFlag    *bool
+	hugepageVolumeFlag            *bool
+	removeResourcesFlag           *bool
+	podLevelResourcesFlag         *bool
+	allContainersFlag             *bool
+	userDefinedFailureFlag        *bool
+	bestEffortInjectionFlag       *bool
+	createIfAbsentFlag            *bool
+	resolveAliasesFlag            *bool
+	resourceClaimNetworksFlag     *string
+	labelSelectorNetworksFlag     *string
+	runtimeClassOverridesFlag     *string
+	targetContainerImagesFlag     *string
+	tolerationsFlag               *string
+	extendedResourcePatchModeFlag *string
+	allowedCNITypesFlag           *string
+	networkAnnotationKeysFlag     *string
+	skippedOwnersFlag             *string
+	ignoredNamespacesFlag         *string
+	ownerKindsFlag                *string
+	skipFieldSelectorFlag         *string
+	virtualNodeSelectorFlag       *string
+	virtualTolerationsFlag        *string
+	virtualNodePrefixesFlag       *string
+	namespaceLabelFlag            *string
+	podNetInfoConflictFlag        *string
+	downwardAPIVolumeNameFlag     *string
+	downwardAPIMountPathFlag      *string
+	windowsMountPathFlag          *string
+	hugepageMountPathFlag         *string
+	tokenMountPathFlag            *string
+	tokenExpirationFlag           *time.Duration
+	nadNotFoundMessageFlag        *string
+	streamRequestBodyFlag         *bool
+	streamedRequestBodyLimitFlag  *int64
+	nadLookupRetriesFlag          *int
+	nadLookupRetryDelayFlag       *time.Duration
+	lookupTimeoutFlag             *time.Duration
+	lookupRateLimitFlag           *float64
+	lookupRateBurstFlag           *int
+	lookupWarningFractionFlag     *float64
+	maxConcurrentAdmissionsFlag   *int
+	admissionQueueTimeoutFlag     *time.Duration
+	companionResourcesFlag        *string
+	resourceQuantityFormatFlag    *string
+	injectionFinalizerFlag        *string
+	honorResourcesPolicyFlag      *string
+	fallbackNamespaceFlag         *string
+	annotationDomainFlag          *string
+	allowedResourcePrefixesFlag   *string
+	disallowedResourceActionFlag  *string
+	partialResourcesActionFlag    *string
+	nodeSelectorConflictFlag      *string
+	noContainersActionFlag        *string
+	defaultNetworksActionFlag     *string
+	maxResourceCountFlag          *int64
+	resourceCapActionFlag         *string
+	serverErrorActionFlag         *string
+
+	configuration             map[string]controlSwitchesStates
+	resourceNameKeys          []string
+	extendedResourcePatchMode string
+	allowedCNITypes           []string
+	networkAnnotationKeys     []string
+	skippedOwners             []string
+	ignoredNamespaces         []string
+	ownerKinds                []string
+	skipFieldSelector         fields.Selector
+	skipFieldSelectorErr      error
+	virtualNodeSelector       labels.Selector
+	virtualNodeSelectorErr    error
+	virtualTolerations        []corev1.Toleration
+	virtualTolerationsErr     error
+	virtualNodePrefixes       []string
+	namespaceLabel            string
+	podNetInfoConflict        string
+	downwardAPIVolumeName     string
+	downwardAPIMountPath      string
+	windowsMountPath          string
+	hugepageMountPath         string
+	tokenMountPath            string
+	tokenExpiration           time.Duration
+	nadNotFoundMessageText    string
+	nadNotFoundMessage        *template.Template
+	nadNotFoundMessageErr     error
+	streamRequestBody         bool
+	streamedRequestBodyLimit  int64
+	nadLookupRetries          int
+	nadLookupRetryDelay       time.Duration
+	lookupTimeout             time.Duration
+	lookupRateLimit           float64
+	lookupRateBurst           int
+	lookupWarningFraction     float64
+	maxConcurrentAdmissions   int
+	admissionQueueTimeout     time.Duration
+	companionResources        map[string][]CompanionResource
+	companionResourcesErr     error
+	resourceQuantityFormat    string
+	quantityFormats           map[string]resource.Format
+	quantityFormatsErr        error
+	resourceClaimNetworks     map[string]string
+	resourceClaimNetworksErr  error
+	labelSelectorNetworks     []LabelSelectorNetwork
+	labelSelectorNetworksErr  error
+	runtimeClassOverrides     map[string]RuntimeClassOverride
+	runtimeClassOverridesErr  error
+	targetContainerImages     []string
+	targetContainerImagesRe   []*regexp.Regexp
+	targetContainerImagesErr  error
+	tolerations               []corev1.Toleration
+	tolerationsErr            error
+	injectionFinalizer        string
+	honorResourcesPolicy      string
+	fallbackNamespace         string
+	annotationDomain          string
+	allowedResourcePrefixes   []string
+	disallowedResourceAction  string
+	partialResourcesAction    string
+	nodeSelectorConflict      string
+	noContainersAction        string
+	defaultNetworksAction     string
+	maxResourceCount          int64
+	resourceCapAction         string
+	serverErrorAction         string
+	isValid                   bool
+}
+
+// SetupControlSwitchesFlags - setup all control switches flags that can be set as command line NRI arguments
+// :return pointer to the structure that should be initialized with InitControlSwitches
+func SetupControlSwitchesFlags() *ControlSwitches {
+	var initFlags ControlSwitches
+
+	initFlags.injectHugepageDownAPI = flag.Bool("injectHugepageDownApi", false, "Enable hugepage requests and limits into Downward API.")
+	initFlags.resourceNameKeysFlag = flag.String("network-resource-name-keys", "k8s.v1.cni.cncf.io/resourceName", "comma separated resource name keys --network-resource-name-keys.")
+	initFlags.resourcesHonorFlag = flag.Bool("honor-resources", false, "Honor the existing requested resources requests & limits --honor-resources")
+	initFlags.honorResourcesPolicyFlag = flag.String("honor-resources-policy", HonorResourcesPolicySum, "How honored existing resources are combined with injected ones: sum, max or topup --honor-resources-policy")
+	initFlags.injectIntoInitContainers = flag.Bool("inject-into-init-containers", false, "Inject resources requests & limits into init containers as well --inject-into-init-containers")
+	initFlags.injectIntoEphemeralContainers = flag.Bool("inject-into-ephemeral-containers", false, "Mount Downward API volume into ephemeral containers added to pods --inject-into-ephemeral-containers")
+	initFlags.resourceNameOverrideFlag = flag.Bool("resource-name-override", false, "Allow pod annotation to override resource names defined by net-attach-defs --resource-name-override")
+	initFlags.workloadControllersFlag = flag.Bool("mutate-workload-controllers", false, "Mutate pod template of Deployments, StatefulSets and DaemonSets --mutate-workload-controllers")
+	initFlags.injectedResourcesAnnotFlag = flag.Bool("injected-resources-annotation", false, "Record injected resources as a pod annotation --injected-resources-annotation")
+	initFlags.skipReasonWarningsFlag = flag.Bool("skip-reason-warnings", false, "Return reason why pod was not injected as admission warning --skip-reason-warnings")
+	initFlags.topologyHintsFlag = flag.Bool("topology-hints", false, "Annotate pods whose resources come from topology aware net-attach-defs --topology-hints")
+	initFlags.dedupNetworksFlag = flag.Bool("deduplicate-networks", false, "Request resources once for network selected multiple times with the same interface --deduplicate-networks")
+	initFlags.denyUnresolvedNamespaceFlag = flag.Bool("deny-unresolved-namespace", false, "Deny pod whose namespace cannot be determined instead of using --fallback-namespace --deny-unresolved-namespace")
+	initFlags.denialEventsFlag = flag.Bool("denial-events", false, "Emit Kubernetes event on pod or its owner when injection is denied --denial-events")
+	initFlags.strategicMergePatchFlag = flag.Bool("strategic-merge-patch", false, "Log strategic merge patch equivalent to the JSON patch and return it as audit annotation --strategic-merge-patch")
+	initFlags.sourceNadsAnnotFlag = flag.Bool("source-nads-annotation", false, "Record net-attach-defs which contributed injected resources as a pod annotation --source-nads-annotation")
+	initFlags.resourceClaimsFlag = flag.Bool("resource-claims", false, "Inject resources of networks mapped to resource claims of pod by --resource-claim-networks --resource-claims")
+	initFlags.resourceClaimNetworksFlag = flag.String("resource-claim-networks", "", "comma separated claim=[namespace/]network pairs mapping resource claims and resource claim templates to net-attach-defs --resource-claim-networks")
+	initFlags.labelSelectorNetworksFlag = flag.String("label-selector-networks", "", "semicolon separated selector:[namespace/]network pairs mapping label selectors of pods without network annotation to net-attach-defs, e.g. network=dataplane:sriov-net --label-selector-networks")
+	initFlags.tolerationsFlag = flag.String("tolerations", "", "comma separated key[=value][:effect] tolerations added to pods with injected resources, e.g. sriov=true:NoSchedule --tolerations")
+	initFlags.injectDownwardAPIVolumeFlag = flag.Bool("inject-downward-api-volume", true, "Inject Downward API volume with pod network information along with resources --inject-downward-api-volume")
+	initFlags.configResourceNameFlag = flag.Bool("config-resource-name", false, "Take resource name from resourceName field of net-attach-def CNI config when resource name annotation is missing --config-resource-name")
+	initFlags.idempotencyFlag = flag.Bool("idempotency", true, "Mark injected pods with status annotation and skip injection into marked pods --idempotency")
+	initFlags.nodeAffinityAnnotationFlag = flag.Bool("node-affinity-annotation", false, "Merge node affinity from k8s.v1.cni.cncf.io/nodeAffinity annotation of net-attach-defs into pod affinity --node-affinity-annotation")
+	initFlags.skipUnresolvedNamespaceFlag = flag.Bool("skip-unresolved-namespace", false, "Admit pod whose namespace cannot be determined without injection instead of using --fallback-namespace --skip-unresolved-namespace")
+	initFlags.requireOptInFlag = flag.Bool("require-opt-in", false, "Inject only into pods annotated with <annotation-domain>/inject: true --require-opt-in")
+	initFlags.resourceContainersMountFlag = flag.Bool("downward-api-mount-resource-containers-only", false, "Mount Downward API volume only into containers which resources are injected into --downward-api-mount-resource-containers-only")
+	initFlags.ownerNetworkAnnotationFlag = flag.Bool("owner-network-annotation", false, "Read k8s.v1.cni.cncf.io/networks annotation from the controller owning the pod when pod does not carry it --owner-network-annotation")
+	initFlags.hugepageVolumeFlag = flag.Bool("inject-hugepage-volume", false, "Inject emptyDir volume backed by hugepages into containers requesting hugepages --inject-hugepage-volume")
+	initFlags.removeResourcesFlag = flag.Bool("remove-resources", false, "Remove resources listed in k8s.v1.cni.cncf.io/removeResources pod annotation from containers --remove-resources")
+	initFlags.podLevelResourcesFlag = flag.Bool("pod-level-resources", false, "Inject resources into pod-level resources of pods defining them instead of the first container --pod-level-resources")
+	initFlags.allContainersFlag = flag.Bool("inject-into-all-containers", false, "Inject resources into every app container instead of the first one --inject-into-all-containers")
+	initFlags.userDefinedFailureFlag = flag.Bool("deny-user-defined-injection-failure", false, "Deny pod when user-defined injection patch cannot be created instead of mutating it without the injections --deny-user-defined-injection-failure")
+	initFlags.bestEffortInjectionFlag = flag.Bool("best-effort-injection", false, "Inject resources of resolved networks and record errors of networks whose net-attach-def cannot be found in pod annotation instead of denying pod --best-effort-injection")
+	initFlags.createIfAbsentFlag = flag.Bool("create-resources-if-absent", false, "Inject resources only when no container of pod defines them, guarded by JSON patch test operations --create-resources-if-absent")
+	initFlags.resolveAliasesFlag = flag.Bool("resolve-network-aliases", false, "Resolve networks missing in net-attach-def cache by UID or alias annotation of the cached net-attach-defs --resolve-network-aliases")
+	initFlags.validatePatchFlag = flag.Bool("validate-patch", false, "Apply the JSON patch to the mutated object before responding and deny the request when it does not apply --validate-patch")
+	initFlags.fallbackNamespaceFlag = flag.String("fallback-namespace", DefaultFallbackNamespace, "Namespace of pod used when it cannot be determined from the request --fallback-namespace")
+	initFlags.annotationDomainFlag = flag.String("annotation-domain", DefaultAnnotationDomain, "Domain prefix of pod annotations owned by the injector, e.g. <domain>/skip --annotation-domain")
+	initFlags.allowedCNITypesFlag = flag.String("allowed-cni-types", "", "comma separated CNI types allowed for networks requesting resources, any type is allowed when empty --allowed-cni-types")
+	initFlags.networkAnnotationKeysFlag = flag.String("additional-network-annotation-keys", "", "comma separated keys of pod annotations with network selections scanned along with k8s.v1.cni.cncf.io/networks --additional-network-annotation-keys")
+	initFlags.ownerKindsFlag = flag.String("owner-kinds", strings.Join(SupportedOwnerKinds, ","), "comma separated kinds of pod owners namespace of the pod is resolved from when request does not carry it --owner-kinds")
+	initFlags.skippedOwnersFlag = flag.String("skip-owners", "", "comma separated Kind or Kind/name of pod owners whose pods are not mutated, e.g. DaemonSet/node-agent,Job --skip-owners")
+	initFlags.ignoredNamespacesFlag = flag.String("ignored-namespaces", "", "comma separated namespaces whose pods are never mutated, e.g. kube-system,kube-public,kube-node-lease --ignored-namespaces")
+	initFlags.skipFieldSelectorFlag = flag.String("skip-field-selector", "", "field selector of pods which are not mutated, e.g. spec.restartPolicy=Never --skip-field-selector")
+	initFlags.virtualNodeSelectorFlag = flag.String("virtual-node-selector", "", "label selector matched against pod node selector, matching pods target virtual nodes and are not mutated, e.g. type=virtual-kubelet --virtual-node-selector")
+	initFlags.virtualTolerationsFlag = flag.String("virtual-node-tolerations", "", "comma separated key[=value][:effect] tolerations of virtual node taints, pods tolerating any of them are not mutated, e.g. virtual-kubelet.io/provider --virtual-node-tolerations")
+	initFlags.virtualNodePrefixesFlag = flag.String("virtual-node-name-prefixes", "", "comma separated prefixes of virtual node names, pods bound to such nodes are not mutated, e.g. virtual-kubelet --virtual-node-name-prefixes")
+	initFlags.allowedResourcePrefixesFlag = flag.String("allowed-resource-prefixes", "", "comma separated prefixes of resource names allowed to be injected, any resource is allowed when empty --allowed-resource-prefixes")
+	initFlags.disallowedResourceActionFlag = flag.String("disallowed-resource-action", DisallowedResourceSkip, "Action when network requests resource outside of allowed prefixes: skip or deny --disallowed-resource-action")
+	initFlags.partialResourcesActionFlag = flag.String("partial-resources-action", PartialResourcesComplete, "Action when container sets only request or only limit of resource requested by its networks: complete or deny --partial-resources-action")
+	initFlags.noContainersActionFlag = flag.String("no-containers-action", NoContainersAllow, "Action when pod has no containers: allow it unchanged or deny it --no-containers-action")
+	initFlags.defaultNetworksActionFlag = flag.String("multiple-default-networks-action", DefaultNetworksDeny, "Action when default network annotation selects more networks: deny pod or inject resources of the first network only --multiple-default-networks-action")
+	initFlags.nodeSelectorConflictFlag = flag.String("node-selector-conflict-action", NodeSelectorConflictOverride, "Action when pod node selector sets label of net-attach-def node selector to other value: override, preserve or deny --node-selector-conflict-action")
+	initFlags.maxResourceCountFlag = flag.Int64("max-resource-count", 0, "Maximal count of every resource injected into pod, unlimited when 0 --max-resource-count")
+	initFlags.resourceCapActionFlag = flag.String("resource-cap-action", ResourceCapDeny, "Action when pod networks request resource more times than --max-resource-count: clamp or deny --resource-cap-action")
+	initFlags.serverErrorActionFlag = flag.String("server-error-action", ServerErrorDeny, "Action when pod cannot be processed because of the webhook or API server failure: deny or fail --server-error-action")
+	initFlags.namespaceLabelFlag = flag.String("namespace-label", "", "inject resources only into pods in namespaces with this label not set to disabled, all namespaces when empty --namespace-label")
+	initFlags.podNetInfoConflictFlag = flag.String("podnetinfo-volume-conflict", PodNetInfoConflictRename, "Action when pod defines podnetinfo volume which is not a Downward API volume: rename, deny or skip --podnetinfo-volume-conflict")
+	initFlags.downwardAPIVolumeNameFlag = flag.String("downward-api-volume-name", DefaultDownwardAPIVolumeName, "Name of the injected Downward API volume --downward-api-volume-name")
+	initFlags.downwardAPIMountPathFlag = flag.String("downward-api-mount-path", types.DownwardAPIMountPath, "Path at which the Downward API volume is mounted into containers --downward-api-mount-path")
+	initFlags.windowsMountPathFlag = flag.String("windows-downward-api-mount-path", types.WindowsDownwardAPIPath, "Path at which the Downward API volume is mounted into containers of Windows pods --windows-downward-api-mount-path")
+	initFlags.hugepageMountPathFlag = flag.String("hugepage-mount-path", DefaultHugepageMountPath, "Path at which the hugepage volume is mounted into containers --hugepage-mount-path")
+	initFlags.tokenMountPathFlag = flag.String("token-mount-path", DefaultTokenMountPath, "Path at which the service account token volume requested by net-attach-defs is mounted into containers --token-mount-path")
+	initFlags.tokenExpirationFlag = flag.Duration("token-expiration", DefaultTokenExpiration, "Requested lifetime of the service account tokens injected for net-attach-defs, at least 10m --token-expiration")
+	initFlags.nadNotFoundMessageFlag = flag.String("nad-not-found-message", DefaultNadNotFoundMessage, "Template of the message denying pod selecting net-attach-def which does not exist, {{.Namespace}}, {{.Name}} and {{.Error}} are replaced --nad-not-found-message")
+	initFlags.streamRequestBodyFlag = flag.Bool("stream-request-body", false, "Decode AdmissionReview request body as a stream, allowing bodies up to --max-streamed-request-body-bytes --stream-request-body")
+	initFlags.streamedRequestBodyLimitFlag = flag.Int64("max-streamed-request-body-bytes", DefaultStreamedRequestBodyLimit, "Maximal size of AdmissionReview request body decoded as a stream --max-streamed-request-body-bytes")
+	initFlags.nadLookupRetriesFlag = flag.Int("nad-lookup-retries", DefaultNadLookupRetries, "Number of retries of net-attach-def lookup failed with transient API server error --nad-lookup-retries")
+	initFlags.nadLookupRetryDelayFlag = flag.Duration("nad-lookup-retry-delay", DefaultNadLookupRetryDelay, "Delay before the first retry of net-attach-def lookup, doubled with every next retry --nad-lookup-retry-delay")
+	initFlags.lookupTimeoutFlag = flag.Duration("lookup-timeout", 0, "Time after which API server lookups of a request are cancelled, at most the webhook timeout sent by API server less 1s which is used when 0 --lookup-timeout")
+	initFlags.lookupRateLimitFlag = flag.Float64("lookup-rate-limit", 0, "API server lookups per second allowed for every request namespace, backing off from namespaces exceeding it, lookups are not throttled when 0 --lookup-rate-limit")
+	initFlags.lookupRateBurstFlag = flag.Int("lookup-rate-burst", DefaultLookupRateBurst, "API server lookups allowed at once for every request namespace when --lookup-rate-limit is set --lookup-rate-burst")
+	initFlags.lookupWarningFractionFlag = flag.Float64("lookup-warning-fraction", DefaultLookupWarningFraction, "Fraction of the webhook timeout spent in net-attach-def lookups of a request after which a warning is logged, no warning is logged when 0 --lookup-warning-fraction")
+	initFlags.maxConcurrentAdmissionsFlag = flag.Int("max-concurrent-admissions", 0, "Admission requests mutated at once, requests above the limit wait for --admission-queue-timeout and are denied as retryable when it expires, not limited when 0 --max-concurrent-admissions")
+	initFlags.admissionQueueTimeoutFlag = flag.Duration("admission-queue-timeout", 0, "Time admission request above --max-concurrent-admissions waits for a running one to complete, denied at once when 0 --admission-queue-timeout")
+	initFlags.companionResourcesFlag = flag.String("companion-resources", "", "comma separated resource=companion[:ratio] pairs, companion resource is requested along with the resource --companion-resources")
+	initFlags.resourceQuantityFormatFlag = flag.String("resource-quantity-format", string(resource.DecimalSI), "Format of quantities of injected resources, DecimalSI, BinarySI or DecimalExponent, followed by comma separated resource=format pairs overriding it for the resource --resource-quantity-format")
+	initFlags.runtimeClassOverridesFlag = flag.String("runtime-class-overrides", "", "comma separated runtimeClass=option[;option] pairs overriding injection into pods of the runtime class, options are skip-downward-api-volume and target-containers=<regex> --runtime-class-overrides")
+	initFlags.targetContainerImagesFlag = flag.String("target-container-images", "", "comma separated regular expressions matching whole image of containers which resources of networks not targeting containers are injected into, e.g. registry.example.com/dpdk/.* --target-container-images")
+	initFlags.injectionFinalizerFlag = flag.String("injection-finalizer", "", "Finalizer added to pods with injected resources, none when empty --injection-finalizer")
+	initFlags.extendedResourcePatchModeFlag = flag.String("extended-resource-patch-mode", ExtendedResourcePatchModeBoth, "Fields of container resources to inject network resources into: both, limits-only or requests-only --extended-resource-patch-mode")
+
+	return &initFlags
+}
+
+// InitControlSwitches - initialize internal control switches structures based on command line arguments
+func (switches *ControlSwitches) InitControlSwitches() {
+	switches.configuration = make(map[string]controlSwitchesStates)
+
+	switches.initFeatureState(enableHugePageDownAPIKey, switches.injectHugepageDownAPI, false)
+	switches.initFeatureState(enableHonorExistingResourcesKey, switches.resourcesHonorFlag, false)
+	switches.initFeatureState(injectIntoInitContainersKey, switches.injectIntoInitContainers, false)
+	switches.initFeatureState(enableResourceNameOverrideKey, switches.resourceNameOverrideFlag, false)
+	switches.initFeatureState(enableWorkloadControllersKey, switches.workloadControllersFlag, false)
+	switches.initFeatureState(enableInjectedResourcesAnnotationKey, switches.injectedResourcesAnnotFlag, false)
+	switches.initFeatureState(enableSkipReasonWarningsKey, switches.skipReasonWarningsFlag, false)
+	switches.initFeatureState(enableTopologyHintsKey, switches.topologyHintsFlag, false)
+	switches.initFeatureState(enableNetworkDeduplicationKey, switches.dedupNetworksFlag, false)
+	switches.initFeatureState(denyUnresolvedNamespaceKey, switches.denyUnresolvedNamespaceFlag, false)
+	switches.initFeatureState(enableDenialEventsKey, switches.denialEventsFlag, false)
+	switches.initFeatureState(injectIntoEphemeralContainersKey, switches.injectIntoEphemeralContainers, false)
+	switches.initFeatureState(enableStrategicMergePatchKey, switches.strategicMergePatchFlag, false)
+	switches.initFeatureState(enableSourceNadsAnnotationKey, switches.sourceNadsAnnotFlag, false)
+	switches.initFeatureState(enableValidatePatchKey, switches.validatePatchFlag, false)
+	switches.initFeatureState(enableResourceClaimsKey, switches.resourceClaimsFlag, false)
+	switches.initFeatureState(injectDownwardAPIVolumeKey, switches.injectDownwardAPIVolumeFlag, true)
+	switches.initFeatureState(enableConfigResourceNameKey, switches.configResourceNameFlag, false)
+	switches.initFeatureState(enableIdempotencyKey, switches.idempotencyFlag, true)
+	switches.initFeatureState(enableNodeAffinityAnnotationKey, switches.nodeAffinityAnnotationFlag, false)
+	switches.initFeatureState(skipUnresolvedNamespaceKey, switches.skipUnresolvedNamespaceFlag, false)
+	switches.initFeatureState(requireOptInKey, switches.requireOptInFlag, false)
+	switches.initFeatureState(downwardAPIMountResourceContainersOnlyKey, switches.resourceContainersMountFlag, false)
+	switches.initFeatureState(ownerNetworkAnnotationKey, switches.ownerNetworkAnnotationFlag, false)
+	switches.initFeatureState(injectHugepageVolumeKey, switches.hugepageVolumeFlag, false)
+	switches.initFeatureState(removeResourcesKey, switches.removeResourcesFlag, false)
+	switches.initFeatureState(podLevelResourcesKey, switches.podLevelResourcesFlag, false)
+	switches.initFeatureState(injectIntoAllContainersKey, switches.allContainersFlag, false)
+	switches.initFeatureState(denyUserDefinedInjectionFailureKey, switches.userDefinedFailureFlag, false)
+	switches.initFeatureState(bestEffortInjectionKey, switches.bestEffortInjectionFlag, false)
+	switches.initFeatureState(createResourcesIfAbsentKey, switches.createIfAbsentFlag, false)
+	switches.initFeatureState(resolveNetworkAliasesKey, switches.resolveAliasesFlag, false)
+
+	switches.resourceNameKeys = setResourceNameKeys(*switches.resourceNameKeysFlag)
+
+	switches.extendedResourcePatchMode = ExtendedResourcePatchModeBoth
+	if switches.extendedResourcePatchModeFlag != nil {
+		switches.extendedResourcePatchMode = strings.TrimSpace(*switches.extendedResourcePatchModeFlag)
+	}
+
+	switches.allowedCNITypes = nil
+	if switches.allowedCNITypesFlag != nil {
+		for _, cniType := range strings.Split(*switches.allowedCNITypesFlag, ",") {
+			if cniType = strings.TrimSpace(cniType); cniType != "" {
+				switches.allowedCNITypes = append(switches.allowedCNITypes, cniType)
+			}
+		}
+	}
+
+	switches.networkAnnotationKeys = nil
+	if switches.networkAnnotationKeysFlag != nil {
+		for _, key := range strings.Split(*switches.networkAnnotationKeysFlag, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				switches.networkAnnotationKeys = append(switches.networkAnnotationKeys, key)
+			}
+		}
+	}
+
+	switches.skippedOwners = nil
+	if switches.skippedOwnersFlag != nil {
+		for _, owner := range strings.Split(*switches.skippedOwnersFlag, ",") {
+			if owner = strings.TrimSpace(owner); owner != "" {
+				switches.skippedOwners = append(switches.skippedOwners, owner)
+			}
+		}
+	}
+
+	switches.ignoredNamespaces = nil
+	if switches.ignoredNamespacesFlag != nil {
+		for _, namespace := range strings.Split(*switches.ignoredNamespacesFlag, ",") {
+			if namespace = strings.TrimSpace(namespace); namespace != "" {
+				switches.ignoredNamespaces = append(switches.ignoredNamespaces, namespace)
+			}
+		}
+	}
+
+	switches.ownerKinds = SupportedOwnerKinds
+	if switches.ownerKindsFlag != nil {
+		switches.ownerKinds = nil
+		for _, kind := range strings.Split(*switches.ownerKindsFlag, ",") {
+			if kind = strings.TrimSpace(kind); kind != "" {
+				switches.ownerKinds = append(switches.ownerKinds, kind)
+			}
+		}
+	}
+
+	switches.skipFieldSelector, switches.skipFieldSelectorErr = nil, nil
+	if switches.skipFieldSelectorFlag != nil {
+		switches.skipFieldSelector, switches.skipFieldSelectorErr = parseSkipFieldSelector(*switches.skipFieldSelectorFlag)
+	}
+
+	switches.virtualNodeSelector, switches.virtualNodeSelectorErr = nil, nil
+	if switches.virtualNodeSelectorFlag != nil {
+		switches.virtualNodeSelector, switches.virtualNodeSelectorErr = parseVirtualNodeSelector(*switches.virtualNodeSelectorFlag)
+	}
+	switches.virtualTolerations, switches.virtualTolerationsErr = nil, nil
+	if switches.virtualTolerationsFlag != nil {
+		switches.virtualTolerations, switches.virtualTolerationsErr = parseTolerations(*switches.virtualTolerationsFlag)
+	}
+	switches.virtualNodePrefixes = nil
+	if switches.virtualNodePrefixesFlag != nil {
+		for _, prefix := range strings.Split(*switches.virtualNodePrefixesFlag, ",") {
+			if prefix = strings.TrimSpace(prefix); prefix != "" {
+				switches.virtualNodePrefixes = append(switches.virtualNodePrefixes, prefix)
+			}
+		}
+	}
+
+	switches.allowedResourcePrefixes = nil
+	if switches.allowedResourcePrefixesFlag != nil {
+		for _, prefix := range strings.Split(*switches.allowedResourcePrefixesFlag, ",") {
+			if prefix = strings.TrimSpace(prefix); prefix != "" {
+				switches.allowedResourcePrefixes = append(switches.allowedResourcePrefixes, prefix)
+			}
+		}
+	}
+	switches.disallowedResourceAction = DisallowedResourceSkip
+	if switches.disallowedResourceActionFlag != nil {
+		switches.disallowedResourceAction = strings.TrimSpace(*switches.disallowedResourceActionFlag)
+	}
+
+	switches.partialResourcesAction = PartialResourcesComplete
+	if switches.partialResourcesActionFlag != nil {
+		switches.partialResourcesAction = strings.TrimSpace(*switches.partialResourcesActionFlag)
+	}
+
+	switches.nodeSelectorConflict = NodeSelectorConflictOverride
+	if switches.nodeSelectorConflictFlag != nil {
+		switches.nodeSelectorConflict = strings.TrimSpace(*switches.nodeSelectorConflictFlag)
+	}
+	switches.noContainersAction = NoContainersAllow
+	if switches.noContainersActionFlag != nil {
+		switches.noContainersAction = strings.TrimSpace(*switches.noContainersActionFlag)
+	}
+	switches.defaultNetworksAction = DefaultNetworksDeny
+	if switches.defaultNetworksActionFlag != nil {
+		switches.defaultNetworksAction = strings.TrimSpace(*switches.defaultNetworksActionFlag)
+	}
+
+	switches.maxResourceCount = 0
+	if switches.maxResourceCountFlag != nil {
+		switches.maxResourceCount = *switches.maxResourceCountFlag
+	}
+	switches.resourceCapAction = ResourceCapDeny
+	if switches.resourceCapActionFlag != nil {
+		switches.resourceCapAction = strings.TrimSpace(*switches.resourceCapActionFlag)
+	}
+	switches.serverErrorAction = ServerErrorDeny
+	if switches.serverErrorActionFlag != nil {
+		switches.serverErrorAction = strings.TrimSpace(*switches.serverErrorActionFlag)
+	}
+
+	switches.namespaceLabel = ""
+	if switches.namespaceLabelFlag != nil {
+		switches.namespaceLabel = strings.TrimSpace(*switches.namespaceLabelFlag)
+	}
+
+	switches.podNetInfoConflict = PodNetInfoConflictRename
+	if switches.podNetInfoConflictFlag != nil {
+		switches.podNetInfoConflict = strings.TrimSpace(*switches.podNetInfoConflictFlag)
+	}
+
+	switches.downwardAPIVolumeName = DefaultDownwardAPIVolumeName
+	if switches.downwardAPIVolumeNameFlag != nil {
+		switches.downwardAPIVolumeName = strings.TrimSpace(*switches.downwardAPIVolumeNameFlag)
+	}
+	switches.downwardAPIMountPath = types.DownwardAPIMountPath
+	if switches.downwardAPIMountPathFlag != nil {
+		switches.downwardAPIMountPath = strings.TrimSpace(*switches.downwardAPIMountPathFlag)
+	}
+	switches.windowsMountPath = types.WindowsDownwardAPIPath
+	if switches.windowsMountPathFlag != nil {
+		switches.windowsMountPath = strings.TrimSpace(*switches.windowsMountPathFlag)
+	}
+	switches.hugepageMountPath = DefaultHugepageMountPath
+	if switches.hugepageMountPathFlag != nil {
+		switches.hugepageMountPath = strings.TrimSpace(*switches.hugepageMountPathFlag)
+	}
+	switches.tokenMountPath = DefaultTokenMountPath
+	if switches.tokenMountPathFlag != nil {
+		switches.tokenMountPath = strings.TrimSpace(*switches.tokenMountPathFlag)
+	}
+	switches.tokenExpiration = DefaultTokenExpiration
+	if switches.tokenExpirationFlag != nil {
+		switches.tokenExpiration = *switches.tokenExpirationFlag
+	}
+	switches.nadNotFoundMessageText = DefaultNadNotFoundMessage
+	if switches.nadNotFoundMessageFlag != nil {
+		switches.nadNotFoundMessageText = *switches.nadNotFoundMessageFlag
+	}
+	switches.nadNotFoundMessage, switches.nadNotFoundMessageErr = parseNadNotFoundMessage(switches.nadNotFoundMessageText)
+
+	switches.streamRequestBody = false
+	if switches.streamRequestBodyFlag != nil {
+		switches.streamRequestBody = *switches.streamRequestBodyFlag
+	}
+	switches.streamedRequestBodyLimit = DefaultStreamedRequestBodyLimit
+	if switches.streamedRequestBodyLimitFlag != nil {
+		switches.streamedRequestBodyLimit = *switches.streamedRequestBodyLimitFlag
+	}
+
+	switches.nadLookupRetries = DefaultNadLookupRetries
+	if switches.nadLookupRetriesFlag != nil {
+		switches.nadLookupRetries = *switches.nadLookupRetriesFlag
+	}
+	switches.nadLookupRetryDelay = DefaultNadLookupRetryDelay
+	if switches.nadLookupRetryDelayFlag != nil {
+		switches.nadLookupRetryDelay = *switches.nadLookupRetryDelayFlag
+	}
+	switches.lookupTimeout = 0
+	if switches.lookupTimeoutFlag != nil {
+		switches.lookupTimeout = *switches.lookupTimeoutFlag
+	}
+
+	switches.lookupRateLimit = 0
+	if switches.lookupRateLimitFlag != nil {
+		switches.lookupRateLimit = *switches.lookupRateLimitFlag
+	}
+	switches.lookupRateBurst = DefaultLookupRateBurst
+	if switches.lookupRateBurstFlag != nil {
+		switches.lookupRateBurst = *switches.lookupRateBurstFlag
+	}
+	switches.lookupWarningFraction = DefaultLookupWarningFraction
+	if switches.lookupWarningFractionFlag != nil {
+		switches.lookupWarningFraction = *switches.lookupWarningFractionFlag
+	}
+	switches.maxConcurrentAdmissions = 0
+	if switches.maxConcurrentAdmissionsFlag != nil {
+		switches.maxConcurrentAdmissions = *switches.maxConcurrentAdmissionsFlag
+	}
+	switches.admissionQueueTimeout = 0
+	if switches.admissionQueueTimeoutFlag != nil {
+		switches.admissionQueueTimeout = *switches.admissionQueueTimeoutFlag
+	}
+
+	switches.companionResources, switches.companionResourcesErr = nil, nil
+	if switches.companionResourcesFlag != nil {
+		switches.companionResources, switches.companionResourcesErr = parseCompanionResources(*switches.companionResourcesFlag)
+	}
+
+	switches.resourceQuantityFormat = string(resource.DecimalSI)
+	if switches.resourceQuantityFormatFlag != nil {
+		switches.resourceQuantityFormat = strings.TrimSpace(*switches.resourceQuantityFormatFlag)
+	}
+	switches.quantityFormats, switches.quantityFormatsErr = parseQuantityFormats(switches.resourceQuantityFormat)
+
+	switches.resourceClaimNetworks, switches.resourceClaimNetworksErr = nil, nil
+	if switches.resourceClaimNetworksFlag != nil {
+		switches.resourceClaimNetworks, switches.resourceClaimNetworksErr = parseResourceClaimNetworks(*switches.resourceClaimNetworksFlag)
+	}
+
+	switches.labelSelectorNetworks, switches.labelSelectorNetworksErr = nil, nil
+	if switches.labelSelectorNetworksFlag != nil {
+		switches.labelSelectorNetworks, switches.labelSelectorNetworksErr = parseLabelSelectorNetworks(*switches.labelSelectorNetworksFlag)
+	}
+
+	switches.runtimeClassOverrides, switches.runtimeClassOverridesErr = nil, nil
+	if switches.runtimeClassOverridesFlag != nil {
+		switches.runtimeClassOverrides, switches.runtimeClassOverridesErr = parseRuntimeClassOverrides(*switches.runtimeClassOverridesFlag)
+	}
+
+	switches.targetContainerImages, switches.targetContainerImagesRe, switches.targetContainerImagesErr = nil, nil, nil
+	if switches.targetContainerImagesFlag != nil {
+		switches.targetContainerImages, switches.targetContainerImagesRe, switches.targetContainerImagesErr =
+			parseTargetContainerImages(*switches.targetContainerImagesFlag)
+	}
+
+	switches.tolerations, switches.tolerationsErr = nil, nil
+	if switches.tolerationsFlag != nil {
+		switches.tolerations, switches.tolerationsErr = parseTolerations(*switches.tolerationsFlag)
+	}
+
+	switches.injectionFinalizer = ""
+	if switches.injectionFinalizerFlag != nil {
+		switches.injectionFinalizer = strings.TrimSpace(*switches.injectionFinalizerFlag)
+	}
+
+	switches.honorResourcesPolicy = HonorResourcesPolicySum
+	if switches.honorResourcesPolicyFlag != nil {
+		switches.honorResourcesPolicy = strings.TrimSpace(*switches.honorResourcesPolicyFlag)
+	}
+
+	switches.fallbackNamespace = DefaultFallbackNamespace
+	if switches.fallbackNamespaceFlag != nil {
+		switches.fallbackNamespace = strings.TrimSpace(*switches.fallbackNamespaceFlag)
+	}
+
+	switches.annotationDomain = DefaultAnnotationDomain
+	if switches.annotationDomainFlag != nil {
+		switches.annotationDomain = strings.TrimSpace(*switches.annotationDomainFlag)
+	}
+
+	switches.isValid = true
+}
+
+// parseCompanionResources parses comma separated list of resource=companion[:ratio] pairs, ratio is 1 when not defined
+func parseCompanionResources(value string) (map[string][]CompanionResource, error) {
+	companions := make(map[string][]CompanionResource)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		names := strings.Split(pair, "=")
+		if len(names) != 2 || strings.TrimSpace(names[0]) == "" || strings.TrimSpace(names[1]) == "" {
+			return nil, fmt.Errorf("invalid companion resource '%s', expected resource=companion[:ratio]", pair)
+		}
+		companion := CompanionResource{ResourceName: strings.TrimSpace(names[1]), Ratio: 1}
+		if nameAndRatio := strings.Split(companion.ResourceName, ":"); len(nameAndRatio) == 2 {
+			ratio, err := strconv.ParseInt(strings.TrimSpace(nameAndRatio[1]), 10, 64)
+			if err != nil || ratio < 1 {
+				return nil, fmt.Errorf("invalid ratio of companion resource '%s', expected positive integer", pair)
+			}
+			companion = CompanionResource{ResourceName: strings.TrimSpace(nameAndRatio[0]), Ratio: ratio}
+		} else if len(nameAndRatio) > 2 {
+			return nil, fmt.Errorf("invalid companion resource '%s', expected resource=companion[:ratio]", pair)
+		}
+		resourceName := strings.TrimSpace(names[0])
+		companions[resourceName] = append(companions[resourceName], companion)
+	}
+	return companions, nil
+}
+
+// parseQuantityFormats parses format of quantities of all resources, optionally followed by comma separated
+// resource=format pairs overriding it for the resource. Format of all resources is stored under empty key.
+func parseQuantityFormats(value string) (map[string]resource.Format, error) {
+	formats := map[string]resource.Format{"": resource.DecimalSI}
+	for i, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		resourceName, format := "", entry
+		if parts := strings.Split(entry, "="); len(parts) == 2 {
+			resourceName, format = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+			if errs := validation.IsQualifiedName(resourceName); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid resource name '%s' of quantity format: %s", resourceName, strings.Join(errs, ", "))
+			}
+		} else if len(parts) > 2 || i > 0 {
+			return nil, fmt.Errorf("invalid quantity format '%s', expected format followed by resource=format pairs", entry)
+		}
+		switch resource.Format(format) {
+		case resource.DecimalSI, resource.BinarySI, resource.DecimalExponent:
+			formats[resourceName] = resource.Format(format)
+		default:
+			return nil, fmt.Errorf("invalid quantity format '%s', expected one of: %s, %s, %s", format,
+				resource.DecimalSI, resource.BinarySI, resource.DecimalExponent)
+		}
+	}
+	return formats, nil
+}
+
+// parseResourceClaimNetworks parses comma separated list of claim=[namespace/]network pairs
+func parseResourceClaimNetworks(value string) (map[string]string, error) {
+	claimNetworks := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		names := strings.Split(pair, "=")
+		if len(names) != 2 {
+			return nil, fmt.Errorf("invalid resource claim network '%s', expected claim=[namespace/]network", pair)
+		}
+		claim, network := strings.TrimSpace(names[0]), strings.TrimSpace(names[1])
+		if errs := validation.IsDNS1123Subdomain(claim); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid resource claim '%s': %s", claim, strings.Join(errs, ", "))
+		}
+		networkNames := strings.Split(network, "/")
+		for _, name := range networkNames {
+			if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 || len(networkNames) > 2 {
+				return nil, fmt.Errorf("invalid network '%s' of resource claim '%s', expected [namespace/]network", network, claim)
+			}
+		}
+		if mapped, exists := claimNetworks[claim]; exists && mapped != network {
+			return nil, fmt.Errorf("resource claim '%s' is mapped to networks '%s' and '%s'", claim, mapped, network)
+		}
+		claimNetworks[claim] = network
+	}
+	return claimNetworks, nil
+}
+
+// parseLabelSelectorNetworks parses semicolon separated list of selector:[namespace/]network pairs, label selectors
+// are separated by semicolon as they can contain comma
+func parseLabelSelectorNetworks(value string) ([]LabelSelectorNetwork, error) {
+	var selectorNetworks []LabelSelectorNetwork
+	for _, pair := range strings.Split(value, ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		/* neither label selector nor network can contain colon */
+		separator := strings.LastIndex(pair, ":")
+		if separator < 0 {
+			return nil, fmt.Errorf("invalid label selector network '%s', expected selector:[namespace/]network", pair)
+		}
+		selectorValue, network := strings.TrimSpace(pair[:separator]), strings.TrimSpace(pair[separator+1:])
+		selector, err := labels.Parse(selectorValue)
+		if err != nil || selector.Empty() {
+			return nil, fmt.Errorf("invalid label selector '%s' of network '%s', expected non-empty label selector", selectorValue, network)
+		}
+		networkNames := strings.Split(network, "/")
+		for _, name := range networkNames {
+			if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 || len(networkNames) > 2 {
+				return nil, fmt.Errorf("invalid network '%s' of label selector '%s', expected [namespace/]network", network, selectorValue)
+			}
+		}
+		selectorNetworks = append(selectorNetworks, LabelSelectorNetwork{Selector: selector.String(), Network: network, selector: selector})
+	}
+	return selectorNetworks, nil
+}
+
+// windowsAbsPathRegexp matches absolute Windows path with drive letter and at least one directory, e.g. C:\etc
+var windowsAbsPathRegexp = regexp.MustCompile(`^[A-Za-z]:\\[^\\/:*?"<>|]+(\\[^\\/:*?"<>|]+)*\\?$`)
+
+// isWindowsAbsPath returns true when the path is absolute Windows path other than drive root
+func isWindowsAbsPath(value string) bool {
+	return windowsAbsPathRegexp.MatchString(value)
+}
+
+// parseTolerations parses comma separated list of key[=value][:effect] tolerations, toleration without value tolerates
+// taint with any value and toleration without effect tolerates all effects of the taint
+func parseTolerations(value string) ([]corev1.Toleration, error) {
+	var tolerations []corev1.Toleration
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		toleration := corev1.Toleration{Operator: corev1.TolerationOpExists}
+		keyValue := item
+		if separator := strings.LastIndex(item, ":"); separator >= 0 {
+			keyValue, toleration.Effect = item[:separator], corev1.TaintEffect(item[separator+1:])
+			switch toleration.Effect {
+			case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+			default:
+				return nil, fmt.Errorf("invalid effect '%s' of toleration '%s', expected NoSchedule, PreferNoSchedule or NoExecute",
+					toleration.Effect, item)
+			}
+		}
+		if keyAndValue := strings.SplitN(keyValue, "=", 2); len(keyAndValue) == 2 {
+			toleration.Key, toleration.Operator, toleration.Value = keyAndValue[0], corev1.TolerationOpEqual, keyAndValue[1]
+			if errs := validation.IsValidLabelValue(toleration.Value); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid value of toleration '%s': %s", item, strings.Join(errs, ", "))
+			}
+		} else {
+			toleration.Key = keyValue
+		}
+		if errs := validation.IsQualifiedName(toleration.Key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid key of toleration '%s': %s", item, strings.Join(errs, ", "))
+		}
+		tolerations = append(tolerations, toleration)
+	}
+	return tolerations, nil
+}
+
+// parseRuntimeClassOverrides parses comma separated list of runtimeClass=option[;option] pairs, options are
+// skip-downward-api-volume and target-containers=<regex>
+func parseRuntimeClassOverrides(value string) (map[string]RuntimeClassOverride, error) {
+	overrides := make(map[string]RuntimeClassOverride)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		names := strings.SplitN(pair, "=", 2)
+		if len(names) != 2 || strings.TrimSpace(names[1]) == "" {
+			return nil, fmt.Errorf("invalid runtime class override '%s', expected runtimeClass=option[;option]", pair)
+		}
+		runtimeClass := strings.TrimSpace(names[0])
+		if errs := validation.IsDNS1123Subdomain(runtimeClass); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid runtime class '%s': %s", runtimeClass, strings.Join(errs, ", "))
+		}
+		if _, exists := overrides[runtimeClass]; exists {
+			return nil, fmt.Errorf("runtime class '%s' is overridden more than once", runtimeClass)
+		}
+		var override RuntimeClassOverride
+		for _, option := range strings.Split(names[1], ";") {
+			option = strings.TrimSpace(option)
+			switch {
+			case option == RuntimeClassSkipDownwardAPIVolume:
+				override.SkipDownwardAPIVolume = true
+			case strings.HasPrefix(option, RuntimeClassTargetContainers+"="):
+				target := strings.TrimPrefix(option, RuntimeClassTargetContainers+"=")
+				if _, err := regexp.Compile("^(?:" + target + ")$"); err != nil || target == "" {
+					return nil, fmt.Errorf("invalid target containers '%s' of runtime class '%s'", target, runtimeClass)
+				}
+				override.TargetContainers = target
+			default:
+				return nil, fmt.Errorf("unknown option '%s' of runtime class '%s', expected %s or %s=<regex>", option,
+					runtimeClass, RuntimeClassSkipDownwardAPIVolume, RuntimeClassTargetContainers)
+			}
+		}
+		overrides[runtimeClass] = override
+	}
+	return overrides, nil
+}
+
+// parseTargetContainerImages parses comma separated list of regular expressions matching whole container image
+func parseTargetContainerImages(value string) ([]string, []*regexp.Regexp, error) {
+	var expressions []string
+	var images []*regexp.Regexp
+	for _, expression := range strings.Split(value, ",") {
+		if expression = strings.TrimSpace(expression); expression == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + expression + ")$")
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid target container image '%s': %v", expression, err)
+		}
+		expressions = append(expressions, expression)
+		images = append(images, re)
+	}
+	return expressions, images, nil
+}
+
+func isSupportedOwnerKind(kind string) bool {
+	for _, supported := range SupportedOwnerKinds {
+		if kind == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSkipFieldSelector parses field selector of pods which are not mutated, only fields of SkipFieldSelectorFields
+// are supported. Empty selector is returned as nil, so it does not match any pod.
+func parseSkipFieldSelector(value string) (fields.Selector, error) {
+	if value = strings.TrimSpace(value); value == "" {
+		return nil, nil
+	}
+	selector, err := fields.ParseSelector(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid skip field selector '%s': %v", value, err)
+	}
+	for _, requirement := range selector.Requirements() {
+		if !isSkipFieldSelectorField(requirement.Field) {
+			return nil, fmt.Errorf("field '%s' of skip field selector '%s' is not supported, expected one of: %s",
+				requirement.Field, value, strings.Join(SkipFieldSelectorFields, ", "))
+		}
+	}
+	return selector, nil
+}
+
+// nodeNamePrefixRegexp matches beginning of node name, which is a lowercase RFC 1123 subdomain
+var nodeNamePrefixRegexp = regexp.MustCompile(`^[a-z0-9][-a-z0-9.]*$`)
+
+// parseVirtualNodeSelector parses label selector of virtual nodes, nil selector is returned when value is empty
+func parseVirtualNodeSelector(value string) (labels.Selector, error) {
+	if value = strings.TrimSpace(value); value == "" {
+		return nil, nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid virtual node selector '%s': %v", value, err)
+	}
+	return selector, nil
+}
+
+// NadNotFoundMessageData - values of the net-attach-def not found message template
+type NadNotFoundMessageData struct {
+	Namespace string
+	Name      string
+	Error     string
+}
+
+// parseNadNotFoundMessage parses template of the message denying pod selecting net-attach-def which does not exist.
+// Template is executed with sample values, so templates referring to unknown values are rejected at startup.
+func parseNadNotFoundMessage(value string) (*template.Template, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, fmt.Errorf("net-attach-def not found message must not be empty")
+	}
+	message, err := template.New("nad-not-found-message").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid net-attach-def not found message '%s': %v", value, err)
+	}
+	if err := message.Execute(io.Discard, NadNotFoundMessageData{Namespace: "default", Name: "net", Error: "not found"}); err != nil {
+		return nil, fmt.Errorf("invalid net-attach-def not found message '%s': %v", value, err)
+	}
+	return message, nil
+}
+
+func isSkipFieldSelectorField(field string) bool {
+	for _, supported := range SkipFieldSelectorFields {
+		if field == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateControlSwitches - verify that values passed as command line arguments are correct
+func (switches *ControlSwitches) ValidateControlSwitches() error {
+	switch switches.extendedResourcePatchMode {
+	case ExtendedResourcePatchModeBoth, ExtendedResourcePatchModeLimitsOnly, ExtendedResourcePatchModeRequestsOnly:
+	default:
+		return fmt.Errorf("invalid extended resource patch mode '%s', expected one of: %s, %s, %s", switches.extendedResourcePatchMode,
+			ExtendedResourcePatchModeBoth, ExtendedResourcePatchModeLimitsOnly, ExtendedResourcePatchModeRequestsOnly)
+	}
+
+	switch switches.honorResourcesPolicy {
+	case HonorResourcesPolicySum, HonorResourcesPolicyMax, HonorResourcesPolicyTopUp:
+	default:
+		return fmt.Errorf("invalid honor resources policy '%s', expected one of: %s, %s, %s", switches.honorResourcesPolicy,
+			HonorResourcesPolicySum, HonorResourcesPolicyMax, HonorResourcesPolicyTopUp)
+	}
+
+	switch switches.disallowedResourceAction {
+	case DisallowedResourceSkip, DisallowedResourceDeny:
+	default:
+		return fmt.Errorf("invalid disallowed resource action '%s', expected one of: %s, %s", switches.disallowedResourceAction,
+			DisallowedResourceSkip, DisallowedResourceDeny)
+	}
+
+	switch switches.partialResourcesAction {
+	case PartialResourcesComplete, PartialResourcesDeny:
+	default:
+		return fmt.Errorf("invalid partial resources action '%s', expected one of: %s, %s", switches.partialResourcesAction,
+			PartialResourcesComplete, PartialResourcesDeny)
+	}
+
+	switch switches.nodeSelectorConflict {
+	case NodeSelectorConflictOverride, NodeSelectorConflictPreserve, NodeSelectorConflictDeny:
+	default:
+		return fmt.Errorf("invalid node selector conflict action '%s', expected one of: %s, %s, %s", switches.nodeSelectorConflict,
+			NodeSelectorConflictOverride, NodeSelectorConflictPreserve, NodeSelectorConflictDeny)
+	}
+
+	switch switches.noContainersAction {
+	case NoContainersAllow, NoContainersDeny:
+	default:
+		return fmt.Errorf("invalid no containers action '%s', expected one of: %s, %s", switches.noContainersAction,
+			NoContainersAllow, NoContainersDeny)
+	}
+
+	switch switches.defaultNetworksAction {
+	case DefaultNetworksDeny, DefaultNetworksFirst:
+	default:
+		return fmt.Errorf("invalid multiple default networks action '%s', expected one of: %s, %s", switches.defaultNetworksAction,
+			DefaultNetworksDeny, DefaultNetworksFirst)
+	}
+
+	if switches.maxResourceCount < 0 {
+		return fmt.Errorf("maximal resource count %d must not be negative", switches.maxResourceCount)
+	}
+	switch switches.resourceCapAction {
+	case ResourceCapClamp, ResourceCapDeny:
+	default:
+		return fmt.Errorf("invalid resource cap action '%s', expected one of: %s, %s", switches.resourceCapAction,
+			ResourceCapClamp, ResourceCapDeny)
+	}
+	switch switches.serverErrorAction {
+	case ServerErrorDeny, ServerErrorFail:
+	default:
+		return fmt.Errorf("invalid server error action '%s', expected one of: %s, %s", switches.serverErrorAction,
+			ServerErrorDeny, ServerErrorFail)
+	}
+
+	switch switches.podNetInfoConflict {
+	case PodNetInfoConflictRename, PodNetInfoConflictDeny, PodNetInfoConflictSkip:
+	default:
+		return fmt.Errorf("invalid podnetinfo volume conflict action '%s', expected one of: %s, %s, %s", switches.podNetInfoConflict,
+			PodNetInfoConflictRename, PodNetInfoConflictDeny, PodNetInfoConflictSkip)
+	}
+
+	if errs := validation.IsDNS1123Label(switches.downwardAPIVolumeName); len(errs) > 0 {
+		return fmt.Errorf("invalid Downward API volume name '%s': %s", switches.downwardAPIVolumeName, strings.Join(errs, ", "))
+	}
+	/* volume renamed on conflict with pod volume has to be a valid volume name as well */
+	if len(switches.GetRenamedDownwardAPIVolumeName()) > validation.DNS1123LabelMaxLength {
+		return fmt.Errorf("Downward API volume name '%s' is too long, at most %d characters are allowed",
+			switches.downwardAPIVolumeName, validation.DNS1123LabelMaxLength-len(renamedDownwardAPIVolumeSuffix))
+	}
+	if !path.IsAbs(switches.downwardAPIMountPath) || path.Clean(switches.downwardAPIMountPath) == "/" {
+		return fmt.Errorf("invalid Downward API mount path '%s', expected absolute path other than /", switches.downwardAPIMountPath)
+	}
+	if !isWindowsAbsPath(switches.windowsMountPath) {
+		return fmt.Errorf("invalid Windows Downward API mount path '%s', expected absolute path other than drive root, e.g. C:\\etc\\podnetinfo",
+			switches.windowsMountPath)
+	}
+	if !path.IsAbs(switches.hugepageMountPath) || path.Clean(switches.hugepageMountPath) == "/" {
+		return fmt.Errorf("invalid hugepage mount path '%s', expected absolute path other than /", switches.hugepageMountPath)
+	}
+	if path.Clean(switches.hugepageMountPath) == path.Clean(switches.downwardAPIMountPath) {
+		return fmt.Errorf("hugepage mount path '%s' must differ from the Downward API mount path", switches.hugepageMountPath)
+	}
+	if !path.IsAbs(switches.tokenMountPath) || path.Clean(switches.tokenMountPath) == "/" {
+		return fmt.Errorf("invalid token mount path '%s', expected absolute path other than /", switches.tokenMountPath)
+	}
+	if path.Clean(switches.tokenMountPath) == path.Clean(switches.downwardAPIMountPath) ||
+		path.Clean(switches.tokenMountPath) == path.Clean(switches.hugepageMountPath) {
+		return fmt.Errorf("token mount path '%s' must differ from the Downward API and hugepage mount paths", switches.tokenMountPath)
+	}
+	if switches.tokenExpiration < MinTokenExpiration {
+		return fmt.Errorf("token expiration %v is shorter than %v", switches.tokenExpiration, MinTokenExpiration)
+	}
+	if switches.nadNotFoundMessageErr != nil {
+		return switches.nadNotFoundMessageErr
+	}
+
+	if switches.streamRequestBody && switches.streamedRequestBodyLimit < DefaultRequestBodyLimit {
+		return fmt.Errorf("streamed request body limit %d must not be lower than the default limit %d",
+			switches.streamedRequestBodyLimit, DefaultRequestBodyLimit)
+	}
+
+	if switches.companionResourcesErr != nil {
+		return switches.companionResourcesErr
+	}
+
+	if switches.quantityFormatsErr != nil {
+		return switches.quantityFormatsErr
+	}
+
+	if switches.resourceClaimNetworksErr != nil {
+		return switches.resourceClaimNetworksErr
+	}
+
+	if switches.labelSelectorNetworksErr != nil {
+		return switches.labelSelectorNetworksErr
+	}
+
+	if switches.runtimeClassOverridesErr != nil {
+		return switches.runtimeClassOverridesErr
+	}
+
+	if switches.targetContainerImagesErr != nil {
+		return switches.targetContainerImagesErr
+	}
+
+	if switches.tolerationsErr != nil {
+		return switches.tolerationsErr
+	}
+
+	if switches.skipFieldSelectorErr != nil {
+		return switches.skipFieldSelectorErr
+	}
+	if switches.virtualNodeSelectorErr != nil {
+		return switches.virtualNodeSelectorErr
+	}
+	if switches.virtualTolerationsErr != nil {
+		return fmt.Errorf("invalid virtual node tolerations: %v", switches.virtualTolerationsErr)
+	}
+	for _, prefix := range switches.virtualNodePrefixes {
+		if len(prefix) > validation.DNS1123SubdomainMaxLength || !nodeNamePrefixRegexp.MatchString(prefix) {
+			return fmt.Errorf("invalid virtual node name prefix '%s', expected beginning of lowercase RFC 1123 subdomain", prefix)
+		}
+	}
 
-// setActiveState - set active state to the passed value
-func (state *controlSwitchesStates) setActiveState(value bool) {
-	state.active = value
-}
+	if switches.nadLookupRetries < 0 || switches.nadLookupRetryDelay < 0 {
+		return fmt.Errorf("net-attach-def lookup retries %d and retry delay %v must not be negative",
+			switches.nadLookupRetries, switches.nadLookupRetryDelay)
+	}
 
-type ControlSwitches struct {
-	// pointers to command line arguments
-	injectHugepageDownAPI *bool
-	resourceNameKeysFlag  *string
-	resourcesHonorFlag    *bool
+	if switches.lookupTimeout < 0 {
+		return fmt.Errorf("lookup timeout %v must not be negative", switches.lookupTimeout)
+	}
 
-	configuration    map[string]controlSwitchesStates
-	resourceNameKeys []string
-	isValid          bool
-}
+	if switches.lookupRateLimit < 0 || (switches.lookupRateLimit > 0 && switches.lookupRateBurst < 1) {
+		return fmt.Errorf("lookup rate limit %v must not be negative and lookup rate burst %d must be positive",
+			switches.lookupRateLimit, switches.lookupRateBurst)
+	}
 
-// SetupControlSwitchesFlags - setup all control switches flags that can be set as command line NRI arguments
-// :return pointer to the structure that should be initialized with InitControlSwitches
-func SetupControlSwitchesFlags() *ControlSwitches {
-	var initFlags ControlSwitches
+	if switches.lookupWarningFraction < 0 || switches.lookupWarningFraction > 1 {
+		return fmt.Errorf("lookup warning fraction %v must be between 0 and 1", switches.lookupWarningFraction)
+	}
 
-	initFlags.injectHugepageDownAPI = flag.Bool("injectHugepageDownApi", false, "Enable hugepage requests and limits into Downward API.")
-	initFlags.resourceNameKeysFlag = flag.String("network-resource-name-keys", "k8s.v1.cni.cncf.io/resourceName", "comma separated resource name keys --network-resource-name-keys.")
-	initFlags.resourcesHonorFlag = flag.Bool("honor-resources", false, "Honor the existing requested resources requests & limits --honor-resources")
+	if switches.maxConcurrentAdmissions < 0 || switches.admissionQueueTimeout < 0 {
+		return fmt.Errorf("max concurrent admissions %d and admission queue timeout %v must not be negative",
+			switches.maxConcurrentAdmissions, switches.admissionQueueTimeout)
+	}
 
-	return &initFlags
-}
+	if errs := validation.IsDNS1123Label(switches.fallbackNamespace); len(errs) > 0 {
+		return fmt.Errorf("invalid fallback namespace '%s': %s", switches.fallbackNamespace, strings.Join(errs, ", "))
+	}
 
-// InitControlSwitches - initialize internal control switches structures based on command line arguments
-func (switches *ControlSwitches) InitControlSwitches() {
-	switches.configuration = make(map[string]controlSwitchesStates)
+	/* annotation key prefix has to be a DNS subdomain */
+	if errs := validation.IsDNS1123Subdomain(switches.annotationDomain); len(errs) > 0 {
+		return fmt.Errorf("invalid annotation domain '%s': %s", switches.annotationDomain, strings.Join(errs, ", "))
+	}
 
-	state := controlSwitchesStates{initial: *switches.injectHugepageDownAPI, active: *switches.injectHugepageDownAPI}
-	switches.configuration[enableHugePageDownAPIKey] = state
+	for _, key := range switches.networkAnnotationKeys {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid network annotation key '%s': %s", key, strings.Join(errs, ", "))
+		}
+	}
+
+	for _, owner := range switches.skippedOwners {
+		units := strings.Split(owner, "/")
+		if len(units) > 2 || units[0] == "" || (len(units) == 2 && len(validation.IsDNS1123Subdomain(units[1])) > 0) {
+			return fmt.Errorf("invalid skipped owner '%s', expected Kind or Kind/name", owner)
+		}
+	}
 
-	state = controlSwitchesStates{initial: *switches.resourcesHonorFlag, active: *switches.resourcesHonorFlag}
-	switches.configuration[enableHonorExistingResourcesKey] = state
+	for _, namespace := range switches.ignoredNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return fmt.Errorf("invalid ignored namespace '%s': %s", namespace, strings.Join(errs, ", "))
+		}
+	}
 
-	switches.resourceNameKeys = setResourceNameKeys(*switches.resourceNameKeysFlag)
+	for _, kind := range switches.ownerKinds {
+		if !isSupportedOwnerKind(kind) {
+			return fmt.Errorf("unsupported owner kind '%s', expected some of: %s", kind, strings.Join(SupportedOwnerKinds, ", "))
+		}
+	}
 
-	switches.isValid = true
+	if switches.injectionFinalizer != "" {
+		if errs := validation.IsQualifiedName(switches.injectionFinalizer); len(errs) > 0 {
+			return fmt.Errorf("invalid injection finalizer '%s': %s", switches.injectionFinalizer, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// initFeatureState - set initial and active state of the feature based on command line argument, default value
+// is used when argument was not defined
+func (switches *ControlSwitches) initFeatureState(featureName string, flagValue *bool, defaultValue bool) {
+	value := defaultValue
+	if flagValue != nil {
+		value = *flagValue
+	}
+	switches.configuration[featureName] = controlSwitchesStates{initial: value, active: value}
 }
 
 // setResourceNameKeys extracts resources from a string and add them to resourceNameKeys array
@@ -106,6 +1417,331 @@ func (switches *ControlSwitches) GetResourceNameKeys() []string {
 	return switches.resourceNameKeys
 }
 
+// GetAllowedCNITypes returns CNI types allowed for networks requesting resources, empty list allows any type
+func (switches *ControlSwitches) GetAllowedCNITypes() []string {
+	return switches.allowedCNITypes
+}
+
+// GetAdditionalNetworkAnnotationKeys returns keys of pod annotations with network selections scanned along with
+// the networks annotation
+func (switches *ControlSwitches) GetAdditionalNetworkAnnotationKeys() []string {
+	return switches.networkAnnotationKeys
+}
+
+// IsOwnerSkipped returns true when pods of the owner of the given kind and name should not be mutated
+func (switches *ControlSwitches) IsOwnerSkipped(kind, name string) bool {
+	for _, owner := range switches.skippedOwners {
+		if owner == kind || owner == kind+"/"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNamespaceIgnored returns true when pods of the given namespace should never be mutated
+func (switches *ControlSwitches) IsNamespaceIgnored(namespace string) bool {
+	for _, ignored := range switches.ignoredNamespaces {
+		if ignored == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// GetOwnerKinds returns kinds of pod owners namespace of the pod is resolved from
+func (switches *ControlSwitches) GetOwnerKinds() []string {
+	return switches.ownerKinds
+}
+
+// IsOwnerKindEnabled returns true when namespace of the pod is resolved from owner of the kind
+func (switches *ControlSwitches) IsOwnerKindEnabled(kind string) bool {
+	for _, enabled := range switches.ownerKinds {
+		if kind == enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSkippedByFieldSelector returns true when pod with the given fields matches the skip field selector, no pod is
+// skipped when the selector is not set
+func (switches *ControlSwitches) IsSkippedByFieldSelector(podFields fields.Set) bool {
+	return switches.skipFieldSelector != nil && switches.skipFieldSelector.Matches(podFields)
+}
+
+// GetVirtualNodeSelector returns label selector matched against node selector of pods targeting virtual nodes, nil
+// when it is not set
+func (switches *ControlSwitches) GetVirtualNodeSelector() labels.Selector {
+	return switches.virtualNodeSelector
+}
+
+// GetVirtualNodeTolerations returns tolerations of virtual node taints
+func (switches *ControlSwitches) GetVirtualNodeTolerations() []corev1.Toleration {
+	return switches.virtualTolerations
+}
+
+// GetVirtualNodePrefixes returns prefixes of virtual node names
+func (switches *ControlSwitches) GetVirtualNodePrefixes() []string {
+	return switches.virtualNodePrefixes
+}
+
+// IsResourceNameAllowed returns true when resource name starts with one of the allowed prefixes, any resource name
+// is allowed when there are no allowed prefixes
+func (switches *ControlSwitches) IsResourceNameAllowed(resourceName string) bool {
+	if len(switches.allowedResourcePrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range switches.allowedResourcePrefixes {
+		if strings.HasPrefix(resourceName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDisallowedResourceAction returns action taken when network requests resource outside of allowed prefixes
+func (switches *ControlSwitches) GetDisallowedResourceAction() string {
+	return switches.disallowedResourceAction
+}
+
+// GetPartialResourcesAction returns action taken when container sets only request or only limit of resource
+// requested by its networks
+func (switches *ControlSwitches) GetPartialResourcesAction() string {
+	return switches.partialResourcesAction
+}
+
+// GetNodeSelectorConflictAction returns action taken when pod node selector sets label of net-attach-def node
+// selector to other value
+func (switches *ControlSwitches) GetNodeSelectorConflictAction() string {
+	return switches.nodeSelectorConflict
+}
+
+// GetNoContainersAction returns action taken when pod has no containers
+func (switches *ControlSwitches) GetNoContainersAction() string {
+	return switches.noContainersAction
+}
+
+// GetDefaultNetworksAction returns action taken when default network annotation selects more networks
+func (switches *ControlSwitches) GetDefaultNetworksAction() string {
+	return switches.defaultNetworksAction
+}
+
+// GetMaxResourceCount returns maximal count of every resource injected into pod, 0 when unlimited
+func (switches *ControlSwitches) GetMaxResourceCount() int64 {
+	return switches.maxResourceCount
+}
+
+// GetResourceCapAction returns action taken when pod networks request resource more times than allowed
+func (switches *ControlSwitches) GetResourceCapAction() string {
+	return switches.resourceCapAction
+}
+
+// GetServerErrorAction returns action taken when pod cannot be processed because of the webhook or API server failure
+func (switches *ControlSwitches) GetServerErrorAction() string {
+	return switches.serverErrorAction
+}
+
+// GetNamespaceLabel returns key of the namespace label enabling injection, empty when all namespaces are enabled
+func (switches *ControlSwitches) GetNamespaceLabel() string {
+	return switches.namespaceLabel
+}
+
+// GetInjectionFinalizer returns finalizer added to pods with injected resources, empty when disabled
+func (switches *ControlSwitches) GetInjectionFinalizer() string {
+	return switches.injectionFinalizer
+}
+
+// GetCompanionResources returns resources which have to be requested along with the given resource
+func (switches *ControlSwitches) GetCompanionResources(resourceName string) []CompanionResource {
+	return switches.companionResources[resourceName]
+}
+
+// GetQuantityFormat returns format of quantities of the injected resource
+func (switches *ControlSwitches) GetQuantityFormat(resourceName string) resource.Format {
+	if format, exists := switches.quantityFormats[resourceName]; exists {
+		return format
+	}
+	if format, exists := switches.quantityFormats[""]; exists {
+		return format
+	}
+	return resource.DecimalSI
+}
+
+// GetResourceClaimNetwork returns [namespace/]network mapped to the resource claim or resource claim template,
+// empty when the claim is not mapped
+func (switches *ControlSwitches) GetResourceClaimNetwork(claim string) string {
+	return switches.resourceClaimNetworks[claim]
+}
+
+// GetLabelSelectorNetworks returns networks implicitly selected by pod with the labels, in order of the label
+// selectors, every network is returned once
+func (switches *ControlSwitches) GetLabelSelectorNetworks(podLabels labels.Set) []string {
+	var networks []string
+	selected := make(map[string]bool)
+	for _, selectorNetwork := range switches.labelSelectorNetworks {
+		if selectorNetwork.selector.Matches(podLabels) && !selected[selectorNetwork.Network] {
+			selected[selectorNetwork.Network] = true
+			networks = append(networks, selectorNetwork.Network)
+		}
+	}
+	return networks
+}
+
+// GetRuntimeClassOverride returns injection behavior overridden for pods of the runtime class, false when the runtime
+// class is not overridden
+func (switches *ControlSwitches) GetRuntimeClassOverride(runtimeClass string) (RuntimeClassOverride, bool) {
+	override, exists := switches.runtimeClassOverrides[runtimeClass]
+	return override, exists
+}
+
+// GetTolerations returns tolerations added to pods with injected resources
+func (switches *ControlSwitches) GetTolerations() []corev1.Toleration {
+	return switches.tolerations
+}
+
+// IsTargetContainerImage returns true when the whole image matches one of the target container images
+func (switches *ControlSwitches) IsTargetContainerImage(image string) bool {
+	for _, re := range switches.targetContainerImagesRe {
+		if re.MatchString(image) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNadLookupRetries returns number of retries of net-attach-def lookup failed with transient API server error
+func (switches *ControlSwitches) GetNadLookupRetries() int {
+	return switches.nadLookupRetries
+}
+
+// GetNadLookupRetryDelay returns delay before the first retry of net-attach-def lookup
+func (switches *ControlSwitches) GetNadLookupRetryDelay() time.Duration {
+	return switches.nadLookupRetryDelay
+}
+
+// GetLookupTimeout returns time after which API server lookups of a request are cancelled, 0 when only the webhook
+// timeout applies
+func (switches *ControlSwitches) GetLookupTimeout() time.Duration {
+	return switches.lookupTimeout
+}
+
+// GetLookupRateLimit returns API server lookups per second allowed for every request namespace, 0 when not throttled
+func (switches *ControlSwitches) GetLookupRateLimit() float64 {
+	return switches.lookupRateLimit
+}
+
+// GetLookupRateBurst returns number of API server lookups allowed at once for every request namespace
+func (switches *ControlSwitches) GetLookupRateBurst() int {
+	return switches.lookupRateBurst
+}
+
+// GetLookupWarningFraction returns fraction of the webhook timeout spent in net-attach-def lookups of a request after
+// which a warning is logged, 0 when no warning is logged
+func (switches *ControlSwitches) GetLookupWarningFraction() float64 {
+	return switches.lookupWarningFraction
+}
+
+// GetMaxConcurrentAdmissions returns number of admission requests mutated at once, 0 when not limited
+func (switches *ControlSwitches) GetMaxConcurrentAdmissions() int {
+	return switches.maxConcurrentAdmissions
+}
+
+// GetAdmissionQueueTimeout returns time admission request above the concurrency limit waits for a running one to
+// complete, 0 when it is denied at once
+func (switches *ControlSwitches) GetAdmissionQueueTimeout() time.Duration {
+	return switches.admissionQueueTimeout
+}
+
+// IsRequestBodyStreamingEnabled returns true when AdmissionReview request body should be decoded as a stream
+func (switches *ControlSwitches) IsRequestBodyStreamingEnabled() bool {
+	return switches.streamRequestBody
+}
+
+// GetRequestBodyLimit returns maximal size of AdmissionReview request body
+func (switches *ControlSwitches) GetRequestBodyLimit() int64 {
+	if switches.streamRequestBody {
+		return switches.streamedRequestBodyLimit
+	}
+	return DefaultRequestBodyLimit
+}
+
+// GetHonorResourcesPolicy returns how honored existing resources are combined with injected ones
+func (switches *ControlSwitches) GetHonorResourcesPolicy() string {
+	return switches.honorResourcesPolicy
+}
+
+// GetFallbackNamespace returns namespace of pod used when it cannot be determined from the request
+func (switches *ControlSwitches) GetFallbackNamespace() string {
+	return switches.fallbackNamespace
+}
+
+// GetAnnotationDomain returns domain prefix of pod annotations owned by the injector
+func (switches *ControlSwitches) GetAnnotationDomain() string {
+	return switches.annotationDomain
+}
+
+// GetDownwardAPIVolumeName returns name of the injected Downward API volume
+func (switches *ControlSwitches) GetDownwardAPIVolumeName() string {
+	return switches.downwardAPIVolumeName
+}
+
+// GetRenamedDownwardAPIVolumeName returns name of the injected Downward API volume used when pod defines other
+// volume with the configured name
+func (switches *ControlSwitches) GetRenamedDownwardAPIVolumeName() string {
+	return switches.downwardAPIVolumeName + renamedDownwardAPIVolumeSuffix
+}
+
+// GetDownwardAPIMountPath returns path at which the Downward API volume is mounted into containers
+func (switches *ControlSwitches) GetDownwardAPIMountPath() string {
+	return switches.downwardAPIMountPath
+}
+
+// GetWindowsDownwardAPIMountPath returns path at which the Downward API volume is mounted into containers of Windows pods
+func (switches *ControlSwitches) GetWindowsDownwardAPIMountPath() string {
+	return switches.windowsMountPath
+}
+
+// GetHugepageMountPath returns path at which the hugepage volume is mounted into containers
+func (switches *ControlSwitches) GetHugepageMountPath() string {
+	return switches.hugepageMountPath
+}
+
+// GetTokenMountPath returns path at which the service account token volume is mounted into containers
+func (switches *ControlSwitches) GetTokenMountPath() string {
+	return switches.tokenMountPath
+}
+
+// GetTokenExpiration returns requested lifetime of the injected service account tokens
+func (switches *ControlSwitches) GetTokenExpiration() time.Duration {
+	return switches.tokenExpiration
+}
+
+// GetNadNotFoundMessage returns message denying pod selecting net-attach-def namespace/name which does not exist
+func (switches *ControlSwitches) GetNadNotFoundMessage(namespace, name string, cause error) string {
+	message := switches.nadNotFoundMessage
+	if message == nil {
+		message, _ = parseNadNotFoundMessage(DefaultNadNotFoundMessage)
+	}
+	data := NadNotFoundMessageData{Namespace: namespace, Name: name}
+	if cause != nil {
+		data.Error = cause.Error()
+	}
+	var rendered strings.Builder
+	if err := message.Execute(&rendered, data); err != nil {
+		return fmt.Sprintf("could not find network attachment definition '%s/%s': %v", namespace, name, cause)
+	}
+	return rendered.String()
+}
+
+// GetPodNetInfoConflict returns action taken when pod defines podnetinfo volume which is not a Downward API volume
+func (switches *ControlSwitches) GetPodNetInfoConflict() string {
+	return switches.podNetInfoConflict
+}
+
+func (switches *ControlSwitches) GetExtendedResourcePatchMode() string {
+	return switches.extendedResourcePatchMode
+}
+
 func (switches *ControlSwitches) IsHugePagedownAPIEnabled() bool {
 	return switches.configuration[enableHugePageDownAPIKey].active
 }
@@ -114,6 +1750,126 @@ func (switches *ControlSwitches) IsHonorExistingResourcesEnabled() bool {
 	return switches.configuration[enableHonorExistingResourcesKey].active
 }
 
+func (switches *ControlSwitches) IsInjectIntoInitContainersEnabled() bool {
+	return switches.configuration[injectIntoInitContainersKey].active
+}
+
+func (switches *ControlSwitches) IsResourceNameOverrideEnabled() bool {
+	return switches.configuration[enableResourceNameOverrideKey].active
+}
+
+func (switches *ControlSwitches) IsWorkloadControllersEnabled() bool {
+	return switches.configuration[enableWorkloadControllersKey].active
+}
+
+func (switches *ControlSwitches) IsInjectedResourcesAnnotationEnabled() bool {
+	return switches.configuration[enableInjectedResourcesAnnotationKey].active
+}
+
+func (switches *ControlSwitches) IsSkipReasonWarningsEnabled() bool {
+	return switches.configuration[enableSkipReasonWarningsKey].active
+}
+
+func (switches *ControlSwitches) IsTopologyHintsEnabled() bool {
+	return switches.configuration[enableTopologyHintsKey].active
+}
+
+func (switches *ControlSwitches) IsNetworkDeduplicationEnabled() bool {
+	return switches.configuration[enableNetworkDeduplicationKey].active
+}
+
+func (switches *ControlSwitches) IsDenyUnresolvedNamespaceEnabled() bool {
+	return switches.configuration[denyUnresolvedNamespaceKey].active
+}
+
+func (switches *ControlSwitches) IsDenialEventsEnabled() bool {
+	return switches.configuration[enableDenialEventsKey].active
+}
+
+func (switches *ControlSwitches) IsInjectIntoEphemeralContainersEnabled() bool {
+	return switches.configuration[injectIntoEphemeralContainersKey].active
+}
+
+func (switches *ControlSwitches) IsStrategicMergePatchEnabled() bool {
+	return switches.configuration[enableStrategicMergePatchKey].active
+}
+
+func (switches *ControlSwitches) IsSourceNadsAnnotationEnabled() bool {
+	return switches.configuration[enableSourceNadsAnnotationKey].active
+}
+
+func (switches *ControlSwitches) IsValidatePatchEnabled() bool {
+	return switches.configuration[enableValidatePatchKey].active
+}
+
+func (switches *ControlSwitches) IsResourceClaimsEnabled() bool {
+	return switches.configuration[enableResourceClaimsKey].active
+}
+
+func (switches *ControlSwitches) IsInjectDownwardAPIVolumeEnabled() bool {
+	return switches.configuration[injectDownwardAPIVolumeKey].active
+}
+
+func (switches *ControlSwitches) IsConfigResourceNameEnabled() bool {
+	return switches.configuration[enableConfigResourceNameKey].active
+}
+
+func (switches *ControlSwitches) IsIdempotencyEnabled() bool {
+	return switches.configuration[enableIdempotencyKey].active
+}
+
+func (switches *ControlSwitches) IsNodeAffinityAnnotationEnabled() bool {
+	return switches.configuration[enableNodeAffinityAnnotationKey].active
+}
+
+func (switches *ControlSwitches) IsSkipUnresolvedNamespaceEnabled() bool {
+	return switches.configuration[skipUnresolvedNamespaceKey].active
+}
+
+func (switches *ControlSwitches) IsRequireOptInEnabled() bool {
+	return switches.configuration[requireOptInKey].active
+}
+
+func (switches *ControlSwitches) IsDownwardAPIMountResourceContainersOnlyEnabled() bool {
+	return switches.configuration[downwardAPIMountResourceContainersOnlyKey].active
+}
+
+func (switches *ControlSwitches) IsOwnerNetworkAnnotationEnabled() bool {
+	return switches.configuration[ownerNetworkAnnotationKey].active
+}
+
+func (switches *ControlSwitches) IsInjectHugepageVolumeEnabled() bool {
+	return switches.configuration[injectHugepageVolumeKey].active
+}
+
+func (switches *ControlSwitches) IsRemoveResourcesEnabled() bool {
+	return switches.configuration[removeResourcesKey].active
+}
+
+func (switches *ControlSwitches) IsPodLevelResourcesEnabled() bool {
+	return switches.configuration[podLevelResourcesKey].active
+}
+
+func (switches *ControlSwitches) IsInjectIntoAllContainersEnabled() bool {
+	return switches.configuration[injectIntoAllContainersKey].active
+}
+
+func (switches *ControlSwitches) IsDenyUserDefinedInjectionFailureEnabled() bool {
+	return switches.configuration[denyUserDefinedInjectionFailureKey].active
+}
+
+func (switches *ControlSwitches) IsBestEffortInjectionEnabled() bool {
+	return switches.configuration[bestEffortInjectionKey].active
+}
+
+func (switches *ControlSwitches) IsCreateResourcesIfAbsentEnabled() bool {
+	return switches.configuration[createResourcesIfAbsentKey].active
+}
+
+func (switches *ControlSwitches) IsResolveNetworkAliasesEnabled() bool {
+	return switches.configuration[resolveNetworkAliasesKey].active
+}
+
 func (switches *ControlSwitches) IsResourcesNameEnabled() bool {
 	return len(*switches.resourceNameKeysFlag) > 0
 }
@@ -130,19 +1886,118 @@ func (switches *ControlSwitches) GetAllFeaturesState() string {
 	output = fmt.Sprintf("HugePageInject: %t", switches.IsHugePagedownAPIEnabled())
 	output = output + " / " + fmt.Sprintf("HonorExistingResources: %t", switches.IsHonorExistingResourcesEnabled())
 	output = output + " / " + fmt.Sprintf("EnableResourceNames: %t", switches.IsResourcesNameEnabled())
+	output = output + " / " + fmt.Sprintf("InjectIntoInitContainers: %t", switches.IsInjectIntoInitContainersEnabled())
+	output = output + " / " + fmt.Sprintf("ResourceNameOverride: %t", switches.IsResourceNameOverrideEnabled())
+	output = output + " / " + fmt.Sprintf("WorkloadControllers: %t", switches.IsWorkloadControllersEnabled())
+	output = output + " / " + fmt.Sprintf("InjectedResourcesAnnotation: %t", switches.IsInjectedResourcesAnnotationEnabled())
+	output = output + " / " + fmt.Sprintf("SkipReasonWarnings: %t", switches.IsSkipReasonWarningsEnabled())
+	output = output + " / " + fmt.Sprintf("TopologyHints: %t", switches.IsTopologyHintsEnabled())
+	output = output + " / " + fmt.Sprintf("NetworkDeduplication: %t", switches.IsNetworkDeduplicationEnabled())
+	output = output + " / " + fmt.Sprintf("DenyUnresolvedNamespace: %t", switches.IsDenyUnresolvedNamespaceEnabled())
+	output = output + " / " + fmt.Sprintf("DenialEvents: %t", switches.IsDenialEventsEnabled())
+	output = output + " / " + fmt.Sprintf("InjectIntoEphemeralContainers: %t", switches.IsInjectIntoEphemeralContainersEnabled())
+	output = output + " / " + fmt.Sprintf("StrategicMergePatch: %t", switches.IsStrategicMergePatchEnabled())
+	output = output + " / " + fmt.Sprintf("SourceNadsAnnotation: %t", switches.IsSourceNadsAnnotationEnabled())
+	output = output + " / " + fmt.Sprintf("ValidatePatch: %t", switches.IsValidatePatchEnabled())
+	output = output + " / " + fmt.Sprintf("ResourceClaims: %t", switches.IsResourceClaimsEnabled())
+	output = output + " / " + fmt.Sprintf("InjectDownwardAPIVolume: %t", switches.IsInjectDownwardAPIVolumeEnabled())
+	output = output + " / " + fmt.Sprintf("ConfigResourceName: %t", switches.IsConfigResourceNameEnabled())
+	output = output + " / " + fmt.Sprintf("Idempotency: %t", switches.IsIdempotencyEnabled())
+	output = output + " / " + fmt.Sprintf("NodeAffinityAnnotation: %t", switches.IsNodeAffinityAnnotationEnabled())
+	output = output + " / " + fmt.Sprintf("SkipUnresolvedNamespace: %t", switches.IsSkipUnresolvedNamespaceEnabled())
+	output = output + " / " + fmt.Sprintf("RequireOptIn: %t", switches.IsRequireOptInEnabled())
+	output = output + " / " + fmt.Sprintf("DownwardAPIMountResourceContainersOnly: %t", switches.IsDownwardAPIMountResourceContainersOnlyEnabled())
+	output = output + " / " + fmt.Sprintf("OwnerNetworkAnnotation: %t", switches.IsOwnerNetworkAnnotationEnabled())
+	output = output + " / " + fmt.Sprintf("InjectHugepageVolume: %t", switches.IsInjectHugepageVolumeEnabled())
+	output = output + " / " + fmt.Sprintf("RemoveResources: %t", switches.IsRemoveResourcesEnabled())
+	output = output + " / " + fmt.Sprintf("PodLevelResources: %t", switches.IsPodLevelResourcesEnabled())
+	output = output + " / " + fmt.Sprintf("InjectIntoAllContainers: %t", switches.IsInjectIntoAllContainersEnabled())
+	output = output + " / " + fmt.Sprintf("DenyUserDefinedInjectionFailure: %t", switches.IsDenyUserDefinedInjectionFailureEnabled())
+	output = output + " / " + fmt.Sprintf("BestEffortInjection: %t", switches.IsBestEffortInjectionEnabled())
+	output = output + " / " + fmt.Sprintf("CreateResourcesIfAbsent: %t", switches.IsCreateResourcesIfAbsentEnabled())
+	output = output + " / " + fmt.Sprintf("ResolveNetworkAliases: %t", switches.IsResolveNetworkAliasesEnabled())
+	output = output + " / " + fmt.Sprintf("ExtendedResourcePatchMode: %s", switches.GetExtendedResourcePatchMode())
 
 	return output
 }
 
+// GetConfig returns the effective configuration, features are reported in their active state
+func (switches *ControlSwitches) GetConfig() Config {
+	features := make(map[string]bool, len(switches.configuration))
+	for featureName, state := range switches.configuration {
+		features[featureName] = state.active
+	}
+
+	skipFieldSelector := ""
+	if switches.skipFieldSelector != nil {
+		skipFieldSelector = switches.skipFieldSelector.String()
+	}
+	virtualNodeSelector := ""
+	if switches.virtualNodeSelector != nil {
+		virtualNodeSelector = switches.virtualNodeSelector.String()
+	}
+
+	return Config{
+		Features:                  features,
+		ResourceNameKeys:          switches.resourceNameKeys,
+		NetworkAnnotationKeys:     switches.networkAnnotationKeys,
+		AnnotationDomain:          switches.annotationDomain,
+		ExtendedResourcePatchMode: switches.extendedResourcePatchMode,
+		AllowedCNITypes:           switches.allowedCNITypes,
+		AllowedResourcePrefixes:   switches.allowedResourcePrefixes,
+		DisallowedResourceAction:  switches.disallowedResourceAction,
+		PartialResourcesAction:    switches.partialResourcesAction,
+		NodeSelectorConflict:      switches.nodeSelectorConflict,
+		NoContainersAction:        switches.noContainersAction,
+		DefaultNetworksAction:     switches.defaultNetworksAction,
+		MaxResourceCount:          switches.maxResourceCount,
+		ResourceCapAction:         switches.resourceCapAction,
+		ServerErrorAction:         switches.serverErrorAction,
+		SkippedOwners:             switches.skippedOwners,
+		IgnoredNamespaces:         switches.ignoredNamespaces,
+		OwnerKinds:                switches.ownerKinds,
+		SkipFieldSelector:         skipFieldSelector,
+		VirtualNodeSelector:       virtualNodeSelector,
+		VirtualNodeTolerations:    switches.virtualTolerations,
+		VirtualNodePrefixes:       switches.virtualNodePrefixes,
+		NamespaceLabel:            switches.namespaceLabel,
+		FallbackNamespace:         switches.fallbackNamespace,
+		PodNetInfoConflict:        switches.podNetInfoConflict,
+		DownwardAPIVolumeName:     switches.downwardAPIVolumeName,
+		DownwardAPIMountPath:      switches.downwardAPIMountPath,
+		WindowsDownwardAPIPath:    switches.windowsMountPath,
+		HugepageMountPath:         switches.hugepageMountPath,
+		TokenMountPath:            switches.tokenMountPath,
+		TokenExpiration:           switches.tokenExpiration.String(),
+		NadNotFoundMessage:        switches.nadNotFoundMessageText,
+		StreamRequestBody:         switches.streamRequestBody,
+		RequestBodyLimit:          switches.GetRequestBodyLimit(),
+		NadLookupRetries:          switches.nadLookupRetries,
+		NadLookupRetryDelay:       switches.nadLookupRetryDelay.String(),
+		LookupTimeout:             switches.lookupTimeout.String(),
+		LookupRateLimit:           switches.lookupRateLimit,
+		LookupRateBurst:           switches.lookupRateBurst,
+		LookupWarningFraction:     switches.lookupWarningFraction,
+		MaxConcurrentAdmissions:   switches.maxConcurrentAdmissions,
+		AdmissionQueueTimeout:     switches.admissionQueueTimeout.String(),
+		CompanionResources:        switches.companionResources,
+		ResourceQuantityFormat:    switches.resourceQuantityFormat,
+		ResourceClaimNetworks:     switches.resourceClaimNetworks,
+		LabelSelectorNetworks:     switches.labelSelectorNetworks,
+		RuntimeClassOverrides:     switches.runtimeClassOverrides,
+		TargetContainerImages:     switches.targetContainerImages,
+		Tolerations:               switches.tolerations,
+		InjectionFinalizer:        switches.injectionFinalizer,
+		HonorResourcesPolicy:      switches.honorResourcesPolicy,
+	}
+}
+
 // setAllFeaturesToInitialState - reset feature state to initial one set during NRI initialization
 func (switches *ControlSwitches) setAllFeaturesToInitialState() {
-	state := switches.configuration[enableHugePageDownAPIKey]
-	state.setActiveToInitialState()
-	switches.configuration[enableHugePageDownAPIKey] = state
-
-	state = switches.configuration[enableHonorExistingResourcesKey]
-	state.setActiveToInitialState()
-	switches.configuration[enableHonorExistingResourcesKey] = state
+	for featureName, state := range switches.configuration {
+		state.setActiveToInitialState()
+		switches.configuration[featureName] = state
+	}
 }
 
 // setFeatureToState set given feature to the state defined in the map object
@@ -158,6 +2013,38 @@ func (switches *ControlSwitches) setFeatureToState(featureName string, switchObj
 	}
 }
 
+// ValidateControlSwitchesConfigMap returns error when features of the config map cannot be parsed or refer to unknown
+// feature names, features which are not valid are ignored when the config map is processed
+func (switches *ControlSwitches) ValidateControlSwitchesConfigMap(controlSwitchesCm *corev1.ConfigMap) error {
+	v, fileExists := controlSwitchesCm.Data[types.ConfigMapMainFileKey]
+	if !fileExists {
+		return nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(v), &obj); err != nil {
+		return fmt.Errorf("invalid %s: %v", types.ConfigMapMainFileKey, err)
+	}
+	controlSwitches, mainExists := obj[controlSwitchesMainKey]
+	if !mainExists {
+		return nil
+	}
+	var switchObj map[string]bool
+	if err := json.Unmarshal(controlSwitches, &switchObj); err != nil {
+		return fmt.Errorf("invalid [%s]: %v", controlSwitchesMainKey, err)
+	}
+	var unknown []string
+	for featureName := range switchObj {
+		if _, exists := switches.configuration[featureName]; !exists {
+			unknown = append(unknown, featureName)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown features in [%s]: %s", controlSwitchesMainKey, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
 // ProcessControlSwitchesConfigMap sets on the fly control switches
 // :param controlSwitchesCm - Kubernetes ConfigMap with control switches definition
 func (switches *ControlSwitches) ProcessControlSwitchesConfigMap(controlSwitchesCm *corev1.ConfigMap) {
@@ -180,8 +2067,9 @@ func (switches *ControlSwitches) ProcessControlSwitchesConfigMap(controlSwitches
 				return
 			}
 
-			switches.setFeatureToState(enableHugePageDownAPIKey, switchObj)
-			switches.setFeatureToState(enableHonorExistingResourcesKey, switchObj)
+			for featureName := range switches.configuration {
+				switches.setFeatureToState(featureName, switchObj)
+			}
 		} else {
 			glog.Warningf("Map does not contains [%s]", controlSwitchesMainKey)
 		}
@@ -15,9 +15,18 @@
 package controlswitches
 
 import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -219,6 +228,1086 @@ var _ = Describe("Verify controlswitches package", func() {
 		})
 	})
 
+	Describe("Inject into init containers", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Feature disabled when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsInjectIntoInitContainersEnabled()).Should(Equal(false))
+		})
+
+		It("Feature set by flag and toggled by config map", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.injectIntoInitContainers = createBool(true)
+			structure.InitControlSwitches()
+
+			Expect(structure.IsInjectIntoInitContainersEnabled()).Should(Equal(true))
+
+			cm := corev1.ConfigMap{
+				Data: map[string]string{"config.json": `{"features": {"injectIntoInitContainers": false}}`},
+			}
+			structure.ProcessControlSwitchesConfigMap(&cm)
+			Expect(structure.IsInjectIntoInitContainersEnabled()).Should(Equal(false))
+			Expect(structure.configuration[injectIntoInitContainersKey].initial).Should(Equal(true))
+		})
+	})
+
+	Describe("Extended resource patch mode", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Both requests and limits when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetExtendedResourcePatchMode()).Should(Equal(ExtendedResourcePatchModeBoth))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Mode set by flag", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.extendedResourcePatchModeFlag = createString(ExtendedResourcePatchModeLimitsOnly)
+			structure.InitControlSwitches()
+
+			Expect(structure.GetExtendedResourcePatchMode()).Should(Equal(ExtendedResourcePatchModeLimitsOnly))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Unknown mode is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.extendedResourcePatchModeFlag = createString("limits")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Podnetinfo volume conflict", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Rename when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetPodNetInfoConflict()).Should(Equal(PodNetInfoConflictRename))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Unknown action is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.podNetInfoConflictFlag = createString("ignore")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+
+		It("Default Downward API volume when flags are not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetDownwardAPIVolumeName()).Should(Equal("podnetinfo"))
+			Expect(structure.GetRenamedDownwardAPIVolumeName()).Should(Equal("podnetinfo-nri"))
+			Expect(structure.GetDownwardAPIMountPath()).Should(Equal("/etc/podnetinfo"))
+			Expect(structure.GetWindowsDownwardAPIMountPath()).Should(Equal(`C:\etc\podnetinfo`))
+		})
+
+		DescribeTable("Downward API volume validation",
+			func(name, mountPath string, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.downwardAPIVolumeNameFlag = createString(name)
+				structure.downwardAPIMountPathFlag = createString(mountPath)
+				structure.InitControlSwitches()
+
+				if valid {
+					Expect(structure.ValidateControlSwitches()).Should(Succeed())
+				} else {
+					Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+				}
+			},
+			Entry("custom name and path", "netinfo", "/var/run/netinfo", true),
+			Entry("invalid name", "Net_Info", "/var/run/netinfo", false),
+			Entry("name too long to be renamed", strings.Repeat("a", 60), "/var/run/netinfo", false),
+			Entry("relative path", "netinfo", "var/run/netinfo", false),
+			Entry("root path", "netinfo", "/", false),
+		)
+
+		DescribeTable("Windows Downward API mount path validation",
+			func(mountPath string, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.windowsMountPathFlag = createString(mountPath)
+				structure.InitControlSwitches()
+
+				if valid {
+					Expect(structure.ValidateControlSwitches()).Should(Succeed())
+					Expect(structure.GetWindowsDownwardAPIMountPath()).Should(Equal(mountPath))
+				} else {
+					Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+				}
+			},
+			Entry("default path", `C:\etc\podnetinfo`, true),
+			Entry("other drive", `d:\podnetinfo\`, true),
+			Entry("Linux path", "/etc/podnetinfo", false),
+			Entry("relative path", `etc\podnetinfo`, false),
+			Entry("drive relative path", `C:etc\podnetinfo`, false),
+			Entry("drive root", `C:\`, false),
+			Entry("invalid characters", `C:\etc\pod*netinfo`, false),
+		)
+
+		DescribeTable("Hugepage mount path validation",
+			func(mountPath string, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.hugepageMountPathFlag = createString(mountPath)
+				structure.InitControlSwitches()
+
+				if valid {
+					Expect(structure.ValidateControlSwitches()).Should(Succeed())
+				} else {
+					Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+				}
+			},
+			Entry("default path", "/hugepages", true),
+			Entry("custom path", "/dev/hugepages", true),
+			Entry("relative path", "hugepages", false),
+			Entry("root path", "/", false),
+			Entry("Downward API mount path", "/etc/podnetinfo/", false),
+		)
+
+		DescribeTable("Token mount path and expiration validation",
+			func(mountPath string, expiration time.Duration, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.tokenMountPathFlag = createString(mountPath)
+				structure.tokenExpirationFlag = &expiration
+				structure.InitControlSwitches()
+
+				if valid {
+					Expect(structure.ValidateControlSwitches()).Should(Succeed())
+				} else {
+					Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+				}
+			},
+			Entry("defaults", "/var/run/secrets/cni", time.Hour, true),
+			Entry("shortest expiration", "/var/run/secrets/cni", 10*time.Minute, true),
+			Entry("relative path", "secrets/cni", time.Hour, false),
+			Entry("root path", "/", time.Hour, false),
+			Entry("Downward API mount path", "/etc/podnetinfo", time.Hour, false),
+			Entry("hugepage mount path", "/hugepages/", time.Hour, false),
+			Entry("too short expiration", "/var/run/secrets/cni", 5*time.Minute, false),
+		)
+	})
+
+	Describe("Companion resources", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Companion resources parsed from flag", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.companionResourcesFlag = createString("intel.com/sriov=intel.com/mgmt, intel.com/sriov=intel.com/aux:2")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.GetCompanionResources("intel.com/sriov")).Should(Equal([]CompanionResource{
+				{ResourceName: "intel.com/mgmt", Ratio: 1},
+				{ResourceName: "intel.com/aux", Ratio: 2},
+			}))
+			Expect(structure.GetCompanionResources("intel.com/mgmt")).Should(BeEmpty())
+		})
+
+		It("Invalid ratio is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.companionResourcesFlag = createString("intel.com/sriov=intel.com/mgmt:0")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Resource quantity format", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("DecimalSI format by default", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.GetQuantityFormat("intel.com/sriov")).Should(Equal(resource.DecimalSI))
+		})
+
+		It("Format of all resources overridden per resource", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.resourceQuantityFormatFlag = createString("BinarySI, intel.com/sriov=DecimalExponent")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.GetQuantityFormat("intel.com/sriov")).Should(Equal(resource.DecimalExponent))
+			Expect(structure.GetQuantityFormat("intel.com/mgmt")).Should(Equal(resource.BinarySI))
+		})
+
+		DescribeTable("Quantity format validation",
+			func(value string, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.resourceQuantityFormatFlag = createString(value)
+				structure.InitControlSwitches()
+
+				if valid {
+					Expect(structure.ValidateControlSwitches()).Should(Succeed())
+				} else {
+					Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+				}
+			},
+			Entry("format of all resources", "BinarySI", true),
+			Entry("format of resource only", "intel.com/sriov=BinarySI", true),
+			Entry("empty", "", true),
+			Entry("unknown format", "Binary", false),
+			Entry("unknown format of resource", "intel.com/sriov=binarysi", false),
+			Entry("format of all resources after resource", "intel.com/sriov=BinarySI,DecimalSI", false),
+			Entry("invalid resource name", "intel.com/sriov vf=BinarySI", false),
+		)
+	})
+
+	Describe("Honor resources policy", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Sum when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(true), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetHonorResourcesPolicy()).Should(Equal(HonorResourcesPolicySum))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Unknown policy is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(true), createString(""))
+			structure.honorResourcesPolicyFlag = createString("min")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Fallback namespace", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Default namespace when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetFallbackNamespace()).Should(Equal(DefaultFallbackNamespace))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Invalid namespace is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.fallbackNamespaceFlag = createString("Not_A_Namespace")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Annotation domain", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Default domain when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetAnnotationDomain()).Should(Equal(DefaultAnnotationDomain))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Custom domain is accepted", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.annotationDomainFlag = createString(" nri.example.com ")
+			structure.InitControlSwitches()
+
+			Expect(structure.GetAnnotationDomain()).Should(Equal("nri.example.com"))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Invalid domain is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.annotationDomainFlag = createString("example.com/nri")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Allowed resource prefixes", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Any resource is allowed when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsResourceNameAllowed("example.com/anything")).Should(BeTrue())
+			Expect(structure.GetDisallowedResourceAction()).Should(Equal(DisallowedResourceSkip))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Only resources with allowed prefixes are allowed", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.allowedResourcePrefixesFlag = createString("openshift.io/, intel.com/")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsResourceNameAllowed("intel.com/sriov")).Should(BeTrue())
+			Expect(structure.IsResourceNameAllowed("openshift.io/sriov")).Should(BeTrue())
+			Expect(structure.IsResourceNameAllowed("example.com/intel.com/sriov")).Should(BeFalse())
+		})
+
+		It("Unknown action is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.disallowedResourceActionFlag = createString("ignore")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Partial resources action", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Default action when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetPartialResourcesAction()).Should(Equal(PartialResourcesComplete))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Unknown action is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.partialResourcesActionFlag = createString("skip")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Node selector conflict action", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Default action when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetNodeSelectorConflictAction()).Should(Equal(NodeSelectorConflictOverride))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Unknown action is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.nodeSelectorConflictFlag = createString("merge")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("No containers action", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Default action when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetNoContainersAction()).Should(Equal(NoContainersAllow))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Unknown action is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.noContainersActionFlag = createString("skip")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Multiple default networks action", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Default action when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetDefaultNetworksAction()).Should(Equal(DefaultNetworksDeny))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("First network action is accepted", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.defaultNetworksActionFlag = createString(" first ")
+			structure.InitControlSwitches()
+
+			Expect(structure.GetDefaultNetworksAction()).Should(Equal(DefaultNetworksFirst))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Unknown action is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.defaultNetworksActionFlag = createString("last")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Resource count cap", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Unlimited when flags are not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetMaxResourceCount()).Should(BeZero())
+			Expect(structure.GetResourceCapAction()).Should(Equal(ResourceCapDeny))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Negative count is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			count := int64(-1)
+			structure.maxResourceCountFlag = &count
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+
+		It("Unknown action is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.resourceCapActionFlag = createString("skip")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Additional network annotation keys", func() {
+		It("should parse comma separated keys", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.networkAnnotationKeysFlag = createString(" example.com/networks, ,networks ")
+			structure.InitControlSwitches()
+			Expect(structure.ValidateControlSwitches()).To(Succeed())
+			Expect(structure.GetAdditionalNetworkAnnotationKeys()).To(Equal([]string{"example.com/networks", "networks"}))
+		})
+
+		It("should reject invalid key", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.networkAnnotationKeysFlag = createString("example.com/net works")
+			structure.InitControlSwitches()
+			Expect(structure.ValidateControlSwitches()).To(HaveOccurred())
+		})
+	})
+
+	Describe("Lookup rate limit", func() {
+		DescribeTable("should validate lookup rate limit and burst",
+			func(limit float64, burst int, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.lookupRateLimitFlag = &limit
+				structure.lookupRateBurstFlag = &burst
+				structure.InitControlSwitches()
+				if valid {
+					Expect(structure.ValidateControlSwitches()).To(Succeed())
+				} else {
+					Expect(structure.ValidateControlSwitches()).To(HaveOccurred())
+				}
+			},
+			Entry("disabled", 0.0, 0, true),
+			Entry("enabled", 5.0, 10, true),
+			Entry("negative limit", -1.0, 10, false),
+			Entry("enabled without burst", 5.0, 0, false),
+		)
+	})
+
+	Describe("Admission concurrency", func() {
+		It("should not limit admissions by default", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+			Expect(structure.GetMaxConcurrentAdmissions()).To(BeZero())
+			Expect(structure.GetAdmissionQueueTimeout()).To(BeZero())
+		})
+
+		DescribeTable("should validate max concurrent admissions and admission queue timeout",
+			func(limit int, timeout time.Duration, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.maxConcurrentAdmissionsFlag = &limit
+				structure.admissionQueueTimeoutFlag = &timeout
+				structure.InitControlSwitches()
+				if valid {
+					Expect(structure.ValidateControlSwitches()).To(Succeed())
+					Expect(structure.GetMaxConcurrentAdmissions()).To(Equal(limit))
+					Expect(structure.GetAdmissionQueueTimeout()).To(Equal(timeout))
+				} else {
+					Expect(structure.ValidateControlSwitches()).To(HaveOccurred())
+				}
+			},
+			Entry("not limited", 0, time.Duration(0), true),
+			Entry("denied at once", 50, time.Duration(0), true),
+			Entry("queued", 50, 2*time.Second, true),
+			Entry("negative limit", -1, time.Duration(0), false),
+			Entry("negative timeout", 50, -time.Second, false),
+		)
+	})
+
+	Describe("Lookup timeout", func() {
+		It("should not limit lookups by default", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+			Expect(structure.GetLookupTimeout()).To(BeZero())
+		})
+
+		DescribeTable("should validate lookup timeout",
+			func(timeout time.Duration, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.lookupTimeoutFlag = &timeout
+				structure.InitControlSwitches()
+				if valid {
+					Expect(structure.ValidateControlSwitches()).To(Succeed())
+					Expect(structure.GetLookupTimeout()).To(Equal(timeout))
+				} else {
+					Expect(structure.ValidateControlSwitches()).To(HaveOccurred())
+				}
+			},
+			Entry("webhook timeout", time.Duration(0), true),
+			Entry("shorter timeout", 3*time.Second, true),
+			Entry("negative timeout", -time.Second, false),
+		)
+	})
+
+	Describe("Lookup warning fraction", func() {
+		It("should warn about lookups taking half of the webhook timeout by default", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+			Expect(structure.GetLookupWarningFraction()).To(Equal(DefaultLookupWarningFraction))
+		})
+
+		DescribeTable("should validate lookup warning fraction",
+			func(fraction float64, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.lookupWarningFractionFlag = &fraction
+				structure.InitControlSwitches()
+				if valid {
+					Expect(structure.ValidateControlSwitches()).To(Succeed())
+					Expect(structure.GetLookupWarningFraction()).To(Equal(fraction))
+				} else {
+					Expect(structure.ValidateControlSwitches()).To(HaveOccurred())
+				}
+			},
+			Entry("disabled", 0.0, true),
+			Entry("fraction", 0.8, true),
+			Entry("whole timeout", 1.0, true),
+			Entry("negative fraction", -0.1, false),
+			Entry("more than timeout", 1.5, false),
+		)
+	})
+
+	Describe("Skipped owners", func() {
+		DescribeTable("should validate skipped owners",
+			func(owners string, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.skippedOwnersFlag = createString(owners)
+				structure.InitControlSwitches()
+				if valid {
+					Expect(structure.ValidateControlSwitches()).To(Succeed())
+				} else {
+					Expect(structure.ValidateControlSwitches()).To(HaveOccurred())
+				}
+			},
+			Entry("none", "", true),
+			Entry("kinds and names", "Job, DaemonSet/node-agent", true),
+			Entry("missing kind", "/node-agent", false),
+			Entry("invalid name", "DaemonSet/Node_Agent", false),
+			Entry("too many units", "DaemonSet/ns/node-agent", false),
+		)
+	})
+
+	Describe("Ignored namespaces", func() {
+		DescribeTable("should validate ignored namespaces",
+			func(namespaces string, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.ignoredNamespacesFlag = createString(namespaces)
+				structure.InitControlSwitches()
+				if valid {
+					Expect(structure.ValidateControlSwitches()).To(Succeed())
+				} else {
+					Expect(structure.ValidateControlSwitches()).To(HaveOccurred())
+				}
+			},
+			Entry("none", "", true),
+			Entry("namespaces", "kube-system, kube-public,kube-node-lease", true),
+			Entry("invalid namespace", "Kube_System", false),
+		)
+
+		It("should ignore only listed namespaces", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.ignoredNamespacesFlag = createString("kube-system,kube-public")
+			structure.InitControlSwitches()
+			Expect(structure.IsNamespaceIgnored("kube-public")).To(BeTrue())
+			Expect(structure.IsNamespaceIgnored("default")).To(BeFalse())
+		})
+	})
+
+	Describe("Virtual nodes", func() {
+		DescribeTable("should validate virtual node signature",
+			func(selector, tolerations, prefixes string, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.virtualNodeSelectorFlag = createString(selector)
+				structure.virtualTolerationsFlag = createString(tolerations)
+				structure.virtualNodePrefixesFlag = createString(prefixes)
+				structure.InitControlSwitches()
+				if valid {
+					Expect(structure.ValidateControlSwitches()).To(Succeed())
+				} else {
+					Expect(structure.ValidateControlSwitches()).To(HaveOccurred())
+				}
+			},
+			Entry("none", "", "", "", true),
+			Entry("full signature", "type=virtual-kubelet", "virtual-kubelet.io/provider:NoSchedule", "virtual-kubelet, vk-", true),
+			Entry("invalid selector", "type in (virtual", "", "", false),
+			Entry("invalid toleration", "", "virtual-kubelet.io/provider:Never", "", false),
+			Entry("invalid prefix", "", "", "Virtual_Kubelet", false),
+		)
+
+		It("should report the signature in configuration", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.virtualNodeSelectorFlag = createString("type=virtual-kubelet")
+			structure.virtualNodePrefixesFlag = createString("virtual-kubelet")
+			structure.InitControlSwitches()
+			Expect(structure.GetConfig().VirtualNodeSelector).To(Equal("type=virtual-kubelet"))
+			Expect(structure.GetConfig().VirtualNodePrefixes).To(Equal([]string{"virtual-kubelet"}))
+		})
+	})
+
+	Describe("Skip field selector", func() {
+		DescribeTable("should validate skip field selector",
+			func(selector string, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.skipFieldSelectorFlag = createString(selector)
+				structure.InitControlSwitches()
+				if valid {
+					Expect(structure.ValidateControlSwitches()).To(Succeed())
+				} else {
+					Expect(structure.ValidateControlSwitches()).To(HaveOccurred())
+				}
+			},
+			Entry("none", " ", true),
+			Entry("restart policy", "spec.restartPolicy=Never", true),
+			Entry("several fields", "spec.restartPolicy!=Always,spec.hostNetwork=true", true),
+			Entry("unsupported field", "metadata.name=test", false),
+			Entry("malformed", "spec.restartPolicy", false),
+		)
+
+		DescribeTable("should match pod fields",
+			func(selector string, podFields fields.Set, skipped bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.skipFieldSelectorFlag = createString(selector)
+				structure.InitControlSwitches()
+				Expect(structure.IsSkippedByFieldSelector(podFields)).To(Equal(skipped))
+			},
+			Entry("no selector", "", fields.Set{"spec.restartPolicy": "Never"}, false),
+			Entry("matching field", "spec.restartPolicy=Never", fields.Set{"spec.restartPolicy": "Never"}, true),
+			Entry("other value", "spec.restartPolicy=Never", fields.Set{"spec.restartPolicy": "Always"}, false),
+			Entry("all requirements match", "spec.restartPolicy=Never,spec.schedulerName!=default-scheduler",
+				fields.Set{"spec.restartPolicy": "Never", "spec.schedulerName": "batch"}, true),
+			Entry("one requirement does not match", "spec.restartPolicy=Never,spec.schedulerName!=default-scheduler",
+				fields.Set{"spec.restartPolicy": "Never", "spec.schedulerName": "default-scheduler"}, false),
+		)
+	})
+
+	Describe("Resource claim networks", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Resource claim networks parsed from flag", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.resourceClaimNetworksFlag = createString("sriov-claim=sriov-net, dpdk-template=infra/dpdk-net")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.GetResourceClaimNetwork("sriov-claim")).Should(Equal("sriov-net"))
+			Expect(structure.GetResourceClaimNetwork("dpdk-template")).Should(Equal("infra/dpdk-net"))
+			Expect(structure.GetResourceClaimNetwork("other-claim")).Should(BeEmpty())
+		})
+
+		DescribeTable("Invalid resource claim networks are rejected",
+			func(value string) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.resourceClaimNetworksFlag = createString(value)
+				structure.InitControlSwitches()
+
+				Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+			},
+			Entry("missing network", "sriov-claim"),
+			Entry("empty network", "sriov-claim="),
+			Entry("invalid claim name", "Sriov_Claim=sriov-net"),
+			Entry("too many path elements", "sriov-claim=a/b/c"),
+			Entry("claim mapped twice", "sriov-claim=sriov-net,sriov-claim=other-net"),
+		)
+	})
+
+	Describe("Runtime class overrides", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Runtime class overrides parsed from flag", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.runtimeClassOverridesFlag = createString("kata=skip-downward-api-volume;target-containers=vm-.*, gvisor=skip-downward-api-volume")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			override, exists := structure.GetRuntimeClassOverride("kata")
+			Expect(exists).Should(BeTrue())
+			Expect(override).Should(Equal(RuntimeClassOverride{SkipDownwardAPIVolume: true, TargetContainers: "vm-.*"}))
+			override, exists = structure.GetRuntimeClassOverride("gvisor")
+			Expect(exists).Should(BeTrue())
+			Expect(override).Should(Equal(RuntimeClassOverride{SkipDownwardAPIVolume: true}))
+			_, exists = structure.GetRuntimeClassOverride("runc")
+			Expect(exists).Should(BeFalse())
+		})
+
+		DescribeTable("Invalid runtime class overrides are rejected",
+			func(value string) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.runtimeClassOverridesFlag = createString(value)
+				structure.InitControlSwitches()
+
+				Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+			},
+			Entry("missing options", "kata"),
+			Entry("empty options", "kata="),
+			Entry("invalid runtime class", "Kata_Containers=skip-downward-api-volume"),
+			Entry("unknown option", "kata=skip-volume"),
+			Entry("empty target containers", "kata=target-containers="),
+			Entry("invalid target containers", "kata=target-containers=vm-("),
+			Entry("runtime class overridden twice", "kata=skip-downward-api-volume,kata=target-containers=vm"),
+		)
+	})
+
+	Describe("Server error action", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Pod is denied when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetServerErrorAction()).Should(Equal(ServerErrorDeny))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Fail action is accepted", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.serverErrorActionFlag = createString(" fail ")
+			structure.InitControlSwitches()
+
+			Expect(structure.GetServerErrorAction()).Should(Equal(ServerErrorFail))
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Unknown action is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.serverErrorActionFlag = createString("ignore")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Injection finalizer", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Finalizer is disabled when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.GetInjectionFinalizer()).Should(BeEmpty())
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+		})
+
+		It("Invalid finalizer is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.injectionFinalizerFlag = createString("example.com/not valid")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Owner kinds", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("All supported owner kinds are enabled when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.GetOwnerKinds()).Should(Equal(SupportedOwnerKinds))
+			Expect(structure.IsOwnerKindEnabled("Job")).Should(BeTrue())
+		})
+
+		It("Only listed owner kinds are enabled", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.ownerKindsFlag = createString(" ReplicaSet, ,Job ")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.IsOwnerKindEnabled("Job")).Should(BeTrue())
+			Expect(structure.IsOwnerKindEnabled("DaemonSet")).Should(BeFalse())
+			Expect(structure.GetConfig().OwnerKinds).Should(Equal([]string{"ReplicaSet", "Job"}))
+		})
+
+		It("Unsupported owner kind is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.ownerKindsFlag = createString("ReplicaSet,CronJob")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Label selector networks", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Networks of matching selectors are returned once in order", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.labelSelectorNetworksFlag = createString("network=dataplane:sriov-net; tier in (dpdk,vpp),network:infra/dpdk-net;;tier:sriov-net")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.GetLabelSelectorNetworks(labels.Set{"network": "dataplane", "tier": "dpdk"})).Should(
+				Equal([]string{"sriov-net", "infra/dpdk-net"}))
+			Expect(structure.GetLabelSelectorNetworks(labels.Set{"network": "management"})).Should(BeEmpty())
+			Expect(structure.GetConfig().LabelSelectorNetworks).Should(HaveLen(3))
+		})
+
+		DescribeTable("Invalid label selector networks are rejected",
+			func(value string) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.labelSelectorNetworksFlag = createString(value)
+				structure.InitControlSwitches()
+
+				Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+			},
+			Entry("missing network", "network=dataplane"),
+			Entry("empty selector", ":sriov-net"),
+			Entry("invalid selector", "network in dataplane:sriov-net"),
+			Entry("invalid network", "network=dataplane:ns/sriov/net"),
+		)
+	})
+
+	Describe("Target container images", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("No container image is targeted when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.IsTargetContainerImage("registry.example.com/dpdk/testpmd")).Should(BeFalse())
+		})
+
+		It("Whole image is matched against the expressions", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.targetContainerImagesFlag = createString(" registry.example.com/dpdk/.*, ,quay.io/dpdk:.* ")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.IsTargetContainerImage("registry.example.com/dpdk/testpmd:22.11")).Should(BeTrue())
+			Expect(structure.IsTargetContainerImage("quay.io/dpdk:latest")).Should(BeTrue())
+			Expect(structure.IsTargetContainerImage("mirror.example.com/registry.example.com/dpdk/testpmd")).Should(BeFalse())
+			Expect(structure.GetConfig().TargetContainerImages).Should(Equal([]string{"registry.example.com/dpdk/.*", "quay.io/dpdk:.*"}))
+		})
+
+		It("Invalid expression is rejected", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.targetContainerImagesFlag = createString("registry.example.com/dpdk/(.*")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+		})
+	})
+
+	Describe("Tolerations", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("No toleration is added when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.GetTolerations()).Should(BeEmpty())
+		})
+
+		It("Tolerations are parsed", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.tolerationsFlag = createString(" sriov=true:NoSchedule, ,example.com/nic:NoExecute,dedicated")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			expected := []corev1.Toleration{
+				{Key: "sriov", Operator: corev1.TolerationOpEqual, Value: "true", Effect: corev1.TaintEffectNoSchedule},
+				{Key: "example.com/nic", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+				{Key: "dedicated", Operator: corev1.TolerationOpExists},
+			}
+			Expect(structure.GetTolerations()).Should(Equal(expected))
+			Expect(structure.GetConfig().Tolerations).Should(Equal(expected))
+		})
+
+		DescribeTable("Invalid toleration is rejected",
+			func(tolerations string) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.tolerationsFlag = createString(tolerations)
+				structure.InitControlSwitches()
+
+				Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+			},
+			Entry("unknown effect", "sriov=true:NoRun"),
+			Entry("empty effect", "sriov=true:"),
+			Entry("empty key", "=true:NoSchedule"),
+			Entry("invalid key", "sriov nic:NoSchedule"),
+			Entry("invalid value", "sriov=a=b:NoSchedule"),
+		)
+	})
+
+	Describe("Net-attach-def not found message", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Default message is used when flag is not defined", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.GetNadNotFoundMessage("default", "sriov-net", errors.New("not found"))).Should(
+				Equal("could not find network attachment definition 'default/sriov-net': not found"))
+		})
+
+		It("Configured message is rendered", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.nadNotFoundMessageFlag = createString("network {{.Namespace}}/{{.Name}} does not exist, see https://example.com/networks")
+			structure.InitControlSwitches()
+
+			Expect(structure.ValidateControlSwitches()).Should(Succeed())
+			Expect(structure.GetNadNotFoundMessage("default", "sriov-net", errors.New("not found"))).Should(
+				Equal("network default/sriov-net does not exist, see https://example.com/networks"))
+			Expect(structure.GetConfig().NadNotFoundMessage).Should(Equal("network {{.Namespace}}/{{.Name}} does not exist, see https://example.com/networks"))
+		})
+
+		DescribeTable("Invalid message is rejected",
+			func(message string) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.nadNotFoundMessageFlag = createString(message)
+				structure.InitControlSwitches()
+
+				Expect(structure.ValidateControlSwitches()).ShouldNot(Succeed())
+			},
+			Entry("empty message", " "),
+			Entry("unterminated action", "network {{.Name is missing"),
+			Entry("unknown value", "network {{.Network}} is missing"),
+		)
+	})
+
+	Describe("Effective configuration", func() {
+		BeforeEach(func() {
+			structure = SetupControlSwitchesUnitTests(createBool(true), createBool(false),
+				createString("k8s.v1.cni.cncf.io/resourceName,example.com/resourceName"))
+			structure.companionResourcesFlag = createString("intel.com/sriov=intel.com/rdma:2")
+			timeout := 3 * time.Second
+			structure.lookupTimeoutFlag = &timeout
+			structure.InitControlSwitches()
+		})
+
+		It("should report features, resource name keys and settings", func() {
+			config := structure.GetConfig()
+			Expect(config.Features).To(HaveKeyWithValue(enableHugePageDownAPIKey, true))
+			Expect(config.Features).To(HaveKeyWithValue(enableHonorExistingResourcesKey, false))
+			Expect(config.Features).To(HaveLen(len(structure.configuration)))
+			Expect(config.ResourceNameKeys).To(Equal([]string{"k8s.v1.cni.cncf.io/resourceName", "example.com/resourceName"}))
+			Expect(config.AnnotationDomain).To(Equal(DefaultAnnotationDomain))
+			Expect(config.CompanionResources).To(HaveKeyWithValue("intel.com/sriov", []CompanionResource{{ResourceName: "intel.com/rdma", Ratio: 2}}))
+			Expect(config.LookupTimeout).To(Equal("3s"))
+			Expect(config.RequestBodyLimit).To(Equal(DefaultRequestBodyLimit))
+		})
+
+		It("should report active state of features changed by config map", func() {
+			structure.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{
+				Data: map[string]string{"config.json": `{"features": {"enableHugePageDownApi": false}}`},
+			})
+			Expect(structure.GetConfig().Features).To(HaveKeyWithValue(enableHugePageDownAPIKey, false))
+		})
+
+		It("should be serializable as JSON", func() {
+			raw, err := json.Marshal(structure.GetConfig())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(raw)).To(ContainSubstring(`"resourceNameKeys":["k8s.v1.cni.cncf.io/resourceName","example.com/resourceName"]`))
+			Expect(string(raw)).To(ContainSubstring(`"companionResources":{"intel.com/sriov":[{"resourceName":"intel.com/rdma","ratio":2}]}`))
+		})
+	})
+
+	Describe("Validate Control Switches config map", func() {
+		DescribeTable("should validate features of the config map",
+			func(data map[string]string, valid bool) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.InitControlSwitches()
+				err := structure.ValidateControlSwitchesConfigMap(&corev1.ConfigMap{Data: data})
+				if valid {
+					Expect(err).NotTo(HaveOccurred())
+				} else {
+					Expect(err).To(HaveOccurred())
+				}
+			},
+			Entry("without config.json key", map[string]string{}, true),
+			Entry("without features", map[string]string{"config.json": `{"user-defined-injections": {}}`}, true),
+			Entry("known features", map[string]string{"config.json": `{"features": {"enableHugePageDownApi": true, "enableIdempotency": false}}`}, true),
+			Entry("malformed config", map[string]string{"config.json": `{"features": `}, false),
+			Entry("feature which is not bool", map[string]string{"config.json": `{"features": {"enableHugePageDownApi": "yes"}}`}, false),
+			Entry("unknown feature", map[string]string{"config.json": `{"features": {"enableHugePagesDownApi": true}}`}, false),
+		)
+	})
+
 	Describe("Process Control Switches config map", func() {
 		Context("Map without [features]", func() {
 			BeforeEach(func() {
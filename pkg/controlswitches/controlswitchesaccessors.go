@@ -19,6 +19,12 @@
 
 package controlswitches
 
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/fields"
+)
+
 func SetupControlSwitchesUnitTests(downAPI, honor *bool, name *string) *ControlSwitches {
 	var initFlags ControlSwitches
 
@@ -28,3 +34,248 @@ func SetupControlSwitchesUnitTests(downAPI, honor *bool, name *string) *ControlS
 
 	return &initFlags
 }
+
+// SetExtendedResourcePatchModeUnitTests sets fields of container resources to inject network resources into
+func (switches *ControlSwitches) SetExtendedResourcePatchModeUnitTests(mode string) {
+	switches.extendedResourcePatchMode = mode
+}
+
+// SetAllowedCNITypesUnitTests sets CNI types allowed for networks requesting resources
+func (switches *ControlSwitches) SetAllowedCNITypesUnitTests(cniTypes []string) {
+	switches.allowedCNITypes = cniTypes
+}
+
+// SetNamespaceLabelUnitTests sets key of the namespace label enabling injection
+func (switches *ControlSwitches) SetNamespaceLabelUnitTests(label string) {
+	switches.namespaceLabel = label
+}
+
+// SetPodNetInfoConflictUnitTests sets action taken when pod defines podnetinfo volume which is not a Downward API volume
+func (switches *ControlSwitches) SetPodNetInfoConflictUnitTests(action string) {
+	switches.podNetInfoConflict = action
+}
+
+// SetRequestBodyStreamingUnitTests enables decoding of request body as a stream up to the given limit
+func (switches *ControlSwitches) SetRequestBodyStreamingUnitTests(enabled bool, limit int64) {
+	switches.streamRequestBody = enabled
+	switches.streamedRequestBodyLimit = limit
+}
+
+// SetNadLookupRetriesUnitTests sets retries of net-attach-def lookup and delay before the first retry
+func (switches *ControlSwitches) SetNadLookupRetriesUnitTests(retries int, delay time.Duration) {
+	switches.nadLookupRetries = retries
+	switches.nadLookupRetryDelay = delay
+}
+
+// SetCompanionResourcesUnitTests sets resources requested in lockstep with other resources
+func (switches *ControlSwitches) SetCompanionResourcesUnitTests(companions map[string][]CompanionResource) {
+	switches.companionResources = companions
+}
+
+// SetInjectionFinalizerUnitTests sets finalizer added to pods with injected resources
+func (switches *ControlSwitches) SetInjectionFinalizerUnitTests(finalizer string) {
+	switches.injectionFinalizer = finalizer
+}
+
+// SetHonorResourcesPolicyUnitTests sets how honored existing resources are combined with injected ones
+func (switches *ControlSwitches) SetHonorResourcesPolicyUnitTests(policy string) {
+	switches.honorResourcesPolicy = policy
+}
+
+// SetFallbackNamespaceUnitTests sets namespace of pod used when it cannot be determined from the request
+func (switches *ControlSwitches) SetFallbackNamespaceUnitTests(namespace string) {
+	switches.fallbackNamespace = namespace
+}
+
+// SetAllowedResourcePrefixesUnitTests sets prefixes of resource names allowed to be injected and action taken
+// for other resource names
+func (switches *ControlSwitches) SetAllowedResourcePrefixesUnitTests(prefixes []string, action string) {
+	switches.allowedResourcePrefixes = prefixes
+	switches.disallowedResourceAction = action
+}
+
+// SetDownwardAPIVolumeUnitTests sets name and mount path of the injected Downward API volume
+func (switches *ControlSwitches) SetDownwardAPIVolumeUnitTests(name, mountPath string) {
+	switches.downwardAPIVolumeName = name
+	switches.downwardAPIMountPath = mountPath
+}
+
+// SetPartialResourcesActionUnitTests sets action taken when container sets only request or only limit of resource
+// requested by its networks
+func (switches *ControlSwitches) SetPartialResourcesActionUnitTests(action string) {
+	switches.partialResourcesAction = action
+}
+
+// SetNodeSelectorConflictActionUnitTests sets action taken when pod node selector sets label of net-attach-def node
+// selector to other value
+func (switches *ControlSwitches) SetNodeSelectorConflictActionUnitTests(action string) {
+	switches.nodeSelectorConflict = action
+}
+
+// SetNoContainersActionUnitTests sets action taken when pod has no containers
+func (switches *ControlSwitches) SetNoContainersActionUnitTests(action string) {
+	switches.noContainersAction = action
+}
+
+// SetDefaultNetworksActionUnitTests sets action taken when default network annotation selects more networks
+func (switches *ControlSwitches) SetDefaultNetworksActionUnitTests(action string) {
+	switches.defaultNetworksAction = action
+}
+
+// SetMaxResourceCountUnitTests sets maximal count of every resource injected into pod and action taken when it
+// is exceeded
+func (switches *ControlSwitches) SetMaxResourceCountUnitTests(count int64, action string) {
+	switches.maxResourceCount = count
+	switches.resourceCapAction = action
+}
+
+// SetAdditionalNetworkAnnotationKeysUnitTests sets keys of pod annotations with network selections scanned along
+// with the networks annotation
+func (switches *ControlSwitches) SetAdditionalNetworkAnnotationKeysUnitTests(keys []string) {
+	switches.networkAnnotationKeys = keys
+}
+
+// SetLookupRateLimitUnitTests sets API server lookups per second and burst allowed for every request namespace
+func (switches *ControlSwitches) SetLookupRateLimitUnitTests(limit float64, burst int) {
+	switches.lookupRateLimit = limit
+	switches.lookupRateBurst = burst
+}
+
+// SetAdmissionConcurrencyUnitTests sets number of admission requests mutated at once and time the requests above
+// the limit wait
+func (switches *ControlSwitches) SetAdmissionConcurrencyUnitTests(limit int, timeout time.Duration) {
+	switches.maxConcurrentAdmissions = limit
+	switches.admissionQueueTimeout = timeout
+}
+
+// SetSkippedOwnersUnitTests sets Kind or Kind/name of pod owners whose pods are not mutated
+func (switches *ControlSwitches) SetSkippedOwnersUnitTests(owners []string) {
+	switches.skippedOwners = owners
+}
+
+// SetIgnoredNamespacesUnitTests sets namespaces whose pods are never mutated
+func (switches *ControlSwitches) SetIgnoredNamespacesUnitTests(namespaces []string) {
+	switches.ignoredNamespaces = namespaces
+}
+
+// SetResourceClaimNetworksUnitTests sets networks mapped to resource claims and resource claim templates
+func (switches *ControlSwitches) SetResourceClaimNetworksUnitTests(claimNetworks map[string]string) {
+	switches.resourceClaimNetworks = claimNetworks
+}
+
+// SetServerErrorActionUnitTests sets action taken when pod cannot be processed because of the webhook or API server
+// failure
+func (switches *ControlSwitches) SetServerErrorActionUnitTests(action string) {
+	switches.serverErrorAction = action
+}
+
+// SetAnnotationDomainUnitTests sets domain prefix of pod annotations owned by the injector
+func (switches *ControlSwitches) SetAnnotationDomainUnitTests(domain string) {
+	switches.annotationDomain = domain
+}
+
+// SetLookupTimeoutUnitTests sets time after which API server lookups of a request are cancelled
+func (switches *ControlSwitches) SetLookupTimeoutUnitTests(timeout time.Duration) {
+	switches.lookupTimeout = timeout
+}
+
+// SetRuntimeClassOverridesUnitTests sets injection behavior overridden for pods of runtime classes
+func (switches *ControlSwitches) SetRuntimeClassOverridesUnitTests(overrides map[string]RuntimeClassOverride) {
+	switches.runtimeClassOverrides = overrides
+}
+
+// SetSkipFieldSelectorUnitTests sets field selector of pods which are not mutated
+func (switches *ControlSwitches) SetSkipFieldSelectorUnitTests(selector fields.Selector) {
+	switches.skipFieldSelector = selector
+}
+
+// SetVirtualNodeSignatureUnitTests sets node selector, tolerations and node name prefixes of pods targeting virtual nodes
+func (switches *ControlSwitches) SetVirtualNodeSignatureUnitTests(selector, tolerations string, prefixes []string) error {
+	parsedSelector, err := parseVirtualNodeSelector(selector)
+	if err != nil {
+		return err
+	}
+	parsedTolerations, err := parseTolerations(tolerations)
+	if err != nil {
+		return err
+	}
+	switches.virtualNodeSelector, switches.virtualTolerations, switches.virtualNodePrefixes = parsedSelector, parsedTolerations, prefixes
+	return nil
+}
+
+// SetWindowsDownwardAPIMountPathUnitTests sets path at which the Downward API volume is mounted into containers of
+// Windows pods
+func (switches *ControlSwitches) SetWindowsDownwardAPIMountPathUnitTests(mountPath string) {
+	switches.windowsMountPath = mountPath
+}
+
+// SetHugepageMountPathUnitTests sets path at which the hugepage volume is mounted into containers
+func (switches *ControlSwitches) SetHugepageMountPathUnitTests(mountPath string) {
+	switches.hugepageMountPath = mountPath
+}
+
+// SetTokenMountPathUnitTests sets path at which the service account token volume is mounted into containers
+func (switches *ControlSwitches) SetTokenMountPathUnitTests(mountPath string) {
+	switches.tokenMountPath = mountPath
+}
+
+// SetNadNotFoundMessageUnitTests sets template of the message denying pod selecting net-attach-def which does not exist
+func (switches *ControlSwitches) SetNadNotFoundMessageUnitTests(message string) error {
+	parsed, err := parseNadNotFoundMessage(message)
+	if err != nil {
+		return err
+	}
+	switches.nadNotFoundMessageText, switches.nadNotFoundMessage = message, parsed
+	return nil
+}
+
+// SetTargetContainerImagesUnitTests sets regular expressions matching whole image of containers targeted by resources
+func (switches *ControlSwitches) SetTargetContainerImagesUnitTests(images string) error {
+	expressions, res, err := parseTargetContainerImages(images)
+	if err != nil {
+		return err
+	}
+	switches.targetContainerImages, switches.targetContainerImagesRe = expressions, res
+	return nil
+}
+
+// SetLabelSelectorNetworksUnitTests sets networks implicitly selected by pods matching the label selectors
+func (switches *ControlSwitches) SetLabelSelectorNetworksUnitTests(selectorNetworks string) error {
+	parsed, err := parseLabelSelectorNetworks(selectorNetworks)
+	if err != nil {
+		return err
+	}
+	switches.labelSelectorNetworks = parsed
+	return nil
+}
+
+// SetOwnerKindsUnitTests sets kinds of pod owners namespace of the pod is resolved from
+func (switches *ControlSwitches) SetOwnerKindsUnitTests(kinds ...string) {
+	switches.ownerKinds = kinds
+}
+
+// SetTolerationsUnitTests sets tolerations added to pods with injected resources
+func (switches *ControlSwitches) SetTolerationsUnitTests(tolerations string) error {
+	parsed, err := parseTolerations(tolerations)
+	if err != nil {
+		return err
+	}
+	switches.tolerations = parsed
+	return nil
+}
+
+// SetLookupWarningFractionUnitTests sets fraction of the webhook timeout spent in net-attach-def lookups after which
+// a warning is logged
+func (switches *ControlSwitches) SetLookupWarningFractionUnitTests(fraction float64) {
+	switches.lookupWarningFraction = fraction
+}
+
+// SetResourceQuantityFormatUnitTests sets format of quantities of injected resources, globally and per resource
+func (switches *ControlSwitches) SetResourceQuantityFormatUnitTests(value string) error {
+	formats, err := parseQuantityFormats(value)
+	if err != nil {
+		return err
+	}
+	switches.resourceQuantityFormat, switches.quantityFormats = value, formats
+	return nil
+}
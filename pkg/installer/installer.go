@@ -145,7 +145,7 @@ func createMutatingWebhookConfiguration(certificate []byte, failurePolicyStr str
 					},
 				},
 				FailurePolicy:           &failurePolicy,
-				AdmissionReviewVersions: []string{"v1"},
+				AdmissionReviewVersions: []string{"v1", "v1beta1"},
 				SideEffects:             &sideEffects,
 				NamespaceSelector:       &namespaceSelector,
 				Rules: []arv1.RuleWithOperations{
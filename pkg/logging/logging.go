@@ -0,0 +1,171 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FormatGlog logs entries as glog text lines
+	FormatGlog = "glog"
+	// FormatJSON logs entries as JSON lines
+	FormatJSON = "json"
+)
+
+// Fields are key/value pairs attached to log entries
+type Fields map[string]interface{}
+
+// Logger is the logging interface used by the webhook
+type Logger interface {
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+	// WithFields returns logger that attaches given fields to every entry
+	WithFields(fields Fields) Logger
+}
+
+// New returns logger for the given format
+func New(format string) (Logger, error) {
+	switch format {
+	case FormatGlog:
+		return NewGlogLogger(), nil
+	case FormatJSON:
+		return NewJSONLogger(os.Stderr), nil
+	default:
+		return nil, errors.Errorf("unsupported log format '%s', expected '%s' or '%s'", format, FormatGlog, FormatJSON)
+	}
+}
+
+// NewGlogLogger returns logger that writes entries with glog
+func NewGlogLogger() Logger {
+	return &glogLogger{}
+}
+
+// NewJSONLogger returns logger that writes entries as JSON lines to the given writer
+func NewJSONLogger(out io.Writer) Logger {
+	return &jsonLogger{out: out, mutex: &sync.Mutex{}}
+}
+
+func mergeFields(current, added Fields) Fields {
+	merged := make(Fields, len(current)+len(added))
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range added {
+		merged[k] = v
+	}
+	return merged
+}
+
+// glogLogger writes entries with glog, fields are appended to the message as key=value pairs
+type glogLogger struct {
+	fields Fields
+}
+
+func (l *glogLogger) format(format string, args ...interface{}) string {
+	msg := fmt.Sprintf(format, args...)
+	if len(l.fields) == 0 {
+		return msg
+	}
+
+	keys := make([]string, 0, len(l.fields))
+	for k := range l.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(msg)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=%v", k, l.fields[k])
+	}
+	return sb.String()
+}
+
+func (l *glogLogger) Infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, l.format(format, args...))
+}
+
+func (l *glogLogger) Warningf(format string, args ...interface{}) {
+	glog.WarningDepth(1, l.format(format, args...))
+}
+
+func (l *glogLogger) Errorf(format string, args ...interface{}) {
+	glog.ErrorDepth(1, l.format(format, args...))
+}
+
+func (l *glogLogger) Fatalf(format string, args ...interface{}) {
+	glog.FatalDepth(1, l.format(format, args...))
+}
+
+func (l *glogLogger) WithFields(fields Fields) Logger {
+	return &glogLogger{fields: mergeFields(l.fields, fields)}
+}
+
+// jsonLogger writes every entry as single JSON line
+type jsonLogger struct {
+	out    io.Writer
+	mutex  *sync.Mutex
+	fields Fields
+}
+
+func (l *jsonLogger) write(level, format string, args ...interface{}) {
+	entry := mergeFields(l.fields, Fields{
+		"ts":    time.Now().UTC().Format(time.RFC3339Nano),
+		"level": level,
+		"msg":   fmt.Sprintf(format, args...),
+	})
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(Fields{"level": "error", "msg": fmt.Sprintf("unable to marshal log entry: %v", err)})
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.out.Write(append(line, '\n'))
+}
+
+func (l *jsonLogger) Infof(format string, args ...interface{}) {
+	l.write("info", format, args...)
+}
+
+func (l *jsonLogger) Warningf(format string, args ...interface{}) {
+	l.write("warning", format, args...)
+}
+
+func (l *jsonLogger) Errorf(format string, args ...interface{}) {
+	l.write("error", format, args...)
+}
+
+func (l *jsonLogger) Fatalf(format string, args ...interface{}) {
+	l.write("fatal", format, args...)
+	os.Exit(255)
+}
+
+func (l *jsonLogger) WithFields(fields Fields) Logger {
+	return &jsonLogger{out: l.out, mutex: l.mutex, fields: mergeFields(l.fields, fields)}
+}
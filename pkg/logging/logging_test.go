@@ -0,0 +1,79 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging", func() {
+	Describe("Creating logger", func() {
+		It("should create glog logger", func() {
+			logger, err := New(FormatGlog)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(logger).To(BeAssignableToTypeOf(&glogLogger{}))
+		})
+
+		It("should create json logger", func() {
+			logger, err := New(FormatJSON)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(logger).To(BeAssignableToTypeOf(&jsonLogger{}))
+		})
+
+		It("should fail on unknown format", func() {
+			_, err := New("xml")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("JSON logger", func() {
+		It("should write entry with fields as single JSON line", func() {
+			out := &bytes.Buffer{}
+			logger := NewJSONLogger(out).WithFields(Fields{"pod": "test", "namespace": "default"})
+			logger.WithFields(Fields{"result": "allowed", "latency_ms": 3}).Infof("request %s", "processed")
+			logger.Warningf("second")
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			Expect(lines).To(HaveLen(2))
+
+			entry := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(lines[0]), &entry)).To(Succeed())
+			Expect(entry).To(HaveKeyWithValue("level", "info"))
+			Expect(entry).To(HaveKeyWithValue("msg", "request processed"))
+			Expect(entry).To(HaveKeyWithValue("pod", "test"))
+			Expect(entry).To(HaveKeyWithValue("namespace", "default"))
+			Expect(entry).To(HaveKeyWithValue("result", "allowed"))
+			Expect(entry).To(HaveKeyWithValue("latency_ms", BeNumerically("==", 3)))
+			Expect(entry).To(HaveKey("ts"))
+
+			entry = map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(lines[1]), &entry)).To(Succeed())
+			Expect(entry).To(HaveKeyWithValue("level", "warning"))
+			Expect(entry).NotTo(HaveKey("result"))
+		})
+	})
+
+	Describe("Glog logger", func() {
+		It("should append sorted fields to the message", func() {
+			logger := &glogLogger{fields: Fields{"pod": "test", "namespace": "default"}}
+			Expect(logger.format("request %s", "processed")).To(Equal("request processed namespace=default pod=test"))
+		})
+	})
+})
@@ -15,6 +15,9 @@
 package cache
 
 import (
+	"context"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,41 +26,130 @@ import (
 	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	"github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
 	"github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+	"github.com/pkg/errors"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 )
 
 type NetAttachDefCache struct {
 	networkAnnotationsMap      map[string]map[string]string
+	networkConfigMap           map[string]string
+	networkReferenceMap        map[string]map[string]bool
 	networkAnnotationsMapMutex *sync.Mutex
+	aliasKey                   string
+	namespaces                 []string
+	resyncPeriod               time.Duration
 	stopper                    chan struct{}
 	isRunning                  int32
+	client                     versioned.Interface
+	resyncMutex                sync.Mutex
 }
 
 type NetAttachDefCacheService interface {
 	Start()
 	Stop()
 	Get(namespace string, networkName string) map[string]string
+	GetConfig(namespace string, networkName string) string
+	List() []NetAttachDefCacheEntry
+	Resolve(namespace string, reference string) []string
+	Resync(ctx context.Context) error
 }
 
-func Create() NetAttachDefCacheService {
-	return &NetAttachDefCache{make(map[string]map[string]string),
-		&sync.Mutex{}, make(chan struct{}), 0}
+// NetAttachDefCacheEntry is a net-attach-def held by the cache
+type NetAttachDefCacheEntry struct {
+	Namespace   string
+	Name        string
+	Annotations map[string]string
+	Config      string
 }
 
-// Start creates informer for NetworkAttachmentDefinition events and populate the local cache
+// Create returns cache of net-attach-defs in the given namespaces, all namespaces are watched when the list is empty.
+// Informers are fully resynced with the given period, resync is disabled when the period is 0. Net-attach-defs are
+// indexed by their UID and by value of their aliasKey annotation, aliases are not indexed when aliasKey is empty.
+func Create(namespaces []string, resyncPeriod time.Duration, aliasKey string) NetAttachDefCacheService {
+	return &NetAttachDefCache{networkAnnotationsMap: make(map[string]map[string]string),
+		networkConfigMap: make(map[string]string), networkReferenceMap: make(map[string]map[string]bool),
+		networkAnnotationsMapMutex: &sync.Mutex{}, aliasKey: aliasKey, namespaces: namespaces,
+		resyncPeriod: resyncPeriod, stopper: make(chan struct{})}
+}
+
+// Start creates informers for NetworkAttachmentDefinition events and populate the local cache, one informer is
+// created for each watched namespace
 func (nc *NetAttachDefCache) Start() {
-	factory := externalversions.NewSharedInformerFactoryWithOptions(setupNetAttachDefClient(), 0, externalversions.WithNamespace(""))
-	informer := factory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions().Informer()
+	nc.client = setupNetAttachDefClient()
+	nc.startInformers(nc.stopper, nc.networkAnnotationsMap, nc.networkConfigMap, nc.networkReferenceMap)
+}
+
+// Resync lists all net-attach-defs from API server again and replaces the cache content with them. New informers fill
+// fresh maps which replace the cached ones once they are synced, so the cache keeps serving the old content meanwhile.
+// Old informers are stopped afterwards. Cache is kept as it is when new informers do not sync before ctx is done.
+func (nc *NetAttachDefCache) Resync(ctx context.Context) error {
+	nc.resyncMutex.Lock()
+	defer nc.resyncMutex.Unlock()
+	if nc.client == nil || nc.networkAnnotationsMap == nil {
+		return errors.New("net-attach-def cache is not running")
+	}
+
+	stopper := make(chan struct{})
+	annotationsMap, configMap := make(map[string]map[string]string), make(map[string]string)
+	referenceMap := make(map[string]map[string]bool)
+	synced := nc.startInformers(stopper, annotationsMap, configMap, referenceMap)
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		close(stopper)
+		return errors.New("net-attach-def informers did not sync")
+	}
+
+	nc.networkAnnotationsMapMutex.Lock()
+	nc.networkAnnotationsMap, nc.networkConfigMap, nc.networkReferenceMap = annotationsMap, configMap, referenceMap
+	previous := nc.stopper
+	nc.stopper = stopper
+	nc.networkAnnotationsMapMutex.Unlock()
+	close(previous)
+	glog.Infof("net-attach-def cache is resynced with %d net-attach-defs", len(annotationsMap))
+	return nil
+}
+
+// startInformers starts informers of the watched namespaces which keep the given maps up to date until the stopper
+// is closed, functions reporting whether the informers are synced are returned
+func (nc *NetAttachDefCache) startInformers(stopper chan struct{}, annotationsMap map[string]map[string]string,
+	configMap map[string]string, referenceMap map[string]map[string]bool) []cache.InformerSynced {
+	namespaces := nc.namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
 	// mutex to serialize the events.
 	mutex := &sync.Mutex{}
-
-	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	put := func(netAttachDef *cniv1.NetworkAttachmentDefinition) {
+		nc.networkAnnotationsMapMutex.Lock()
+		annotationsMap[nc.getKey(netAttachDef.Namespace, netAttachDef.Name)] = netAttachDef.Annotations
+		configMap[nc.getKey(netAttachDef.Namespace, netAttachDef.Name)] = netAttachDef.Spec.Config
+		for _, reference := range nc.getReferences(netAttachDef) {
+			key := nc.getKey(netAttachDef.Namespace, reference)
+			if referenceMap[key] == nil {
+				referenceMap[key] = make(map[string]bool)
+			}
+			referenceMap[key][netAttachDef.Name] = true
+		}
+		nc.networkAnnotationsMapMutex.Unlock()
+	}
+	remove := func(netAttachDef *cniv1.NetworkAttachmentDefinition) {
+		nc.networkAnnotationsMapMutex.Lock()
+		delete(annotationsMap, nc.getKey(netAttachDef.Namespace, netAttachDef.Name))
+		delete(configMap, nc.getKey(netAttachDef.Namespace, netAttachDef.Name))
+		for _, reference := range nc.getReferences(netAttachDef) {
+			key := nc.getKey(netAttachDef.Namespace, reference)
+			delete(referenceMap[key], netAttachDef.Name)
+			if len(referenceMap[key]) == 0 {
+				delete(referenceMap, key)
+			}
+		}
+		nc.networkAnnotationsMapMutex.Unlock()
+	}
+	handler := cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			mutex.Lock()
 			defer mutex.Unlock()
-			netAttachDef := obj.(*cniv1.NetworkAttachmentDefinition)
-			nc.put(netAttachDef.Namespace, netAttachDef.Name, netAttachDef.Annotations)
+			put(obj.(*cniv1.NetworkAttachmentDefinition))
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			mutex.Lock()
@@ -68,45 +160,53 @@ func (nc *NetAttachDefCache) Start() {
 				glog.Infof("no change in net-attach-def %s, ignoring update event", nc.getKey(oldNetAttachDef.Namespace, newNetAttachDef.Name))
 				return
 			}
-			nc.remove(oldNetAttachDef.Namespace, oldNetAttachDef.Name)
-			nc.put(newNetAttachDef.Namespace, newNetAttachDef.Name, newNetAttachDef.Annotations)
+			remove(oldNetAttachDef)
+			put(newNetAttachDef)
 		},
 		DeleteFunc: func(obj interface{}) {
 			mutex.Lock()
 			defer mutex.Unlock()
-			netAttachDef := obj.(*cniv1.NetworkAttachmentDefinition)
-			nc.remove(netAttachDef.Namespace, netAttachDef.Name)
+			remove(obj.(*cniv1.NetworkAttachmentDefinition))
 		},
-	})
-	go func() {
-		atomic.StoreInt32(&(nc.isRunning), int32(1))
-		// informer Run blocks until informer is stopped
-		glog.Infof("starting net-attach-def informer")
-		informer.Run(nc.stopper)
-		glog.Infof("net-attach-def informer is stopped")
-		atomic.StoreInt32(&(nc.isRunning), int32(0))
-	}()
+	}
+
+	var synced []cache.InformerSynced
+	for _, namespace := range namespaces {
+		factory := externalversions.NewSharedInformerFactoryWithOptions(nc.client, nc.resyncPeriod, externalversions.WithNamespace(namespace))
+		informer := factory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions().Informer()
+		informer.AddEventHandler(handler)
+		synced = append(synced, informer.HasSynced)
+
+		atomic.AddInt32(&nc.isRunning, 1)
+		go func(namespace string, informer cache.SharedIndexInformer) {
+			// informer Run blocks until informer is stopped
+			glog.Infof("starting net-attach-def informer of namespace '%s'", namespace)
+			informer.Run(stopper)
+			glog.Infof("net-attach-def informer of namespace '%s' is stopped", namespace)
+			atomic.AddInt32(&nc.isRunning, -1)
+		}(namespace, informer)
+	}
+	return synced
 }
 
-// Stop teardown the NetworkAttachmentDefinition informer
+// Stop teardown the NetworkAttachmentDefinition informers
 func (nc *NetAttachDefCache) Stop() {
+	/* informers are not replaced by resync while they are being stopped */
+	nc.resyncMutex.Lock()
+	defer nc.resyncMutex.Unlock()
 	close(nc.stopper)
 	tEnd := time.Now().Add(3 * time.Second)
 	for tEnd.After(time.Now()) {
 		if atomic.LoadInt32(&nc.isRunning) == 0 {
-			glog.Infof("net-attach-def informer is no longer running, proceed to clean up nad cache")
+			glog.Infof("net-attach-def informers are no longer running, proceed to clean up nad cache")
 			break
 		}
 		time.Sleep(600 * time.Millisecond)
 	}
 	nc.networkAnnotationsMapMutex.Lock()
 	nc.networkAnnotationsMap = nil
-	nc.networkAnnotationsMapMutex.Unlock()
-}
-
-func (nc *NetAttachDefCache) put(namespace, networkName string, annotations map[string]string) {
-	nc.networkAnnotationsMapMutex.Lock()
-	nc.networkAnnotationsMap[nc.getKey(namespace, networkName)] = annotations
+	nc.networkConfigMap = nil
+	nc.networkReferenceMap = nil
 	nc.networkAnnotationsMapMutex.Unlock()
 }
 
@@ -121,16 +221,66 @@ func (nc *NetAttachDefCache) Get(namespace, networkName string) map[string]strin
 	return nil
 }
 
-func (nc *NetAttachDefCache) remove(namespace, networkName string) {
+// GetConfig returns CNI configuration (spec.config) for the given namespace and network name, if it's not available
+// return empty string
+func (nc *NetAttachDefCache) GetConfig(namespace, networkName string) string {
 	nc.networkAnnotationsMapMutex.Lock()
-	delete(nc.networkAnnotationsMap, nc.getKey(namespace, networkName))
-	nc.networkAnnotationsMapMutex.Unlock()
+	defer nc.networkAnnotationsMapMutex.Unlock()
+	return nc.networkConfigMap[nc.getKey(namespace, networkName)]
+}
+
+// Resolve returns names of the cached net-attach-defs of the namespace whose UID or alias annotation is the given
+// reference, sorted by name. More names are returned when the alias is shared by more net-attach-defs.
+func (nc *NetAttachDefCache) Resolve(namespace, reference string) []string {
+	nc.networkAnnotationsMapMutex.Lock()
+	defer nc.networkAnnotationsMapMutex.Unlock()
+	var names []string
+	for name := range nc.networkReferenceMap[nc.getKey(namespace, reference)] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List returns all cached net-attach-defs sorted by namespace and name, annotations of the entries are copies
+func (nc *NetAttachDefCache) List() []NetAttachDefCacheEntry {
+	nc.networkAnnotationsMapMutex.Lock()
+	defer nc.networkAnnotationsMapMutex.Unlock()
+	entries := make([]NetAttachDefCacheEntry, 0, len(nc.networkAnnotationsMap))
+	for key, annotations := range nc.networkAnnotationsMap {
+		names := strings.SplitN(key, "/", 2)
+		copied := make(map[string]string, len(annotations))
+		for k, v := range annotations {
+			copied[k] = v
+		}
+		entries = append(entries, NetAttachDefCacheEntry{Namespace: names[0], Name: names[1], Annotations: copied,
+			Config: nc.networkConfigMap[key]})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Namespace != entries[j].Namespace {
+			return entries[i].Namespace < entries[j].Namespace
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
 }
 
 func (nc *NetAttachDefCache) getKey(namespace, networkName string) string {
 	return namespace + "/" + networkName
 }
 
+// getReferences returns UID and alias the net-attach-def can be referenced by instead of its name
+func (nc *NetAttachDefCache) getReferences(netAttachDef *cniv1.NetworkAttachmentDefinition) []string {
+	var references []string
+	if netAttachDef.UID != "" {
+		references = append(references, string(netAttachDef.UID))
+	}
+	if alias := strings.TrimSpace(netAttachDef.Annotations[nc.aliasKey]); nc.aliasKey != "" && alias != "" {
+		references = append(references, alias)
+	}
+	return references
+}
+
 // setupNetAttachDefClient creates K8s client for net-attach-def crd
 func setupNetAttachDefClient() versioned.Interface {
 	config, err := rest.InClusterConfig()
@@ -0,0 +1,103 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+type ConfigMapWatcher struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+	updates   chan *corev1.ConfigMap
+	stopper   chan struct{}
+	isRunning int32
+}
+
+type ConfigMapWatcherService interface {
+	Start()
+	Stop()
+	Updates() <-chan *corev1.ConfigMap
+}
+
+// CreateConfigMapWatcher creates watcher of the config map with the given namespace and name
+func CreateConfigMapWatcher(clientset kubernetes.Interface, namespace, name string) ConfigMapWatcherService {
+	return &ConfigMapWatcher{clientset, namespace, name, make(chan *corev1.ConfigMap, 1), make(chan struct{}), 0}
+}
+
+// Start creates informer for events of the config map, its latest content is sent to the updates channel. Empty
+// config map is sent when the config map is deleted.
+func (cw *ConfigMapWatcher) Start() {
+	listWatch := cache.NewListWatchFromClient(cw.clientset.CoreV1().RESTClient(), "configmaps", cw.namespace,
+		fields.OneTermEqualSelector("metadata.name", cw.name))
+	informer := cache.NewSharedIndexInformer(listWatch, &corev1.ConfigMap{}, 0, cache.Indexers{})
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cw.send(obj.(*corev1.ConfigMap))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			cw.send(newObj.(*corev1.ConfigMap))
+		},
+		DeleteFunc: func(obj interface{}) {
+			cw.send(&corev1.ConfigMap{})
+		},
+	})
+	go func() {
+		atomic.StoreInt32(&(cw.isRunning), int32(1))
+		// informer Run blocks until informer is stopped
+		glog.Infof("starting config map %s/%s informer", cw.namespace, cw.name)
+		informer.Run(cw.stopper)
+		glog.Infof("config map %s/%s informer is stopped", cw.namespace, cw.name)
+		atomic.StoreInt32(&(cw.isRunning), int32(0))
+	}()
+}
+
+// Stop teardown the config map informer
+func (cw *ConfigMapWatcher) Stop() {
+	close(cw.stopper)
+	tEnd := time.Now().Add(3 * time.Second)
+	for tEnd.After(time.Now()) {
+		if atomic.LoadInt32(&cw.isRunning) == 0 {
+			glog.Infof("config map informer is no longer running")
+			break
+		}
+		time.Sleep(600 * time.Millisecond)
+	}
+}
+
+// Updates returns channel with the latest content of the config map
+func (cw *ConfigMapWatcher) Updates() <-chan *corev1.ConfigMap {
+	return cw.updates
+}
+
+// send replaces content of the config map not consumed yet, so only the latest one is processed
+func (cw *ConfigMapWatcher) send(cm *corev1.ConfigMap) {
+	for {
+		select {
+		case cw.updates <- cm:
+			return
+		case <-cw.updates:
+		}
+	}
+}
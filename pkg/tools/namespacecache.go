@@ -0,0 +1,120 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+type NamespaceCache struct {
+	namespaceLabelsMap      map[string]map[string]string
+	namespaceLabelsMapMutex *sync.Mutex
+	clientset               kubernetes.Interface
+	stopper                 chan struct{}
+	isRunning               int32
+}
+
+type NamespaceCacheService interface {
+	Start()
+	Stop()
+	Get(namespace string) (map[string]string, bool)
+}
+
+func CreateNamespaceCache(clientset kubernetes.Interface) NamespaceCacheService {
+	return &NamespaceCache{make(map[string]map[string]string),
+		&sync.Mutex{}, clientset, make(chan struct{}), 0}
+}
+
+// Start creates informer for Namespace events and populate the local cache
+func (nc *NamespaceCache) Start() {
+	listWatch := cache.NewListWatchFromClient(nc.clientset.CoreV1().RESTClient(), "namespaces", "", fields.Everything())
+	informer := cache.NewSharedIndexInformer(listWatch, &corev1.Namespace{}, 0, cache.Indexers{})
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			namespace := obj.(*corev1.Namespace)
+			nc.put(namespace.Name, namespace.Labels)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			namespace := newObj.(*corev1.Namespace)
+			nc.put(namespace.Name, namespace.Labels)
+		},
+		DeleteFunc: func(obj interface{}) {
+			namespace, ok := obj.(*corev1.Namespace)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					return
+				}
+				if namespace, ok = tombstone.Obj.(*corev1.Namespace); !ok {
+					return
+				}
+			}
+			nc.remove(namespace.Name)
+		},
+	})
+	go func() {
+		atomic.StoreInt32(&(nc.isRunning), int32(1))
+		// informer Run blocks until informer is stopped
+		glog.Infof("starting namespace informer")
+		informer.Run(nc.stopper)
+		glog.Infof("namespace informer is stopped")
+		atomic.StoreInt32(&(nc.isRunning), int32(0))
+	}()
+}
+
+// Stop teardown the Namespace informer
+func (nc *NamespaceCache) Stop() {
+	close(nc.stopper)
+	tEnd := time.Now().Add(3 * time.Second)
+	for tEnd.After(time.Now()) {
+		if atomic.LoadInt32(&nc.isRunning) == 0 {
+			glog.Infof("namespace informer is no longer running, proceed to clean up namespace cache")
+			break
+		}
+		time.Sleep(600 * time.Millisecond)
+	}
+	nc.namespaceLabelsMapMutex.Lock()
+	nc.namespaceLabelsMap = nil
+	nc.namespaceLabelsMapMutex.Unlock()
+}
+
+func (nc *NamespaceCache) put(namespace string, labels map[string]string) {
+	nc.namespaceLabelsMapMutex.Lock()
+	nc.namespaceLabelsMap[namespace] = labels
+	nc.namespaceLabelsMapMutex.Unlock()
+}
+
+// Get returns labels map for the given namespace, second value is false when namespace is not available
+func (nc *NamespaceCache) Get(namespace string) (map[string]string, bool) {
+	nc.namespaceLabelsMapMutex.Lock()
+	defer nc.namespaceLabelsMapMutex.Unlock()
+	labels, exists := nc.namespaceLabelsMap[namespace]
+	return labels, exists
+}
+
+func (nc *NamespaceCache) remove(namespace string) {
+	nc.namespaceLabelsMapMutex.Lock()
+	delete(nc.namespaceLabelsMap, namespace)
+	nc.namespaceLabelsMapMutex.Unlock()
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ownerResyncPeriod is period of full resync of owner informers, correcting the cache in case of missed events
+const ownerResyncPeriod = 10 * time.Minute
+
+// ownerResource is API resource of pod owner kind watched by the owner cache
+type ownerResource struct {
+	client   func(clientset kubernetes.Interface) cache.Getter
+	resource string
+	object   runtime.Object
+}
+
+func appsClient(clientset kubernetes.Interface) cache.Getter {
+	return clientset.AppsV1().RESTClient()
+}
+
+func coreClient(clientset kubernetes.Interface) cache.Getter {
+	return clientset.CoreV1().RESTClient()
+}
+
+func batchClient(clientset kubernetes.Interface) cache.Getter {
+	return clientset.BatchV1().RESTClient()
+}
+
+// ownerResources are API resources of the supported pod owner kinds
+var ownerResources = map[string]ownerResource{
+	"ReplicaSet":            {appsClient, "replicasets", &appsv1.ReplicaSet{}},
+	"DaemonSet":             {appsClient, "daemonsets", &appsv1.DaemonSet{}},
+	"StatefulSet":           {appsClient, "statefulsets", &appsv1.StatefulSet{}},
+	"ReplicationController": {coreClient, "replicationcontrollers", &corev1.ReplicationController{}},
+	"Job":                   {batchClient, "jobs", &batchv1.Job{}},
+}
+
+// OwnerCache maps UIDs of pod owners of the watched kinds, e.g. ReplicaSets or Jobs, to their namespaces
+type OwnerCache struct {
+	ownerNamespaceMap      map[string]string
+	ownerNamespaceMapMutex *sync.Mutex
+	clientset              kubernetes.Interface
+	kinds                  []string
+	stopper                chan struct{}
+	isRunning              int32
+}
+
+type OwnerCacheService interface {
+	Start()
+	Stop()
+	GetNamespace(kind string, uid types.UID) (string, bool)
+}
+
+// CreateOwnerCache creates cache of owners of the given kinds, kinds which are not supported are not watched
+func CreateOwnerCache(clientset kubernetes.Interface, kinds []string) OwnerCacheService {
+	return &OwnerCache{make(map[string]string), &sync.Mutex{}, clientset, kinds, make(chan struct{}), 0}
+}
+
+// Start creates informers for events of pod owners and populate the local cache
+func (oc *OwnerCache) Start() {
+	informers := make(map[string]cache.SharedIndexInformer, len(oc.kinds))
+	for _, kind := range oc.kinds {
+		owner, supported := ownerResources[kind]
+		if !supported {
+			glog.Warningf("owner kind %s is not supported, it is not watched", kind)
+			continue
+		}
+		informers[kind] = cache.NewSharedIndexInformer(cache.NewListWatchFromClient(owner.client(oc.clientset), owner.resource, "", fields.Everything()),
+			owner.object, ownerResyncPeriod, cache.Indexers{})
+	}
+
+	if len(informers) == 0 {
+		return
+	}
+
+	running := int32(len(informers))
+	atomic.StoreInt32(&(oc.isRunning), int32(1))
+	for kind, informer := range informers {
+		kind := kind
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				oc.put(kind, obj)
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oc.put(kind, newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				oc.remove(kind, obj)
+			},
+		})
+
+		go func(informer cache.SharedIndexInformer) {
+			// informer Run blocks until informer is stopped
+			glog.Infof("starting %s owner informer", kind)
+			informer.Run(oc.stopper)
+			glog.Infof("%s owner informer is stopped", kind)
+			if atomic.AddInt32(&running, -1) == 0 {
+				atomic.StoreInt32(&(oc.isRunning), int32(0))
+			}
+		}(informer)
+	}
+}
+
+// Stop teardown the owner informers
+func (oc *OwnerCache) Stop() {
+	close(oc.stopper)
+	tEnd := time.Now().Add(3 * time.Second)
+	for tEnd.After(time.Now()) {
+		if atomic.LoadInt32(&oc.isRunning) == 0 {
+			glog.Infof("owner informers are no longer running, proceed to clean up owner cache")
+			break
+		}
+		time.Sleep(600 * time.Millisecond)
+	}
+	oc.ownerNamespaceMapMutex.Lock()
+	oc.ownerNamespaceMap = nil
+	oc.ownerNamespaceMapMutex.Unlock()
+}
+
+func (oc *OwnerCache) getKey(kind string, uid types.UID) string {
+	return kind + "/" + string(uid)
+}
+
+func (oc *OwnerCache) put(kind string, obj interface{}) {
+	owner, err := meta.Accessor(obj)
+	if err != nil {
+		glog.Warningf("unexpected %s owner object %T: %v", kind, obj, err)
+		return
+	}
+	oc.ownerNamespaceMapMutex.Lock()
+	oc.ownerNamespaceMap[oc.getKey(kind, owner.GetUID())] = owner.GetNamespace()
+	oc.ownerNamespaceMapMutex.Unlock()
+}
+
+// GetNamespace returns namespace of the pod owner of the given kind and UID, second value is false when owner
+// is not available
+func (oc *OwnerCache) GetNamespace(kind string, uid types.UID) (string, bool) {
+	oc.ownerNamespaceMapMutex.Lock()
+	defer oc.ownerNamespaceMapMutex.Unlock()
+	namespace, exists := oc.ownerNamespaceMap[oc.getKey(kind, uid)]
+	return namespace, exists
+}
+
+func (oc *OwnerCache) remove(kind string, obj interface{}) {
+	owner, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	oc.ownerNamespaceMapMutex.Lock()
+	delete(oc.ownerNamespaceMap, oc.getKey(kind, owner.GetUID()))
+	oc.ownerNamespaceMapMutex.Unlock()
+}
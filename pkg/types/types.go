@@ -16,6 +16,7 @@ package types
 
 const (
 	DownwardAPIMountPath   = "/etc/podnetinfo"
+	WindowsDownwardAPIPath = `C:\etc\podnetinfo`
 	AnnotationsPath        = "annotations"
 	LabelsPath             = "labels"
 	EnvNameContainerName   = "CONTAINER_NAME"
@@ -2,105 +2,258 @@ package userdefinedinjections
 
 import (
 	"encoding/json"
+	"path"
 	"reflect"
 	"strings"
 	"sync"
 
 	"github.com/golang/glog"
+	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
 )
 
 const (
 	userDefinedInjectionsMainKey = "user-defined-injections"
+
+	annotationsPath = "/metadata/annotations"
+	sysctlsPath     = "/spec/securityContext/sysctls"
+	volumesPath     = "/spec/volumes"
 )
 
+// UserDefinedVolume is a pod volume defined by user-defined injection, the volume is mounted into every container
+// when mount path is defined
+type UserDefinedVolume struct {
+	corev1.Volume
+	MountPath string `json:"mountPath,omitempty"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// userDefinedSelector is the optional label selector of user-defined injection, injection without selector is
+// applied to pods having label with the injection key set to "true"
+type userDefinedSelector struct {
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
 // UserDefinedInjections user defined injections
 type UserDefinedInjections struct {
-	sync.Mutex
+	sync.RWMutex
 	Patchs map[string]types.JsonPatchOperation
+	// Selectors of injections defining label selector, by injection key
+	Selectors map[string]labels.Selector
 }
 
 // CreateUserInjectionsStructure returns empty UserDefinedInjections structure
 func CreateUserInjectionsStructure() *UserDefinedInjections {
-	var userDefinedInjects = UserDefinedInjections{Patchs: make(map[string]types.JsonPatchOperation)}
+	var userDefinedInjects = UserDefinedInjections{Patchs: make(map[string]types.JsonPatchOperation),
+		Selectors: make(map[string]labels.Selector)}
 	return &userDefinedInjects
 }
 
+// getSelector returns label selector of user-defined injection, nil when injection does not define any
+func getSelector(value json.RawMessage) (labels.Selector, error) {
+	var injection userDefinedSelector
+	if err := json.Unmarshal(value, &injection); err != nil {
+		return nil, err
+	}
+	if injection.Selector == nil {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(injection.Selector)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid selector")
+	}
+	return selector, nil
+}
+
 // SetUserDefinedInjections sets additional injections to be applied in Pod spec
+// SetUserDefinedInjections reloads injections from the config map. New injections are validated before they replace
+// the current ones, which are kept when any of the new injections is invalid.
 func (userDefinedInjects *UserDefinedInjections) SetUserDefinedInjections(injectionsCm *corev1.ConfigMap) {
-	if v, fileExists := injectionsCm.Data[types.ConfigMapMainFileKey]; fileExists {
-		var obj map[string]json.RawMessage
-		var err error
-		if err = json.Unmarshal([]byte(v), &obj); err != nil {
-			glog.Warningf("Error during json unmarshal of main: %v", err)
-			return
+	patchs, selectors, err := parseUserDefinedInjections(injectionsCm)
+	if err != nil {
+		glog.Errorf("Failed to reload user-defined injections, keeping current injections: %v", err)
+		return
+	}
+
+	// lock for writing
+	userDefinedInjects.Lock()
+	defer userDefinedInjects.Unlock()
+
+	if reflect.DeepEqual(userDefinedInjects.Patchs, patchs) && equalSelectors(userDefinedInjects.Selectors, selectors) {
+		return
+	}
+	for k, patch := range patchs {
+		if existValue, exists := userDefinedInjects.Patchs[k]; !exists || !reflect.DeepEqual(existValue, patch) {
+			glog.Infof("Initializing user-defined injections with key: %v, value: %v", k, patch)
 		}
+	}
+	for k := range userDefinedInjects.Patchs {
+		if _, ok := patchs[k]; !ok {
+			glog.Infof("Removing stale entry: %v from user-defined injections", k)
+		}
+	}
+	userDefinedInjects.Patchs = patchs
+	userDefinedInjects.Selectors = selectors
+	glog.Infof("Reloaded user-defined injections, %d injections are active", len(patchs))
+}
 
-		if userDefinedInjections, mainExists := obj[userDefinedInjectionsMainKey]; mainExists {
-			var userDefinedInjectionsObj map[string]json.RawMessage
-			if err = json.Unmarshal([]byte(userDefinedInjections), &userDefinedInjectionsObj); err != nil {
-				glog.Warningf("Error during json unmarshal of injections: %v", err)
-				return
-			}
+// ValidateUserDefinedInjections returns error when any of the injections defined by the config map is invalid
+func ValidateUserDefinedInjections(injectionsCm *corev1.ConfigMap) error {
+	_, _, err := parseUserDefinedInjections(injectionsCm)
+	return err
+}
 
-			// lock for writing
-			userDefinedInjects.Lock()
-			defer userDefinedInjects.Unlock()
-
-			var patch types.JsonPatchOperation
-			var userDefinedPatchs = userDefinedInjects.Patchs
-
-			for k, value := range userDefinedInjectionsObj {
-				existValue, exists := userDefinedPatchs[k]
-				// unmarshal userDefined injection to json patch
-				err := json.Unmarshal([]byte(value), &patch)
-				if err != nil {
-					glog.Errorf("Failed to unmarshal user-defined injection: %v", v)
-					continue
-				}
-				// metadata.Annotations is the only supported field for user definition
-				// jsonPatchOperation.Path should be "/metadata/annotations"
-				if patch.Path != "/metadata/annotations" {
-					glog.Errorf("Path: %v is not supported, only /metadata/annotations can be defined by user", patch.Path)
-					continue
-				}
-
-				if !exists || !reflect.DeepEqual(existValue, patch) {
-					glog.Infof("Initializing user-defined injections with key: %v, value: %v", k, v)
-					userDefinedPatchs[k] = patch
-				}
-			}
+// parseUserDefinedInjections returns injections and their label selectors defined by the config map, no injection is
+// defined when the config map does not contain the injections key
+func parseUserDefinedInjections(injectionsCm *corev1.ConfigMap) (map[string]types.JsonPatchOperation, map[string]labels.Selector, error) {
+	patchs := make(map[string]types.JsonPatchOperation)
+	selectors := make(map[string]labels.Selector)
 
-			// remove stale entries from userDefined configMap
-			for k := range userDefinedPatchs {
-				if _, ok := userDefinedInjectionsObj[k]; ok {
-					continue
-				}
-				glog.Infof("Removing stale entry: %v from user-defined injections", k)
-				delete(userDefinedPatchs, k)
-			}
-		} else {
-			glog.Warningf("Map does not contains [%s]. Clear old entries.", userDefinedInjectionsMainKey)
-			userDefinedInjects.Patchs = make(map[string]types.JsonPatchOperation)
+	v, fileExists := injectionsCm.Data[types.ConfigMapMainFileKey]
+	if !fileExists {
+		glog.V(2).Infof("Map does not contains [%s], no user-defined injections", types.ConfigMapMainFileKey)
+		return patchs, selectors, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(v), &obj); err != nil {
+		return nil, nil, errors.Wrap(err, "error during json unmarshal of main")
+	}
+	userDefinedInjections, mainExists := obj[userDefinedInjectionsMainKey]
+	if !mainExists {
+		glog.V(2).Infof("Map does not contains [%s], no user-defined injections", userDefinedInjectionsMainKey)
+		return patchs, selectors, nil
+	}
+	var userDefinedInjectionsObj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(userDefinedInjections), &userDefinedInjectionsObj); err != nil {
+		return nil, nil, errors.Wrap(err, "error during json unmarshal of injections")
+	}
+
+	for k, value := range userDefinedInjectionsObj {
+		// unmarshal userDefined injection to json patch
+		var patch types.JsonPatchOperation
+		if err := json.Unmarshal([]byte(value), &patch); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to unmarshal user-defined injection %v", k)
+		}
+		if err := validateUserDefinedPatch(patch); err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid user-defined injection %v", k)
+		}
+		selector, err := getSelector(value)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid user-defined injection %v", k)
+		}
+		patchs[k] = patch
+		if selector != nil {
+			selectors[k] = selector
 		}
-	} else {
-		glog.Warningf("Map does not contains [%s]. Clear old entries", types.ConfigMapMainFileKey)
-		userDefinedInjects.Patchs = make(map[string]types.JsonPatchOperation)
 	}
+	return patchs, selectors, nil
+}
+
+// equalSelectors returns true when both maps contain the same selectors under the same keys
+func equalSelectors(a, b map[string]labels.Selector) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, selector := range a {
+		other, exists := b[k]
+		if !exists || selector.String() != other.String() {
+			return false
+		}
+	}
+	return true
+}
+
+func validateUserDefinedPatch(patch types.JsonPatchOperation) error {
+	switch patch.Path {
+	case annotationsPath:
+		return nil
+	case sysctlsPath:
+		if patch.Operation != "add" {
+			return errors.Errorf("operation %s is not supported for %s, only add can be defined by user", patch.Operation, patch.Path)
+		}
+		_, err := GetSysctls(patch)
+		return err
+	case volumesPath:
+		if patch.Operation != "add" {
+			return errors.Errorf("operation %s is not supported for %s, only add can be defined by user", patch.Operation, patch.Path)
+		}
+		_, err := GetVolumes(patch)
+		return err
+	default:
+		return errors.Errorf("path %s is not supported, only %s, %s and %s can be defined by user", patch.Path, annotationsPath,
+			sysctlsPath, volumesPath)
+	}
+}
+
+// GetSysctls returns sysctls carried by user-defined injection that targets spec.securityContext.sysctls
+func GetSysctls(patch types.JsonPatchOperation) ([]corev1.Sysctl, error) {
+	var sysctls []corev1.Sysctl
+
+	raw, err := json.Marshal(patch.Value)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &sysctls); err != nil {
+		return nil, errors.Wrap(err, "value is not a list of sysctls")
+	}
+	for _, sysctl := range sysctls {
+		if sysctl.Name == "" {
+			return nil, errors.New("sysctl name cannot be empty")
+		}
+	}
+
+	return sysctls, nil
+}
+
+// GetVolumes returns volumes carried by user-defined injection that targets spec.volumes
+func GetVolumes(patch types.JsonPatchOperation) ([]UserDefinedVolume, error) {
+	var volumes []UserDefinedVolume
+
+	raw, err := json.Marshal(patch.Value)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &volumes); err != nil {
+		return nil, errors.Wrap(err, "value is not a list of volumes")
+	}
+	for _, volume := range volumes {
+		if errs := validation.IsDNS1123Label(volume.Name); len(errs) > 0 {
+			return nil, errors.Errorf("invalid volume name '%s': %s", volume.Name, strings.Join(errs, ", "))
+		}
+		if volume.MountPath != "" && !path.IsAbs(volume.MountPath) {
+			return nil, errors.Errorf("mount path '%s' of volume '%s' is not absolute", volume.MountPath, volume.Name)
+		}
+	}
+
+	return volumes, nil
 }
 
 // CreateUserDefinedPatch creates customized patch for the specified POD
 func (userDefinedInjects *UserDefinedInjections) CreateUserDefinedPatch(pod corev1.Pod) ([]types.JsonPatchOperation, error) {
 	var userDefinedPatch []types.JsonPatchOperation
 
+	if userDefinedInjects == nil {
+		return nil, errors.New("user-defined injections are not initialized")
+	}
+
 	// lock for reading
-	userDefinedInjects.Lock()
-	defer userDefinedInjects.Unlock()
+	userDefinedInjects.RLock()
+	defer userDefinedInjects.RUnlock()
 
 	for k, v := range userDefinedInjects.Patchs {
-		// The userDefinedInjects will be injected when:
+		// The userDefinedInjects with label selector will be injected when the selector matches pod labels
+		if selector, exists := userDefinedInjects.Selectors[k]; exists {
+			if selector.Matches(labels.Set(pod.ObjectMeta.Labels)) {
+				userDefinedPatch = append(userDefinedPatch, v)
+			}
+			continue
+		}
+		// Other userDefinedInjects will be injected when:
 		// 1. Pod labels contain the patch key defined in userDefinedInjects
 		// 2. The value of patch key in pod labels(not in userDefinedInjects) is "true"
 		if podValue, exists := pod.ObjectMeta.Labels[k]; exists && strings.ToLower(podValue) == "true" {
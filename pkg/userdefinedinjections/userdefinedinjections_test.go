@@ -176,6 +176,73 @@ var _ = Describe("UserDefinedInjections", func() {
 			map[string]types.JsonPatchOperation{},
 			map[string]types.JsonPatchOperation{},
 		),
+		Entry(
+			"patch - sysctls",
+			&corev1.ConfigMap{
+				Data: map[string]string{
+					"config.json": "{\"user-defined-injections\": { \"nri-inject-sysctl\": {\"op\": \"add\", \"path\": \"/spec/securityContext/sysctls\", \"value\": [{\"name\": \"net.ipv4.conf.all.arp_filter\", \"value\": \"1\"}]}}}"},
+			},
+			map[string]types.JsonPatchOperation{},
+			map[string]types.JsonPatchOperation{
+				"nri-inject-sysctl": types.JsonPatchOperation{
+					Operation: "add",
+					Path:      "/spec/securityContext/sysctls",
+					Value:     []interface{}{map[string]interface{}{"name": "net.ipv4.conf.all.arp_filter", "value": "1"}},
+				},
+			},
+		),
+		Entry(
+			"patch - sysctls with invalid value",
+			&corev1.ConfigMap{
+				Data: map[string]string{
+					"config.json": "{\"user-defined-injections\": { \"nri-inject-sysctl\": {\"op\": \"add\", \"path\": \"/spec/securityContext/sysctls\", \"value\": {\"net.ipv4.conf.all.arp_filter\": \"1\"}}}}"},
+			},
+			map[string]types.JsonPatchOperation{},
+			map[string]types.JsonPatchOperation{},
+		),
+		Entry(
+			"patch - sysctls with unsupported operation",
+			&corev1.ConfigMap{
+				Data: map[string]string{
+					"config.json": "{\"user-defined-injections\": { \"nri-inject-sysctl\": {\"op\": \"remove\", \"path\": \"/spec/securityContext/sysctls\", \"value\": [{\"name\": \"net.ipv4.conf.all.arp_filter\", \"value\": \"1\"}]}}}"},
+			},
+			map[string]types.JsonPatchOperation{},
+			map[string]types.JsonPatchOperation{},
+		),
+		Entry(
+			"patch - volumes",
+			&corev1.ConfigMap{
+				Data: map[string]string{
+					"config.json": "{\"user-defined-injections\": { \"nri-inject-volume\": {\"op\": \"add\", \"path\": \"/spec/volumes\", \"value\": [{\"name\": \"hugepage\", \"emptyDir\": {\"medium\": \"HugePages\"}, \"mountPath\": \"/hugepages\"}]}}}"},
+			},
+			map[string]types.JsonPatchOperation{},
+			map[string]types.JsonPatchOperation{
+				"nri-inject-volume": types.JsonPatchOperation{
+					Operation: "add",
+					Path:      "/spec/volumes",
+					Value: []interface{}{map[string]interface{}{
+						"name": "hugepage", "emptyDir": map[string]interface{}{"medium": "HugePages"}, "mountPath": "/hugepages"}},
+				},
+			},
+		),
+		Entry(
+			"patch - volumes with invalid name",
+			&corev1.ConfigMap{
+				Data: map[string]string{
+					"config.json": "{\"user-defined-injections\": { \"nri-inject-volume\": {\"op\": \"add\", \"path\": \"/spec/volumes\", \"value\": [{\"name\": \"Huge_Page\", \"emptyDir\": {}}]}}}"},
+			},
+			map[string]types.JsonPatchOperation{},
+			map[string]types.JsonPatchOperation{},
+		),
+		Entry(
+			"patch - volumes with relative mount path",
+			&corev1.ConfigMap{
+				Data: map[string]string{
+					"config.json": "{\"user-defined-injections\": { \"nri-inject-volume\": {\"op\": \"add\", \"path\": \"/spec/volumes\", \"value\": [{\"name\": \"hugepage\", \"emptyDir\": {}, \"mountPath\": \"hugepages\"}]}}}"},
+			},
+			map[string]types.JsonPatchOperation{},
+			map[string]types.JsonPatchOperation{},
+		),
 		Entry(
 			"patch - additional networks annotation",
 			&corev1.ConfigMap{
@@ -243,4 +310,143 @@ var _ = Describe("UserDefinedInjections", func() {
 			},
 		),
 	)
+
+	Describe("Label selectors", func() {
+		configMapWith := func(injection string) *corev1.ConfigMap {
+			return &corev1.ConfigMap{Data: map[string]string{
+				"config.json": `{"user-defined-injections": {"frontend-networks": ` + injection + `}}`,
+			}}
+		}
+		podWith := func(podLabels map[string]string) corev1.Pod {
+			return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Labels: podLabels}}
+		}
+		annotationPatch := types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/metadata/annotations",
+			Value:     map[string]interface{}{"k8s.v1.cni.cncf.io/networks": "sriov-net"},
+		}
+
+		DescribeTable("matching pods",
+			func(selector string, podLabels map[string]string, matches bool) {
+				userDefinedInjects := CreateUserInjectionsStructure()
+				userDefinedInjects.SetUserDefinedInjections(configMapWith(`{"op": "add", "path": "/metadata/annotations",
+					"value": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}, "selector": ` + selector + `}`))
+
+				appliedPatchs, err := userDefinedInjects.CreateUserDefinedPatch(podWith(podLabels))
+				Expect(err).NotTo(HaveOccurred())
+				if matches {
+					Expect(appliedPatchs).To(Equal([]types.JsonPatchOperation{annotationPatch}))
+				} else {
+					Expect(appliedPatchs).To(BeEmpty())
+				}
+			},
+			Entry("In matches",
+				`{"matchExpressions": [{"key": "app", "operator": "In", "values": ["team-a-frontend", "team-b-frontend"]}]}`,
+				map[string]string{"app": "team-b-frontend"}, true),
+			Entry("In does not match",
+				`{"matchExpressions": [{"key": "app", "operator": "In", "values": ["team-a-frontend", "team-b-frontend"]}]}`,
+				map[string]string{"app": "team-a-backend"}, false),
+			Entry("NotIn matches",
+				`{"matchExpressions": [{"key": "app", "operator": "NotIn", "values": ["team-a-backend"]}]}`,
+				map[string]string{"app": "team-a-frontend"}, true),
+			Entry("Exists matches",
+				`{"matchExpressions": [{"key": "tier", "operator": "Exists"}]}`,
+				map[string]string{"tier": "frontend"}, true),
+			Entry("Exists does not match",
+				`{"matchExpressions": [{"key": "tier", "operator": "Exists"}]}`,
+				map[string]string{"app": "team-a-frontend"}, false),
+			Entry("matchLabels matches",
+				`{"matchLabels": {"tier": "frontend"}}`,
+				map[string]string{"tier": "frontend", "app": "team-a-frontend"}, true),
+			Entry("selector replaces injection key label",
+				`{"matchLabels": {"tier": "frontend"}}`,
+				map[string]string{"frontend-networks": "true"}, false),
+		)
+
+		It("should ignore injection with invalid selector", func() {
+			userDefinedInjects := CreateUserInjectionsStructure()
+			userDefinedInjects.SetUserDefinedInjections(configMapWith(`{"op": "add", "path": "/metadata/annotations",
+				"value": {"k8s.v1.cni.cncf.io/networks": "sriov-net"},
+				"selector": {"matchExpressions": [{"key": "app", "operator": "Like", "values": ["*-frontend"]}]}}`))
+			Expect(userDefinedInjects.Patchs).To(BeEmpty())
+			Expect(userDefinedInjects.Selectors).To(BeEmpty())
+		})
+
+		It("should fall back to injection key label when selector is removed", func() {
+			userDefinedInjects := CreateUserInjectionsStructure()
+			userDefinedInjects.SetUserDefinedInjections(configMapWith(`{"op": "add", "path": "/metadata/annotations",
+				"value": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}, "selector": {"matchLabels": {"tier": "frontend"}}}`))
+			Expect(userDefinedInjects.Selectors).To(HaveKey("frontend-networks"))
+
+			userDefinedInjects.SetUserDefinedInjections(configMapWith(`{"op": "add", "path": "/metadata/annotations",
+				"value": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}}`))
+			Expect(userDefinedInjects.Selectors).To(BeEmpty())
+			appliedPatchs, err := userDefinedInjects.CreateUserDefinedPatch(podWith(map[string]string{"frontend-networks": "true"}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(appliedPatchs).To(Equal([]types.JsonPatchOperation{annotationPatch}))
+		})
+	})
+
+	Describe("Reloading injections", func() {
+		configMapWith := func(injections string) *corev1.ConfigMap {
+			return &corev1.ConfigMap{Data: map[string]string{
+				"config.json": `{"user-defined-injections": {` + injections + `}}`,
+			}}
+		}
+		annotationInjection := `"nri-inject-annotation": {"op": "add", "path": "/metadata/annotations",
+			"value": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}}`
+		annotationPatch := types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/metadata/annotations",
+			Value:     map[string]interface{}{"k8s.v1.cni.cncf.io/networks": "sriov-net"},
+		}
+
+		DescribeTable("should keep current injections when new ones are invalid",
+			func(in *corev1.ConfigMap) {
+				userDefinedInjects := CreateUserInjectionsStructure()
+				userDefinedInjects.SetUserDefinedInjections(configMapWith(annotationInjection))
+
+				userDefinedInjects.SetUserDefinedInjections(in)
+				Expect(userDefinedInjects.Patchs).To(Equal(map[string]types.JsonPatchOperation{"nri-inject-annotation": annotationPatch}))
+			},
+			Entry("malformed config", &corev1.ConfigMap{Data: map[string]string{"config.json": `{"user-defined-injections": `}}),
+			Entry("invalid injection along with valid one", configMapWith(annotationInjection+`,
+				"nri-inject-volume": {"op": "add", "path": "/spec/volumes", "value": [{"name": "Huge_Page", "emptyDir": {}}]}`)),
+			Entry("invalid selector", configMapWith(`"nri-inject-annotation": {"op": "add", "path": "/metadata/annotations",
+				"value": {}, "selector": {"matchExpressions": [{"key": "app", "operator": "Like"}]}}`)),
+		)
+
+		It("should validate injections without applying them", func() {
+			Expect(ValidateUserDefinedInjections(configMapWith(annotationInjection))).To(Succeed())
+			Expect(ValidateUserDefinedInjections(&corev1.ConfigMap{})).To(Succeed())
+			Expect(ValidateUserDefinedInjections(configMapWith(`"nri-inject-node": {"op": "add", "path": "/spec/nodeName", "value": "node1"}`))).
+				To(MatchError(ContainSubstring("nri-inject-node")))
+		})
+
+		It("should serve patches while injections are reloaded", func() {
+			userDefinedInjects := CreateUserInjectionsStructure()
+			pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Labels: map[string]string{"nri-inject-annotation": "true"}}}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					userDefinedInjects.SetUserDefinedInjections(configMapWith(annotationInjection))
+					userDefinedInjects.SetUserDefinedInjections(configMapWith(""))
+				}
+			}()
+			for i := 0; i < 100; i++ {
+				appliedPatchs, err := userDefinedInjects.CreateUserDefinedPatch(pod)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(len(appliedPatchs)).To(BeNumerically("<=", 1))
+			}
+			<-done
+		})
+	})
+
+	It("should fail to create patch without user-defined injections structure", func() {
+		var userDefinedInjects *UserDefinedInjections
+		appliedPatchs, err := userDefinedInjects.CreateUserDefinedPatch(corev1.Pod{})
+		Expect(err).To(HaveOccurred())
+		Expect(appliedPatchs).To(BeEmpty())
+	})
 })
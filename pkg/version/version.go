@@ -0,0 +1,52 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package version reports the version and build information of the binaries, injected at build time via ldflags, e.g.
+// -X github.com/k8snetworkplumbingwg/network-resources-injector/pkg/version.Version=v1.0.0
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// build information, overridden at build time
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info is the version and build information reported by --version flag and /version endpoint
+type Info struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the version and build information of the running binary
+func Get() Info {
+	return Info{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// String returns the version and build information on a single line
+func (i Info) String() string {
+	return fmt.Sprintf("version %s, git commit %s, build date %s, %s", i.Version, i.GitCommit, i.BuildDate, i.GoVersion)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version
+
+import (
+	"encoding/json"
+	"runtime"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Version", func() {
+	var version, gitCommit, buildDate string
+
+	BeforeEach(func() {
+		version, gitCommit, buildDate = Version, GitCommit, BuildDate
+	})
+
+	AfterEach(func() {
+		Version, GitCommit, BuildDate = version, gitCommit, buildDate
+	})
+
+	It("should report defaults when build information is not injected", func() {
+		Expect(Get()).To(Equal(Info{Version: "dev", GitCommit: "unknown", BuildDate: "unknown", GoVersion: runtime.Version()}))
+	})
+
+	It("should report injected build information", func() {
+		Version, GitCommit, BuildDate = "v1.2.3", "0123abc", "2026-10-17T10:00:00Z"
+		Expect(Get().String()).To(Equal("version v1.2.3, git commit 0123abc, build date 2026-10-17T10:00:00Z, " + runtime.Version()))
+	})
+
+	It("should serialize build information as JSON", func() {
+		Version, GitCommit, BuildDate = "v1.2.3", "0123abc", "2026-10-17T10:00:00Z"
+		data, err := json.Marshal(Get())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(`{"version":"v1.2.3","gitCommit":"0123abc","buildDate":"2026-10-17T10:00:00Z","goVersion":"` + runtime.Version() + `"}`))
+	})
+})
@@ -0,0 +1,143 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+/* admin server responds within 10 seconds, resync which takes longer is abandoned */
+const cacheResyncTimeout = 8 * time.Second
+
+// CachedNetAttachDef is a net-attach-def held by the cache, reduced to the annotations resources are injected by
+type CachedNetAttachDef struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// ResourceNames holds values of the configured resource name keys present in the annotations
+	ResourceNames map[string]string `json:"resourceNames,omitempty"`
+	NodeSelector  string            `json:"nodeSelector,omitempty"`
+}
+
+// CacheHandler responds with JSON list of the cached net-attach-defs, optionally filtered by namespace and name
+// query parameters
+func CacheHandler(w http.ResponseWriter, req *http.Request) {
+	defaultWebhook.CacheHandler(w, req)
+}
+
+// CacheHandler responds with JSON list of net-attach-defs held by the cache of the webhook, optionally filtered by
+// namespace and name query parameters
+func (wh *Webhook) CacheHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Invalid HTTP verb requested", http.StatusMethodNotAllowed)
+		return
+	}
+	if wh.nadCache == nil {
+		http.Error(w, "net-attach-def cache is not set up", http.StatusServiceUnavailable)
+		return
+	}
+
+	namespace, name := req.URL.Query().Get("namespace"), req.URL.Query().Get("name")
+	nads := []CachedNetAttachDef{}
+	for _, entry := range wh.nadCache.List() {
+		if (namespace != "" && entry.Namespace != namespace) || (name != "" && entry.Name != name) {
+			continue
+		}
+		nad := CachedNetAttachDef{Namespace: entry.Namespace, Name: entry.Name, NodeSelector: entry.Annotations[nodeSelectorKey]}
+		for _, key := range wh.controlSwitches.GetResourceNameKeys() {
+			if resourceName, exists := entry.Annotations[key]; exists {
+				if nad.ResourceNames == nil {
+					nad.ResourceNames = make(map[string]string)
+				}
+				nad.ResourceNames[key] = resourceName
+			}
+		}
+		nads = append(nads, nad)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(nads); err != nil {
+		logger.Errorf("error writing cached net-attach-defs: %v", err)
+	}
+}
+
+// CacheResyncResult is the response of the cache resync endpoint
+type CacheResyncResult struct {
+	// NetAttachDefs is the count of net-attach-defs held by the cache after the resync
+	NetAttachDefs int `json:"netAttachDefs"`
+}
+
+// CacheResyncHandler lists net-attach-defs from API server again and replaces the cache content with them
+func CacheResyncHandler(w http.ResponseWriter, req *http.Request) {
+	defaultWebhook.CacheResyncHandler(w, req)
+}
+
+// CacheResyncHandler lists net-attach-defs from API server again and replaces content of the cache of the webhook with
+// them. Unlike the read-only endpoints it always requires verified client certificate, so it is not available without
+// client CAs.
+func (wh *Webhook) CacheResyncHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Invalid HTTP verb requested", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		http.Error(w, "verified client certificate is required", http.StatusForbidden)
+		return
+	}
+	if wh.nadCache == nil {
+		http.Error(w, "net-attach-def cache is not set up", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), cacheResyncTimeout)
+	defer cancel()
+	logger.Infof("net-attach-def cache resync is requested by %s", req.TLS.VerifiedChains[0][0].Subject)
+	if err := wh.nadCache.Resync(ctx); err != nil {
+		logger.Errorf("error resyncing net-attach-def cache: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(CacheResyncResult{NetAttachDefs: len(wh.nadCache.List())}); err != nil {
+		logger.Errorf("error writing cache resync result: %v", err)
+	}
+}
+
+// MetricsHandler responds with metrics of the admission requests in Prometheus text format
+func MetricsHandler(w http.ResponseWriter, req *http.Request) {
+	defaultWebhook.MetricsHandler(w, req)
+}
+
+// MetricsHandler responds with number of admission requests mutated by the webhook at the moment and of requests
+// denied because of the concurrency limit, in Prometheus text format
+func (wh *Webhook) MetricsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Invalid HTTP verb requested", http.StatusMethodNotAllowed)
+		return
+	}
+
+	inFlight, rejected := wh.admissions.stats()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP nri_admissions_in_flight Admission requests mutated at the moment.\n")
+	fmt.Fprintf(w, "# TYPE nri_admissions_in_flight gauge\n")
+	fmt.Fprintf(w, "nri_admissions_in_flight %d\n", inFlight)
+	fmt.Fprintf(w, "# HELP nri_admissions_rejected_total Admission requests denied because of the concurrency limit.\n")
+	fmt.Fprintf(w, "# TYPE nri_admissions_rejected_total counter\n")
+	fmt.Fprintf(w, "nri_admissions_rejected_total %d\n", rejected)
+}
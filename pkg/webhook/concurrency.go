@@ -0,0 +1,118 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// admissionRetryAfterSeconds is the delay suggested to clients of the API server retrying the denied request
+const admissionRetryAfterSeconds = 1
+
+// admissionLimiter limits admission requests mutated at once, so burst of pod creations does not open more API server
+// lookups than the webhook and API server are able to handle
+type admissionLimiter struct {
+	mutex    sync.Mutex
+	inFlight int
+	rejected uint64
+	// released is closed and replaced when a request completes, waking up the waiting requests
+	released chan struct{}
+}
+
+// acquire returns true when the request fits into the limit of requests mutated at once, requests above the limit
+// wait up to the timeout for a running one to complete. Requests are not limited when the limit is 0, they are still
+// counted as in flight.
+func (al *admissionLimiter) acquire(ctx context.Context, limit int, timeout time.Duration) bool {
+	var expired <-chan time.Time
+	if limit > 0 && timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		al.mutex.Lock()
+		if limit <= 0 || al.inFlight < limit {
+			al.inFlight++
+			al.mutex.Unlock()
+			return true
+		}
+		if timeout <= 0 {
+			al.rejected++
+			al.mutex.Unlock()
+			return false
+		}
+		if al.released == nil {
+			al.released = make(chan struct{})
+		}
+		released := al.released
+		al.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-expired:
+			al.reject()
+			return false
+		case <-ctx.Done():
+			al.reject()
+			return false
+		}
+	}
+}
+
+// reject counts request denied because of the limit
+func (al *admissionLimiter) reject() {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	al.rejected++
+}
+
+// release completes the acquired request and wakes up the waiting ones
+func (al *admissionLimiter) release() {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	al.inFlight--
+	if al.released != nil {
+		close(al.released)
+		al.released = nil
+	}
+}
+
+// stats returns number of requests in flight and of requests rejected since the start
+func (al *admissionLimiter) stats() (int, uint64) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	return al.inFlight, al.rejected
+}
+
+// prepareTooManyRequestsResponse denies the request with 429 status, so API server passes it to its client, which
+// retries the request later
+func prepareTooManyRequestsResponse(ar *admissionv1.AdmissionReview, limit int) error {
+	message := fmt.Sprintf("network resources injector is mutating %d admission requests at once, retry later", limit)
+	if err := prepareAdmissionReviewResponse(false, message, ar); err != nil {
+		return err
+	}
+	ar.Response.Result.Code = http.StatusTooManyRequests
+	ar.Response.Result.Reason = metav1.StatusReasonTooManyRequests
+	ar.Response.Result.Details = &metav1.StatusDetails{RetryAfterSeconds: admissionRetryAfterSeconds}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/logging"
+)
+
+// lookupLatencyKey is the context key of the lookup latency tracked for the admission request
+type lookupLatencyKey struct{}
+
+// lookupLatency sums time spent in net-attach-def lookups made for an admission request and remembers the slowest one
+type lookupLatency struct {
+	mutex   sync.Mutex
+	total   time.Duration
+	slowest string
+	longest time.Duration
+}
+
+// withLookupLatency returns context tracking time of net-attach-def lookups made with it
+func withLookupLatency(ctx context.Context) (context.Context, *lookupLatency) {
+	latency := &lookupLatency{}
+	return context.WithValue(ctx, lookupLatencyKey{}, latency), latency
+}
+
+// recordLookupLatency adds duration of the lookup of the net-attach-def to latency tracked in the context, if any
+func recordLookupLatency(ctx context.Context, nad string, duration time.Duration) {
+	latency, ok := ctx.Value(lookupLatencyKey{}).(*lookupLatency)
+	if !ok {
+		return
+	}
+	latency.mutex.Lock()
+	defer latency.mutex.Unlock()
+	latency.total += duration
+	if duration > latency.longest {
+		latency.slowest = nad
+		latency.longest = duration
+	}
+}
+
+// warnIfSlow logs warning when lookups took at least the fraction of the webhook timeout, so requests approaching
+// the timeout are visible before API server gives up on the webhook
+func (latency *lookupLatency) warnIfSlow(l logging.Logger, budget time.Duration, fraction float64) {
+	if fraction <= 0 {
+		return
+	}
+	latency.mutex.Lock()
+	defer latency.mutex.Unlock()
+	if latency.total == 0 || latency.total < time.Duration(fraction*float64(budget)) {
+		return
+	}
+	l.WithFields(logging.Fields{"lookup_ms": latency.total.Milliseconds(), "budget_ms": budget.Milliseconds(),
+		"slowest_nad": latency.slowest, "slowest_ms": latency.longest.Milliseconds()}).
+		Warningf("net-attach-def lookups are approaching the webhook timeout")
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+)
+
+const (
+	/* owners looked up on behalf of pods are cached for this long, so pods of one controller share the lookup */
+	ownerLookupTTL = time.Minute
+	/* number of cached owners before the expired ones are dropped */
+	maxCachedOwners = 1000
+	/* pod is owned by a ReplicaSet which is owned by a Deployment, controllers further up are not looked up */
+	maxOwnerDepth = 2
+)
+
+// cachedOwner is metadata of the owner controller, or nil when the owner does not exist
+type cachedOwner struct {
+	meta    *metav1.ObjectMeta
+	expires time.Time
+}
+
+// ownerLookups caches metadata of pod owner controllers read from API server by kind/namespace/name
+type ownerLookups struct {
+	mutex  sync.Mutex
+	owners map[string]cachedOwner
+}
+
+func (ol *ownerLookups) get(key string) (*metav1.ObjectMeta, bool) {
+	ol.mutex.Lock()
+	defer ol.mutex.Unlock()
+	owner, exists := ol.owners[key]
+	if !exists || time.Now().After(owner.expires) {
+		return nil, false
+	}
+	return owner.meta, true
+}
+
+func (ol *ownerLookups) put(key string, meta *metav1.ObjectMeta) {
+	ol.mutex.Lock()
+	defer ol.mutex.Unlock()
+	if ol.owners == nil {
+		ol.owners = make(map[string]cachedOwner)
+	}
+	if len(ol.owners) >= maxCachedOwners {
+		now := time.Now()
+		for k, owner := range ol.owners {
+			if now.After(owner.expires) {
+				delete(ol.owners, k)
+			}
+		}
+	}
+	if len(ol.owners) >= maxCachedOwners {
+		ol.owners = make(map[string]cachedOwner)
+	}
+	ol.owners[key] = cachedOwner{meta: meta, expires: time.Now().Add(ownerLookupTTL)}
+}
+
+// getOwnerNetworkSelections returns network annotation of the controller owning the pod, or of the controller owning
+// that one, e.g. Deployment of the ReplicaSet. The nearest controller carrying the annotation wins. Owners which do
+// not exist or are not supported are skipped.
+func (wh *Webhook) getOwnerNetworkSelections(ctx context.Context, pod corev1.Pod) (string, bool, error) {
+	ownerRef := metav1.GetControllerOf(&pod)
+	for depth := 0; ownerRef != nil && depth < maxOwnerDepth; depth++ {
+		owner, err := wh.getOwnerMeta(ctx, pod.ObjectMeta.Namespace, *ownerRef)
+		if err != nil {
+			return "", false, err
+		}
+		if owner == nil {
+			return "", false, nil
+		}
+		if nets, exists := owner.Annotations[networksAnnotationKey]; exists {
+			logger.Infof("%s is found in annotations of %s %s/%s owning pod %s", networksAnnotationKey, ownerRef.Kind,
+				owner.Namespace, owner.Name, pod.ObjectMeta.Name)
+			return nets, true, nil
+		}
+		ownerRef = metav1.GetControllerOf(owner)
+	}
+	return "", false, nil
+}
+
+// getOwnerMeta returns metadata of the owner in the namespace, nil is returned when owner does not exist, has
+// different UID, its kind is not supported or it is not cached and the lookup is throttled
+func (wh *Webhook) getOwnerMeta(ctx context.Context, namespace string, ownerRef metav1.OwnerReference) (*metav1.ObjectMeta, error) {
+	key := ownerRef.Kind + "/" + namespace + "/" + ownerRef.Name
+	owner, cached := wh.ownerLookups.get(key)
+	if !cached {
+		/* owner missing in the lookup cache is ignored while lookups are throttled */
+		if !wh.allowLookup(lookupSource(ctx, namespace)) {
+			logger.Warningf("could not get %s %s/%s, lookups are throttled, owner is ignored", ownerRef.Kind, namespace, ownerRef.Name)
+			return nil, nil
+		}
+		var err error
+		owner, err = wh.getOwnerFromAPIServer(ctx, namespace, ownerRef)
+		if apierrors.IsNotFound(err) {
+			logger.Infof("%s %s/%s is not found", ownerRef.Kind, namespace, ownerRef.Name)
+			owner, err = nil, nil
+		}
+		if err != nil {
+			return nil, classifyLookupError(errors.Wrapf(err, "could not get %s %s/%s", ownerRef.Kind, namespace, ownerRef.Name))
+		}
+		wh.ownerLookups.put(key, owner)
+	}
+	/* owner with the same name could have been recreated */
+	if owner == nil || owner.UID != ownerRef.UID {
+		return nil, nil
+	}
+	return owner, nil
+}
+
+func (wh *Webhook) getOwnerFromAPIServer(ctx context.Context, namespace string, ownerRef metav1.OwnerReference) (*metav1.ObjectMeta, error) {
+	getOptions := metav1.GetOptions{}
+	switch ownerRef.Kind {
+	case "ReplicaSet":
+		owner, err := wh.clientset.AppsV1().ReplicaSets(namespace).Get(ctx, ownerRef.Name, getOptions)
+		if err != nil {
+			return nil, err
+		}
+		return &owner.ObjectMeta, nil
+	case "Deployment":
+		owner, err := wh.clientset.AppsV1().Deployments(namespace).Get(ctx, ownerRef.Name, getOptions)
+		if err != nil {
+			return nil, err
+		}
+		return &owner.ObjectMeta, nil
+	case "DaemonSet":
+		owner, err := wh.clientset.AppsV1().DaemonSets(namespace).Get(ctx, ownerRef.Name, getOptions)
+		if err != nil {
+			return nil, err
+		}
+		return &owner.ObjectMeta, nil
+	case "StatefulSet":
+		owner, err := wh.clientset.AppsV1().StatefulSets(namespace).Get(ctx, ownerRef.Name, getOptions)
+		if err != nil {
+			return nil, err
+		}
+		return &owner.ObjectMeta, nil
+	case "ReplicationController":
+		owner, err := wh.clientset.CoreV1().ReplicationControllers(namespace).Get(ctx, ownerRef.Name, getOptions)
+		if err != nil {
+			return nil, err
+		}
+		return &owner.ObjectMeta, nil
+	default:
+		logger.Infof("owner reference kind is not supported: %v", ownerRef.Kind)
+		return nil, nil
+	}
+}
+
+// networksAnnotationPatch returns network annotation selected on behalf of the pod, e.g. of its owner, in the form of
+// user defined annotations patch, so it is used the same way as user defined network annotation and pod gets it for
+// Multus to attach the networks
+func networksAnnotationPatch(nets string) types.JsonPatchOperation {
+	return types.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/metadata/annotations",
+		Value:     map[string]interface{}{networksAnnotationKey: nets},
+	}
+}
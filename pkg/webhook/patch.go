@@ -0,0 +1,106 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/logging"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+)
+
+// strategicMergePatchAuditKey is key of the audit annotation carrying strategic merge patch equivalent to the
+// JSON patch of the response
+const strategicMergePatchAuditKey = "strategic-merge-patch"
+
+// patchedObjectTypes are structures of the mutated objects, they define merge strategy of their lists
+var patchedObjectTypes = map[string]interface{}{
+	"Pod":         corev1.Pod{},
+	"Deployment":  appsv1.Deployment{},
+	"StatefulSet": appsv1.StatefulSet{},
+	"DaemonSet":   appsv1.DaemonSet{},
+}
+
+// setResponsePatch sets JSON patch of the admission response. API server accepts only JSON patch from admission
+// webhooks, so equivalent strategic merge patch is rendered for introspection only, when enabled: it is logged and
+// returned as audit annotation of the request. When patch validation is enabled, error is returned instead of the
+// patch which does not apply to the mutated object.
+func (wh *Webhook) setResponsePatch(ar *admissionv1.AdmissionReview, patch []types.JsonPatchOperation, l logging.Logger) error {
+	patchBytes, _ := json.Marshal(patch)
+	if wh.controlSwitches.IsValidatePatchEnabled() {
+		if err := validatePatch(ar.Request.Object.Raw, patchBytes); err != nil {
+			l.Errorf("patch %s does not apply to %s %s/%s: %v", patchBytes, ar.Request.Kind.Kind,
+				ar.Request.Namespace, ar.Request.Name, err)
+			return serverError{errors.Wrapf(err, "network resources injector created invalid patch of %s", ar.Request.Kind.Kind)}
+		}
+	}
+	ar.Response.Patch = patchBytes
+	ar.Response.PatchType = func() *admissionv1.PatchType {
+		pt := admissionv1.PatchTypeJSONPatch
+		return &pt
+	}()
+
+	if !wh.controlSwitches.IsStrategicMergePatchEnabled() {
+		return nil
+	}
+	smp, err := strategicMergePatch(ar.Request.Object.Raw, patchBytes, patchedObjectTypes[ar.Request.Kind.Kind])
+	if err != nil {
+		l.Warningf("failed to render strategic merge patch: %v", err)
+		return nil
+	}
+	l.Infof("strategic merge patch: %s", smp)
+	if ar.Response.AuditAnnotations == nil {
+		ar.Response.AuditAnnotations = make(map[string]string)
+	}
+	ar.Response.AuditAnnotations[strategicMergePatchAuditKey] = string(smp)
+	return nil
+}
+
+// validatePatch applies JSON patch to the original object, the same way API server does, so patch API server
+// would reject is caught before it is returned
+func validatePatch(original, patchBytes []byte) error {
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		return errors.Wrap(err, "unable to decode JSON patch")
+	}
+	if _, err := patch.Apply(original); err != nil {
+		return errors.Wrap(err, "unable to apply JSON patch")
+	}
+	return nil
+}
+
+// strategicMergePatch renders JSON patch of the object as strategic merge patch, by applying the JSON patch and
+// diffing the patched object with the original one
+func strategicMergePatch(original, patchBytes []byte, dataStruct interface{}) ([]byte, error) {
+	if dataStruct == nil {
+		return nil, errors.New("unknown type of the patched object")
+	}
+	patch, err := jsonpatch.DecodePatch(patchBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode JSON patch")
+	}
+	patched, err := patch.Apply(original)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to apply JSON patch")
+	}
+	return strategicpatch.CreateTwoWayMergePatch(original, patched, dataStruct)
+}
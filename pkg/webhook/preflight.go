@@ -0,0 +1,168 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/userdefinedinjections"
+)
+
+const (
+	nadGroupVersion = "k8s.cni.cncf.io/v1"
+	nadResource     = "network-attachment-definitions"
+)
+
+// PreflightResult is the outcome of a single preflight check, Err is nil when the check passed
+type PreflightResult struct {
+	Check string
+	Err   error
+}
+
+// PreflightReport returns human readable report of the preflight checks and true when all of them passed
+func PreflightReport(results []PreflightResult) (string, bool) {
+	var report strings.Builder
+	passed := true
+	for _, result := range results {
+		if result.Err != nil {
+			passed = false
+			fmt.Fprintf(&report, "FAIL %s: %v\n", result.Check, result.Err)
+		} else {
+			fmt.Fprintf(&report, "PASS %s\n", result.Check)
+		}
+	}
+	return report.String(), passed
+}
+
+// Preflight checks configuration of the webhook, reachability of API server, presence of the net-attach-def CRD and
+// content of the control switches config map in the given namespace. All checks are run, checks depending on
+// API server are failed when it cannot be reached.
+func (wh *Webhook) Preflight(ctx context.Context, namespace, configMapName string) []PreflightResult {
+	var results []PreflightResult
+	check := func(name string, err error) {
+		results = append(results, PreflightResult{Check: name, Err: err})
+	}
+
+	check("control switches", wh.controlSwitches.ValidateControlSwitches())
+	check("resource name keys", wh.checkResourceNameKeys())
+
+	_, err := wh.clientset.Discovery().ServerVersion()
+	if err != nil {
+		err = errors.Wrap(err, "could not reach API server")
+	}
+	check("API server", err)
+	reachable := err == nil
+
+	crdErr := errors.New("API server is not reachable")
+	if reachable {
+		crdErr = wh.checkNadCRD()
+	}
+	check("net-attach-def CRD", crdErr)
+
+	nadsErr := errors.New("net-attach-def CRD is not available")
+	if crdErr == nil {
+		nadsErr = wh.checkNadTargetContainers(ctx)
+	}
+	check("net-attach-def target containers", nadsErr)
+
+	configMapErr := errors.New("API server is not reachable")
+	if reachable {
+		configMapErr = wh.checkControlSwitchesConfigMap(ctx, namespace, configMapName)
+	}
+	check(fmt.Sprintf("config map %s/%s", namespace, configMapName), configMapErr)
+
+	return results
+}
+
+// checkResourceNameKeys returns error when no resource name key is configured or any of them is empty
+func (wh *Webhook) checkResourceNameKeys() error {
+	keys := wh.controlSwitches.GetResourceNameKeys()
+	if len(keys) == 0 {
+		return errors.New("no resource name key is configured")
+	}
+	for i, key := range keys {
+		if key == "" {
+			return errors.Errorf("resource name key %d is empty", i)
+		}
+	}
+	return nil
+}
+
+// checkNadCRD returns error when API server does not serve net-attach-defs
+func (wh *Webhook) checkNadCRD() error {
+	resources, err := wh.clientset.Discovery().ServerResourcesForGroupVersion(nadGroupVersion)
+	if err != nil {
+		return errors.Wrapf(err, "could not discover resources of %s", nadGroupVersion)
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == nadResource {
+			return nil
+		}
+	}
+	return errors.Errorf("%s are not served by %s", nadResource, nadGroupVersion)
+}
+
+// checkNadTargetContainers returns error listing net-attach-defs whose target containers expression does not compile
+func (wh *Webhook) checkNadTargetContainers(ctx context.Context) error {
+	raw, err := wh.clientset.ExtensionsV1beta1().RESTClient().Get().AbsPath("/apis", nadGroupVersion, nadResource).DoRaw(ctx)
+	if err != nil {
+		return errors.Wrap(err, "could not list net-attach-defs")
+	}
+	nads := cniv1.NetworkAttachmentDefinitionList{}
+	if err := json.Unmarshal(raw, &nads); err != nil {
+		return errors.Wrap(err, "could not decode net-attach-defs")
+	}
+	var invalid []string
+	for _, nad := range nads.Items {
+		if target, exists := nad.GetAnnotations()[targetContainersKey]; exists {
+			if _, err := compileTargetContainers(target); err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s/%s: %v", nad.Namespace, nad.Name, err))
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		return errors.Errorf("invalid %s annotation of net-attach-defs: %s", targetContainersKey, strings.Join(invalid, "; "))
+	}
+	return nil
+}
+
+// checkControlSwitchesConfigMap returns error when features or user-defined injections of the config map are not
+// valid, missing config map is valid
+func (wh *Webhook) checkControlSwitchesConfigMap(ctx context.Context, namespace, name string) error {
+	cm, err := wh.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not get config map")
+	}
+	if err := wh.controlSwitches.ValidateControlSwitchesConfigMap(cm); err != nil {
+		return err
+	}
+	return errors.Wrap(userdefinedinjections.ValidateUserDefinedInjections(cm), "invalid user-defined injections")
+}
+
+// Preflight runs the preflight checks of the webhook configured by the package level functions
+func Preflight(ctx context.Context, namespace, configMapName string) []PreflightResult {
+	return defaultWebhook.Preflight(ctx, namespace, configMapName)
+}
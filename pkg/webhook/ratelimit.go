@@ -0,0 +1,134 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// maxIdleLimiters is number of source limiters kept before the idle ones are dropped
+const maxIdleLimiters = 1000
+
+// maxLookupBackoff caps the time lookups of a throttled source are refused for
+const maxLookupBackoff = 5 * time.Minute
+
+// errLookupThrottled is returned instead of looking up API server when lookups of the source are throttled, callers
+// decide from caches only
+var errLookupThrottled = errors.New("lookups are throttled")
+
+// sourceLimiter throttles lookups of one source. Source exceeding the rate is refused for the backoff period, which
+// doubles with every throttled lookup in a row and is reset by the first lookup allowed afterwards.
+type sourceLimiter struct {
+	limiter      *rate.Limiter
+	throttled    int
+	backoffUntil time.Time
+}
+
+// sourceLimiters throttle API server lookups made on behalf of pods, separately for every source, which is the
+// namespace of the admission request
+type sourceLimiters struct {
+	mutex    sync.Mutex
+	limiters map[string]*sourceLimiter
+}
+
+// allow returns true when lookup of the source fits into the limit of lookups per second and the burst and the source
+// is not backing off, lookups are not throttled when the limit is 0
+func (sl *sourceLimiters) allow(source string, limit float64, burst int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	if sl.limiters == nil {
+		sl.limiters = make(map[string]*sourceLimiter)
+	}
+	now := time.Now()
+	limiter, exists := sl.limiters[source]
+	if !exists || limiter.limiter.Limit() != rate.Limit(limit) || limiter.limiter.Burst() != burst {
+		if len(sl.limiters) >= maxIdleLimiters {
+			sl.dropIdle(now)
+		}
+		limiter = &sourceLimiter{limiter: rate.NewLimiter(rate.Limit(limit), burst)}
+		sl.limiters[source] = limiter
+	}
+	if now.Before(limiter.backoffUntil) {
+		return false
+	}
+	if limiter.limiter.AllowN(now, 1) {
+		limiter.throttled = 0
+		return true
+	}
+	limiter.throttled++
+	limiter.backoffUntil = now.Add(lookupBackoff(limit, limiter.throttled))
+	return false
+}
+
+// lookupBackoff returns time lookups are refused for after the given number of throttled lookups in a row, starting
+// with the interval between two lookups allowed by the limit
+func lookupBackoff(limit float64, throttled int) time.Duration {
+	backoff := time.Duration(float64(time.Second) / limit)
+	for i := 1; i < throttled && backoff < maxLookupBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxLookupBackoff {
+		return maxLookupBackoff
+	}
+	return backoff
+}
+
+// dropIdle removes limiters with full bucket which are not backing off, they behave the same as newly created ones
+func (sl *sourceLimiters) dropIdle(now time.Time) {
+	for source, limiter := range sl.limiters {
+		if !now.Before(limiter.backoffUntil) && limiter.limiter.TokensAt(now) >= float64(limiter.limiter.Burst()) {
+			delete(sl.limiters, source)
+		}
+	}
+}
+
+// lookupSourceKey is the context key of the source API server lookups are throttled for
+type lookupSourceKey struct{}
+
+// withLookupSource returns context throttling lookups made with it as lookups of the admission request source, which
+// is the request namespace, or the requesting user when the namespace is not set
+func withLookupSource(ctx context.Context, request *admissionv1.AdmissionRequest) context.Context {
+	if request == nil {
+		return ctx
+	}
+	source := request.Namespace
+	if source == "" {
+		source = "user/" + request.UserInfo.Username
+	}
+	return context.WithValue(ctx, lookupSourceKey{}, source)
+}
+
+// lookupSource returns source of lookups made with the context, the fallback when the context has none
+func lookupSource(ctx context.Context, fallback string) string {
+	if source, ok := ctx.Value(lookupSourceKey{}).(string); ok {
+		return source
+	}
+	return fallback
+}
+
+// allowLookup returns true when API server lookup of the source is not throttled
+func (wh *Webhook) allowLookup(source string) bool {
+	return wh.lookupLimiters.allow(source, wh.controlSwitches.GetLookupRateLimit(), wh.controlSwitches.GetLookupRateBurst())
+}
@@ -19,6 +19,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"sync"
 
 	"github.com/golang/glog"
@@ -138,3 +139,73 @@ func GetClientAuth(insecure bool) tls.ClientAuthType {
 	}
 	return tls.RequireAndVerifyClientCert
 }
+
+// DefaultTLSMinVersion is the minimal TLS version accepted by the webhook server by default
+const DefaultTLSMinVersion = "1.2"
+
+// DefaultTLSCipherSuites are TLS 1.2 cipher suites accepted by the webhook server by default. Cipher suites of
+// TLS 1.3 are not configurable.
+var DefaultTLSCipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//ParseTLSMinVersion returns TLS version of the given name, either 1.2 or 1.3
+func ParseTLSMinVersion(name string) (uint16, error) {
+	version, ok := tlsVersions[strings.TrimSpace(name)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version '%s', expected 1.2 or 1.3", name)
+	}
+	return version, nil
+}
+
+//ParseTLSCipherSuites returns TLS 1.2 cipher suites of the comma separated IANA names, e.g.
+//TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, default cipher suites when no name is given. Cipher suites with known
+//security issues are rejected.
+func ParseTLSCipherSuites(names string) ([]uint16, error) {
+	supported := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		suite, ok := supported[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("insecure TLS cipher suite '%s' is not allowed", name)
+		case !ok:
+			return nil, fmt.Errorf("unknown TLS cipher suite '%s'", name)
+		case !supportsTLS12(suite):
+			return nil, fmt.Errorf("TLS cipher suite '%s' is not configurable, only TLS 1.2 cipher suites are", name)
+		}
+		suites = append(suites, suite.ID)
+	}
+	if len(suites) == 0 {
+		return DefaultTLSCipherSuites, nil
+	}
+	return suites, nil
+}
+
+func supportsTLS12(suite *tls.CipherSuite) bool {
+	for _, version := range suite.SupportedVersions {
+		if version == tls.VersionTLS12 {
+			return true
+		}
+	}
+	return false
+}
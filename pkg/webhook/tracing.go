@@ -0,0 +1,84 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	/* name of the instrumentation library reported with the spans */
+	tracerName = "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/webhook"
+	/* name of the service reported with the spans */
+	tracingServiceName = "network-resources-injector"
+
+	admissionSpanName       = "admission-review"
+	namespaceLookupSpanName = "namespace-lookup"
+	nadLookupSpanName       = "net-attach-def-lookup"
+	patchSpanName           = "patch-construction"
+)
+
+// SetupTracing exports spans of admission handling to the OTLP/HTTP collector listening on the given host:port
+// endpoint, trace context is propagated from W3C trace headers of the requests. Spans are recorded only when the
+// request is sampled by its parent or when it has no parent. The returned function flushes and stops the exporter.
+func SetupTracing(ctx context.Context, endpoint string, insecure bool) (func(context.Context) error, error) {
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create trace exporter for endpoint '%s'", endpoint)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(tracingServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// tracer returns tracer of the global provider, spans are not recorded unless tracing is set up
+func tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// startAdmissionSpan starts span of the admission request, as a child of the span sent in the request headers if any
+func startAdmissionSpan(ctx context.Context, header map[string][]string) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+	return tracer().Start(ctx, admissionSpanName, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// endSpan ends the span, marking it as failed when the error is given
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
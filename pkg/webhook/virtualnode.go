@@ -0,0 +1,58 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// isVirtualNodePod returns true when pod targets virtual nodes, e.g. of Virtual Kubelet providers, which do not provide
+// network resources. Pod targets virtual nodes when it is bound to node with one of the virtual node name prefixes,
+// its node selector matches the virtual node selector or it tolerates one of the virtual node taints.
+func (wh *Webhook) isVirtualNodePod(pod *corev1.Pod) bool {
+	for _, prefix := range wh.controlSwitches.GetVirtualNodePrefixes() {
+		if strings.HasPrefix(pod.Spec.NodeName, prefix) {
+			return true
+		}
+	}
+	if selector := wh.controlSwitches.GetVirtualNodeSelector(); selector != nil && len(pod.Spec.NodeSelector) > 0 &&
+		selector.Matches(labels.Set(pod.Spec.NodeSelector)) {
+		return true
+	}
+	for _, signature := range wh.controlSwitches.GetVirtualNodeTolerations() {
+		for _, toleration := range pod.Spec.Tolerations {
+			if matchesTolerationSignature(toleration, signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesTolerationSignature returns true when toleration of the pod tolerates the taint described by the signature.
+// Signature without value or effect matches toleration with any value or effect, toleration without key tolerating
+// every taint is not a signature of any node.
+func matchesTolerationSignature(toleration, signature corev1.Toleration) bool {
+	if toleration.Key != signature.Key {
+		return false
+	}
+	if signature.Value != "" && toleration.Operator != corev1.TolerationOpExists && toleration.Value != signature.Value {
+		return false
+	}
+	return signature.Effect == "" || toleration.Effect == "" || toleration.Effect == signature.Effect
+}
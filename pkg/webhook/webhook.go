@@ -18,33 +18,59 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/golang/glog"
 	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	multus "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
 
 	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/selection"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/logging"
 	netcache "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/tools"
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/userdefinedinjections"
 )
 
+// networkReplicas holds number of resource sets requested by network selection elements, element which is not
+// present requests one set
+type networkReplicas map[*multus.NetworkSelectionElement]int64
+
+// get returns number of resource sets requested by the network selection element
+func (replicas networkReplicas) get(net *multus.NetworkSelectionElement) int64 {
+	if count, exists := replicas[net]; exists {
+		return count
+	}
+	return 1
+}
+
 type hugepageResourceData struct {
 	ResourceName  string
 	ContainerName string
@@ -54,26 +80,150 @@ type hugepageResourceData struct {
 const (
 	networksAnnotationKey       = "k8s.v1.cni.cncf.io/networks"
 	nodeSelectorKey             = "k8s.v1.cni.cncf.io/nodeSelector"
+	resourceNameOverrideKey     = "k8s.v1.cni.cncf.io/resourceNameOverride"
+	removeResourcesKey          = "k8s.v1.cni.cncf.io/removeResources"
+	injectorStatusInjected      = "injected"
+	topologyAwareKey            = "k8s.v1.cni.cncf.io/topologyAware"
+	cpuRequestKey               = "k8s.v1.cni.cncf.io/cpuRequest"
+	memoryRequestKey            = "k8s.v1.cni.cncf.io/memoryRequest"
+	targetContainersKey         = "k8s.v1.cni.cncf.io/targetContainers"
+	nodeAffinityKey             = "k8s.v1.cni.cncf.io/nodeAffinity"
+	resourceMapKey              = "k8s.v1.cni.cncf.io/resourceMap"
+	tokenAudienceKey            = "k8s.v1.cni.cncf.io/serviceAccountTokenAudience"
 	defaultNetworkAnnotationKey = "v1.multus-cni.io/default-network"
+	namespaceLabelDisabledValue = "disabled"
+
+	/* key of network selection element in JSON form with number of resource sets requested by the network */
+	networkReplicasKey = "replicas"
+
+	/* names of annotations owned by the injector, prefixed with the configured annotation domain */
+	injectedResourcesKey = "injected-resources"
+	sourceNadsKey        = "source-nads"
+	skipInjectionKey     = "skip"
+	injectOptInKey       = "inject"
+	injectorStatusKey    = "status"
+	topologyHintKey      = "topology-aware"
+	injectionErrorsKey   = "injection-errors"
+	injectedComputeKey   = "injected-compute"
+
+	// NadAliasAnnotation - name of the net-attach-def annotation holding alias networks can select it by, prefixed
+	// with the configured annotation domain
+	NadAliasAnnotation = "alias"
+
+	containersPath          = "/spec/containers"
+	initContainersPath      = "/spec/initContainers"
+	ephemeralContainersPath = "/spec/ephemeralContainers"
+	podTemplatePath         = "/spec/template"
+	podSpecPath             = "/spec"
+
+	/* ephemeral containers are added to existing pods by update of this pod subresource */
+	ephemeralContainersSubResource = "ephemeralcontainers"
+
+	/* name of the projected volume with service account tokens requested by net-attach-defs */
+	tokenVolumeName = "cni-token"
+
+	/* reason of the event emitted when injection is denied */
+	injectionDeniedReason = "NetworkResourcesInjectionDenied"
+
+	/* API server waits for the webhook 10s by default */
+	defaultWebhookTimeout = 10 * time.Second
+	webhookResponseMargin = time.Second
 )
 
 var (
+	logger = logging.NewGlogLogger()
+	// defaultWebhook is the webhook configured by the package level functions, kept for compatibility
+	defaultWebhook = &Webhook{userDefinedInjections: userdefinedinjections.CreateUserInjectionsStructure()}
+)
+
+// Webhook holds dependencies of the mutating webhook, so independent webhooks can be served side by side
+type Webhook struct {
 	clientset             kubernetes.Interface
 	nadCache              netcache.NetAttachDefCacheService
+	namespaceCache        netcache.NamespaceCacheService
+	ownerCache            netcache.OwnerCacheService
+	eventRecorder         record.EventRecorder
 	userDefinedInjections *userdefinedinjections.UserDefinedInjections
 	controlSwitches       *controlswitches.ControlSwitches
-)
+	lookupLimiters        sourceLimiters
+	ownerLookups          ownerLookups
+	admissions            admissionLimiter
+}
+
+// NewWebhook creates webhook using the API client and control switches, caches, event recorder and user defined
+// injections are optional and can be set up with the setters
+func NewWebhook(clientset kubernetes.Interface, switches *controlswitches.ControlSwitches) *Webhook {
+	return &Webhook{
+		clientset:             clientset,
+		controlSwitches:       switches,
+		userDefinedInjections: userdefinedinjections.CreateUserInjectionsStructure(),
+	}
+}
+
+// SetNetAttachDefCache sets up the net-attach-def cache service of the webhook
+func (wh *Webhook) SetNetAttachDefCache(cache netcache.NetAttachDefCacheService) {
+	wh.nadCache = cache
+}
+
+// SetOwnerCache sets up the pod owner cache service of the webhook
+func (wh *Webhook) SetOwnerCache(cache netcache.OwnerCacheService) {
+	wh.ownerCache = cache
+}
+
+// SetNamespaceCache sets up the namespace cache service of the webhook
+func (wh *Webhook) SetNamespaceCache(cache netcache.NamespaceCacheService) {
+	wh.namespaceCache = cache
+}
+
+// SetEventRecorder sets recorder of events emitted by the webhook when injection is denied
+func (wh *Webhook) SetEventRecorder(recorder record.EventRecorder) {
+	wh.eventRecorder = recorder
+}
+
+// SetUserInjectionStructure sets user defined injections of the webhook
+func (wh *Webhook) SetUserInjectionStructure(injections *userdefinedinjections.UserDefinedInjections) {
+	wh.userDefinedInjections = injections
+}
+
+// admissionCodecs decode AdmissionReview of all supported versions, older clusters still send v1beta1
+var admissionCodecs = func() serializer.CodecFactory {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(admissionv1.AddToScheme(scheme))
+	utilruntime.Must(admissionv1beta1.AddToScheme(scheme))
+	return serializer.NewCodecFactory(scheme)
+}()
+
+// SetLogger sets logger used by the webhook
+func SetLogger(l logging.Logger) {
+	logger = l
+}
 
 func SetControlSwitches(activeConfiguration *controlswitches.ControlSwitches) {
-	controlSwitches = activeConfiguration
+	defaultWebhook.controlSwitches = activeConfiguration
+}
+
+// SetEventRecorder sets recorder of events emitted when injection is denied
+func SetEventRecorder(recorder record.EventRecorder) {
+	defaultWebhook.SetEventRecorder(recorder)
 }
 
 func SetUserInjectionStructure(injections *userdefinedinjections.UserDefinedInjections) {
-	userDefinedInjections = injections
+	defaultWebhook.SetUserInjectionStructure(injections)
+}
+
+// isSupportedAdmissionReviewVersion returns true for AdmissionReview API versions the webhook is able to handle
+func isSupportedAdmissionReviewVersion(apiVersion string) bool {
+	return apiVersion == admissionv1.SchemeGroupVersion.String() || apiVersion == admissionv1beta1.SchemeGroupVersion.String()
 }
 
 func prepareAdmissionReviewResponse(allowed bool, message string, ar *admissionv1.AdmissionReview) error {
 	if ar.Request != nil {
+		/* API server accepts only response of the same version as was sent in the request */
+		apiVersion := ar.APIVersion
+		if !isSupportedAdmissionReviewVersion(apiVersion) {
+			apiVersion = admissionv1.SchemeGroupVersion.String()
+		}
+		ar.TypeMeta = metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: apiVersion}
 		ar.Response = &admissionv1.AdmissionResponse{
 			UID:     ar.Request.UID,
 			Allowed: allowed,
@@ -88,11 +238,15 @@ func prepareAdmissionReviewResponse(allowed bool, message string, ar *admissionv
 	return errors.New("received empty AdmissionReview request")
 }
 
-func readAdmissionReview(req *http.Request, w http.ResponseWriter) (*admissionv1.AdmissionReview, int, error) {
+func (wh *Webhook) readAdmissionReview(req *http.Request, w http.ResponseWriter) (*admissionv1.AdmissionReview, int, error) {
+	if wh.controlSwitches.IsRequestBodyStreamingEnabled() {
+		return wh.streamAdmissionReview(req, w)
+	}
+
 	var body []byte
 
 	if req.Body != nil {
-		req.Body = http.MaxBytesReader(w, req.Body, 1<<20)
+		req.Body = http.MaxBytesReader(w, req.Body, wh.controlSwitches.GetRequestBodyLimit())
 		if data, err := ioutil.ReadAll(req.Body); err == nil {
 			body = data
 		}
@@ -100,7 +254,7 @@ func readAdmissionReview(req *http.Request, w http.ResponseWriter) (*admissionv1
 
 	if len(body) == 0 {
 		err := errors.New("Error reading HTTP request: empty body")
-		glog.Errorf("%s", err)
+		logger.Errorf("%s", err)
 		return nil, http.StatusBadRequest, err
 	}
 
@@ -108,7 +262,7 @@ func readAdmissionReview(req *http.Request, w http.ResponseWriter) (*admissionv1
 	contentType := req.Header.Get("Content-Type")
 	if contentType != "application/json" {
 		err := errors.Errorf("Invalid Content-Type='%s', expected 'application/json'", contentType)
-		glog.Errorf("%v", err)
+		logger.Errorf("%v", err)
 		return nil, http.StatusUnsupportedMediaType, err
 	}
 
@@ -116,26 +270,81 @@ func readAdmissionReview(req *http.Request, w http.ResponseWriter) (*admissionv1
 	ar, err := deserializeAdmissionReview(body)
 	if err != nil {
 		err := errors.Wrap(err, "error deserializing AdmissionReview")
-		glog.Errorf("%v", err)
+		logger.Errorf("%v", err)
 		return nil, http.StatusBadRequest, err
 	}
 
 	return ar, http.StatusOK, nil
 }
 
-func deserializeAdmissionReview(body []byte) (*admissionv1.AdmissionReview, error) {
+// streamAdmissionReview decodes AdmissionReview directly from the request body, without reading whole body into
+// memory first, so larger requests up to the streamed body limit can be handled with bounded memory usage
+func (wh *Webhook) streamAdmissionReview(req *http.Request, w http.ResponseWriter) (*admissionv1.AdmissionReview, int, error) {
+	/* validate HTTP request headers */
+	contentType := req.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		err := errors.Errorf("Invalid Content-Type='%s', expected 'application/json'", contentType)
+		logger.Errorf("%v", err)
+		return nil, http.StatusUnsupportedMediaType, err
+	}
+
+	if req.Body == nil || req.Body == http.NoBody {
+		err := errors.New("Error reading HTTP request: empty body")
+		logger.Errorf("%s", err)
+		return nil, http.StatusBadRequest, err
+	}
+
 	ar := &admissionv1.AdmissionReview{}
-	runtimeScheme := runtime.NewScheme()
-	codecs := serializer.NewCodecFactory(runtimeScheme)
-	deserializer := codecs.UniversalDeserializer()
-	_, _, err := deserializer.Decode(body, nil, ar)
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, wh.controlSwitches.GetRequestBodyLimit()))
+	if err := decoder.Decode(ar); err != nil {
+		if err == io.EOF {
+			err = errors.New("Error reading HTTP request: empty body")
+		} else {
+			err = errors.Wrap(err, "error deserializing AdmissionReview")
+		}
+		logger.Errorf("%v", err)
+		return nil, http.StatusBadRequest, err
+	}
 
 	/* Decode() won't return an error if the data wasn't actual AdmissionReview */
-	if err == nil && ar.TypeMeta.Kind != "AdmissionReview" {
-		err = errors.New("received object is not an AdmissionReview")
+	if ar.TypeMeta.Kind != "AdmissionReview" {
+		err := errors.New("error deserializing AdmissionReview: received object is not an AdmissionReview")
+		logger.Errorf("%v", err)
+		return nil, http.StatusBadRequest, err
+	}
+
+	if !isSupportedAdmissionReviewVersion(ar.APIVersion) {
+		err := errors.Errorf("error deserializing AdmissionReview: unsupported AdmissionReview version '%s'", ar.APIVersion)
+		logger.Errorf("%v", err)
+		return nil, http.StatusBadRequest, err
+	}
+
+	return ar, http.StatusOK, nil
+}
+
+// deserializeAdmissionReview decodes AdmissionReview of any supported version, v1beta1 AdmissionReview is returned
+// as v1 object with original apiVersion kept, so the response is sent back in the version of the request
+func deserializeAdmissionReview(body []byte) (*admissionv1.AdmissionReview, error) {
+	obj, gvk, err := admissionCodecs.UniversalDeserializer().Decode(body, nil, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return ar, err
+	switch review := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		review.SetGroupVersionKind(*gvk)
+		return review, nil
+	case *admissionv1beta1.AdmissionReview:
+		/* v1beta1 AdmissionReview is wire compatible with v1, only apiVersion differs */
+		ar := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, ar); err != nil {
+			return nil, err
+		}
+		ar.SetGroupVersionKind(*gvk)
+		return ar, nil
+	default:
+		return nil, errors.New("received object is not an AdmissionReview")
+	}
 }
 
 func deserializeNetworkAttachmentDefinition(ar *admissionv1.AdmissionReview) (cniv1.NetworkAttachmentDefinition, error) {
@@ -145,11 +354,61 @@ func deserializeNetworkAttachmentDefinition(ar *admissionv1.AdmissionReview) (cn
 	return netAttachDef, err
 }
 
-func deserializePod(ar *admissionv1.AdmissionReview) (corev1.Pod, error) {
+// namespaceError is returned when namespace of the pod cannot be determined
+type namespaceError struct {
+	error
+}
+
+func (e namespaceError) Unwrap() error {
+	return e.error
+}
+
+// serverError is returned when request cannot be processed because of the webhook or API server failure, not
+// because of the object
+type serverError struct {
+	error
+}
+
+func (e serverError) Unwrap() error {
+	return e.error
+}
+
+// unresolvedNetworkError is returned when net-attach-def selected by the pod cannot be looked up, requested resources
+// are not modified by the network then
+type unresolvedNetworkError struct {
+	error
+}
+
+func (e unresolvedNetworkError) Unwrap() error {
+	return e.error
+}
+
+// isServerError returns true when err or any error it wraps is a server error
+func isServerError(err error) bool {
+	var se serverError
+	return errors.As(err, &se)
+}
+
+// classifyLookupError marks transient API server errors as server errors, other lookup errors are caused by
+// the object, e.g. it references net-attach-def which does not exist
+func classifyLookupError(err error) error {
+	if isRetryableError(err) {
+		return serverError{err}
+	}
+	return err
+}
+
+func (wh *Webhook) deserializePod(ctx context.Context, ar *admissionv1.AdmissionReview) (corev1.Pod, error) {
 	/* unmarshal Pod from AdmissionReview request */
 	pod := corev1.Pod{}
+	if ar.Request == nil {
+		return pod, errors.New("received empty AdmissionReview request")
+	}
+	if len(ar.Request.Object.Raw) == 0 {
+		return pod, errors.New("received AdmissionReview request without object")
+	}
 	err := json.Unmarshal(ar.Request.Object.Raw, &pod)
-	if pod.ObjectMeta.Namespace != "" {
+	if err != nil || pod.ObjectMeta.Namespace != "" {
 		return pod, err
 	}
 
@@ -161,86 +420,183 @@ func deserializePod(ar *admissionv1.AdmissionReview) (corev1.Pod, error) {
 
 	ownerRef := pod.ObjectMeta.OwnerReferences
 	if ownerRef != nil && len(ownerRef) > 0 {
-		namespace, err := getNamespaceFromOwnerReference(pod.ObjectMeta.OwnerReferences[0])
+		namespace, err := wh.getNamespaceFromOwnerReference(ctx, pod.ObjectMeta.OwnerReferences[0])
+		/* owner is not in the owner cache, so the namespace is unknown when the lookup is throttled */
+		if errors.Is(err, errLookupThrottled) {
+			logger.Warningf("%v, namespace of pod '%s' is resolved from caches only", err, pod.ObjectMeta.Name)
+			namespace, err = "", nil
+		}
 		if err != nil {
-			return pod, err
+			return pod, namespaceError{classifyLookupError(err)}
 		}
 		pod.ObjectMeta.Namespace = namespace
 	}
+	if pod.ObjectMeta.Namespace != "" {
+		return pod, nil
+	}
+
+	return pod, wh.resolveUnknownNamespace(&pod)
+}
+
+// resolveUnknownNamespace sets the fallback namespace to the pod whose namespace could not be determined. Rather than
+// guessing, such pod could be denied, or its namespace is left empty when it should be admitted without injection.
+func (wh *Webhook) resolveUnknownNamespace(pod *corev1.Pod) error {
+	if wh.controlSwitches.IsDenyUnresolvedNamespaceEnabled() {
+		return namespaceError{errors.Errorf("namespace of pod '%s' could not be determined", pod.ObjectMeta.Name)}
+	}
+	if wh.controlSwitches.IsSkipUnresolvedNamespaceEnabled() {
+		logger.Warningf("namespace of pod '%s' could not be determined, pod is not mutated", pod.ObjectMeta.Name)
+		return nil
+	}
+	pod.ObjectMeta.Namespace = wh.controlSwitches.GetFallbackNamespace()
+	logger.Infof("namespace of pod '%s' could not be determined, using fallback namespace '%s'",
+		pod.ObjectMeta.Name, pod.ObjectMeta.Namespace)
+	return nil
+}
+
+// workloadControllerKinds are kinds of objects with pod template which could be mutated instead of pods
+var workloadControllerKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+}
+
+// isWorkloadController returns true when AdmissionReview object is a workload controller with pod template
+func isWorkloadController(ar *admissionv1.AdmissionReview) bool {
+	return ar.Request != nil && ar.Request.Kind.Group == v1.GroupName && workloadControllerKinds[ar.Request.Kind.Kind]
+}
+
+// deserializePodTemplate returns pod built from the pod template of the workload controller, pod name and namespace
+// are taken from the controller
+func (wh *Webhook) deserializePodTemplate(ar *admissionv1.AdmissionReview) (corev1.Pod, error) {
+	controller := struct {
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+		Spec              struct {
+			Template corev1.PodTemplateSpec `json:"template"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(ar.Request.Object.Raw, &controller); err != nil {
+		return corev1.Pod{}, err
+	}
+
+	pod := corev1.Pod{
+		ObjectMeta: controller.Spec.Template.ObjectMeta,
+		Spec:       controller.Spec.Template.Spec,
+	}
+	pod.ObjectMeta.Name = controller.Name
+	pod.ObjectMeta.Namespace = controller.Namespace
+	if pod.ObjectMeta.Namespace == "" {
+		pod.ObjectMeta.Namespace = ar.Request.Namespace
+	}
+	if pod.ObjectMeta.Namespace != "" {
+		return pod, nil
+	}
+	return pod, wh.resolveUnknownNamespace(&pod)
+}
 
-	// pod.ObjectMeta.Namespace may still be empty at this point,
-	// but there is a chance that net-attach-def annotation contains
-	// a valid namespace
-	return pod, err
+// prefixPatchPaths moves patch operations computed for a pod under the given path, e.g. pod template
+func prefixPatchPaths(patch []types.JsonPatchOperation, prefix string) []types.JsonPatchOperation {
+	for i := range patch {
+		patch[i].Path = prefix + patch[i].Path
+	}
+	return patch
 }
 
-func getNamespaceFromOwnerReference(ownerRef metav1.OwnerReference) (namespace string, err error) {
-	namespace = ""
-	switch ownerRef.Kind {
-	case "ReplicaSet":
-		var replicaSets *v1.ReplicaSetList
-		replicaSets, err = clientset.AppsV1().ReplicaSets("").List(context.TODO(), metav1.ListOptions{})
+// ownerLister lists owners with the given name across all namespaces
+type ownerLister func(ctx context.Context, clientset kubernetes.Interface, listOptions metav1.ListOptions) ([]metav1.ObjectMeta, error)
+
+// ownerListers list owners of the supported kinds, see controlswitches.SupportedOwnerKinds. Pods are owned directly
+// by these controllers, so pod of a CronJob is resolved via the Job owning it.
+var ownerListers = map[string]ownerLister{
+	"ReplicaSet": func(ctx context.Context, clientset kubernetes.Interface, listOptions metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+		replicaSets, err := clientset.AppsV1().ReplicaSets("").List(ctx, listOptions)
 		if err != nil {
-			return
+			return nil, err
 		}
+		owners := make([]metav1.ObjectMeta, 0, len(replicaSets.Items))
 		for _, replicaSet := range replicaSets.Items {
-			if replicaSet.ObjectMeta.Name == ownerRef.Name && replicaSet.ObjectMeta.UID == ownerRef.UID {
-				namespace = replicaSet.ObjectMeta.Namespace
-				err = nil
-				break
-			}
+			owners = append(owners, replicaSet.ObjectMeta)
 		}
-	case "DaemonSet":
-		var daemonSets *v1.DaemonSetList
-		daemonSets, err = clientset.AppsV1().DaemonSets("").List(context.TODO(), metav1.ListOptions{})
+		return owners, nil
+	},
+	"DaemonSet": func(ctx context.Context, clientset kubernetes.Interface, listOptions metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+		daemonSets, err := clientset.AppsV1().DaemonSets("").List(ctx, listOptions)
 		if err != nil {
-			return
+			return nil, err
 		}
+		owners := make([]metav1.ObjectMeta, 0, len(daemonSets.Items))
 		for _, daemonSet := range daemonSets.Items {
-			if daemonSet.ObjectMeta.Name == ownerRef.Name && daemonSet.ObjectMeta.UID == ownerRef.UID {
-				namespace = daemonSet.ObjectMeta.Namespace
-				err = nil
-				break
-			}
+			owners = append(owners, daemonSet.ObjectMeta)
 		}
-	case "StatefulSet":
-		var statefulSets *v1.StatefulSetList
-		statefulSets, err = clientset.AppsV1().StatefulSets("").List(context.TODO(), metav1.ListOptions{})
+		return owners, nil
+	},
+	"StatefulSet": func(ctx context.Context, clientset kubernetes.Interface, listOptions metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+		statefulSets, err := clientset.AppsV1().StatefulSets("").List(ctx, listOptions)
 		if err != nil {
-			return
+			return nil, err
 		}
+		owners := make([]metav1.ObjectMeta, 0, len(statefulSets.Items))
 		for _, statefulSet := range statefulSets.Items {
-			if statefulSet.ObjectMeta.Name == ownerRef.Name && statefulSet.ObjectMeta.UID == ownerRef.UID {
-				namespace = statefulSet.ObjectMeta.Namespace
-				err = nil
-				break
-			}
+			owners = append(owners, statefulSet.ObjectMeta)
 		}
-	case "ReplicationController":
-		var replicationControllers *corev1.ReplicationControllerList
-		replicationControllers, err = clientset.CoreV1().ReplicationControllers("").List(context.TODO(), metav1.ListOptions{})
+		return owners, nil
+	},
+	"ReplicationController": func(ctx context.Context, clientset kubernetes.Interface, listOptions metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+		replicationControllers, err := clientset.CoreV1().ReplicationControllers("").List(ctx, listOptions)
 		if err != nil {
-			return
+			return nil, err
 		}
+		owners := make([]metav1.ObjectMeta, 0, len(replicationControllers.Items))
 		for _, replicationController := range replicationControllers.Items {
-			if replicationController.ObjectMeta.Name == ownerRef.Name && replicationController.ObjectMeta.UID == ownerRef.UID {
-				namespace = replicationController.ObjectMeta.Namespace
-				err = nil
-				break
-			}
+			owners = append(owners, replicationController.ObjectMeta)
 		}
-	default:
-		glog.Infof("owner reference kind is not supported: %v, using default namespace", ownerRef.Kind)
-		namespace = "default"
-		return
-	}
+		return owners, nil
+	},
+	"Job": func(ctx context.Context, clientset kubernetes.Interface, listOptions metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+		jobs, err := clientset.BatchV1().Jobs("").List(ctx, listOptions)
+		if err != nil {
+			return nil, err
+		}
+		owners := make([]metav1.ObjectMeta, 0, len(jobs.Items))
+		for _, job := range jobs.Items {
+			owners = append(owners, job.ObjectMeta)
+		}
+		return owners, nil
+	},
+}
 
-	if namespace == "" {
-		err = errors.New("pod namespace is not found")
+// getNamespaceFromOwnerReference returns namespace of the pod owner. Owner is looked up in the owner cache first,
+// on cache miss owners of the given kind and name are listed from API server and matched by UID. Namespace is left
+// empty for the caller to decide when owner kind is not supported or not enabled, errLookupThrottled is returned when
+// the lookup is throttled.
+func (wh *Webhook) getNamespaceFromOwnerReference(ctx context.Context, ownerRef metav1.OwnerReference) (string, error) {
+	listOwners, supported := ownerListers[ownerRef.Kind]
+	if !supported || !wh.controlSwitches.IsOwnerKindEnabled(ownerRef.Kind) {
+		logger.Infof("owner reference kind is not supported: %v", ownerRef.Kind)
+		return "", nil
+	}
+	if wh.ownerCache != nil {
+		if namespace, exists := wh.ownerCache.GetNamespace(ownerRef.Kind, ownerRef.UID); exists {
+			return namespace, nil
+		}
+		logger.Infof("cache entry not found, retrieving %s '%s' from api server", ownerRef.Kind, ownerRef.Name)
+	}
+	if !wh.allowLookup(lookupSource(ctx, "")) {
+		return "", errors.Wrapf(errLookupThrottled, "could not get %s '%s'", ownerRef.Kind, ownerRef.Name)
 	}
 
-	return
-
+	/* owner namespace is unknown, so owners with the same name are listed across all namespaces */
+	listOptions := metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", ownerRef.Name).String()}
+	owners, err := listOwners(ctx, wh.clientset, listOptions)
+	if err != nil {
+		return "", err
+	}
+	for _, owner := range owners {
+		if owner.Name == ownerRef.Name && owner.UID == ownerRef.UID {
+			return owner.Namespace, nil
+		}
+	}
+	return "", errors.New("pod namespace is not found")
 }
 
 func toSafeJsonPatchKey(in string) string {
@@ -249,54 +605,152 @@ func toSafeJsonPatchKey(in string) string {
 	return out
 }
 
+// fromSafeJsonPatchKey decodes JSON pointer reference token escaped by toSafeJsonPatchKey, '~1' has to be replaced
+// before '~0' so that escaped '~1' literal is not decoded into '/'
+func fromSafeJsonPatchKey(in string) string {
+	out := strings.Replace(in, "~1", "/", -1)
+	out = strings.Replace(out, "~0", "~", -1)
+	return out
+}
+
+// parsePodNetworkSelections parses network selection annotation, either JSON array of network selection elements
+// or comma separated list of [namespace/]name[@interface] elements. Either all elements are returned, or none of
+// them along with an error.
 func parsePodNetworkSelections(podNetworks, defaultNamespace string) ([]*multus.NetworkSelectionElement, error) {
 	var networkSelections []*multus.NetworkSelectionElement
 
 	if len(podNetworks) == 0 {
 		err := errors.New("empty string passed as network selection elements list")
-		glog.Error(err)
+		logger.Errorf("%v", err)
 		return nil, err
 	}
 
 	/* try to parse as JSON array */
 	err := json.Unmarshal([]byte(podNetworks), &networkSelections)
-
-	/* if failed, try to parse as comma separated */
-	if err != nil {
-		glog.Infof("'%s' is not in JSON format: %s... trying to parse as comma separated network selections list", podNetworks, err)
+	if err == nil {
+		if err := validateNetworkSelections(podNetworks, networkSelections); err != nil {
+			err := errors.Wrap(err, "error parsing network selection element")
+			logger.Errorf("%v", err)
+			return nil, err
+		}
+	} else {
+		/* if failed, try to parse as comma separated, JSON array could have been decoded partially */
+		logger.Infof("'%s' is not in JSON format: %s... trying to parse as comma separated network selections list", podNetworks, err)
+		networkSelections = nil
 		for _, networkSelection := range strings.Split(podNetworks, ",") {
 			networkSelection = strings.TrimSpace(networkSelection)
 			networkSelectionElement, err := parsePodNetworkSelectionElement(networkSelection, defaultNamespace)
 			if err != nil {
 				err := errors.Wrap(err, "error parsing network selection element")
-				glog.Error(err)
+				logger.Errorf("%v", err)
 				return nil, err
 			}
 			networkSelections = append(networkSelections, networkSelectionElement)
 		}
 	}
 
-	/* fill missing namespaces with default value */
+	/* fill missing namespaces with default value, pod namespace is resolved before its networks are parsed */
 	for _, networkSelection := range networkSelections {
 		if networkSelection.Namespace == "" {
 			if defaultNamespace == "" {
-				// Ignore the AdmissionReview request when the following conditions are met:
-				// 1) net-attach-def annotation doesn't contain a valid namespace
-				// 2) defaultNamespace retrieved from admission request is empty
-				// Pod admission would fail in subsquent call "getNetworkAttachmentDefinition"
-				// if no namespace is specified. We don't want to fail the pod creation
-				// in such case since it is possible that pod is not a SR-IOV pod
-				glog.Warningf("The admission request doesn't contain a valid namespace, ignoring...")
-				return nil, nil
-			} else {
-				networkSelection.Namespace = defaultNamespace
+				err := errors.Errorf("namespace of network '%s' could not be determined", networkSelection.Name)
+				logger.Errorf("%v", err)
+				return nil, err
 			}
+			networkSelection.Namespace = defaultNamespace
 		}
 	}
 
 	return networkSelections, nil
 }
 
+// validateNetworkSelections checks network selection elements decoded from JSON array. Elements have to select
+// network by a valid name, namespace is optional.
+func validateNetworkSelections(podNetworks string, networkSelections []*multus.NetworkSelectionElement) error {
+	if networkSelections == nil {
+		return errors.Errorf("network selection elements list '%s' is not an array", podNetworks)
+	}
+	for i, networkSelection := range networkSelections {
+		if networkSelection == nil {
+			return errors.Errorf("network selection element %d is null", i)
+		}
+		if networkSelection.Name == "" {
+			return errors.Errorf("network selection element %d has no name", i)
+		}
+		if msgs := validation.IsDNS1123Subdomain(networkSelection.Name); len(msgs) > 0 {
+			return errors.Errorf("network selection element %d has invalid name '%s': %s", i, networkSelection.Name,
+				strings.Join(msgs, ", "))
+		}
+		if networkSelection.Namespace == "" {
+			continue
+		}
+		if msgs := validation.IsDNS1123Label(networkSelection.Namespace); len(msgs) > 0 {
+			return errors.Errorf("network selection element %d has invalid namespace '%s': %s", i, networkSelection.Namespace,
+				strings.Join(msgs, ", "))
+		}
+	}
+	return nil
+}
+
+// parseNetworkReplicas records replicas of network selection elements decoded from JSON array, networks have to be
+// the elements parsed from the same podNetworks. Elements of comma separated list request one resource set each.
+func parseNetworkReplicas(podNetworks string, networks []*multus.NetworkSelectionElement, replicas networkReplicas) error {
+	var elements []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(podNetworks), &elements); err != nil || len(elements) != len(networks) {
+		return nil
+	}
+	for i, element := range elements {
+		raw, exists := element[networkReplicasKey]
+		if !exists {
+			continue
+		}
+		var count int64
+		if err := json.Unmarshal(raw, &count); err != nil || count < 1 {
+			err := errors.Errorf("network selection element %d has invalid %s '%s', positive integer is expected", i,
+				networkReplicasKey, string(raw))
+			logger.Errorf("%v", err)
+			return err
+		}
+		replicas[networks[i]] = count
+	}
+	return nil
+}
+
+// dedupNetworkSelections removes repeated selections of the same network with the same interface name, keeping
+// the first one. Selections of the same network with different interfaces are attachments of their own and are kept.
+func dedupNetworkSelections(networks []*multus.NetworkSelectionElement) []*multus.NetworkSelectionElement {
+	seen := make(map[string]bool, len(networks))
+	deduped := make([]*multus.NetworkSelectionElement, 0, len(networks))
+	for _, n := range networks {
+		key := n.Namespace + "/" + n.Name + "@" + n.InterfaceRequest
+		if seen[key] {
+			logger.Infof("ignoring repeated selection of network '%s/%s' with interface '%s'", n.Namespace, n.Name, n.InterfaceRequest)
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, n)
+	}
+	return deduped
+}
+
+// mergeNetworkSelections appends networks selected by an alternate annotation to the already selected networks.
+// Network already selected with the same interface name overlaps and is skipped, repeated selections within the
+// alternate annotation are kept.
+func mergeNetworkSelections(networks, alternate []*multus.NetworkSelectionElement) []*multus.NetworkSelectionElement {
+	selected := make(map[string]bool, len(networks))
+	for _, n := range networks {
+		selected[n.Namespace+"/"+n.Name+"@"+n.InterfaceRequest] = true
+	}
+	for _, n := range alternate {
+		if selected[n.Namespace+"/"+n.Name+"@"+n.InterfaceRequest] {
+			logger.Infof("network '%s/%s' with interface '%s' is already selected, skipping...", n.Namespace, n.Name, n.InterfaceRequest)
+			continue
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
 func parsePodNetworkSelectionElement(selection, defaultNamespace string) (*multus.NetworkSelectionElement, error) {
 	var namespace, name, netInterface string
 	var networkSelectionElement *multus.NetworkSelectionElement
@@ -309,9 +763,14 @@ func parsePodNetworkSelectionElement(selection, defaultNamespace string) (*multu
 	case 2:
 		namespace = units[0]
 		name = units[1]
+		if namespace == "" {
+			err := errors.Errorf("invalid network selection element - empty namespace in: '%s'", selection)
+			logger.Infof("%v", err)
+			return networkSelectionElement, err
+		}
 	default:
 		err := errors.Errorf("invalid network selection element - more than one '/' rune in: '%s'", selection)
-		glog.Info(err)
+		logger.Infof("%v", err)
 		return networkSelectionElement, err
 	}
 
@@ -323,9 +782,20 @@ func parsePodNetworkSelectionElement(selection, defaultNamespace string) (*multu
 	case 2:
 		name = units[0]
 		netInterface = units[1]
+		if netInterface == "" {
+			err := errors.Errorf("invalid network selection element - empty interface in: '%s'", selection)
+			logger.Infof("%v", err)
+			return networkSelectionElement, err
+		}
 	default:
 		err := errors.Errorf("invalid network selection element - more than one '@' rune in: '%s'", selection)
-		glog.Info(err)
+		logger.Infof("%v", err)
+		return networkSelectionElement, err
+	}
+
+	if name == "" {
+		err := errors.Errorf("invalid network selection element - empty network name in: '%s'", selection)
+		logger.Infof("%v", err)
 		return networkSelectionElement, err
 	}
 
@@ -334,7 +804,7 @@ func parsePodNetworkSelectionElement(selection, defaultNamespace string) (*multu
 		ok := validNameRegex.MatchString(unit)
 		if !ok && len(unit) > 0 {
 			err := errors.Errorf("at least one of the network selection units is invalid: error found at '%s'", unit)
-			glog.Info(err)
+			logger.Infof("%v", err)
 			return networkSelectionElement, err
 		}
 	}
@@ -348,12 +818,63 @@ func parsePodNetworkSelectionElement(selection, defaultNamespace string) (*multu
 	return networkSelectionElement, nil
 }
 
-func getNetworkAttachmentDefinition(namespace, name string) (*cniv1.NetworkAttachmentDefinition, error) {
+// isRetryableError returns true for transient API server errors which could succeed when request is repeated
+func isRetryableError(err error) bool {
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	if apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsInternalError(err) || apierrors.IsServiceUnavailable(err) {
+		return true
+	}
+	if status, ok := err.(apierrors.APIStatus); ok && status.Status().Code >= http.StatusInternalServerError {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (wh *Webhook) getNetworkAttachmentDefinition(ctx context.Context, namespace, name string) (*cniv1.NetworkAttachmentDefinition, error) {
 	path := fmt.Sprintf("/apis/k8s.cni.cncf.io/v1/namespaces/%s/network-attachment-definitions/%s", namespace, name)
-	rawNetworkAttachmentDefinition, err := clientset.ExtensionsV1beta1().RESTClient().Get().AbsPath(path).DoRaw(context.TODO())
+
+	if !wh.allowLookup(lookupSource(ctx, namespace)) {
+		return nil, errors.Wrapf(errLookupThrottled, "could not get Network Attachment Definition %s/%s", namespace, name)
+	}
+
+	/* time of the lookup including retries counts against the webhook timeout */
+	start := time.Now()
+	defer func() {
+		recordLookupLatency(ctx, namespace+"/"+name, time.Since(start))
+	}()
+
+	var rawNetworkAttachmentDefinition []byte
+	var err error
+	delay := wh.controlSwitches.GetNadLookupRetryDelay()
+retries:
+	for attempt := 0; ; attempt++ {
+		rawNetworkAttachmentDefinition, err = wh.clientset.ExtensionsV1beta1().RESTClient().Get().AbsPath(path).DoRaw(ctx)
+		if err == nil || attempt >= wh.controlSwitches.GetNadLookupRetries() || !isRetryableError(err) {
+			break
+		}
+		/* do not retry when the webhook would not be able to respond in time */
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			break
+		}
+		logger.Warningf("transient error getting Network Attachment Definition %s/%s, retrying in %v: %v", namespace, name, delay, err)
+		/* request could be cancelled by API server while waiting */
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			break retries
+		}
+		delay *= 2
+	}
 	if err != nil {
-		err := errors.Wrapf(err, "could not get Network Attachment Definition %s/%s", namespace, name)
-		glog.Error(err)
+		err := errors.Wrapf(classifyLookupError(err), "could not get Network Attachment Definition %s/%s", namespace, name)
+		logger.Errorf("%v", err)
 		return nil, err
 	}
 
@@ -363,89 +884,846 @@ func getNetworkAttachmentDefinition(namespace, name string) (*cniv1.NetworkAttac
 	return &networkAttachmentDefinition, nil
 }
 
-func parseNetworkAttachDefinition(net *multus.NetworkSelectionElement, reqs map[string]int64, nsMap map[string]string) (map[string]int64, map[string]string, error) {
+// networkResources accumulates resources requested by the pod networks along with their node selection constraints
+// and the other pod requirements the networks define
+type networkResources struct {
+	/* map of resources request needed by a pod and a number of them */
+	reqs map[string]int64
+	/* map of node labels on which pod needs to be scheduled */
+	nsMap map[string]string
+	/* node affinity match expressions required by the pod networks */
+	nodeAffinity []corev1.NodeSelectorRequirement
+	/* CPU and memory requested by the networks, under the target containers expression */
+	computeReqs map[string]corev1.ResourceList
+	/* topology awareness of networks requesting resources, under the network 'namespace/name' key */
+	topologyAware map[string]bool
+	/* resources of networks targeted at containers by name, under the target expression */
+	targetedReqs map[string]map[string]int64
+	/* node affinity required or preferred by the networks */
+	injectedAffinity *corev1.NodeAffinity
+	/* audiences of service account tokens requested by the networks */
+	tokenAudiences map[string]bool
+}
+
+// newNetworkResources returns networkResources without any network added
+func newNetworkResources() *networkResources {
+	return &networkResources{
+		reqs:             make(map[string]int64),
+		nsMap:            make(map[string]string),
+		computeReqs:      make(map[string]corev1.ResourceList),
+		topologyAware:    make(map[string]bool),
+		targetedReqs:     make(map[string]map[string]int64),
+		injectedAffinity: &corev1.NodeAffinity{},
+		tokenAudiences:   make(map[string]bool),
+	}
+}
+
+// parseNetworkAttachDefinition looks up net-attach-def selected by the network and adds resources, node selection
+// constraints and other requirements of the network to netResources. Resources are requested replicas times.
+func (wh *Webhook) parseNetworkAttachDefinition(ctx context.Context, net *multus.NetworkSelectionElement, replicas int64, netResources *networkResources) error {
+	ctx, span := tracer().Start(ctx, nadLookupSpanName, trace.WithAttributes(attribute.String("k8s.namespace.name", net.Namespace),
+		attribute.String("net-attach-def.name", net.Name)))
 	/* for each network in annotation ask API server for network-attachment-definition */
-	annotationsMap := nadCache.Get(net.Namespace, net.Name)
+	annotationsMap := wh.nadCache.Get(net.Namespace, net.Name)
+	config := wh.nadCache.GetConfig(net.Namespace, net.Name)
+	span.SetAttributes(attribute.Bool("cache.hit", annotationsMap != nil))
+	if annotationsMap == nil && wh.controlSwitches.IsResolveNetworkAliasesEnabled() {
+		name, err := wh.resolveNetworkReference(net)
+		if err != nil {
+			endSpan(span, err)
+			logger.Errorf("%v", err)
+			return err
+		}
+		if name != "" {
+			logger.Infof("network '%s/%s' refers to net-attach-def '%s/%s'", net.Namespace, net.Name, net.Namespace, name)
+			span.SetAttributes(attribute.String("net-attach-def.resolved-name", name))
+			annotationsMap = wh.nadCache.Get(net.Namespace, name)
+			config = wh.nadCache.GetConfig(net.Namespace, name)
+		}
+	}
 	if annotationsMap == nil {
-		glog.Infof("cache entry not found, retrieving network attachment definition '%s/%s' from api server", net.Namespace, net.Name)
-		networkAttachmentDefinition, err := getNetworkAttachmentDefinition(net.Namespace, net.Name)
+		logger.Infof("cache entry not found, retrieving network attachment definition '%s/%s' from api server", net.Namespace, net.Name)
+		networkAttachmentDefinition, err := wh.getNetworkAttachmentDefinition(ctx, net.Namespace, net.Name)
 		if err != nil {
+			endSpan(span, err)
 			/* if doesn't exist: deny pod */
 			reason := errors.Wrapf(err, "could not find network attachment definition '%s/%s'", net.Namespace, net.Name)
-			glog.Error(reason)
-			return reqs, nsMap, reason
+			if apierrors.IsNotFound(err) {
+				reason = errors.New(wh.controlSwitches.GetNadNotFoundMessage(net.Namespace, net.Name, err))
+			}
+			/* decision is made from the cache only, which does not have the net-attach-def */
+			if errors.Is(err, errLookupThrottled) {
+				reason = errors.Errorf("network attachment definition '%s/%s' is not found in cache and API server lookups are throttled",
+					net.Namespace, net.Name)
+			}
+			logger.Errorf("%v", reason)
+			return unresolvedNetworkError{reason}
 		}
 		annotationsMap = networkAttachmentDefinition.GetAnnotations()
+		config = networkAttachmentDefinition.Spec.Config
 	}
-	glog.Infof("network attachment definition '%s/%s' found", net.Namespace, net.Name)
+	span.End()
+	logger.Infof("network attachment definition '%s/%s' found", net.Namespace, net.Name)
 
-	/* network object exists, so check if it contains resourceName annotation */
-	for _, networkResourceNameKey := range controlSwitches.GetResourceNameKeys() {
-		if resourceName, exists := annotationsMap[networkResourceNameKey]; exists {
-			/* add resource to map/increment if it was already there */
-			reqs[resourceName]++
-			glog.Infof("resource '%s' needs to be requested for network '%s/%s'", resourceName, net.Namespace, net.Name)
-		} else {
-			glog.Infof("network '%s/%s' doesn't use custom resources, skipping...", net.Namespace, net.Name)
-		}
-	}
+	return wh.addNetworkResources(net, replicas, annotationsMap, config, netResources)
+}
 
-	/* parse the net-attach-def annotations for node selector label and add it to the desiredNsMap */
-	if ns, exists := annotationsMap[nodeSelectorKey]; exists {
-		nsNameValue := strings.Split(ns, "=")
-		nsNameValueLen := len(nsNameValue)
-		if nsNameValueLen > 2 {
-			reason := fmt.Errorf("node selector in net-attach-def %s has more than one label", net.Name)
-			glog.Error(reason)
-			return reqs, nsMap, reason
-		} else if nsNameValueLen == 2 {
-			nsMap[strings.TrimSpace(nsNameValue[0])] = strings.TrimSpace(nsNameValue[1])
-		} else {
-			nsMap[strings.TrimSpace(ns)] = ""
-		}
+// resolveNetworkReference returns name of the cached net-attach-def the network selects by its UID or alias
+// annotation instead of its name, empty name when there is none. Alias shared by more net-attach-defs is an error.
+func (wh *Webhook) resolveNetworkReference(net *multus.NetworkSelectionElement) (string, error) {
+	names := wh.nadCache.Resolve(net.Namespace, net.Name)
+	switch len(names) {
+	case 0:
+		return "", nil
+	case 1:
+		return names[0], nil
 	}
-
-	return reqs, nsMap, nil
+	return "", errors.Errorf("network '%s/%s' is ambiguous, it is alias of net-attach-defs %s", net.Namespace, net.Name,
+		strings.Join(names, ", "))
 }
 
-func handleValidationError(w http.ResponseWriter, ar *admissionv1.AdmissionReview, orgErr error) {
-	err := prepareAdmissionReviewResponse(false, orgErr.Error(), ar)
-	if err != nil {
-		err := errors.Wrap(err, "error preparing AdmissionResponse")
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+// computeNetworkResources returns resources requested by the networks, their node selection constraints and CPU
+// and memory they request under the target containers expression. Annotations and configs of the selected
+// net-attach-defs are given under the 'namespace/name' key, missing config is treated as empty one. Resources
+// targeted at containers by name are included in the requested resources, networks missing in replicas request one
+// resource set. Net-attach-defs are not looked up, so the result depends only on the arguments and the control
+// switches.
+func (wh *Webhook) computeNetworkResources(networks []*multus.NetworkSelectionElement, replicas networkReplicas, nadAnnotations map[string]map[string]string,
+	nadConfigs map[string]string) (map[string]int64, map[string]string, []corev1.NodeSelectorRequirement, map[string]corev1.ResourceList, error) {
+	netResources := newNetworkResources()
+
+	for _, net := range networks {
+		annotationsMap, exists := nadAnnotations[net.Namespace+"/"+net.Name]
+		if !exists {
+			return netResources.reqs, netResources.nsMap, netResources.nodeAffinity, netResources.computeReqs,
+				errors.Errorf("could not find network attachment definition '%s/%s'", net.Namespace, net.Name)
+		}
+		if err := wh.addNetworkResources(net, replicas.get(net), annotationsMap, nadConfigs[net.Namespace+"/"+net.Name], netResources); err != nil {
+			return netResources.reqs, netResources.nsMap, netResources.nodeAffinity, netResources.computeReqs, err
+		}
 	}
-	writeResponse(w, ar)
-}
 
-func writeResponse(w http.ResponseWriter, ar *admissionv1.AdmissionReview) {
-	glog.Infof("sending response to the Kubernetes API server")
-	resp, _ := json.Marshal(ar)
-	w.Write(resp)
+	return netResources.reqs, netResources.nsMap, netResources.nodeAffinity, netResources.computeReqs, nil
 }
 
-func patchEmptyResources(patch []types.JsonPatchOperation, containerIndex uint, key string) []types.JsonPatchOperation {
-	patch = append(patch, types.JsonPatchOperation{
-		Operation: "add",
-		Path:      "/spec/containers/" + fmt.Sprintf("%d", containerIndex) + "/resources/" + toSafeJsonPatchKey(key),
-		Value:     corev1.ResourceList{},
-	})
-	return patch
-}
+// addNetworkResources adds resources requested by the network to netResources, along with its node selection
+// constraints, CPU and memory, resources targeted at containers by name, node affinity and service account token
+// audience, according to the annotations and config of the net-attach-def selected by the network. Resources and
+// their companions are requested replicas times, CPU and memory of the network are requested once.
+func (wh *Webhook) addNetworkResources(net *multus.NetworkSelectionElement, replicas int64, annotationsMap map[string]string, config string,
+	netResources *networkResources) error {
 
-func addVolDownwardAPI(patch []types.JsonPatchOperation, hugepageResourceList []hugepageResourceData, pod *corev1.Pod) []types.JsonPatchOperation {
+	/* replicas above the maximal resource count are handled like resource requested too many times */
+	if maxCount := wh.controlSwitches.GetMaxResourceCount(); maxCount > 0 && replicas > maxCount {
+		reason := errors.Errorf("network '%s/%s' requests %d replicas, at most %d is allowed", net.Namespace, net.Name, replicas, maxCount)
+		if wh.controlSwitches.GetResourceCapAction() == controlswitches.ResourceCapDeny {
+			logger.Errorf("%v", reason)
+			return reason
+		}
+		logger.Warningf("%v, clamping to maximum", reason)
+		replicas = maxCount
+	}
 
-	if len(pod.Spec.Volumes) == 0 {
-		patch = append(patch, types.JsonPatchOperation{
-			Operation: "add",
-			Path:      "/spec/volumes",
-			Value:     []corev1.Volume{},
-		})
+	if wh.controlSwitches.IsConfigResourceNameEnabled() {
+		annotationsMap = wh.withConfigResourceName(net, annotationsMap, config)
 	}
 
-	dAPIItems := []corev1.DownwardAPIVolumeFile{}
+	target, targeted := annotationsMap[targetContainersKey]
+	if targeted {
+		if _, err := compileTargetContainers(target); err != nil {
+			reason := errors.Wrapf(err, "invalid annotation '%s' of net-attach-def '%s/%s' with value '%s'",
+				targetContainersKey, net.Namespace, net.Name, target)
+			logger.Errorf("%v", reason)
+			return reason
+		}
+	}
 
-	if pod.Labels != nil && len(pod.Labels) > 0 {
+	/* resources requested by the network with their counts, more keys could carry the same resource name */
+	var resourceNames []string
+	requested := make(map[string]bool)
+	counts := make(map[string]int64)
+
+	/* network object exists, so check if it contains resourceName annotation */
+	for _, networkResourceNameKey := range wh.controlSwitches.GetResourceNameKeys() {
+		value, exists := annotationsMap[networkResourceNameKey]
+		if !exists {
+			logger.Infof("network '%s/%s' doesn't use custom resources, skipping...", net.Namespace, net.Name)
+			continue
+		}
+		names, err := parseResourceNames(value)
+		if err != nil {
+			reason := errors.Wrapf(err, "invalid annotation '%s' of net-attach-def '%s/%s'", networkResourceNameKey, net.Namespace, net.Name)
+			logger.Errorf("%v", reason)
+			return reason
+		}
+		if len(names) == 0 {
+			logger.Infof("annotation '%s' of network '%s/%s' has no resource name, skipping...", networkResourceNameKey, net.Namespace, net.Name)
+		}
+		for _, resourceName := range names {
+			if requested[resourceName] {
+				logger.Infof("resource '%s' of key '%s' is already requested for network '%s/%s', skipping...",
+					resourceName, networkResourceNameKey, net.Namespace, net.Name)
+				continue
+			}
+			requested[resourceName] = true
+			resourceNames = append(resourceNames, resourceName)
+			counts[resourceName] = 1
+		}
+	}
+
+	/* complex devices could need more resource types, or more resources of a type, described by the resource map */
+	if value, exists := annotationsMap[resourceMapKey]; exists {
+		resourceMap, err := parseResourceMap(value)
+		if err != nil {
+			reason := errors.Wrapf(err, "invalid annotation '%s' of net-attach-def '%s/%s'", resourceMapKey, net.Namespace, net.Name)
+			logger.Errorf("%v", reason)
+			return reason
+		}
+		mapNames := make([]string, 0, len(resourceMap))
+		for resourceName := range resourceMap {
+			mapNames = append(mapNames, resourceName)
+		}
+		sort.Strings(mapNames)
+		for _, resourceName := range mapNames {
+			if !requested[resourceName] {
+				requested[resourceName] = true
+				resourceNames = append(resourceNames, resourceName)
+			}
+			/* count of the resource map takes precedence over the resource name annotation */
+			counts[resourceName] = resourceMap[resourceName]
+		}
+	}
+
+	for _, resourceName := range resourceNames {
+		/* resource name has to match one of the allowed prefixes */
+		if !wh.controlSwitches.IsResourceNameAllowed(resourceName) {
+			reason := errors.Errorf("resource '%s' of network attachment definition '%s/%s' is not allowed to be injected",
+				resourceName, net.Namespace, net.Name)
+			if wh.controlSwitches.GetDisallowedResourceAction() == controlswitches.DisallowedResourceDeny {
+				logger.Errorf("%v", reason)
+				return reason
+			}
+			logger.Warningf("%v, skipping...", reason)
+			continue
+		}
+		/* network requesting resources has to be of the allowed CNI type */
+		if err := wh.validateCNIType(net, config); err != nil {
+			logger.Errorf("%v", err)
+			return err
+		}
+		/* add resource to map/increment if it was already there, along with its companion resources */
+		count := counts[resourceName] * replicas
+		netResources.reqs[resourceName] += count
+		if targeted {
+			addTargetedResource(netResources.targetedReqs, target, resourceName, count)
+		}
+		for _, companion := range wh.controlSwitches.GetCompanionResources(resourceName) {
+			netResources.reqs[companion.ResourceName] += companion.Ratio * count
+			if targeted {
+				addTargetedResource(netResources.targetedReqs, target, companion.ResourceName, companion.Ratio*count)
+			}
+			logger.WithFields(logging.Fields{"resource": companion.ResourceName}).Infof("companion resource '%s' of '%s' needs to be requested for network '%s/%s'",
+				companion.ResourceName, resourceName, net.Namespace, net.Name)
+		}
+		logger.WithFields(logging.Fields{"resource": resourceName}).Infof("resource '%s' needs to be requested for network '%s/%s'",
+			resourceName, net.Namespace, net.Name)
+		if wh.controlSwitches.IsTopologyHintsEnabled() {
+			aware, err := isTopologyAware(annotationsMap)
+			if err != nil {
+				reason := errors.Wrapf(err, "invalid topology awareness of net-attach-def '%s/%s'", net.Namespace, net.Name)
+				logger.Errorf("%v", reason)
+				return reason
+			}
+			netResources.topologyAware[net.Namespace+"/"+net.Name] = aware
+		}
+	}
+
+	if err := addComputeResources(net, annotationsMap, target, netResources.computeReqs); err != nil {
+		logger.Errorf("%v", err)
+		return err
+	}
+
+	/* parse the net-attach-def annotations for node selector label and add it to the desiredNsMap */
+	if ns, exists := annotationsMap[nodeSelectorKey]; exists {
+		var err error
+		netResources.nodeAffinity, err = parseNodeSelector(ns, netResources.nsMap, netResources.nodeAffinity)
+		if err != nil {
+			reason := errors.Wrapf(err, "invalid node selector in net-attach-def %s", net.Name)
+			logger.Errorf("%v", reason)
+			return reason
+		}
+	}
+
+	if value, exists := annotationsMap[nodeAffinityKey]; exists {
+		if !wh.controlSwitches.IsNodeAffinityAnnotationEnabled() {
+			logger.Infof("node affinity annotation of net-attach-def '%s/%s' is disabled, skipping...", net.Namespace, net.Name)
+		} else {
+			networkAffinity, err := parseNodeAffinity(value)
+			if err != nil {
+				reason := errors.Wrapf(err, "invalid annotation '%s' of net-attach-def '%s/%s'", nodeAffinityKey, net.Namespace, net.Name)
+				logger.Errorf("%v", reason)
+				return reason
+			}
+			mergeNodeAffinity(netResources.injectedAffinity, networkAffinity)
+		}
+	}
+
+	if value, exists := annotationsMap[tokenAudienceKey]; exists {
+		audience := strings.TrimSpace(value)
+		if audience == "" {
+			reason := errors.Errorf("annotation '%s' of net-attach-def '%s/%s' is empty", tokenAudienceKey, net.Namespace, net.Name)
+			logger.Errorf("%v", reason)
+			return reason
+		}
+		netResources.tokenAudiences[audience] = true
+	}
+
+	return nil
+}
+
+// parseResourceNames parses resource name annotation, which holds a resource name or comma separated list of them.
+// Empty items are ignored, so empty annotation yields no resource name.
+func parseResourceNames(value string) ([]string, error) {
+	var resourceNames []string
+	for _, resourceName := range strings.Split(value, ",") {
+		if resourceName = strings.TrimSpace(resourceName); resourceName == "" {
+			continue
+		}
+		if msgs := validation.IsQualifiedName(resourceName); len(msgs) > 0 {
+			return nil, errors.Errorf("invalid resource name '%s': %s", resourceName, strings.Join(msgs, ", "))
+		}
+		resourceNames = append(resourceNames, resourceName)
+	}
+	return resourceNames, nil
+}
+
+// parseResourceMap parses resource map annotation, which holds JSON object with counts of resources under their names,
+// e.g. {"intel.com/sriov": 2, "intel.com/mgmt": 1}. Counts have to be positive integers.
+func parseResourceMap(value string) (map[string]int64, error) {
+	var resourceMap map[string]int64
+	if err := json.Unmarshal([]byte(value), &resourceMap); err != nil {
+		return nil, errors.Wrap(err, "expected JSON object with resource counts under resource names")
+	}
+	for resourceName, count := range resourceMap {
+		if msgs := validation.IsQualifiedName(resourceName); len(msgs) > 0 {
+			return nil, errors.Errorf("invalid resource name '%s': %s", resourceName, strings.Join(msgs, ", "))
+		}
+		if count <= 0 {
+			return nil, errors.Errorf("count %d of resource '%s' is not positive", count, resourceName)
+		}
+	}
+	return resourceMap, nil
+}
+
+// compileTargetContainers compiles expression of the target containers annotation, it has to match the whole
+// container name
+func compileTargetContainers(expression string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expression + ")$")
+}
+
+// addTargetedResource records count of the resource requested for containers matching the target expression
+func addTargetedResource(targetedReqs map[string]map[string]int64, target, resourceName string, count int64) {
+	if _, exists := targetedReqs[target]; !exists {
+		targetedReqs[target] = make(map[string]int64)
+	}
+	targetedReqs[target][resourceName] += count
+}
+
+// computeResourceKeys are net-attach-def annotations with CPU and memory requested by the network, e.g. for CNI
+// sidecar or DPDK poll mode driver
+var computeResourceKeys = []struct {
+	key          string
+	resourceName corev1.ResourceName
+}{
+	{cpuRequestKey, corev1.ResourceCPU},
+	{memoryRequestKey, corev1.ResourceMemory},
+}
+
+// addComputeResources adds CPU and memory requested by net-attach-def annotations of the network to computeReqs under
+// the target containers expression of the network, empty for network not targeting containers
+func addComputeResources(net *multus.NetworkSelectionElement, annotationsMap map[string]string, target string,
+	computeReqs map[string]corev1.ResourceList) error {
+	for _, computeResource := range computeResourceKeys {
+		value, exists := annotationsMap[computeResource.key]
+		if !exists {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(value))
+		if err != nil {
+			return errors.Wrapf(err, "invalid annotation '%s' of net-attach-def '%s/%s' with value '%s'",
+				computeResource.key, net.Namespace, net.Name, value)
+		}
+		if quantity.Sign() < 0 {
+			return errors.Errorf("invalid annotation '%s' of net-attach-def '%s/%s', quantity '%s' must not be negative",
+				computeResource.key, net.Namespace, net.Name, value)
+		}
+		if _, exists := computeReqs[target]; !exists {
+			computeReqs[target] = corev1.ResourceList{}
+		}
+		total := computeReqs[target][computeResource.resourceName]
+		total.Add(quantity)
+		computeReqs[target][computeResource.resourceName] = total
+		logger.WithFields(logging.Fields{"resource": string(computeResource.resourceName)}).Infof("%s of '%s' needs to be requested for network '%s/%s'",
+			computeResource.resourceName, value, net.Namespace, net.Name)
+	}
+	return nil
+}
+
+// isTopologyAware returns true when net-attach-def annotations flag the network as topology aware
+func isTopologyAware(annotationsMap map[string]string) (bool, error) {
+	value, exists := annotationsMap[topologyAwareKey]
+	if !exists {
+		return false, nil
+	}
+	aware, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.Errorf("annotation '%s' has value '%s', expected true or false", topologyAwareKey, value)
+	}
+	return aware, nil
+}
+
+// topologyHintAnnotation returns annotation patch hinting that all pod resources are topology aware. Hint is
+// returned only when every network requesting resources is topology aware, second value is false otherwise.
+func (wh *Webhook) topologyHintAnnotation(topologyAware map[string]bool) (types.JsonPatchOperation, bool) {
+	if len(topologyAware) == 0 {
+		return types.JsonPatchOperation{}, false
+	}
+	for _, aware := range topologyAware {
+		if !aware {
+			return types.JsonPatchOperation{}, false
+		}
+	}
+	return types.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/metadata/annotations",
+		Value:     map[string]interface{}{wh.annotationKey(topologyHintKey): "true"},
+	}, true
+}
+
+// applyResourceNameOverride replaces resource names resolved from net-attach-defs with the ones defined by the pod
+// annotation. Annotation contains comma separated list of 'original=override' resource name pairs.
+func applyResourceNameOverride(pod corev1.Pod, reqs map[string]int64) (map[string]int64, error) {
+	overrides, exists := pod.ObjectMeta.Annotations[resourceNameOverrideKey]
+	if !exists {
+		return reqs, nil
+	}
+
+	result := make(map[string]int64, len(reqs))
+	for resourceName, count := range reqs {
+		result[resourceName] = count
+	}
+	for _, override := range strings.Split(overrides, ",") {
+		names := strings.Split(override, "=")
+		if len(names) != 2 || strings.TrimSpace(names[0]) == "" || strings.TrimSpace(names[1]) == "" {
+			return reqs, errors.Errorf("invalid resource name override '%s' in annotation %s, expected 'original=override'",
+				override, resourceNameOverrideKey)
+		}
+		original, replacement := strings.TrimSpace(names[0]), strings.TrimSpace(names[1])
+		if count, requested := result[original]; requested {
+			delete(result, original)
+			result[replacement] += count
+			logger.Infof("resource '%s' is overridden with '%s' for pod %s/%s", original, replacement,
+				pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		}
+	}
+	return result, nil
+}
+
+// removeAnnotatedResources returns patch removing resources listed in the pod annotation from requests and limits of
+// app and init containers. Removed resources are deleted from the pod as well, so the injection does not see them as
+// defined by the pod. Annotation contains comma separated list of resource names.
+func removeAnnotatedResources(pod *corev1.Pod) ([]types.JsonPatchOperation, error) {
+	value, exists := pod.ObjectMeta.Annotations[removeResourcesKey]
+	if !exists {
+		return nil, nil
+	}
+	resourceNames, err := parseResourceNames(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid annotation %s", removeResourcesKey)
+	}
+
+	var patch []types.JsonPatchOperation
+	patch = removeContainerResources(patch, pod.Spec.InitContainers, initContainersPath, resourceNames)
+	patch = removeContainerResources(patch, pod.Spec.Containers, containersPath, resourceNames)
+	return patch, nil
+}
+
+// removeContainerResources removes the resources from requests and limits of the containers. Only resources defined
+// by a container are removed from it, as API server rejects patch removing missing member.
+func removeContainerResources(patch []types.JsonPatchOperation, containers []corev1.Container, containersField string,
+	resourceNames []string) []types.JsonPatchOperation {
+	for i := range containers {
+		resources := containers[i].Resources
+		path := containerPath(containersField, i)
+		for _, resourceName := range resourceNames {
+			name := corev1.ResourceName(resourceName)
+			_, inRequests := resources.Requests[name]
+			_, inLimits := resources.Limits[name]
+			if inRequests {
+				patch = append(patch, types.JsonPatchOperation{
+					Operation: "remove",
+					Path:      path + "/resources/requests/" + toSafeJsonPatchKey(resourceName),
+				})
+				delete(resources.Requests, name)
+			}
+			if inLimits {
+				patch = append(patch, types.JsonPatchOperation{
+					Operation: "remove",
+					Path:      path + "/resources/limits/" + toSafeJsonPatchKey(resourceName),
+				})
+				delete(resources.Limits, name)
+			}
+			if inRequests || inLimits {
+				logger.Infof("resource '%s' is removed from container %s", resourceName, containers[i].Name)
+			}
+		}
+	}
+	return patch
+}
+
+// getCNIType returns type of the CNI plugin defined by the net-attach-def config, for plugin configuration
+// list the type of the first plugin is returned
+func getCNIType(config string) (string, error) {
+	var netConf struct {
+		Type    string `json:"type"`
+		Plugins []struct {
+			Type string `json:"type"`
+		} `json:"plugins"`
+	}
+
+	if err := json.Unmarshal([]byte(config), &netConf); err != nil {
+		return "", errors.Wrap(err, "failed to parse CNI config")
+	}
+	if netConf.Type == "" && len(netConf.Plugins) > 0 {
+		return netConf.Plugins[0].Type, nil
+	}
+	if netConf.Type == "" {
+		return "", errors.New("CNI config does not define plugin type")
+	}
+	return netConf.Type, nil
+}
+
+// getConfigResourceName returns resource name defined by resourceName field of the CNI config, or of the first
+// plugin defining it in the plugins list, empty when no resource name is defined
+func getConfigResourceName(config string) (string, error) {
+	var netConf struct {
+		ResourceName string `json:"resourceName"`
+		Plugins      []struct {
+			ResourceName string `json:"resourceName"`
+		} `json:"plugins"`
+	}
+
+	if err := json.Unmarshal([]byte(config), &netConf); err != nil {
+		return "", errors.Wrap(err, "failed to parse CNI config")
+	}
+	if netConf.ResourceName != "" {
+		return netConf.ResourceName, nil
+	}
+	for _, plugin := range netConf.Plugins {
+		if plugin.ResourceName != "" {
+			return plugin.ResourceName, nil
+		}
+	}
+	return "", nil
+}
+
+// withConfigResourceName returns annotations of the net-attach-def with resource name taken from its CNI config
+// when neither resource name annotation nor resource map is defined. Invalid CNI config is logged and no resource is
+// requested for it. Annotations of the cache are not modified.
+func (wh *Webhook) withConfigResourceName(net *multus.NetworkSelectionElement, annotationsMap map[string]string, config string) map[string]string {
+	resourceNameKeys := wh.controlSwitches.GetResourceNameKeys()
+	for _, networkResourceNameKey := range append([]string{resourceMapKey}, resourceNameKeys...) {
+		if _, exists := annotationsMap[networkResourceNameKey]; exists {
+			return annotationsMap
+		}
+	}
+	if config == "" || len(resourceNameKeys) == 0 {
+		return annotationsMap
+	}
+
+	resourceName, err := getConfigResourceName(config)
+	if err != nil {
+		logger.Warningf("could not get resource name of network attachment definition '%s/%s', no resource is requested: %v",
+			net.Namespace, net.Name, err)
+		return annotationsMap
+	}
+	if resourceName == "" {
+		return annotationsMap
+	}
+
+	logger.Infof("resource name '%s' of network '%s/%s' is taken from its CNI config", resourceName, net.Namespace, net.Name)
+	withResourceName := make(map[string]string, len(annotationsMap)+1)
+	for key, value := range annotationsMap {
+		withResourceName[key] = value
+	}
+	withResourceName[resourceNameKeys[0]] = resourceName
+	return withResourceName
+}
+
+// validateCNIType checks that CNI type of the network is one of the allowed types, any type is accepted
+// when the list of allowed types is empty
+func (wh *Webhook) validateCNIType(net *multus.NetworkSelectionElement, config string) error {
+	allowedTypes := wh.controlSwitches.GetAllowedCNITypes()
+	if len(allowedTypes) == 0 {
+		return nil
+	}
+
+	cniType, err := getCNIType(config)
+	if err != nil {
+		return errors.Wrapf(err, "could not get CNI type of network attachment definition '%s/%s'", net.Namespace, net.Name)
+	}
+	for _, allowedType := range allowedTypes {
+		if cniType == allowedType {
+			return nil
+		}
+	}
+	return errors.Errorf("network attachment definition '%s/%s' has CNI type '%s', expected one of: %s",
+		net.Namespace, net.Name, cniType, strings.Join(allowedTypes, ", "))
+}
+
+// parseNodeSelector translates the net-attach-def node selector annotation, written in the Kubernetes
+// label selector syntax, into pod scheduling constraints. Selector may contain multiple comma separated
+// requirements, e.g. 'key1=val1,key2=val2'. Equality based requirements are added to the nsMap (pod
+// nodeSelector), any other operator is turned into a node affinity match expression. Label required with other
+// value by selector of another network already in the nsMap is rejected, as no node can satisfy both.
+func parseNodeSelector(selector string, nsMap map[string]string, nodeAffinity []corev1.NodeSelectorRequirement) ([]corev1.NodeSelectorRequirement, error) {
+	requirements, err := labels.ParseToRequirements(selector)
+	if err != nil {
+		return nodeAffinity, err
+	}
+
+	selected := make(map[string]string)
+	for _, requirement := range requirements {
+		var operator corev1.NodeSelectorOperator
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals:
+			value := requirement.Values().List()[0]
+			if previous, exists := selected[requirement.Key()]; exists && previous != value {
+				return nodeAffinity, errors.Errorf("node selector '%s' requires conflicting values '%s' and '%s' of label '%s'",
+					selector, previous, value, requirement.Key())
+			}
+			selected[requirement.Key()] = value
+			continue
+		case selection.NotEquals, selection.NotIn:
+			operator = corev1.NodeSelectorOpNotIn
+		case selection.In:
+			operator = corev1.NodeSelectorOpIn
+		case selection.Exists:
+			operator = corev1.NodeSelectorOpExists
+		case selection.DoesNotExist:
+			operator = corev1.NodeSelectorOpDoesNotExist
+		case selection.GreaterThan:
+			operator = corev1.NodeSelectorOpGt
+		case selection.LessThan:
+			operator = corev1.NodeSelectorOpLt
+		default:
+			return nodeAffinity, errors.Errorf("unsupported operator '%s' in node selector '%s'", requirement.Operator(), selector)
+		}
+		nodeAffinity = append(nodeAffinity, corev1.NodeSelectorRequirement{
+			Key:      requirement.Key(),
+			Operator: operator,
+			Values:   requirement.Values().List(),
+		})
+	}
+
+	for key, value := range selected {
+		if previous, exists := nsMap[key]; exists && previous != value {
+			return nodeAffinity, errors.Errorf("node selector '%s' requires value '%s' of label '%s', other network requires '%s'",
+				selector, value, key, previous)
+		}
+	}
+	for key, value := range selected {
+		nsMap[key] = value
+	}
+	return nodeAffinity, nil
+}
+
+// nodeSelectorOperators maps operators of node affinity match expressions back to label selector operators
+var nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// checkNodeSelectorRequirements checks the node affinity match expressions of net-attach-def node selectors against
+// labels pinned by node selector of the pod. Pod requiring label value the match expression excludes can not be
+// scheduled, so it is denied when the node selector conflict action is deny and a warning is logged otherwise.
+func (wh *Webhook) checkNodeSelectorRequirements(existing map[string]string, desired []corev1.NodeSelectorRequirement) error {
+	for _, expression := range desired {
+		value, exists := existing[expression.Key]
+		if !exists {
+			continue
+		}
+		requirement, err := labels.NewRequirement(expression.Key, nodeSelectorOperators[expression.Operator], expression.Values)
+		if err != nil {
+			return err
+		}
+		if requirement.Matches(labels.Set{expression.Key: value}) {
+			continue
+		}
+		if wh.controlSwitches.GetNodeSelectorConflictAction() == controlswitches.NodeSelectorConflictDeny {
+			return errors.Errorf("pod node selector requires label '%s' to be '%s', its networks require '%s'",
+				expression.Key, value, requirement.String())
+		}
+		logger.Warningf("pod node selector requires label '%s' to be '%s', its networks require '%s', pod can not be scheduled",
+			expression.Key, value, requirement.String())
+	}
+	return nil
+}
+
+// respondWithError responds to request which cannot be processed. Pod is denied when the error is caused by the
+// object. Server errors fail the admission call with HTTP 500 when configured, so API server applies failure policy
+// of the webhook, and deny the pod otherwise. HTTP 400 is returned when there is no AdmissionReview request to
+// respond to.
+func (wh *Webhook) respondWithError(w http.ResponseWriter, ar *admissionv1.AdmissionReview, pod corev1.Pod, l logging.Logger, orgErr error) {
+	l.Errorf("%v", orgErr)
+	if isServerError(orgErr) && wh.controlSwitches.GetServerErrorAction() == controlswitches.ServerErrorFail {
+		http.Error(w, orgErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	wh.recordDenialEvent(ar, pod, orgErr)
+	if err := prepareAdmissionReviewResponse(false, orgErr.Error(), ar); err != nil {
+		l.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
+			pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeResponse(w, ar)
+}
+
+func handleValidationError(w http.ResponseWriter, ar *admissionv1.AdmissionReview, orgErr error) {
+	err := prepareAdmissionReviewResponse(false, orgErr.Error(), ar)
+	if err != nil {
+		err := errors.Wrap(err, "error preparing AdmissionResponse")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeResponse(w, ar)
+}
+
+func writeResponse(w http.ResponseWriter, ar *admissionv1.AdmissionReview) {
+	logger.Infof("sending response to the Kubernetes API server")
+	resp, _ := json.Marshal(ar)
+	w.Write(resp)
+}
+
+// containerPath returns JSON patch path of the container under the given containers field
+func containerPath(containersField string, containerIndex int) string {
+	return containersField + "/" + strconv.Itoa(containerIndex)
+}
+
+func patchEmptyResources(patch []types.JsonPatchOperation, containerPath string, key string) []types.JsonPatchOperation {
+	patch = append(patch, types.JsonPatchOperation{
+		Operation: "add",
+		Path:      containerPath + "/resources/" + toSafeJsonPatchKey(key),
+		Value:     corev1.ResourceList{},
+	})
+	return patch
+}
+
+func hasVolumeMount(container corev1.Container, volumeName string) bool {
+	for _, volumeMount := range container.VolumeMounts {
+		if volumeMount.Name == volumeName {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMountPath(container corev1.Container, mountPath string) bool {
+	for _, volumeMount := range container.VolumeMounts {
+		if volumeMount.MountPath == mountPath {
+			return true
+		}
+	}
+	return false
+}
+
+func getVolume(pod *corev1.Pod, volumeName string) *corev1.Volume {
+	for i := range pod.Spec.Volumes {
+		if pod.Spec.Volumes[i].Name == volumeName {
+			return &pod.Spec.Volumes[i]
+		}
+	}
+	return nil
+}
+
+func hasVolume(pod *corev1.Pod, volumeName string) bool {
+	return getVolume(pod, volumeName) != nil
+}
+
+// hasHugepageResource returns true when the container referenced by hugepage resource data defines non-zero
+// value of the referenced request or limit
+func hasHugepageResource(pod *corev1.Pod, hugepageResource hugepageResourceData) bool {
+	parts := strings.SplitN(hugepageResource.ResourceName, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		if container.Name != hugepageResource.ContainerName {
+			continue
+		}
+		var resources corev1.ResourceList
+		switch parts[0] {
+		case "requests":
+			resources = container.Resources.Requests
+		case "limits":
+			resources = container.Resources.Limits
+		}
+		quantity, exists := resources[corev1.ResourceName(parts[1])]
+		return exists && !quantity.IsZero()
+	}
+	return false
+}
+
+// getDownwardAPIVolumeName returns name of the Downward API volume to be injected. When pod already defines
+// volume with the configured name which is not a Downward API volume, the injected volume is renamed, not injected
+// (empty name is returned) or pod is denied according to the control switch.
+func (wh *Webhook) getDownwardAPIVolumeName(pod *corev1.Pod) (string, error) {
+	volumeName := wh.controlSwitches.GetDownwardAPIVolumeName()
+	volume := getVolume(pod, volumeName)
+	if volume == nil || volume.DownwardAPI != nil {
+		return volumeName, nil
+	}
+
+	switch wh.controlSwitches.GetPodNetInfoConflict() {
+	case controlswitches.PodNetInfoConflictDeny:
+		return "", errors.Errorf("pod defines volume '%s' which is not a Downward API volume, the name is reserved for "+
+			"the network resources injector", volumeName)
+	case controlswitches.PodNetInfoConflictSkip:
+		logger.Warningf("pod %s/%s defines volume '%s' which is not a Downward API volume, Downward API volume is not injected",
+			pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, volumeName)
+		return "", nil
+	}
+	renamed := wh.controlSwitches.GetRenamedDownwardAPIVolumeName()
+	logger.Warningf("pod %s/%s defines volume '%s' which is not a Downward API volume, injecting '%s' instead",
+		pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, volumeName, renamed)
+	return renamed, nil
+}
+
+func addVolDownwardAPI(patch []types.JsonPatchOperation, hugepageResourceList []hugepageResourceData, pod *corev1.Pod,
+	volumeName string) []types.JsonPatchOperation {
+	/* volume could be already there when webhook is reinvoked after its patch was applied */
+	if hasVolume(pod, volumeName) {
+		logger.Infof("pod %s/%s already has volume %s, skipping...", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, volumeName)
+		return patch
+	}
+
+	if len(pod.Spec.Volumes) == 0 {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/volumes",
+			Value:     []corev1.Volume{},
+		})
+	}
+
+	dAPIItems := []corev1.DownwardAPIVolumeFile{}
+
+	if pod.Labels != nil && len(pod.Labels) > 0 {
 		labels := corev1.ObjectFieldSelector{
 			FieldPath: "metadata.labels",
 		}
@@ -468,6 +1746,12 @@ func addVolDownwardAPI(patch []types.JsonPatchOperation, hugepageResourceList []
 	}
 
 	for _, hugepageResource := range hugepageResourceList {
+		/* kubelet fails to start pod when Downward API file references value not set on the container */
+		if !hasHugepageResource(pod, hugepageResource) {
+			logger.Warningf("pod %s/%s container %s does not define %s, not exposing it via Downward API",
+				pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, hugepageResource.ContainerName, hugepageResource.ResourceName)
+			continue
+		}
 		hugepageSelector := corev1.ResourceFieldSelector{
 			Resource:      hugepageResource.ResourceName,
 			ContainerName: hugepageResource.ContainerName,
@@ -487,7 +1771,7 @@ func addVolDownwardAPI(patch []types.JsonPatchOperation, hugepageResourceList []
 		DownwardAPI: &dAPIVolSource,
 	}
 	vol := corev1.Volume{
-		Name:         "podnetinfo",
+		Name:         volumeName,
 		VolumeSource: volSource,
 	}
 
@@ -500,24 +1784,40 @@ func addVolDownwardAPI(patch []types.JsonPatchOperation, hugepageResourceList []
 	return patch
 }
 
-func addVolumeMount(patch []types.JsonPatchOperation, containers []corev1.Container) []types.JsonPatchOperation {
+// addVolumeMount mounts the volume at mountPath into containers with index in mountContainers, or into all containers
+// when mountContainers is nil
+func addVolumeMount(patch []types.JsonPatchOperation, containers []corev1.Container, containersField string,
+	volumeName, mountPath string, mountContainers map[int]bool) []types.JsonPatchOperation {
 
 	vm := corev1.VolumeMount{
-		Name:      "podnetinfo",
+		Name:      volumeName,
 		ReadOnly:  true,
-		MountPath: types.DownwardAPIMountPath,
+		MountPath: mountPath,
 	}
 	for containerIndex, container := range containers {
-		if len(container.VolumeMounts) == 0 {
+		if mountContainers != nil && !mountContainers[containerIndex] {
+			continue
+		}
+		/* mount could be already there when webhook is reinvoked after its patch was applied */
+		if hasVolumeMount(container, vm.Name) {
+			logger.Infof("container %s already mounts volume %s, skipping...", container.Name, vm.Name)
+			continue
+		}
+		if hasMountPath(container, vm.MountPath) {
+			logger.Warningf("container %s already mounts other volume at %s, skipping...", container.Name, vm.MountPath)
+			continue
+		}
+		/* other volume could be already mounted by the patch */
+		if len(container.VolumeMounts) == 0 && !hasPatchPath(patch, containerPath(containersField, containerIndex)+"/volumeMounts") {
 			patch = append(patch, types.JsonPatchOperation{
 				Operation: "add",
-				Path:      "/spec/containers/" + strconv.Itoa(containerIndex) + "/volumeMounts",
+				Path:      containerPath(containersField, containerIndex) + "/volumeMounts",
 				Value:     []corev1.VolumeMount{},
 			})
 		}
 		patch = append(patch, types.JsonPatchOperation{
 			Operation: "add",
-			Path:      "/spec/containers/" + strconv.Itoa(containerIndex) + "/volumeMounts/-",
+			Path:      containerPath(containersField, containerIndex) + "/volumeMounts/-",
 			Value:     vm,
 		})
 	}
@@ -525,30 +1825,114 @@ func addVolumeMount(patch []types.JsonPatchOperation, containers []corev1.Contai
 	return patch
 }
 
-func createVolPatch(patch []types.JsonPatchOperation, hugepageResourceList []hugepageResourceData, pod *corev1.Pod) []types.JsonPatchOperation {
-	patch = addVolumeMount(patch, pod.Spec.Containers)
-	patch = addVolDownwardAPI(patch, hugepageResourceList, pod)
+// createVolPatch adds the Downward API volume and mounts it into app containers with index in mountContainers, or into
+// all of them when mountContainers is nil. Init containers all get the volume mounted when injection into them is enabled.
+func (wh *Webhook) createVolPatch(patch []types.JsonPatchOperation, hugepageResourceList []hugepageResourceData, pod *corev1.Pod,
+	volumeName string, mountContainers map[int]bool) []types.JsonPatchOperation {
+	mountPath := wh.downwardAPIMountPath(pod)
+	patch = addVolumeMount(patch, pod.Spec.Containers, containersPath, volumeName, mountPath, mountContainers)
+	if wh.controlSwitches.IsInjectIntoInitContainersEnabled() {
+		patch = addVolumeMount(patch, pod.Spec.InitContainers, initContainersPath, volumeName, mountPath, nil)
+	}
+	patch = addVolDownwardAPI(patch, hugepageResourceList, pod, volumeName)
 	return patch
 }
 
-func addEnvVar(patch []types.JsonPatchOperation, containerIndex int, firstElement bool,
-	envName string, envVal string) []types.JsonPatchOperation {
+// createTokenVolumePatch adds projected volume with service account token for every audience requested by the
+// networks and mounts it into app containers with index in mountContainers, or into all of them when mountContainers is
+// nil. Token of the audience is projected into file named after the audience. Pod defining volume with the same name
+// which is not a projected volume is denied, projected one is left as it is.
+func (wh *Webhook) createTokenVolumePatch(patch []types.JsonPatchOperation, pod *corev1.Pod, tokenAudiences map[string]bool,
+	mountContainers map[int]bool) ([]types.JsonPatchOperation, error) {
+	if volume := getVolume(pod, tokenVolumeName); volume != nil && volume.Projected == nil {
+		return patch, errors.Errorf("pod defines volume '%s' which is not a projected volume, the name is reserved for "+
+			"the network resources injector", tokenVolumeName)
+	}
 
-	env := corev1.EnvVar{
-		Name:  envName,
-		Value: envVal,
+	mountPath := wh.controlSwitches.GetTokenMountPath()
+	patch = addVolumeMount(patch, pod.Spec.Containers, containersPath, tokenVolumeName, mountPath, mountContainers)
+	if wh.controlSwitches.IsInjectIntoInitContainersEnabled() {
+		patch = addVolumeMount(patch, pod.Spec.InitContainers, initContainersPath, tokenVolumeName, mountPath, nil)
 	}
 
-	if firstElement {
+	/* volume could be already there when webhook is reinvoked after its patch was applied */
+	if hasVolume(pod, tokenVolumeName) {
+		logger.Infof("pod %s/%s already has volume %s, skipping...", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, tokenVolumeName)
+		return patch, nil
+	}
+
+	audiences := make([]string, 0, len(tokenAudiences))
+	for audience := range tokenAudiences {
+		audiences = append(audiences, audience)
+	}
+	sort.Strings(audiences)
+	expirationSeconds := int64(wh.controlSwitches.GetTokenExpiration().Seconds())
+	sources := make([]corev1.VolumeProjection, 0, len(audiences))
+	for _, audience := range audiences {
+		sources = append(sources, corev1.VolumeProjection{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+			Audience:          audience,
+			ExpirationSeconds: &expirationSeconds,
+			Path:              sanitizeDownwardAPIPathElement(audience),
+		}})
+	}
+
+	if len(pod.Spec.Volumes) == 0 && !hasPatchPath(patch, "/spec/volumes") {
 		patch = append(patch, types.JsonPatchOperation{
 			Operation: "add",
-			Path:      "/spec/containers/" + fmt.Sprintf("%d", containerIndex) + "/env",
-			Value:     []corev1.EnvVar{env},
+			Path:      "/spec/volumes",
+			Value:     []corev1.Volume{},
+		})
+	}
+	patch = append(patch, types.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/spec/volumes/-",
+		Value: corev1.Volume{
+			Name:         tokenVolumeName,
+			VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: sources}},
+		},
+	})
+	return patch, nil
+}
+
+// createFinalizerPatch adds the finalizer to pod metadata unless pod already carries it
+func createFinalizerPatch(patch []types.JsonPatchOperation, existing []string, finalizer string) []types.JsonPatchOperation {
+	for _, f := range existing {
+		if f == finalizer {
+			return patch
+		}
+	}
+	if len(existing) == 0 {
+		return append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/metadata/finalizers",
+			Value:     []string{finalizer},
+		})
+	}
+	return append(patch, types.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/metadata/finalizers/-",
+		Value:     finalizer,
+	})
+}
+
+func addEnvVar(patch []types.JsonPatchOperation, containerPath string, firstElement bool,
+	envName string, envVal string) []types.JsonPatchOperation {
+
+	env := corev1.EnvVar{
+		Name:  envName,
+		Value: envVal,
+	}
+
+	if firstElement {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      containerPath + "/env",
+			Value:     []corev1.EnvVar{env},
 		})
 	} else {
 		patch = append(patch, types.JsonPatchOperation{
 			Operation: "add",
-			Path:      "/spec/containers/" + fmt.Sprintf("%d", containerIndex) + "/env/-",
+			Path:      containerPath + "/env/-",
 			Value:     env,
 		})
 	}
@@ -557,7 +1941,7 @@ func addEnvVar(patch []types.JsonPatchOperation, containerIndex int, firstElemen
 }
 
 func createEnvPatch(patch []types.JsonPatchOperation, container *corev1.Container,
-	containerIndex int, envName string, envVal string) []types.JsonPatchOperation {
+	containerPath string, envName string, envVal string) []types.JsonPatchOperation {
 
 	// Determine if requested ENV already exists
 	found := false
@@ -567,7 +1951,7 @@ func createEnvPatch(patch []types.JsonPatchOperation, container *corev1.Containe
 			if env.Name == envName {
 				found = true
 				if env.Value != envVal {
-					glog.Warningf("Error, adding env '%s', name existed but value different: '%s' != '%s'",
+					logger.Warningf("Error, adding env '%s', name existed but value different: '%s' != '%s'",
 						envName, env.Value, envVal)
 				}
 				break
@@ -578,41 +1962,277 @@ func createEnvPatch(patch []types.JsonPatchOperation, container *corev1.Containe
 	}
 
 	if !found {
-		patch = addEnvVar(patch, containerIndex, firstElement, envName, envVal)
+		patch = addEnvVar(patch, containerPath, firstElement, envName, envVal)
 	}
 	return patch
 }
 
-func createNodeSelectorPatch(patch []types.JsonPatchOperation, existing map[string]string, desired map[string]string) []types.JsonPatchOperation {
+// createNodeSelectorPatch merges labels of net-attach-def node selectors into the node selector of pod. Label the pod
+// already sets to other value is handled according to the node selector conflict action: the net-attach-def value
+// replaces it with a warning, the pod value is kept, or the pod is denied.
+func (wh *Webhook) createNodeSelectorPatch(patch []types.JsonPatchOperation, existing map[string]string, desired map[string]string) ([]types.JsonPatchOperation, error) {
 	targetMap := make(map[string]string)
 	if existing != nil {
 		for k, v := range existing {
 			targetMap[k] = v
 		}
 	}
-	if desired != nil {
-		for k, v := range desired {
-			targetMap[k] = v
+
+	keys := make([]string, 0, len(desired))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := desired[k]
+		if current, exists := existing[k]; exists && current != v {
+			switch wh.controlSwitches.GetNodeSelectorConflictAction() {
+			case controlswitches.NodeSelectorConflictDeny:
+				return nil, errors.Errorf("pod node selector requires label '%s' to be '%s', its networks require '%s'", k, current, v)
+			case controlswitches.NodeSelectorConflictPreserve:
+				logger.Warningf("pod node selector requires label '%s' to be '%s', keeping it instead of '%s' required by its networks", k, current, v)
+				continue
+			default:
+				logger.Warningf("pod node selector requires label '%s' to be '%s', overriding it with '%s' required by its networks", k, current, v)
+			}
 		}
+		targetMap[k] = v
 	}
 	if len(targetMap) == 0 {
-		return patch
+		return patch, nil
 	}
 	patch = append(patch, types.JsonPatchOperation{
 		Operation: "add",
 		Path:      "/spec/nodeSelector",
 		Value:     targetMap,
 	})
+	return patch, nil
+}
+
+// createTolerationsPatch appends configured tolerations to tolerations of the pod, toleration the pod already defines
+// is not added again
+func createTolerationsPatch(patch []types.JsonPatchOperation, existing []corev1.Toleration, desired []corev1.Toleration) []types.JsonPatchOperation {
+	var tolerations []corev1.Toleration
+	for i := range desired {
+		defined := false
+		for _, toleration := range existing {
+			if toleration.MatchToleration(&desired[i]) {
+				defined = true
+				break
+			}
+		}
+		if !defined {
+			tolerations = append(tolerations, desired[i])
+		}
+	}
+	if len(tolerations) == 0 {
+		return patch
+	}
+	if len(existing) == 0 {
+		return append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/tolerations",
+			Value:     tolerations,
+		})
+	}
+	for _, toleration := range tolerations {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/tolerations/-",
+			Value:     toleration,
+		})
+	}
+	return patch
+}
+
+// parseNodeAffinity decodes node affinity from the net-attach-def annotation. Empty node selector terms match no
+// nodes and weights of preferred terms have to be in range 1-100, so such affinity is rejected.
+func parseNodeAffinity(value string) (*corev1.NodeAffinity, error) {
+	nodeAffinity := &corev1.NodeAffinity{}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(nodeAffinity); err != nil {
+		return nil, err
+	}
+
+	if required := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		if len(required.NodeSelectorTerms) == 0 {
+			return nil, errors.New("required node affinity has no node selector terms")
+		}
+		for i, term := range required.NodeSelectorTerms {
+			if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+				return nil, errors.Errorf("required node selector term %d is empty", i)
+			}
+		}
+	}
+	for i, term := range nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if term.Weight < 1 || term.Weight > 100 {
+			return nil, errors.Errorf("preferred scheduling term %d has weight %d, expected 1-100", i, term.Weight)
+		}
+	}
+	return nodeAffinity, nil
+}
+
+// mergeNodeAffinity adds node affinity of the network to dst. Required node selector terms are ORed, so both
+// required node selectors are satisfied only by every combination of their terms. Preferred terms are appended.
+func mergeNodeAffinity(dst *corev1.NodeAffinity, src *corev1.NodeAffinity) {
+	if src.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		if dst.RequiredDuringSchedulingIgnoredDuringExecution == nil ||
+			len(dst.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
+			dst.RequiredDuringSchedulingIgnoredDuringExecution = src.RequiredDuringSchedulingIgnoredDuringExecution.DeepCopy()
+		} else {
+			var terms []corev1.NodeSelectorTerm
+			for _, dstTerm := range dst.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				for _, srcTerm := range src.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+					term := dstTerm.DeepCopy()
+					term.MatchExpressions = append(term.MatchExpressions, srcTerm.DeepCopy().MatchExpressions...)
+					term.MatchFields = append(term.MatchFields, srcTerm.DeepCopy().MatchFields...)
+					terms = append(terms, *term)
+				}
+			}
+			dst.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = terms
+		}
+	}
+	for _, term := range src.PreferredDuringSchedulingIgnoredDuringExecution {
+		dst.PreferredDuringSchedulingIgnoredDuringExecution = append(dst.PreferredDuringSchedulingIgnoredDuringExecution, *term.DeepCopy())
+	}
+}
+
+// createNodeAffinityPatch merges node affinity match expressions and node affinity required by the networks with
+// node affinity of the pod
+func createNodeAffinityPatch(patch []types.JsonPatchOperation, existing *corev1.Affinity, desired []corev1.NodeSelectorRequirement,
+	injected *corev1.NodeAffinity) []types.JsonPatchOperation {
+	hasInjected := injected != nil && (injected.RequiredDuringSchedulingIgnoredDuringExecution != nil ||
+		len(injected.PreferredDuringSchedulingIgnoredDuringExecution) > 0)
+	if len(desired) == 0 && !hasInjected {
+		return patch
+	}
+
+	nodeAffinity := &corev1.NodeAffinity{}
+	if existing != nil && existing.NodeAffinity != nil {
+		nodeAffinity = existing.NodeAffinity.DeepCopy()
+	}
+	if hasInjected {
+		mergeNodeAffinity(nodeAffinity, injected)
+	}
+
+	/* node selector terms are ORed, so desired requirements have to be ANDed into every existing term */
+	if len(desired) > 0 {
+		if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil ||
+			len(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{}},
+			}
+		}
+		terms := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+		for i := range terms {
+			terms[i].MatchExpressions = append(terms[i].MatchExpressions, desired...)
+		}
+	}
+
+	if existing == nil {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/affinity",
+			Value:     corev1.Affinity{NodeAffinity: nodeAffinity},
+		})
+	} else {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/affinity/nodeAffinity",
+			Value:     nodeAffinity,
+		})
+	}
 	return patch
 }
 
-func createResourcePatch(patch []types.JsonPatchOperation, Containers []corev1.Container, resourceRequests map[string]int64) []types.JsonPatchOperation {
+// completePartialResources handles containers setting only request or only limit of resource requested by pod
+// networks. Such resource is not injected, so the missing field is either added with quantity of the one set by the
+// user, or the pod is denied, according to the partial resources action.
+func (wh *Webhook) completePartialResources(patch []types.JsonPatchOperation, Containers []corev1.Container, resourceRequests map[string]int64) ([]types.JsonPatchOperation, error) {
+	for containerIndex, container := range Containers {
+		path := containerPath(containersPath, containerIndex)
+		for resourceName := range resourceRequests {
+			name := corev1.ResourceName(resourceName)
+			request, requested := container.Resources.Requests[name]
+			limit, limited := container.Resources.Limits[name]
+			if requested == limited || isOvercommitAllowed(name) {
+				continue
+			}
+			/* requests default to limits, which is what limits-only patch mode relies on */
+			if limited && wh.controlSwitches.GetExtendedResourcePatchMode() == controlswitches.ExtendedResourcePatchModeLimitsOnly {
+				continue
+			}
+
+			setField, missingField, quantity := "limits", "requests", limit
+			if requested {
+				setField, missingField, quantity = "requests", "limits", request
+			}
+			if wh.controlSwitches.GetPartialResourcesAction() == controlswitches.PartialResourcesDeny {
+				return nil, errors.Errorf("container '%s' sets only %s of resource '%s' requested by pod networks, both requests and limits have to be set",
+					container.Name, setField, resourceName)
+			}
+
+			logger.Infof("container '%s' sets only %s of resource '%s', adding %s of the same quantity",
+				container.Name, setField, resourceName, missingField)
+			fieldPath := path + "/resources/" + missingField
+			if (missingField == "requests" && len(container.Resources.Requests) == 0) ||
+				(missingField == "limits" && len(container.Resources.Limits) == 0) {
+				if !hasPatchPath(patch, fieldPath) {
+					patch = patchEmptyResources(patch, path, missingField)
+				}
+			}
+			patch = append(patch, types.JsonPatchOperation{
+				Operation: "add",
+				Path:      fieldPath + "/" + toSafeJsonPatchKey(resourceName),
+				Value:     quantity,
+			})
+		}
+	}
+	return patch, nil
+}
+
+// capResourceRequests limits count of every requested resource to the configured maximum, so a pod selecting the
+// same network many times does not exhaust devices. Resource above the maximum is clamped or the pod is denied.
+func (wh *Webhook) capResourceRequests(resourceRequests map[string]int64) (map[string]int64, error) {
+	maxCount := wh.controlSwitches.GetMaxResourceCount()
+	if maxCount == 0 {
+		return resourceRequests, nil
+	}
+
+	names := make([]string, 0, len(resourceRequests))
+	for resourceName := range resourceRequests {
+		names = append(names, resourceName)
+	}
+	sort.Strings(names)
+
+	for _, resourceName := range names {
+		count := resourceRequests[resourceName]
+		if count <= maxCount {
+			continue
+		}
+		if wh.controlSwitches.GetResourceCapAction() == controlswitches.ResourceCapDeny {
+			return nil, errors.Errorf("resource '%s' is requested %d times, at most %d is allowed", resourceName, count, maxCount)
+		}
+		logger.Warningf("resource '%s' is requested %d times, clamping to maximum of %d", resourceName, count, maxCount)
+		resourceRequests[resourceName] = maxCount
+	}
+	return resourceRequests, nil
+}
+
+func (wh *Webhook) createResourcePatch(patch []types.JsonPatchOperation, Containers []corev1.Container, resourceRequests map[string]int64) ([]types.JsonPatchOperation, error) {
 	/* check whether resources paths exists in the first container and add as the first patches if missing */
 	if len(Containers[0].Resources.Requests) == 0 {
-		patch = patchEmptyResources(patch, 0, "requests")
+		patch = patchEmptyResources(patch, containerPath(containersPath, 0), "requests")
 	}
 	if len(Containers[0].Resources.Limits) == 0 {
-		patch = patchEmptyResources(patch, 0, "limits")
+		patch = patchEmptyResources(patch, containerPath(containersPath, 0), "limits")
+	}
+
+	/* resources set partially by the user are not injected, so they have to be made consistent first */
+	patch, err := wh.completePartialResources(patch, Containers, resourceRequests)
+	if err != nil {
+		return nil, err
 	}
 
 	for resourceName := range resourceRequests {
@@ -626,316 +2246,1964 @@ func createResourcePatch(patch []types.JsonPatchOperation, Containers []corev1.C
 		}
 	}
 
-	resourceList := *getResourceList(resourceRequests)
+	resourceList := *wh.getResourceList(resourceRequests)
 
 	for resource, quantity := range resourceList {
-		patch = appendResource(patch, resource.String(), quantity, quantity)
+		patch = wh.appendResource(patch, containerPath(containersPath, 0), Containers[0].Resources, resource.String(), quantity, quantity)
 	}
 
-	return patch
+	return patch, nil
+}
+
+// absentValue is value of test operation asserting the path does not exist, raw null is not omitted from the operation
+var absentValue = json.RawMessage("null")
+
+// createIfAbsentResourcePatch injects resources missing in all containers into the first container, the same way as
+// createResourcePatch, but every added resource field is preceded by test operation. Test operation with null value
+// passes only when the path does not exist, so the patch is rejected instead of overwriting resources set by another
+// mutation of the pod in the meantime.
+func (wh *Webhook) createIfAbsentResourcePatch(patch []types.JsonPatchOperation, Containers []corev1.Container, resourceRequests map[string]int64) ([]types.JsonPatchOperation, error) {
+	start := len(patch)
+	patch, err := wh.createResourcePatch(patch, Containers, resourceRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	guarded := append([]types.JsonPatchOperation{}, patch[:start]...)
+	for _, operation := range patch[start:] {
+		if operation.Operation == "add" && strings.Contains(operation.Path, "/resources/") {
+			guarded = append(guarded, types.JsonPatchOperation{Operation: "test", Path: operation.Path, Value: absentValue})
+		}
+		guarded = append(guarded, operation)
+	}
+	return guarded, nil
+}
+
+// honoredQuantity returns quantity of the resource requested by the target container when existing resources are
+// honored. own is the quantity already requested by the target container, total by all the containers.
+func (wh *Webhook) honoredQuantity(injected, own, total int64) int64 {
+	switch wh.controlSwitches.GetHonorResourcesPolicy() {
+	case controlswitches.HonorResourcesPolicyMax:
+		if own > injected {
+			return own
+		}
+		return injected
+	case controlswitches.HonorResourcesPolicyTopUp:
+		if missing := injected - total; missing > 0 {
+			return own + missing
+		}
+		return own
+	default:
+		return own + injected
+	}
+}
+
+// sumResource returns quantity of the resource in the given resource lists of all containers
+func sumResource(resourceLists []corev1.ResourceList, resourceName corev1.ResourceName) int64 {
+	var total int64
+	for _, resourceList := range resourceLists {
+		if value, ok := resourceList[resourceName]; ok {
+			total += value.Value()
+		}
+	}
+	return total
 }
 
-func updateResourcePatch(patch []types.JsonPatchOperation, Containers []corev1.Container, resourceRequests map[string]int64) []types.JsonPatchOperation {
+func (wh *Webhook) updateResourcePatch(patch []types.JsonPatchOperation, Containers []corev1.Container, resourceRequests map[string]int64) []types.JsonPatchOperation {
 	var existingrequestsMap map[corev1.ResourceName]resource.Quantity
 	var existingLimitsMap map[corev1.ResourceName]resource.Quantity
 
 	if len(Containers[0].Resources.Requests) == 0 {
-		patch = patchEmptyResources(patch, 0, "requests")
+		patch = patchEmptyResources(patch, containerPath(containersPath, 0), "requests")
 	} else {
 		existingrequestsMap = Containers[0].Resources.Requests
 	}
 	if len(Containers[0].Resources.Limits) == 0 {
-		patch = patchEmptyResources(patch, 0, "limits")
+		patch = patchEmptyResources(patch, containerPath(containersPath, 0), "limits")
 	} else {
 		existingLimitsMap = Containers[0].Resources.Limits
 	}
 
-	resourceList := *getResourceList(resourceRequests)
+	var allRequests, allLimits []corev1.ResourceList
+	for _, container := range Containers {
+		allRequests = append(allRequests, container.Resources.Requests)
+		allLimits = append(allLimits, container.Resources.Limits)
+	}
+
+	for resourceName, count := range resourceRequests {
+		name := corev1.ResourceName(resourceName)
+		ownRequest, requested := existingrequestsMap[name]
+		ownLimit, limited := existingLimitsMap[name]
+		request := wh.honoredQuantity(count, ownRequest.Value(), sumResource(allRequests, name))
+		limit := wh.honoredQuantity(count, ownLimit.Value(), sumResource(allLimits, name))
+		if requested && limited && request == ownRequest.Value() && limit == ownLimit.Value() {
+			/* containers already request enough of the resource */
+			continue
+		}
+		patch = wh.appendResource(patch, containerPath(containersPath, 0), Containers[0].Resources, resourceName,
+			wh.newQuantity(resourceName, request), wh.newQuantity(resourceName, limit))
+	}
+
+	return patch
+}
+
+// assignComputeResources returns CPU and memory requested by the networks under index of the container they are
+// injected into. Requests of networks targeting containers go to the first container matching the target expression,
+// the other requests to the first container matching the default target, if any. First container gets requests
+// matching no container.
+func assignComputeResources(containers []corev1.Container, computeReqs map[string]corev1.ResourceList, defaultTarget string) map[int]corev1.ResourceList {
+	assigned := make(map[int]corev1.ResourceList)
+	for target, requests := range computeReqs {
+		if target == "" {
+			target = defaultTarget
+		}
+		containerIndex := 0
+		if re, err := compileTargetContainers(target); target != "" && err == nil {
+			for i, container := range containers {
+				if re.MatchString(container.Name) {
+					containerIndex = i
+					break
+				}
+			}
+		}
+		if _, exists := assigned[containerIndex]; !exists {
+			assigned[containerIndex] = corev1.ResourceList{}
+		}
+		for name, quantity := range requests {
+			total := assigned[containerIndex][name]
+			total.Add(quantity)
+			assigned[containerIndex][name] = total
+		}
+	}
+	return assigned
+}
+
+// injectedCompute returns CPU and memory injected into the containers by previous invocation of the webhook, read from
+// the injected compute annotation of the pod under the container name
+func (wh *Webhook) injectedCompute(pod *corev1.Pod) map[string]corev1.ResourceList {
+	injected := make(map[string]corev1.ResourceList)
+	value, exists := pod.ObjectMeta.Annotations[wh.annotationKey(injectedComputeKey)]
+	if !exists {
+		return injected
+	}
+	if err := json.Unmarshal([]byte(value), &injected); err != nil {
+		logger.Warningf("ignoring invalid annotation '%s' of pod %s/%s: %v", wh.annotationKey(injectedComputeKey),
+			pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
+		return map[string]corev1.ResourceList{}
+	}
+	return injected
+}
+
+// createComputeResourcePatch sets requests of the containers to CPU and memory requested by the networks on top of the
+// requests the containers have on their own. Existing limits of the containers are raised by the same quantity, so
+// they remain above the requests. Quantities injected by previous invocation of the webhook, recorded by the returned
+// annotation patch, are not counted as own requests of the containers, so the patch is the same when the webhook is
+// invoked again for the patched pod.
+func (wh *Webhook) createComputeResourcePatch(patch []types.JsonPatchOperation, pod *corev1.Pod,
+	computeReqs map[int]corev1.ResourceList) ([]types.JsonPatchOperation, []types.JsonPatchOperation) {
+	previous := wh.injectedCompute(pod)
+	if len(computeReqs) == 0 && len(previous) == 0 {
+		return patch, nil
+	}
+
+	injected := make(map[string]corev1.ResourceList)
+	for containerIndex, container := range pod.Spec.Containers {
+		requests := computeReqs[containerIndex]
+		if len(requests) == 0 && len(previous[container.Name]) == 0 {
+			continue
+		}
+		path := containerPath(containersPath, containerIndex)
+		if len(container.Resources.Requests) == 0 && len(requests) > 0 && !hasPatchPath(patch, path+"/resources/requests") {
+			patch = patchEmptyResources(patch, path, "requests")
+		}
+
+		names := make([]string, 0, len(requests)+len(previous[container.Name]))
+		for name := range requests {
+			names = append(names, string(name))
+		}
+		for name := range previous[container.Name] {
+			if _, exists := requests[name]; !exists {
+				names = append(names, string(name))
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			resourceName := corev1.ResourceName(name)
+			injectedBefore, requested := previous[container.Name][resourceName], requests[resourceName]
+			if existing, exists := container.Resources.Requests[resourceName]; exists || requested.Sign() > 0 {
+				patch = appendComputeQuantity(patch, path+"/resources/requests/"+toSafeJsonPatchKey(name), existing, injectedBefore, requested)
+			}
+			if existing, exists := container.Resources.Limits[resourceName]; exists {
+				patch = appendComputeQuantity(patch, path+"/resources/limits/"+toSafeJsonPatchKey(name), existing, injectedBefore, requested)
+			}
+		}
+		if len(requests) > 0 {
+			injected[container.Name] = requests
+		}
+	}
+
+	/* annotation is rewritten even when nothing is injected anymore, so the quantities are not subtracted again */
+	value, err := json.Marshal(injected)
+	if err != nil {
+		logger.Warningf("failed to create injected compute annotation: %v", err)
+		return patch, nil
+	}
+	return patch, []types.JsonPatchOperation{{
+		Operation: "add",
+		Path:      "/metadata/annotations",
+		Value:     map[string]interface{}{wh.annotationKey(injectedComputeKey): string(value)},
+	}}
+}
+
+// appendComputeQuantity sets the quantity at the path to the existing quantity without the quantity injected before,
+// which it can not go below, with the requested quantity added. Quantity which was injected only is removed when the
+// networks do not request it anymore.
+func appendComputeQuantity(patch []types.JsonPatchOperation, path string, existing, injected, requested resource.Quantity) []types.JsonPatchOperation {
+	quantity := existing.DeepCopy()
+	if quantity.Cmp(injected) >= 0 {
+		quantity.Sub(injected)
+	}
+	quantity.Add(requested)
+	if quantity.IsZero() && injected.Sign() > 0 {
+		return append(patch, types.JsonPatchOperation{
+			Operation: "remove",
+			Path:      path,
+		})
+	}
+	return append(patch, types.JsonPatchOperation{
+		Operation: "add",
+		Path:      path,
+		Value:     quantity,
+	})
+}
+
+// mergeAnnotationsPatch adds annotations of the annotations patches into the annotations patch already in the patch,
+// which would be replaced by another one, or appends them as a new annotations patch
+func mergeAnnotationsPatch(patch []types.JsonPatchOperation, pod corev1.Pod, annotationsPatch []types.JsonPatchOperation) []types.JsonPatchOperation {
+	for i := range patch {
+		annotations, ok := patch[i].Value.(map[string]string)
+		if patch[i].Path != "/metadata/annotations" || patch[i].Operation != "add" || !ok {
+			continue
+		}
+		for _, p := range annotationsPatch {
+			for k, v := range p.Value.(map[string]interface{}) {
+				annotations[k] = v.(string)
+			}
+		}
+		return patch
+	}
+	return appendAddAnnotPatch(patch, pod, annotationsPatch)
+}
+
+// createInitContainersResourcePatch injects resources into every init container. Kubelet computes the effective pod
+// request as the maximum of the init containers requests and the sum of the app containers requests, and devices
+// allocated to init containers are reused by app containers, so this does not increase the pod demand. Resources
+// are deduplicated per init container, independently of the app containers.
+func (wh *Webhook) createInitContainersResourcePatch(patch []types.JsonPatchOperation, initContainers []corev1.Container, resourceRequests map[string]int64) []types.JsonPatchOperation {
+	resourceList := *wh.getResourceList(resourceRequests)
+
+	for containerIndex, container := range initContainers {
+		path := containerPath(initContainersPath, containerIndex)
+		toInject := corev1.ResourceList{}
+		for resourceName, quantity := range resourceList {
+			_, inRequests := container.Resources.Requests[resourceName]
+			_, inLimits := container.Resources.Limits[resourceName]
+			if (inRequests || inLimits) && !wh.controlSwitches.IsHonorExistingResourcesEnabled() {
+				continue
+			}
+			toInject[resourceName] = quantity
+		}
+		if len(toInject) == 0 {
+			continue
+		}
+
+		if len(container.Resources.Requests) == 0 {
+			patch = patchEmptyResources(patch, path, "requests")
+		}
+		if len(container.Resources.Limits) == 0 {
+			patch = patchEmptyResources(patch, path, "limits")
+		}
+		for resourceName, quantity := range toInject {
+			/* init containers run one by one, so each of them is honored on its own */
+			ownRequest := container.Resources.Requests[resourceName]
+			ownLimit := container.Resources.Limits[resourceName]
+			request := wh.honoredQuantity(quantity.Value(), ownRequest.Value(), ownRequest.Value())
+			limit := wh.honoredQuantity(quantity.Value(), ownLimit.Value(), ownLimit.Value())
+			patch = wh.appendResource(patch, path, container.Resources, resourceName.String(),
+				wh.newQuantity(resourceName.String(), request), wh.newQuantity(resourceName.String(), limit))
+		}
+	}
+
+	return patch
+}
+
+// createAllContainersResourcePatch injects resources into every app container, e.g. for DPDK multi-process pods whose
+// containers all need the devices. Resources are deduplicated per container, so resource defined by one container does
+// not prevent injection into the others.
+func (wh *Webhook) createAllContainersResourcePatch(patch []types.JsonPatchOperation, containers []corev1.Container,
+	resourceRequests map[string]int64) ([]types.JsonPatchOperation, error) {
+	honor := wh.controlSwitches.IsHonorExistingResourcesEnabled()
+	if !honor {
+		/* resources set partially by the user are not injected, so they have to be made consistent first */
+		var err error
+		patch, err = wh.completePartialResources(patch, containers, resourceRequests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := make([]string, 0, len(resourceRequests))
+	for resourceName := range resourceRequests {
+		names = append(names, resourceName)
+	}
+	sort.Strings(names)
+
+	for containerIndex, container := range containers {
+		path := containerPath(containersPath, containerIndex)
+		var toInject []string
+		for _, resourceName := range names {
+			name := corev1.ResourceName(resourceName)
+			_, inRequests := container.Resources.Requests[name]
+			_, inLimits := container.Resources.Limits[name]
+			if (inRequests || inLimits) && !honor {
+				continue
+			}
+			toInject = append(toInject, resourceName)
+		}
+		if len(toInject) == 0 {
+			continue
+		}
+
+		if len(container.Resources.Requests) == 0 && !hasPatchPath(patch, path+"/resources/requests") {
+			patch = patchEmptyResources(patch, path, "requests")
+		}
+		if len(container.Resources.Limits) == 0 && !hasPatchPath(patch, path+"/resources/limits") {
+			patch = patchEmptyResources(patch, path, "limits")
+		}
+		for _, resourceName := range toInject {
+			/* every container gets its own devices, so each of them is honored on its own */
+			ownRequest := container.Resources.Requests[corev1.ResourceName(resourceName)]
+			ownLimit := container.Resources.Limits[corev1.ResourceName(resourceName)]
+			request := wh.honoredQuantity(resourceRequests[resourceName], ownRequest.Value(), ownRequest.Value())
+			limit := wh.honoredQuantity(resourceRequests[resourceName], ownLimit.Value(), ownLimit.Value())
+			patch = wh.appendResource(patch, path, container.Resources, resourceName,
+				wh.newQuantity(resourceName, request), wh.newQuantity(resourceName, limit))
+		}
+	}
+
+	return patch, nil
+}
+
+// assignTargetedResources splits the requested resources between app containers. Resources targeted at containers by
+// name are assigned to the first container matching the target expression, the remaining resources are returned to
+// be injected into the first container as usual. Targeted resources are injected into the first container as well
+// when no container matches.
+func assignTargetedResources(containers []corev1.Container, resourceRequests map[string]int64,
+	targetedReqs map[string]map[string]int64) (map[string]int64, map[int]map[string]int64) {
+	remaining := make(map[string]int64, len(resourceRequests))
+	for resourceName, count := range resourceRequests {
+		remaining[resourceName] = count
+	}
+	assigned := make(map[int]map[string]int64)
+
+	targets := make([]string, 0, len(targetedReqs))
+	for target := range targetedReqs {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		re, err := compileTargetContainers(target)
+		if err != nil {
+			logger.Warningf("invalid target containers '%s', injecting its resources into the first container: %v", target, err)
+			continue
+		}
+		containerIndex := -1
+		for i, container := range containers {
+			if re.MatchString(container.Name) {
+				containerIndex = i
+				break
+			}
+		}
+		if containerIndex < 0 {
+			logger.Warningf("no container matches target containers '%s', injecting its resources into the first container", target)
+			continue
+		}
+		if containerIndex == 0 {
+			continue
+		}
+		if _, exists := assigned[containerIndex]; !exists {
+			assigned[containerIndex] = make(map[string]int64)
+		}
+		for resourceName, count := range targetedReqs[target] {
+			/* requests could have been clamped to the resource cap */
+			if count > remaining[resourceName] {
+				count = remaining[resourceName]
+			}
+			if count == 0 {
+				continue
+			}
+			assigned[containerIndex][resourceName] += count
+			remaining[resourceName] -= count
+			if remaining[resourceName] == 0 {
+				delete(remaining, resourceName)
+			}
+		}
+	}
+
+	return remaining, assigned
+}
+
+// resourceContainers returns indexes of app containers resources are injected into, the first container gets the
+// resources not assigned to other containers
+func resourceContainers(resourceRequests map[string]int64, assignedRequests map[int]map[string]int64) map[int]bool {
+	containers := make(map[int]bool, len(assignedRequests)+1)
+	if len(resourceRequests) > 0 {
+		containers[0] = true
+	}
+	for containerIndex := range assignedRequests {
+		containers[containerIndex] = true
+	}
+	return containers
+}
+
+// runtimeClassOverride returns injection behavior overridden for the runtime class of the pod, pod without overridden
+// runtime class gets zero override which keeps the default behavior
+func (wh *Webhook) runtimeClassOverride(pod *corev1.Pod) controlswitches.RuntimeClassOverride {
+	if pod.Spec.RuntimeClassName == nil {
+		return controlswitches.RuntimeClassOverride{}
+	}
+	override, exists := wh.controlSwitches.GetRuntimeClassOverride(*pod.Spec.RuntimeClassName)
+	if exists {
+		logger.Infof("injection into pod %s/%s is overridden for runtime class '%s': %+v", pod.ObjectMeta.Namespace,
+			pod.ObjectMeta.Name, *pod.Spec.RuntimeClassName, override)
+	}
+	return override
+}
+
+// imageTargetContainer returns name of the first container running image matching the target container images
+func (wh *Webhook) imageTargetContainer(containers []corev1.Container) (string, bool) {
+	for _, container := range containers {
+		if wh.controlSwitches.IsTargetContainerImage(container.Image) {
+			return container.Name, true
+		}
+	}
+	return "", false
+}
+
+// addDefaultTarget targets resources of networks which do not target containers by themselves at containers
+// matching the target expression, e.g. of the runtime class override
+func addDefaultTarget(resourceRequests map[string]int64, targetedReqs map[string]map[string]int64, target string) {
+	for resourceName, count := range resourceRequests {
+		for _, reqs := range targetedReqs {
+			count -= reqs[resourceName]
+		}
+		if count > 0 {
+			addTargetedResource(targetedReqs, target, resourceName, count)
+		}
+	}
+}
+
+// podLevelResources returns pod-level resources of the pod or of the pod template of the workload controller, nil when
+// pod does not define them. Vendored API does not define pod-level resources yet, so they are read from the raw object.
+func podLevelResources(ar *admissionv1.AdmissionReview) (*corev1.ResourceRequirements, error) {
+	var object struct {
+		Spec struct {
+			Resources *corev1.ResourceRequirements `json:"resources"`
+			Template  struct {
+				Spec struct {
+					Resources *corev1.ResourceRequirements `json:"resources"`
+				} `json:"spec"`
+			} `json:"template"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(ar.Request.Object.Raw, &object); err != nil {
+		return nil, errors.Wrap(err, "could not read pod-level resources")
+	}
+	if isWorkloadController(ar) {
+		return object.Spec.Template.Spec.Resources, nil
+	}
+	return object.Spec.Resources, nil
+}
+
+// createPodResourcePatch injects resources into pod-level resources instead of the first container. Resources already
+// defined at pod level or by a container are kept as they are.
+func (wh *Webhook) createPodResourcePatch(patch []types.JsonPatchOperation, containers []corev1.Container,
+	podResources corev1.ResourceRequirements, resourceRequests map[string]int64) []types.JsonPatchOperation {
+	names := make([]string, 0, len(resourceRequests))
+	for resourceName := range resourceRequests {
+		name := corev1.ResourceName(resourceName)
+		_, inRequests := podResources.Requests[name]
+		_, inLimits := podResources.Limits[name]
+		for _, container := range containers {
+			if _, exists := container.Resources.Requests[name]; exists {
+				inRequests = true
+			}
+			if _, exists := container.Resources.Limits[name]; exists {
+				inLimits = true
+			}
+		}
+		if inRequests || inLimits {
+			logger.Infof("pod already defines resource '%s', skipping...", resourceName)
+			continue
+		}
+		names = append(names, resourceName)
+	}
+	if len(names) == 0 {
+		return patch
+	}
+	sort.Strings(names)
+
+	if len(podResources.Requests) == 0 {
+		patch = patchEmptyResources(patch, podSpecPath, "requests")
+	}
+	if len(podResources.Limits) == 0 {
+		patch = patchEmptyResources(patch, podSpecPath, "limits")
+	}
+	for _, resourceName := range names {
+		quantity := wh.newQuantity(resourceName, resourceRequests[resourceName])
+		patch = wh.appendResource(patch, podSpecPath, podResources, resourceName, quantity, quantity)
+	}
+	return patch
+}
+
+// createTargetedResourcePatch injects resources assigned to app containers other than the first one. Resources
+// already defined by the container are kept as they are.
+func (wh *Webhook) createTargetedResourcePatch(patch []types.JsonPatchOperation, containers []corev1.Container,
+	assigned map[int]map[string]int64) []types.JsonPatchOperation {
+	indexes := make([]int, 0, len(assigned))
+	for containerIndex := range assigned {
+		indexes = append(indexes, containerIndex)
+	}
+	sort.Ints(indexes)
+
+	for _, containerIndex := range indexes {
+		container := containers[containerIndex]
+		path := containerPath(containersPath, containerIndex)
+		names := make([]string, 0, len(assigned[containerIndex]))
+		for resourceName := range assigned[containerIndex] {
+			_, inRequests := container.Resources.Requests[corev1.ResourceName(resourceName)]
+			_, inLimits := container.Resources.Limits[corev1.ResourceName(resourceName)]
+			if inRequests || inLimits {
+				logger.Infof("container %s already defines resource '%s', skipping...", container.Name, resourceName)
+				continue
+			}
+			names = append(names, resourceName)
+		}
+		if len(names) == 0 {
+			continue
+		}
+		sort.Strings(names)
+
+		if len(container.Resources.Requests) == 0 {
+			patch = patchEmptyResources(patch, path, "requests")
+		}
+		if len(container.Resources.Limits) == 0 {
+			patch = patchEmptyResources(patch, path, "limits")
+		}
+		for _, resourceName := range names {
+			quantity := wh.newQuantity(resourceName, assigned[containerIndex][resourceName])
+			patch = wh.appendResource(patch, path, container.Resources, resourceName, quantity, quantity)
+		}
+	}
+
+	return patch
+}
+
+// isOvercommitAllowed returns true for native resources, other than hugepages, which are allowed to have
+// requests without limits
+func isOvercommitAllowed(resourceName corev1.ResourceName) bool {
+	name := string(resourceName)
+	isNative := !strings.Contains(name, "/") || strings.Contains(name, corev1.ResourceDefaultNamespacePrefix)
+	return isNative && !strings.HasPrefix(name, corev1.ResourceHugePagesPrefix)
+}
+
+// patchedResourceFields returns whether requests and limits of the container should carry the injected resource
+// according to the extended resource patch mode. Extended resources have to define limits and requests have to be
+// equal to limits, so a field is still patched when it is needed to keep the pod valid for the API server.
+func (wh *Webhook) patchedResourceFields(resourceName corev1.ResourceName, existing corev1.ResourceRequirements) (bool, bool) {
+	_, requestExists := existing.Requests[resourceName]
+	_, limitExists := existing.Limits[resourceName]
+
+	switch wh.controlSwitches.GetExtendedResourcePatchMode() {
+	case controlswitches.ExtendedResourcePatchModeLimitsOnly:
+		/* requests default to limits, unless the request was set by the user */
+		return requestExists, true
+	case controlswitches.ExtendedResourcePatchModeRequestsOnly:
+		if !limitExists && !isOvercommitAllowed(resourceName) {
+			logger.Warningf("resource '%s' must define limits, injecting both requests and limits", resourceName)
+			return true, true
+		}
+		return true, limitExists
+	default:
+		return true, true
+	}
+}
+
+func (wh *Webhook) appendResource(patch []types.JsonPatchOperation, containerPath string, existing corev1.ResourceRequirements,
+	resourceName string, reqQuantity, limitQuantity resource.Quantity) []types.JsonPatchOperation {
+	patchRequests, patchLimits := wh.patchedResourceFields(corev1.ResourceName(resourceName), existing)
+
+	if patchRequests {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      containerPath + "/resources/requests/" + toSafeJsonPatchKey(resourceName),
+			Value:     reqQuantity,
+		})
+	}
+	if patchLimits {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      containerPath + "/resources/limits/" + toSafeJsonPatchKey(resourceName),
+			Value:     limitQuantity,
+		})
+	}
+
+	return patch
+}
+
+func (wh *Webhook) getResourceList(resourceRequests map[string]int64) *corev1.ResourceList {
+	resourceList := corev1.ResourceList{}
+	for name, number := range resourceRequests {
+		resourceList[corev1.ResourceName(name)] = wh.newQuantity(name, number)
+	}
+
+	return &resourceList
+}
+
+// newQuantity returns quantity of the injected resource in the format configured for the resource, so it is rendered
+// the same way as in manifests of the tools comparing it
+func (wh *Webhook) newQuantity(resourceName string, count int64) resource.Quantity {
+	return *resource.NewQuantity(count, wh.controlSwitches.GetQuantityFormat(resourceName))
+}
+
+func appendAddAnnotPatch(patch []types.JsonPatchOperation, pod corev1.Pod, userDefinedPatch []types.JsonPatchOperation) []types.JsonPatchOperation {
+	annotations := make(map[string]string)
+	patchOp := types.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/metadata/annotations",
+		Value:     annotations,
+	}
+
+	for _, p := range userDefinedPatch {
+		if p.Path == "/metadata/annotations" && p.Operation == "add" {
+			//loop over user defined injected annotations key-value pairs
+			for k, v := range p.Value.(map[string]interface{}) {
+				if _, exists := annotations[k]; exists {
+					logger.Warningf("ignoring duplicate user defined injected annotation: %s: %s", k, v.(string))
+				} else {
+					annotations[k] = v.(string)
+				}
+			}
+		}
+	}
+
+	if len(annotations) > 0 {
+		// attempt to add existing pod annotation but do not override
+		for k, v := range pod.ObjectMeta.Annotations {
+			if _, exists := annotations[k]; !exists {
+				annotations[k] = v
+			}
+		}
+		patch = append(patch, patchOp)
+	}
+
+	return patch
+}
+
+func appendAddSysctlPatch(patch []types.JsonPatchOperation, pod corev1.Pod, userDefinedPatch []types.JsonPatchOperation) []types.JsonPatchOperation {
+	var sysctls []corev1.Sysctl
+	names := make(map[string]bool)
+
+	if pod.Spec.SecurityContext != nil {
+		for _, sysctl := range pod.Spec.SecurityContext.Sysctls {
+			sysctls = append(sysctls, sysctl)
+			names[sysctl.Name] = true
+		}
+	}
+	existing := len(sysctls)
+
+	for _, p := range userDefinedPatch {
+		if p.Path != "/spec/securityContext/sysctls" || p.Operation != "add" {
+			continue
+		}
+		userSysctls, err := userdefinedinjections.GetSysctls(p)
+		if err != nil {
+			logger.Warningf("ignoring invalid user defined injected sysctls: %v", err)
+			continue
+		}
+		for _, sysctl := range userSysctls {
+			if names[sysctl.Name] {
+				logger.Warningf("ignoring duplicate user defined injected sysctl: %s: %s", sysctl.Name, sysctl.Value)
+				continue
+			}
+			sysctls = append(sysctls, sysctl)
+			names[sysctl.Name] = true
+		}
+	}
+
+	if len(sysctls) == existing {
+		return patch
+	}
+
+	if pod.Spec.SecurityContext == nil {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/securityContext",
+			Value:     corev1.PodSecurityContext{Sysctls: sysctls},
+		})
+	} else {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/securityContext/sysctls",
+			Value:     sysctls,
+		})
+	}
+
+	return patch
+}
+
+// annotationKey returns key of the injector annotation with the given name under the configured annotation domain
+func (wh *Webhook) annotationKey(name string) string {
+	return wh.controlSwitches.GetAnnotationDomain() + "/" + name
+}
+
+// injectedResourcesAnnotation returns annotation patch recording requested resources as JSON map of resource name
+// to count. Patch has the same form as user defined annotations patch, so both are merged by appendAddAnnotPatch.
+func (wh *Webhook) injectedResourcesAnnotation(resourceRequests map[string]int64) (types.JsonPatchOperation, error) {
+	value, err := json.Marshal(resourceRequests)
+	if err != nil {
+		return types.JsonPatchOperation{}, err
+	}
+	return types.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/metadata/annotations",
+		Value:     map[string]interface{}{wh.annotationKey(injectedResourcesKey): string(value)},
+	}, nil
+}
+
+// injectorStatusAnnotation returns annotation patch marking the pod as injected, it is a part of the patch with
+// resources, so marked pod always carries the injected resources
+func (wh *Webhook) injectorStatusAnnotation() types.JsonPatchOperation {
+	return types.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/metadata/annotations",
+		Value:     map[string]interface{}{wh.annotationKey(injectorStatusKey): injectorStatusInjected},
+	}
+}
+
+// sourceNadsAnnotation returns annotation patch listing 'namespace/name' of net-attach-defs which contributed
+// injected resources, comma separated. Patch has the same form as user defined annotations patch.
+func (wh *Webhook) sourceNadsAnnotation(sourceNads []string) types.JsonPatchOperation {
+	return types.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/metadata/annotations",
+		Value:     map[string]interface{}{wh.annotationKey(sourceNadsKey): strings.Join(sourceNads, ",")},
+	}
+}
+
+// injectionErrorsAnnotation returns annotations patch recording errors of networks skipped by best effort injection,
+// as JSON object of errors under the net-attach-def 'namespace/name' key
+func (wh *Webhook) injectionErrorsAnnotation(injectionErrors map[string]string) types.JsonPatchOperation {
+	value, _ := json.Marshal(injectionErrors)
+	return types.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/metadata/annotations",
+		Value:     map[string]interface{}{wh.annotationKey(injectionErrorsKey): string(value)},
+	}
+}
+
+// appendSourceNad appends net-attach-def selected by the network to sourceNads when the network increased count of
+// requested resources, every net-attach-def is listed once
+func appendSourceNad(sourceNads []string, net *multus.NetworkSelectionElement, countBefore int64, reqs map[string]int64) []string {
+	if countResourceRequests(reqs) == countBefore {
+		return sourceNads
+	}
+	nad := net.Namespace + "/" + net.Name
+	for _, sourceNad := range sourceNads {
+		if sourceNad == nad {
+			return sourceNads
+		}
+	}
+	return append(sourceNads, nad)
+}
+
+// countResourceRequests returns total count of requested resources
+func countResourceRequests(reqs map[string]int64) int64 {
+	var count int64
+	for _, resourceCount := range reqs {
+		count += resourceCount
+	}
+	return count
+}
+
+// hasPatchPath returns true when patch already contains add operation for the given path
+func hasPatchPath(patch []types.JsonPatchOperation, path string) bool {
+	for _, p := range patch {
+		if p.Operation == "add" && p.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// patchedVolumeMounts returns volume mounts added by the patch to the container with the given path
+func patchedVolumeMounts(patch []types.JsonPatchOperation, containerPath string) []corev1.VolumeMount {
+	var mounts []corev1.VolumeMount
+	for _, p := range patch {
+		if vm, ok := p.Value.(corev1.VolumeMount); ok && p.Path == containerPath+"/volumeMounts/-" {
+			mounts = append(mounts, vm)
+		}
+	}
+	return mounts
+}
+
+// appendAddVolumePatch adds volumes defined by user-defined injections and mounts them into every container.
+// Volumes colliding with volumes of the pod or volumes added by the patch, e.g. podnetinfo, are ignored, same as
+// mounts colliding with mounts of the container.
+func appendAddVolumePatch(patch []types.JsonPatchOperation, pod corev1.Pod, userDefinedPatch []types.JsonPatchOperation) []types.JsonPatchOperation {
+	names := make(map[string]bool)
+	for _, volume := range pod.Spec.Volumes {
+		names[volume.Name] = true
+	}
+	for _, p := range patch {
+		if volume, ok := p.Value.(corev1.Volume); ok && p.Path == "/spec/volumes/-" {
+			names[volume.Name] = true
+		}
+	}
+
+	var volumes []userdefinedinjections.UserDefinedVolume
+	for _, p := range userDefinedPatch {
+		if p.Path != "/spec/volumes" || p.Operation != "add" {
+			continue
+		}
+		userVolumes, err := userdefinedinjections.GetVolumes(p)
+		if err != nil {
+			logger.Warningf("ignoring invalid user defined injected volumes: %v", err)
+			continue
+		}
+		for _, volume := range userVolumes {
+			if names[volume.Name] {
+				logger.Warningf("ignoring user defined injected volume %s colliding with existing volume", volume.Name)
+				continue
+			}
+			volumes = append(volumes, volume)
+			names[volume.Name] = true
+		}
+	}
+	if len(volumes) == 0 {
+		return patch
+	}
+
+	if len(pod.Spec.Volumes) == 0 && !hasPatchPath(patch, "/spec/volumes") {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/volumes",
+			Value:     []corev1.Volume{},
+		})
+	}
+	for _, volume := range volumes {
+		patch = append(patch, types.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/volumes/-",
+			Value:     volume.Volume,
+		})
+	}
+
+	for containerIndex, container := range pod.Spec.Containers {
+		path := containerPath(containersPath, containerIndex)
+		mounted := append(patchedVolumeMounts(patch, path), container.VolumeMounts...)
+		for _, volume := range volumes {
+			if volume.MountPath == "" {
+				continue
+			}
+			if isMounted(mounted, volume.Name, volume.MountPath) {
+				logger.Warningf("container %s already mounts volume %s or other volume at %s, skipping...", container.Name,
+					volume.Name, volume.MountPath)
+				continue
+			}
+			if len(container.VolumeMounts) == 0 && !hasPatchPath(patch, path+"/volumeMounts") {
+				patch = append(patch, types.JsonPatchOperation{
+					Operation: "add",
+					Path:      path + "/volumeMounts",
+					Value:     []corev1.VolumeMount{},
+				})
+			}
+			vm := corev1.VolumeMount{Name: volume.Name, ReadOnly: volume.ReadOnly, MountPath: volume.MountPath}
+			patch = append(patch, types.JsonPatchOperation{
+				Operation: "add",
+				Path:      path + "/volumeMounts/-",
+				Value:     vm,
+			})
+			mounted = append(mounted, vm)
+		}
+	}
+
+	return patch
+}
+
+// isMounted returns true when one of the mounts uses the volume or the mount path
+func isMounted(mounts []corev1.VolumeMount, volumeName, mountPath string) bool {
+	for _, vm := range mounts {
+		if vm.Name == volumeName || vm.MountPath == mountPath {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUserDefinedPatch(patch []types.JsonPatchOperation, pod corev1.Pod, userDefinedPatch []types.JsonPatchOperation) []types.JsonPatchOperation {
+	//Add operation for annotations, sysctls and volumes is currently only supported
+	patch = appendAddAnnotPatch(patch, pod, userDefinedPatch)
+	patch = appendAddSysctlPatch(patch, pod, userDefinedPatch)
+	return appendAddVolumePatch(patch, pod, userDefinedPatch)
+}
+
+// hugepageDownwardAPIFiles maps hugepage resources exposed via Downward API to request and limit file name prefixes
+var hugepageDownwardAPIFiles = []struct {
+	resourceName corev1.ResourceName
+	requestPath  string
+	limitPath    string
+}{
+	{"hugepages-1Gi", types.Hugepages1GRequestPath, types.Hugepages1GLimitPath},
+	{"hugepages-2Mi", types.Hugepages2MRequestPath, types.Hugepages2MLimitPath},
+}
+
+// sanitizeDownwardAPIPathElement replaces characters which are not safe to use in the Downward API file name.
+// Valid container names consist of lower case alphanumeric characters and dashes only, so they are not modified.
+func sanitizeDownwardAPIPathElement(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '.' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// hugepageDownwardAPIPath returns Downward API file path for the container, path is suffixed with a sequence number
+// when sanitized container name collides with already exposed one
+func hugepageDownwardAPIPath(hugepageResourceList []hugepageResourceData, prefix, containerName string) string {
+	path := prefix + "_" + sanitizeDownwardAPIPathElement(containerName)
+	for i := 1; ; i++ {
+		collision := false
+		for _, hugepageResource := range hugepageResourceList {
+			if hugepageResource.Path == path && hugepageResource.ContainerName != containerName {
+				collision = true
+				break
+			}
+		}
+		if !collision {
+			return path
+		}
+		path = fmt.Sprintf("%s_%s_%d", prefix, sanitizeDownwardAPIPathElement(containerName), i)
+	}
+}
+
+// processHugepagesForDownwardAPI collects hugepage requests and limits of the given containers, so they can be exposed
+// via Downward API, and adds container name environment variable to each container that requests hugepages
+func processHugepagesForDownwardAPI(patch []types.JsonPatchOperation, containers []corev1.Container, containersField string,
+	hugepageResourceList []hugepageResourceData) ([]types.JsonPatchOperation, []hugepageResourceData) {
+	for containerIndex, container := range containers {
+		found := false
+		for _, hugepageFiles := range hugepageDownwardAPIFiles {
+			if quantity, exists := container.Resources.Requests[hugepageFiles.resourceName]; exists && !quantity.IsZero() {
+				hugepageResourceList = append(hugepageResourceList, hugepageResourceData{
+					ResourceName:  "requests." + string(hugepageFiles.resourceName),
+					ContainerName: container.Name,
+					Path:          hugepageDownwardAPIPath(hugepageResourceList, hugepageFiles.requestPath, container.Name),
+				})
+				found = true
+			}
+			if quantity, exists := container.Resources.Limits[hugepageFiles.resourceName]; exists && !quantity.IsZero() {
+				hugepageResourceList = append(hugepageResourceList, hugepageResourceData{
+					ResourceName:  "limits." + string(hugepageFiles.resourceName),
+					ContainerName: container.Name,
+					Path:          hugepageDownwardAPIPath(hugepageResourceList, hugepageFiles.limitPath, container.Name),
+				})
+				found = true
+			}
+		}
+
+		// If Hugepages are being added to Downward API, add the
+		// 'container.Name' as an environment variable to the container
+		// so container knows its name and can process hugepages properly.
+		if found {
+			patch = createEnvPatch(patch, &container, containerPath(containersField, containerIndex),
+				types.EnvNameContainerName, container.Name)
+		}
+	}
+
+	return patch, hugepageResourceList
+}
+
+// hugepageVolumePrefix is prefix of names of the injected hugepage volumes, suffixed with the hugepage size and the
+// container index
+const hugepageVolumePrefix = "hugepages"
+
+// hugepageRequest is quantity of hugepages of a size requested by the container
+type hugepageRequest struct {
+	size     string
+	quantity resource.Quantity
+}
+
+// containerHugepages returns hugepages requested by the container sorted by size, limit takes precedence over
+// request as the request defaults to it
+func containerHugepages(container corev1.Container) []hugepageRequest {
+	quantities := make(map[string]resource.Quantity)
+	for _, resources := range []corev1.ResourceList{container.Resources.Requests, container.Resources.Limits} {
+		for resourceName, quantity := range resources {
+			if strings.HasPrefix(string(resourceName), corev1.ResourceHugePagesPrefix) && !quantity.IsZero() {
+				quantities[strings.TrimPrefix(string(resourceName), corev1.ResourceHugePagesPrefix)] = quantity
+			}
+		}
+	}
+	requests := make([]hugepageRequest, 0, len(quantities))
+	for size, quantity := range quantities {
+		requests = append(requests, hugepageRequest{size: size, quantity: quantity})
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].size < requests[j].size })
+	return requests
+}
+
+// hasHugepageVolume returns true when pod defines emptyDir volume backed by hugepages
+func hasHugepageVolume(pod *corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil && strings.HasPrefix(string(volume.EmptyDir.Medium), string(corev1.StorageMediumHugePages)) {
+			return true
+		}
+	}
+	return false
+}
+
+// createHugepageVolumePatch adds emptyDir volume backed by hugepages for every hugepage size requested by the
+// container, sized to the requested hugepages, and mounts it into the container. Mount path is suffixed with the size
+// when container requests more sizes. Pod defining hugepage volume on its own, or already injected one, is left as it is.
+func (wh *Webhook) createHugepageVolumePatch(patch []types.JsonPatchOperation, pod *corev1.Pod, containers []corev1.Container,
+	containersField, volumePrefix string) []types.JsonPatchOperation {
+	if hasHugepageVolume(pod) {
+		logger.Infof("pod %s/%s already has hugepage volume, skipping...", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		return patch
+	}
+
+	for containerIndex, container := range containers {
+		hugepages := containerHugepages(container)
+		path := containerPath(containersField, containerIndex)
+		mounted := append(patchedVolumeMounts(patch, path), container.VolumeMounts...)
+		for _, hugepage := range hugepages {
+			volumeName := fmt.Sprintf("%s-%s-%d", volumePrefix, strings.ToLower(hugepage.size), containerIndex)
+			mountPath := wh.controlSwitches.GetHugepageMountPath()
+			if len(hugepages) > 1 {
+				mountPath += "-" + hugepage.size
+			}
+			if hasVolume(pod, volumeName) || isMounted(mounted, volumeName, mountPath) {
+				logger.Warningf("pod %s/%s already has volume %s or container %s mounts other volume at %s, skipping...",
+					pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, volumeName, container.Name, mountPath)
+				continue
+			}
+
+			if len(pod.Spec.Volumes) == 0 && !hasPatchPath(patch, "/spec/volumes") {
+				patch = append(patch, types.JsonPatchOperation{
+					Operation: "add",
+					Path:      "/spec/volumes",
+					Value:     []corev1.Volume{},
+				})
+			}
+			sizeLimit := hugepage.quantity.DeepCopy()
+			patch = append(patch, types.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/spec/volumes/-",
+				Value: corev1.Volume{
+					Name: volumeName,
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{
+						Medium:    corev1.StorageMedium(string(corev1.StorageMediumHugePagesPrefix) + hugepage.size),
+						SizeLimit: &sizeLimit,
+					}},
+				},
+			})
+
+			if len(container.VolumeMounts) == 0 && !hasPatchPath(patch, path+"/volumeMounts") {
+				patch = append(patch, types.JsonPatchOperation{
+					Operation: "add",
+					Path:      path + "/volumeMounts",
+					Value:     []corev1.VolumeMount{},
+				})
+			}
+			vm := corev1.VolumeMount{Name: volumeName, MountPath: mountPath}
+			patch = append(patch, types.JsonPatchOperation{
+				Operation: "add",
+				Path:      path + "/volumeMounts/-",
+				Value:     vm,
+			})
+			mounted = append(mounted, vm)
+		}
+	}
+
+	return patch
+}
+
+func getNetworkSelections(annotationKey string, pod corev1.Pod, userDefinedPatch []types.JsonPatchOperation) (string, bool) {
+	// User defined annotateKey takes precedence than userDefined injections
+	logger.Infof("search %s in original pod annotations", annotationKey)
+	nets, exists := pod.ObjectMeta.Annotations[annotationKey]
+	if exists {
+		logger.Infof("%s is defined in original pod annotations", annotationKey)
+		return nets, exists
+	}
+
+	logger.Infof("search %s in user-defined injections", annotationKey)
+	// userDefinedPatch may contain user defined net-attach-defs
+	if len(userDefinedPatch) > 0 {
+		for _, p := range userDefinedPatch {
+			if p.Operation == "add" && p.Path == "/metadata/annotations" {
+				for k, v := range p.Value.(map[string]interface{}) {
+					if k == annotationKey {
+						logger.Infof("%s is found in user-defined annotations", annotationKey)
+						return v.(string), true
+					}
+				}
+			}
+		}
+	}
+	logger.Infof("%s is not found in either pod annotations or user-defined injections", annotationKey)
+	return "", false
+}
+
+// getAlternateNetworkSelections returns non-empty network selections of the configured additional network
+// annotation keys, in the order of the keys
+func (wh *Webhook) getAlternateNetworkSelections(pod corev1.Pod, userDefinedPatch []types.JsonPatchOperation) []string {
+	var selections []string
+	for _, annotationKey := range wh.controlSwitches.GetAdditionalNetworkAnnotationKeys() {
+		if nets, exists := getNetworkSelections(annotationKey, pod, userDefinedPatch); exists && nets != "" {
+			selections = append(selections, nets)
+		}
+	}
+	return selections
+}
+
+// getResourceClaimNetworkSelections returns networks mapped to resource claims and resource claim templates
+// referenced by the pod, so resources are injected also for pods requesting network devices via Dynamic Resource
+// Allocation on clusters where device plugins still advertise them as extended resources
+func (wh *Webhook) getResourceClaimNetworkSelections(pod corev1.Pod) []string {
+	if !wh.controlSwitches.IsResourceClaimsEnabled() {
+		return nil
+	}
+	var selections []string
+	for _, claim := range pod.Spec.ResourceClaims {
+		var claimName string
+		switch {
+		case claim.Source.ResourceClaimName != nil:
+			claimName = *claim.Source.ResourceClaimName
+		case claim.Source.ResourceClaimTemplateName != nil:
+			claimName = *claim.Source.ResourceClaimTemplateName
+		default:
+			continue
+		}
+		if network := wh.controlSwitches.GetResourceClaimNetwork(claimName); network != "" {
+			logger.Infof("resource claim '%s' of pod %s/%s selects network '%s'", claim.Name,
+				pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, network)
+			selections = append(selections, network)
+		}
+	}
+	return selections
+}
+
+// getLabelSelectorNetworkSelections returns comma separated networks implicitly selected by pod labels, so pods
+// following a labeling convention get the resources without network annotation
+func (wh *Webhook) getLabelSelectorNetworkSelections(pod corev1.Pod) string {
+	networks := wh.controlSwitches.GetLabelSelectorNetworks(labels.Set(pod.ObjectMeta.Labels))
+	if len(networks) == 0 {
+		return ""
+	}
+	logger.Infof("labels of pod %s/%s select networks %v", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, networks)
+	return strings.Join(networks, ",")
+}
+
+// isInjectionEnabledForNamespace returns true when resources injection is allowed in the given namespace. When the
+// namespace label is configured, namespace has to carry the label with value other than disabled.
+func (wh *Webhook) isInjectionEnabledForNamespace(ctx context.Context, namespace string) (bool, error) {
+	labelKey := wh.controlSwitches.GetNamespaceLabel()
+	if labelKey == "" {
+		return true, nil
+	}
+
+	var namespaceLabels map[string]string
+	exists := false
+	if wh.namespaceCache != nil {
+		namespaceLabels, exists = wh.namespaceCache.Get(namespace)
+	}
+	if !exists {
+		logger.Infof("cache entry not found, retrieving namespace '%s' from api server", namespace)
+		ns, err := wh.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			return false, errors.Wrapf(classifyLookupError(err), "could not get namespace '%s'", namespace)
+		}
+		namespaceLabels = ns.GetLabels()
+	}
+
+	value, exists := namespaceLabels[labelKey]
+	return exists && value != namespaceLabelDisabledValue, nil
+}
+
+// webhookTimeout returns time the API server waits for the response, as sent by the API server in the request
+func webhookTimeout(req *http.Request) time.Duration {
+	if value := req.URL.Query().Get("timeout"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultWebhookTimeout
+}
+
+// apiLookupTimeout returns time left for API server lookups, based on the webhook timeout sent by the API server
+// in the request, leaving a margin for the response to be sent back. Configured lookup timeout is used when it is
+// shorter.
+func (wh *Webhook) apiLookupTimeout(req *http.Request) time.Duration {
+	timeout := webhookTimeout(req)
+	if timeout > 2*webhookResponseMargin {
+		timeout -= webhookResponseMargin
+	} else {
+		timeout /= 2
+	}
+	if configured := wh.controlSwitches.GetLookupTimeout(); configured > 0 && configured < timeout {
+		return configured
+	}
+	return timeout
+}
+
+// skipReason describes why the object was admitted without injection
+type skipReason string
+
+const (
+	skipNoNetworkAnnotations        skipReason = "NoNetworkAnnotations"
+	skipNamespaceNotEnabled         skipReason = "NamespaceNotEnabled"
+	skipWorkloadControllersDisabled skipReason = "WorkloadControllersDisabled"
+	skipNoNetworkResources          skipReason = "NoNetworkResources"
+	skipEphemeralContainersDisabled skipReason = "EphemeralContainersDisabled"
+	skipNoDownwardAPIVolume         skipReason = "NoDownwardAPIVolume"
+	skipRequested                   skipReason = "SkipRequested"
+	skipOwnerExcluded               skipReason = "OwnerExcluded"
+	skipAlreadyInjected             skipReason = "AlreadyInjected"
+	skipNamespaceUnresolved         skipReason = "NamespaceUnresolved"
+	skipOptInMissing                skipReason = "OptInMissing"
+	skipFieldSelectorMatched        skipReason = "FieldSelectorMatched"
+	skipNamespaceIgnored            skipReason = "NamespaceIgnored"
+	skipNoContainers                skipReason = "NoContainers"
+	skipVirtualNode                 skipReason = "VirtualNode"
+)
+
+var skipReasonMessages = map[skipReason]string{
+	skipNoNetworkAnnotations:        "Pod spec doesn't have network annotations",
+	skipNamespaceNotEnabled:         "Injection is disabled for pod namespace",
+	skipWorkloadControllersDisabled: "Mutation of workload controllers is disabled",
+	skipNoNetworkResources:          "Pod networks don't need any custom network resources",
+	skipEphemeralContainersDisabled: "Injection into ephemeral containers is disabled",
+	skipNoDownwardAPIVolume:         "Pod has no Downward API volume to mount into ephemeral containers",
+	skipRequested:                   "Pod requested to skip injection by annotation",
+	skipOwnerExcluded:               "Pod owner is excluded from injection",
+	skipAlreadyInjected:             "Pod is already marked as injected by annotation",
+	skipNamespaceUnresolved:         "Pod namespace could not be determined",
+	skipOptInMissing:                "Pod did not opt into injection by annotation",
+	skipFieldSelectorMatched:        "Pod matches skip field selector",
+	skipNamespaceIgnored:            "Pod namespace is ignored by the injector",
+	skipNoContainers:                "Pod has no containers to inject resources into",
+	skipVirtualNode:                 "Pod targets virtual node which does not provide network resources",
+}
+
+// skipReasonMessage returns message describing the skip reason, annotation causing the skip is referred by its key
+func (wh *Webhook) skipReasonMessage(reason skipReason) string {
+	switch reason {
+	case skipRequested:
+		return skipReasonMessages[reason] + " " + wh.annotationKey(skipInjectionKey)
+	case skipAlreadyInjected:
+		return skipReasonMessages[reason] + " " + wh.annotationKey(injectorStatusKey)
+	case skipOptInMissing:
+		return skipReasonMessages[reason] + " " + wh.annotationKey(injectOptInKey)
+	}
+	return skipReasonMessages[reason]
+}
+
+// getSkipReason returns reason why the pod must not be mutated regardless of its networks, second value is false
+// when pod can be mutated. Skip annotation takes precedence over the opt-in annotation.
+func (wh *Webhook) getSkipReason(pod corev1.Pod) (skipReason, bool) {
+	if strings.ToLower(pod.ObjectMeta.Annotations[wh.annotationKey(skipInjectionKey)]) == "true" {
+		return skipRequested, true
+	}
+	if wh.controlSwitches.IsRequireOptInEnabled() && strings.ToLower(pod.ObjectMeta.Annotations[wh.annotationKey(injectOptInKey)]) != "true" {
+		return skipOptInMissing, true
+	}
+	for _, ownerRef := range pod.ObjectMeta.OwnerReferences {
+		if wh.controlSwitches.IsOwnerSkipped(ownerRef.Kind, ownerRef.Name) {
+			return skipOwnerExcluded, true
+		}
+	}
+	if wh.controlSwitches.IsSkippedByFieldSelector(podSkipFields(pod)) {
+		return skipFieldSelectorMatched, true
+	}
+	if wh.isVirtualNodePod(&pod) {
+		return skipVirtualNode, true
+	}
+	return "", false
+}
+
+// podSkipFields returns pod fields the skip field selector is matched against
+func podSkipFields(pod corev1.Pod) fields.Set {
+	runtimeClassName := ""
+	if pod.Spec.RuntimeClassName != nil {
+		runtimeClassName = *pod.Spec.RuntimeClassName
+	}
+	return fields.Set{
+		"spec.restartPolicy":      string(pod.Spec.RestartPolicy),
+		"spec.schedulerName":      pod.Spec.SchedulerName,
+		"spec.serviceAccountName": pod.Spec.ServiceAccountName,
+		"spec.priorityClassName":  pod.Spec.PriorityClassName,
+		"spec.runtimeClassName":   runtimeClassName,
+		"spec.hostNetwork":        strconv.FormatBool(pod.Spec.HostNetwork),
+	}
+}
+
+func (wh *Webhook) logSkipReason(l logging.Logger, reason skipReason) {
+	l.WithFields(logging.Fields{"skip_reason": string(reason)}).Infof("%s. Skipping...", wh.skipReasonMessage(reason))
+}
 
-	for resourceName, quantity := range resourceList {
-		reqQuantity := quantity
-		limitQuantity := quantity
-		if value, ok := existingrequestsMap[resourceName]; ok {
-			reqQuantity.Add(value)
-		}
-		if value, ok := existingLimitsMap[resourceName]; ok {
-			limitQuantity.Add(value)
-		}
-		patch = appendResource(patch, resourceName.String(), reqQuantity, limitQuantity)
+// addSkipReasonWarning returns skip reason as admission warning, so it is displayed to the user, when enabled
+func (wh *Webhook) addSkipReasonWarning(ar *admissionv1.AdmissionReview, reason skipReason) {
+	if ar.Response != nil && wh.controlSwitches.IsSkipReasonWarningsEnabled() {
+		ar.Response.Warnings = append(ar.Response.Warnings,
+			fmt.Sprintf("network-resources-injector skipped injection (%s): %s", reason, wh.skipReasonMessage(reason)))
 	}
+}
 
-	return patch
+// allowWithoutInjection admits the object unchanged, skip reason is logged and returned in the response
+func (wh *Webhook) allowWithoutInjection(w http.ResponseWriter, ar *admissionv1.AdmissionReview, l logging.Logger, reason skipReason) {
+	wh.logSkipReason(l, reason)
+	err := prepareAdmissionReviewResponse(true, wh.skipReasonMessage(reason)+". Skipping...", ar)
+	if err != nil {
+		l.Errorf("error preparing AdmissionReview response, error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wh.addSkipReasonWarning(ar, reason)
+	writeResponse(w, ar)
 }
 
-func appendResource(patch []types.JsonPatchOperation, resourceName string, reqQuantity, limitQuantity resource.Quantity) []types.JsonPatchOperation {
-	patch = append(patch, types.JsonPatchOperation{
-		Operation: "add",
-		Path:      "/spec/containers/0/resources/requests/" + toSafeJsonPatchKey(resourceName),
-		Value:     reqQuantity,
-	})
-	patch = append(patch, types.JsonPatchOperation{
-		Operation: "add",
-		Path:      "/spec/containers/0/resources/limits/" + toSafeJsonPatchKey(resourceName),
-		Value:     limitQuantity,
-	})
+// logAdmissionResult logs outcome of the admission request along with the time spent processing it
+func logAdmissionResult(l logging.Logger, ar *admissionv1.AdmissionReview, start time.Time) {
+	result := "error"
+	if ar.Response != nil {
+		if ar.Response.Allowed {
+			result = "allowed"
+		} else {
+			result = "denied"
+		}
+	}
+	l.WithFields(logging.Fields{"result": result, "latency_ms": time.Since(start).Milliseconds()}).Infof("admission request processed")
+}
 
-	return patch
+// isEphemeralContainersUpdate returns true when AdmissionReview adds ephemeral containers to existing pod
+func isEphemeralContainersUpdate(ar *admissionv1.AdmissionReview) bool {
+	return ar.Request != nil && ar.Request.Operation == admissionv1.Update &&
+		ar.Request.SubResource == ephemeralContainersSubResource
 }
 
-func getResourceList(resourceRequests map[string]int64) *corev1.ResourceList {
-	resourceList := corev1.ResourceList{}
-	for name, number := range resourceRequests {
-		resourceList[corev1.ResourceName(name)] = *resource.NewQuantity(number, resource.DecimalSI)
+// injectedDownwardAPIVolumeName returns name of the Downward API volume injected into the pod at its creation,
+// empty when pod has none
+func (wh *Webhook) injectedDownwardAPIVolumeName(pod corev1.Pod) string {
+	for _, name := range []string{wh.controlSwitches.GetDownwardAPIVolumeName(), wh.controlSwitches.GetRenamedDownwardAPIVolumeName()} {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.Name == name && volume.DownwardAPI != nil {
+				return name
+			}
+		}
 	}
-
-	return &resourceList
+	return ""
 }
 
-func appendAddAnnotPatch(patch []types.JsonPatchOperation, pod corev1.Pod, userDefinedPatch []types.JsonPatchOperation) []types.JsonPatchOperation {
-	annotations := make(map[string]string)
-	patchOp := types.JsonPatchOperation{
-		Operation: "add",
-		Path:      "/metadata/annotations",
-		Value:     annotations,
+// createEphemeralContainersPatch mounts the Downward API volume injected at pod creation into ephemeral containers,
+// so debugging tools see pod network information. Ephemeral containers cannot define resources and pod volumes
+// cannot be changed when ephemeral containers are added, so only volume mounts and environment variables are
+// patched. Second value is false when pod has no Downward API volume.
+func (wh *Webhook) createEphemeralContainersPatch(pod corev1.Pod) ([]types.JsonPatchOperation, bool) {
+	volumeName := wh.injectedDownwardAPIVolumeName(pod)
+	if volumeName == "" {
+		return nil, false
 	}
 
-	for _, p := range userDefinedPatch {
-		if p.Path == "/metadata/annotations" && p.Operation == "add" {
-			//loop over user defined injected annotations key-value pairs
-			for k, v := range p.Value.(map[string]interface{}) {
-				if _, exists := annotations[k]; exists {
-					glog.Warningf("ignoring duplicate user defined injected annotation: %s: %s", k, v.(string))
-				} else {
-					annotations[k] = v.(string)
-				}
-			}
-		}
+	containers := make([]corev1.Container, len(pod.Spec.EphemeralContainers))
+	for i, ephemeralContainer := range pod.Spec.EphemeralContainers {
+		containers[i] = corev1.Container(ephemeralContainer.EphemeralContainerCommon)
 	}
+	patch := addVolumeMount(nil, containers, ephemeralContainersPath, volumeName, wh.downwardAPIMountPath(&pod), nil)
 
-	if len(annotations) > 0 {
-		// attempt to add existing pod annotation but do not override
-		for k, v := range pod.ObjectMeta.Annotations {
-			if _, exists := annotations[k]; !exists {
-				annotations[k] = v
+	/* hugepages exposed via Downward API are those of the container targeted by the ephemeral container */
+	if wh.controlSwitches.IsHugePagedownAPIEnabled() && !isWindowsPod(&pod) {
+		_, hugepageResourceList := processHugepagesForDownwardAPI(nil, pod.Spec.Containers, containersPath, nil)
+		for i, ephemeralContainer := range pod.Spec.EphemeralContainers {
+			for _, hugepageResource := range hugepageResourceList {
+				if hugepageResource.ContainerName == ephemeralContainer.TargetContainerName {
+					patch = createEnvPatch(patch, &containers[i], containerPath(ephemeralContainersPath, i),
+						types.EnvNameContainerName, ephemeralContainer.TargetContainerName)
+					break
+				}
 			}
 		}
-		patch = append(patch, patchOp)
 	}
-
-	return patch
+	return patch, true
 }
 
-func appendUserDefinedPatch(patch []types.JsonPatchOperation, pod corev1.Pod, userDefinedPatch []types.JsonPatchOperation) []types.JsonPatchOperation {
-	//Add operation for annotations is currently only supported
-	return appendAddAnnotPatch(patch, pod, userDefinedPatch)
-}
+// mutateEphemeralContainers handles update of pod ephemeral containers, pod resources are never patched
+func (wh *Webhook) mutateEphemeralContainers(w http.ResponseWriter, ar *admissionv1.AdmissionReview, pod corev1.Pod, l logging.Logger) {
+	patch, ok := wh.createEphemeralContainersPatch(pod)
+	if !ok {
+		wh.allowWithoutInjection(w, ar, l, skipNoDownwardAPIVolume)
+		return
+	}
 
-func getNetworkSelections(annotationKey string, pod corev1.Pod, userDefinedPatch []types.JsonPatchOperation) (string, bool) {
-	// User defined annotateKey takes precedence than userDefined injections
-	glog.Infof("search %s in original pod annotations", annotationKey)
-	nets, exists := pod.ObjectMeta.Annotations[annotationKey]
-	if exists {
-		glog.Infof("%s is defined in original pod annotations", annotationKey)
-		return nets, exists
+	if err := prepareAdmissionReviewResponse(true, "allowed", ar); err != nil {
+		l.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
+			pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	if len(patch) > 0 {
+		l.Infof("patch of ephemeral containers: %v for pod %s/%s", patch, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		if err := wh.setResponsePatch(ar, patch, l); err != nil {
+			wh.respondWithError(w, ar, pod, l, err)
+			return
+		}
+	}
+	writeResponse(w, ar)
+}
 
-	glog.Infof("search %s in user-defined injections", annotationKey)
-	// userDefinedPatch may contain user defined net-attach-defs
-	if len(userDefinedPatch) > 0 {
-		for _, p := range userDefinedPatch {
-			if p.Operation == "add" && p.Path == "/metadata/annotations" {
-				for k, v := range p.Value.(map[string]interface{}) {
-					if k == annotationKey {
-						glog.Infof("%s is found in user-defined annotations", annotationKey)
-						return v.(string), true
-					}
-				}
-			}
+// denialEventReference returns reference of the object the denial event is attached to: the mutated workload
+// controller, the controller owning the pod or the pod itself. Pod does not exist yet when it is denied, so the
+// event of pod without controller refers to the pod by name only.
+func denialEventReference(ar *admissionv1.AdmissionReview, pod corev1.Pod) *corev1.ObjectReference {
+	if isWorkloadController(ar) {
+		return &corev1.ObjectReference{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       ar.Request.Kind.Kind,
+			Name:       ar.Request.Name,
+			Namespace:  pod.ObjectMeta.Namespace,
 		}
 	}
-	glog.Infof("%s is not found in either pod annotations or user-defined injections", annotationKey)
-	return "", false
+	if owner := metav1.GetControllerOf(&pod); owner != nil {
+		return &corev1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			UID:        owner.UID,
+			Namespace:  pod.ObjectMeta.Namespace,
+		}
+	}
+	name := pod.ObjectMeta.Name
+	if name == "" {
+		name = pod.ObjectMeta.GenerateName
+	}
+	return &corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Name: name, Namespace: pod.ObjectMeta.Namespace}
+}
+
+// recordDenialEvent emits warning event describing why injection was denied, so it is visible to app developers
+// without access to the webhook logs
+func (wh *Webhook) recordDenialEvent(ar *admissionv1.AdmissionReview, pod corev1.Pod, reason error) {
+	if wh.eventRecorder == nil || !wh.controlSwitches.IsDenialEventsEnabled() {
+		return
+	}
+	wh.eventRecorder.Eventf(denialEventReference(ar, pod), corev1.EventTypeWarning, injectionDeniedReason,
+		"network resources injection denied: %v", reason)
 }
 
 // MutateHandler handles AdmissionReview requests and sends responses back to the K8s API server
 func MutateHandler(w http.ResponseWriter, req *http.Request) {
-	glog.Infof("Received mutation request. Features status: %s", controlSwitches.GetAllFeaturesState())
+	defaultWebhook.MutateHandler(w, req)
+}
+
+// MutateHandler handles AdmissionReview requests and mutates pods using the dependencies of the webhook
+func (wh *Webhook) MutateHandler(w http.ResponseWriter, req *http.Request) {
+	logger.Infof("Received mutation request. Features status: %s", wh.controlSwitches.GetAllFeaturesState())
+	start := time.Now()
 	var err error
 
+	/* trace context sent by the API server is continued, lookups below are traced as children of the request */
+	spanCtx, span := startAdmissionSpan(req.Context(), req.Header)
+	defer span.End()
+	req = req.WithContext(spanCtx)
+
+	/* API server lookups have to complete before the webhook call times out, they are cancelled with the request */
+	ctx, cancel := context.WithTimeout(req.Context(), wh.apiLookupTimeout(req))
+	defer cancel()
+	ctx, latency := withLookupLatency(ctx)
+
 	/* read AdmissionReview from the HTTP request */
-	ar, httpStatus, err := readAdmissionReview(req, w)
+	ar, httpStatus, err := wh.readAdmissionReview(req, w)
 	if err != nil {
+		endSpan(span, err)
 		http.Error(w, err.Error(), httpStatus)
 		return
 	}
+	ctx = withLookupSource(ctx, ar.Request)
+	if ar.Request != nil {
+		span.SetAttributes(attribute.String("admission.uid", string(ar.Request.UID)),
+			attribute.String("admission.kind", ar.Request.Kind.Kind),
+			attribute.String("admission.operation", string(ar.Request.Operation)))
+	}
+
+	/* requests above the concurrency limit are denied as retryable before any API server lookup is made */
+	limit, queueTimeout := wh.controlSwitches.GetMaxConcurrentAdmissions(), wh.controlSwitches.GetAdmissionQueueTimeout()
+	if !wh.admissions.acquire(ctx, limit, queueTimeout) {
+		logger.Warningf("admission request is denied, %d admission requests are mutated at once", limit)
+		if err := prepareTooManyRequestsResponse(ar, limit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeResponse(w, ar)
+		return
+	}
+	defer wh.admissions.release()
+	defer func() {
+		if ar.Response != nil {
+			span.SetAttributes(attribute.Bool("admission.allowed", ar.Response.Allowed))
+		}
+	}()
 
 	/* read pod annotations */
 	/* if networks missing skip everything */
-	pod, err := deserializePod(ar)
+	var pod corev1.Pod
+	patchPrefix := ""
+	if isEphemeralContainersUpdate(ar) && !wh.controlSwitches.IsInjectIntoEphemeralContainersEnabled() {
+		wh.allowWithoutInjection(w, ar, logger, skipEphemeralContainersDisabled)
+		return
+	}
+	if isWorkloadController(ar) {
+		if !wh.controlSwitches.IsWorkloadControllersEnabled() {
+			wh.allowWithoutInjection(w, ar, logger.WithFields(logging.Fields{"kind": ar.Request.Kind.Kind}), skipWorkloadControllersDisabled)
+			return
+		}
+		/* patches are computed for the pod template and moved under its path */
+		pod, err = wh.deserializePodTemplate(ar)
+		patchPrefix = podTemplatePath
+	} else {
+		pod, err = wh.deserializePod(ctx, ar)
+	}
 	if err != nil {
+		if _, ok := err.(namespaceError); ok {
+			wh.respondWithError(w, ar, pod, logger, err)
+			return
+		}
 		handleValidationError(w, ar, err)
 		return
 	}
-	glog.Infof("AdmissionReview request received for pod %s/%s", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	if pod.ObjectMeta.Namespace == "" {
+		wh.allowWithoutInjection(w, ar, logger.WithFields(logging.Fields{"pod": pod.ObjectMeta.Name}), skipNamespaceUnresolved)
+		return
+	}
+	podLogger := logger.WithFields(logging.Fields{"pod": pod.ObjectMeta.Name, "namespace": pod.ObjectMeta.Namespace})
+	podLogger.Infof("AdmissionReview request received for pod %s/%s", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	defer logAdmissionResult(podLogger, ar, start)
+	defer latency.warnIfSlow(podLogger, webhookTimeout(req), wh.controlSwitches.GetLookupWarningFraction())
+
+	if wh.controlSwitches.IsNamespaceIgnored(pod.ObjectMeta.Namespace) {
+		wh.allowWithoutInjection(w, ar, podLogger, skipNamespaceIgnored)
+		return
+	}
+
+	/* pod could opt out of injection, or has to opt in when required, this takes precedence over its network annotations */
+	if reason, skip := wh.getSkipReason(pod); skip {
+		wh.allowWithoutInjection(w, ar, podLogger, reason)
+		return
+	}
+
+	if isEphemeralContainersUpdate(ar) {
+		wh.mutateEphemeralContainers(w, ar, pod, podLogger)
+		return
+	}
+
+	/* webhook reinvoked for already injected pod must not inject the resources again */
+	if wh.controlSwitches.IsIdempotencyEnabled() && pod.ObjectMeta.Annotations[wh.annotationKey(injectorStatusKey)] == injectorStatusInjected {
+		wh.allowWithoutInjection(w, ar, podLogger, skipAlreadyInjected)
+		return
+	}
+
+	/* pod is validated by API server only after admission webhooks, so it could have no container to patch */
+	if len(pod.Spec.Containers) == 0 {
+		if wh.controlSwitches.GetNoContainersAction() == controlswitches.NoContainersDeny {
+			wh.respondWithError(w, ar, pod, podLogger, errors.New("pod has no containers"))
+			return
+		}
+		wh.allowWithoutInjection(w, ar, podLogger, skipNoContainers)
+		return
+	}
 
-	userDefinedPatch, err := userDefinedInjections.CreateUserDefinedPatch(pod)
+	userDefinedPatch, err := wh.userDefinedInjections.CreateUserDefinedPatch(pod)
 	if err != nil {
-		glog.Warningf("failed to create user-defined injection patch for pod %s/%s, err: %v",
+		/* user-defined injections could be mandatory, e.g. a required security annotation */
+		if wh.controlSwitches.IsDenyUserDefinedInjectionFailureEnabled() {
+			wh.respondWithError(w, ar, pod, podLogger, errors.Wrap(err, "could not create user-defined injection patch"))
+			return
+		}
+		podLogger.Warningf("failed to create user-defined injection patch for pod %s/%s, err: %v",
 			pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
 	}
 
 	defaultNetSelection, defExist := getNetworkSelections(defaultNetworkAnnotationKey, pod, userDefinedPatch)
 	additionalNetSelections, addExists := getNetworkSelections(networksAnnotationKey, pod, userDefinedPatch)
+	/* network annotation could be set only on the controller owning the pod, though pod template is the preferred place */
+	if !addExists && wh.controlSwitches.IsOwnerNetworkAnnotationEnabled() {
+		var ownerNetSelections string
+		ownerNetSelections, addExists, err = wh.getOwnerNetworkSelections(ctx, pod)
+		if err != nil {
+			wh.respondWithError(w, ar, pod, podLogger, err)
+			return
+		}
+		if addExists {
+			additionalNetSelections = ownerNetSelections
+			userDefinedPatch = append(userDefinedPatch, networksAnnotationPatch(ownerNetSelections))
+		}
+	}
+	alternateNetSelections := wh.getAlternateNetworkSelections(pod, userDefinedPatch)
+	/* networks selected by annotations always take precedence over networks implied by pod labels */
+	if !addExists && len(alternateNetSelections) == 0 {
+		if labelNetSelections := wh.getLabelSelectorNetworkSelections(pod); labelNetSelections != "" {
+			additionalNetSelections, addExists = labelNetSelections, true
+			userDefinedPatch = append(userDefinedPatch, networksAnnotationPatch(labelNetSelections))
+		}
+	}
+	/* networks of resource claims are merged the same way as those of additional network annotations */
+	alternateNetSelections = append(alternateNetSelections, wh.getResourceClaimNetworkSelections(pod)...)
+
+	if defExist || addExists || len(alternateNetSelections) > 0 {
+		_, namespaceSpan := tracer().Start(ctx, namespaceLookupSpanName, trace.WithAttributes(attribute.String("k8s.namespace.name", pod.ObjectMeta.Namespace)))
+		injectionEnabled, err := wh.isInjectionEnabledForNamespace(ctx, pod.ObjectMeta.Namespace)
+		endSpan(namespaceSpan, err)
+		if err != nil {
+			wh.respondWithError(w, ar, pod, podLogger, err)
+			return
+		}
+		if !injectionEnabled {
+			wh.allowWithoutInjection(w, ar, podLogger, skipNamespaceNotEnabled)
+			return
+		}
+
+		/* resources, node selection constraints and other requirements of the pod networks */
+		netResources := newNetworkResources()
+
+		/* net-attach-defs which contributed resources, in order of their selection */
+		var sourceNads []string
 
-	if defExist || addExists {
-		/* map of resources request needed by a pod and a number of them */
-		resourceRequests := make(map[string]int64)
+		/* number of resource sets requested by the selected networks */
+		replicas := networkReplicas{}
 
-		/* map of node labels on which pod needs to be scheduled*/
-		desiredNsMap := make(map[string]string)
+		/* errors of networks skipped by best effort injection, under the net-attach-def 'namespace/name' key */
+		injectionErrors := make(map[string]string)
+
+		/* admission warnings displayed to the user when the pod is admitted */
+		var warnings []string
 
 		if defaultNetSelection != "" {
 			defNetwork, err := parsePodNetworkSelections(defaultNetSelection, pod.ObjectMeta.Namespace)
+			if err == nil {
+				err = parseNetworkReplicas(defaultNetSelection, defNetwork, replicas)
+			}
+			if err != nil {
+				wh.respondWithError(w, ar, pod, podLogger, err)
+				return
+			}
+			/* Multus attaches only one default network, pod is denied unless the other networks are ignored explicitly */
+			if len(defNetwork) > 1 && wh.controlSwitches.GetDefaultNetworksAction() == controlswitches.DefaultNetworksFirst {
+				warning := fmt.Sprintf("annotation %s selects %d networks, resources are injected for the first network %s/%s only",
+					defaultNetworkAnnotationKey, len(defNetwork), defNetwork[0].Namespace, defNetwork[0].Name)
+				podLogger.Warningf("%s", warning)
+				warnings = append(warnings, "network-resources-injector: "+warning)
+				defNetwork = defNetwork[:1]
+			}
+			/* empty selection attaches no default network through the annotation, so there is nothing to inject */
+			if len(defNetwork) > 1 {
+				err = errors.Errorf("annotation %s must select exactly one network, %d selected", defaultNetworkAnnotationKey, len(defNetwork))
+				podLogger.Errorf("%v", err)
+			} else if len(defNetwork) == 1 {
+				countBefore := countResourceRequests(netResources.reqs)
+				err = wh.parseNetworkAttachDefinition(ctx, defNetwork[0], replicas.get(defNetwork[0]), netResources)
+				sourceNads = appendSourceNad(sourceNads, defNetwork[0], countBefore, netResources.reqs)
+			}
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				wh.respondWithError(w, ar, pod, podLogger, err)
 				return
 			}
-			if len(defNetwork) == 1 {
-				resourceRequests, desiredNsMap, err = parseNetworkAttachDefinition(defNetwork[0], resourceRequests, desiredNsMap)
+		}
+		if additionalNetSelections != "" || len(alternateNetSelections) > 0 {
+			var networks []*multus.NetworkSelectionElement
+			if additionalNetSelections != "" {
+				/* unmarshal list of network selection objects */
+				networks, err = parsePodNetworkSelections(additionalNetSelections, pod.ObjectMeta.Namespace)
+				if err == nil {
+					err = parseNetworkReplicas(additionalNetSelections, networks, replicas)
+				}
 				if err != nil {
-					err = prepareAdmissionReviewResponse(false, err.Error(), ar)
-					if err != nil {
-						glog.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
-							pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
-						http.Error(w, err.Error(), http.StatusBadRequest)
-						return
-					}
-					writeResponse(w, ar)
+					wh.respondWithError(w, ar, pod, podLogger, err)
 					return
 				}
 			}
-		}
-		if additionalNetSelections != "" {
-			/* unmarshal list of network selection objects */
-			networks, err := parsePodNetworkSelections(additionalNetSelections, pod.ObjectMeta.Namespace)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+			for _, selections := range alternateNetSelections {
+				alternate, err := parsePodNetworkSelections(selections, pod.ObjectMeta.Namespace)
+				if err == nil {
+					err = parseNetworkReplicas(selections, alternate, replicas)
+				}
+				if err != nil {
+					wh.respondWithError(w, ar, pod, podLogger, err)
+					return
+				}
+				networks = mergeNetworkSelections(networks, alternate)
+			}
+			if wh.controlSwitches.IsNetworkDeduplicationEnabled() {
+				networks = dedupNetworkSelections(networks)
 			}
 			for _, n := range networks {
-				resourceRequests, desiredNsMap, err = parseNetworkAttachDefinition(n, resourceRequests, desiredNsMap)
+				countBefore := countResourceRequests(netResources.reqs)
+				err = wh.parseNetworkAttachDefinition(ctx, n, replicas.get(n), netResources)
+				sourceNads = appendSourceNad(sourceNads, n, countBefore, netResources.reqs)
+				var unresolved unresolvedNetworkError
+				if err != nil && wh.controlSwitches.IsBestEffortInjectionEnabled() && errors.As(err, &unresolved) {
+					podLogger.Warningf("skipping network '%s/%s' of pod %s/%s: %v", n.Namespace, n.Name,
+						pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
+					injectionErrors[n.Namespace+"/"+n.Name] = err.Error()
+					continue
+				}
 				if err != nil {
-					err = prepareAdmissionReviewResponse(false, err.Error(), ar)
-					if err != nil {
-						glog.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
-							pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
-						http.Error(w, err.Error(), http.StatusBadRequest)
-						return
-					}
-					writeResponse(w, ar)
+					wh.respondWithError(w, ar, pod, podLogger, err)
 					return
 				}
 			}
-			glog.Infof("pod %s/%s has resource requests: %v and node selectors: %v", pod.ObjectMeta.Namespace,
-				pod.ObjectMeta.Name, resourceRequests, desiredNsMap)
+			podLogger.Infof("pod %s/%s has resource requests: %v and node selectors: %v", pod.ObjectMeta.Namespace,
+				pod.ObjectMeta.Name, netResources.reqs, netResources.nsMap)
+		}
+
+		/* resource names defined by net-attach-defs could be overridden by the pod for testing purposes */
+		if wh.controlSwitches.IsResourceNameOverrideEnabled() {
+			netResources.reqs, err = applyResourceNameOverride(pod, netResources.reqs)
+			if err != nil {
+				wh.respondWithError(w, ar, pod, podLogger, err)
+				return
+			}
+		}
+
+		netResources.reqs, err = wh.capResourceRequests(netResources.reqs)
+		if err != nil {
+			wh.respondWithError(w, ar, pod, podLogger, err)
+			return
+		}
+
+		/* injection into pods of some runtime classes differs, e.g. because of device passthrough into a VM */
+		runtimeClassOverride := wh.runtimeClassOverride(&pod)
+		injectDownwardAPIVolume := wh.controlSwitches.IsInjectDownwardAPIVolumeEnabled() && !runtimeClassOverride.SkipDownwardAPIVolume
+
+		/* Downward API volume is injected only along with resources, unless it is disabled */
+		volumeName := ""
+		if len(netResources.reqs) > 0 && injectDownwardAPIVolume {
+			volumeName, err = wh.getDownwardAPIVolumeName(&pod)
+			if err != nil {
+				wh.respondWithError(w, ar, pod, podLogger, err)
+				return
+			}
+		}
+
+		/* resources stripped by the pod annotation are removed first, so the injection does not see them as defined by the pod */
+		var removalPatch []types.JsonPatchOperation
+		if wh.controlSwitches.IsRemoveResourcesEnabled() {
+			removalPatch, err = removeAnnotatedResources(&pod)
+			if err != nil {
+				wh.respondWithError(w, ar, pod, podLogger, err)
+				return
+			}
 		}
 
 		/* patch with custom resources requests and limits */
 		err = prepareAdmissionReviewResponse(true, "allowed", ar)
 		if err != nil {
-			glog.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
+			podLogger.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
 				pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		var patch []types.JsonPatchOperation
-		if len(resourceRequests) == 0 {
-			glog.Infof("pod %s/%s doesn't need any custom network resources", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		ar.Response.Warnings = append(ar.Response.Warnings, warnings...)
+		_, patchSpan := tracer().Start(ctx, patchSpanName)
+		patch := removalPatch
+		if len(netResources.reqs) == 0 {
+			/* pod is still patched with node selectors required by its networks */
+			wh.logSkipReason(podLogger, skipNoNetworkResources)
+			wh.addSkipReasonWarning(ar, skipNoNetworkResources)
+			if len(injectionErrors) > 0 {
+				patch = appendAddAnnotPatch(patch, pod, []types.JsonPatchOperation{wh.injectionErrorsAnnotation(injectionErrors)})
+			}
 		} else {
-			if controlSwitches.IsHonorExistingResourcesEnabled() {
-				patch = updateResourcePatch(patch, pod.Spec.Containers, resourceRequests)
+			/* record requested resources before app containers dedup modifies them, as the first
+			annotations patch the annotation takes precedence over user defined one with the same key */
+			annotationsPatch := userDefinedPatch
+			if wh.controlSwitches.IsInjectedResourcesAnnotationEnabled() {
+				if annotation, err := wh.injectedResourcesAnnotation(netResources.reqs); err == nil {
+					annotationsPatch = append([]types.JsonPatchOperation{annotation}, userDefinedPatch...)
+				} else {
+					podLogger.Warningf("failed to create injected resources annotation: %v", err)
+				}
+			}
+			/* appended after user defined annotations, so user defined annotation with the same key is kept */
+			if wh.controlSwitches.IsSourceNadsAnnotationEnabled() && len(sourceNads) > 0 {
+				annotationsPatch = append(annotationsPatch, wh.sourceNadsAnnotation(sourceNads))
+			}
+			if len(injectionErrors) > 0 {
+				annotationsPatch = append(annotationsPatch, wh.injectionErrorsAnnotation(injectionErrors))
+			}
+			if wh.controlSwitches.IsIdempotencyEnabled() {
+				annotationsPatch = append([]types.JsonPatchOperation{wh.injectorStatusAnnotation()}, annotationsPatch...)
+			}
+			if wh.controlSwitches.IsTopologyHintsEnabled() {
+				if annotation, ok := wh.topologyHintAnnotation(netResources.topologyAware); ok {
+					annotationsPatch = append([]types.JsonPatchOperation{annotation}, annotationsPatch...)
+				} else {
+					podLogger.Infof("networks of pod %s/%s are not all topology aware: %v, topology hint is not injected",
+						pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, netResources.topologyAware)
+				}
+			}
+
+			/* resources for init containers are computed before app containers dedup modifies the requested resources */
+			if wh.controlSwitches.IsInjectIntoInitContainersEnabled() {
+				patch = wh.createInitContainersResourcePatch(patch, pod.Spec.InitContainers, netResources.reqs)
+			}
+			if runtimeClassOverride.TargetContainers != "" {
+				addDefaultTarget(netResources.reqs, netResources.targetedReqs, runtimeClassOverride.TargetContainers)
+			}
+			/* resources left untargeted by the runtime class go to the container running the target image */
+			if container, found := wh.imageTargetContainer(pod.Spec.Containers); found {
+				podLogger.Infof("container %s of pod %s/%s runs target image, injecting resources of networks not targeting containers into it",
+					container, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+				addDefaultTarget(netResources.reqs, netResources.targetedReqs, regexp.QuoteMeta(container))
+			}
+			var assignedRequests map[int]map[string]int64
+			netResources.reqs, assignedRequests = assignTargetedResources(pod.Spec.Containers, netResources.reqs, netResources.targetedReqs)
+			patch = wh.createTargetedResourcePatch(patch, pod.Spec.Containers, assignedRequests)
+			/* pod sizing its containers with pod-level resources gets the resources at pod level instead of the first container */
+			var podResources *corev1.ResourceRequirements
+			if wh.controlSwitches.IsPodLevelResourcesEnabled() && len(netResources.reqs) > 0 {
+				podResources, err = podLevelResources(ar)
+				if err != nil {
+					endSpan(patchSpan, err)
+					wh.respondWithError(w, ar, pod, podLogger, err)
+					return
+				}
+			}
+			if len(netResources.reqs) == 0 {
+				podLogger.Infof("all resources of pod %s/%s are injected into targeted containers",
+					pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+			} else if podResources != nil {
+				patch = wh.createPodResourcePatch(patch, pod.Spec.Containers, *podResources, netResources.reqs)
+			} else if wh.controlSwitches.IsInjectIntoAllContainersEnabled() {
+				patch, err = wh.createAllContainersResourcePatch(patch, pod.Spec.Containers, netResources.reqs)
+				if err != nil {
+					endSpan(patchSpan, err)
+					wh.respondWithError(w, ar, pod, podLogger, err)
+					return
+				}
+			} else if wh.controlSwitches.IsCreateResourcesIfAbsentEnabled() {
+				patch, err = wh.createIfAbsentResourcePatch(patch, pod.Spec.Containers, netResources.reqs)
+				if err != nil {
+					endSpan(patchSpan, err)
+					wh.respondWithError(w, ar, pod, podLogger, err)
+					return
+				}
+			} else if wh.controlSwitches.IsHonorExistingResourcesEnabled() {
+				patch = wh.updateResourcePatch(patch, pod.Spec.Containers, netResources.reqs)
 			} else {
-				patch = createResourcePatch(patch, pod.Spec.Containers, resourceRequests)
+				patch, err = wh.createResourcePatch(patch, pod.Spec.Containers, netResources.reqs)
+				if err != nil {
+					endSpan(patchSpan, err)
+					wh.respondWithError(w, ar, pod, podLogger, err)
+					return
+				}
 			}
 
 			// Determine if hugepages are being requested for a given container,
 			// and if so, expose the value to the container via Downward API.
+			// Hugepages are exposed in files of the Downward API volume, so they are not exposed without it.
+			/* Windows nodes do not provide hugepages */
+			windowsPod := isWindowsPod(&pod)
 			var hugepageResourceList []hugepageResourceData
-			if controlSwitches.IsHugePagedownAPIEnabled() {
-				for containerIndex, container := range pod.Spec.Containers {
-					found := false
-					if len(container.Resources.Requests) != 0 {
-						if quantity, exists := container.Resources.Requests["hugepages-1Gi"]; exists && quantity.IsZero() == false {
-							hugepageResource := hugepageResourceData{
-								ResourceName:  "requests.hugepages-1Gi",
-								ContainerName: container.Name,
-								Path:          types.Hugepages1GRequestPath + "_" + container.Name,
-							}
-							hugepageResourceList = append(hugepageResourceList, hugepageResource)
-							found = true
-						}
-						if quantity, exists := container.Resources.Requests["hugepages-2Mi"]; exists && quantity.IsZero() == false {
-							hugepageResource := hugepageResourceData{
-								ResourceName:  "requests.hugepages-2Mi",
-								ContainerName: container.Name,
-								Path:          types.Hugepages2MRequestPath + "_" + container.Name,
-							}
-							hugepageResourceList = append(hugepageResourceList, hugepageResource)
-							found = true
-						}
-					}
-					if len(container.Resources.Limits) != 0 {
-						if quantity, exists := container.Resources.Limits["hugepages-1Gi"]; exists && quantity.IsZero() == false {
-							hugepageResource := hugepageResourceData{
-								ResourceName:  "limits.hugepages-1Gi",
-								ContainerName: container.Name,
-								Path:          types.Hugepages1GLimitPath + "_" + container.Name,
-							}
-							hugepageResourceList = append(hugepageResourceList, hugepageResource)
-							found = true
-						}
-						if quantity, exists := container.Resources.Limits["hugepages-2Mi"]; exists && quantity.IsZero() == false {
-							hugepageResource := hugepageResourceData{
-								ResourceName:  "limits.hugepages-2Mi",
-								ContainerName: container.Name,
-								Path:          types.Hugepages2MLimitPath + "_" + container.Name,
-							}
-							hugepageResourceList = append(hugepageResourceList, hugepageResource)
-							found = true
-						}
-					}
-
-					// If Hugepages are being added to Downward API, add the
-					// 'container.Name' as an environment variable to the container
-					// so container knows its name and can process hugepages properly.
-					if found {
-						patch = createEnvPatch(patch, &container, containerIndex,
-							types.EnvNameContainerName, container.Name)
-					}
+			if windowsPod {
+				podLogger.Infof("pod %s/%s is scheduled to Windows nodes, skipping hugepages processing",
+					pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+			} else if wh.controlSwitches.IsHugePagedownAPIEnabled() && !injectDownwardAPIVolume {
+				podLogger.Infof("Downward API volume injection is disabled, hugepages of pod %s/%s are not exposed via Downward API",
+					pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+			} else if wh.controlSwitches.IsHugePagedownAPIEnabled() {
+				patch, hugepageResourceList = processHugepagesForDownwardAPI(patch, pod.Spec.Containers, containersPath, hugepageResourceList)
+				if wh.controlSwitches.IsInjectIntoInitContainersEnabled() {
+					patch, hugepageResourceList = processHugepagesForDownwardAPI(patch, pod.Spec.InitContainers, initContainersPath, hugepageResourceList)
+				}
+			}
+			/* containers without injected resources do not need pod network information */
+			var mountContainers map[int]bool
+			allContainers := wh.controlSwitches.IsInjectIntoAllContainersEnabled() && len(netResources.reqs) > 0 && podResources == nil
+			if wh.controlSwitches.IsDownwardAPIMountResourceContainersOnlyEnabled() && !allContainers {
+				mountContainers = resourceContainers(netResources.reqs, assignedRequests)
+			}
+			if volumeName != "" {
+				patch = wh.createVolPatch(patch, hugepageResourceList, &pod, volumeName, mountContainers)
+			}
+			if len(netResources.tokenAudiences) > 0 {
+				patch, err = wh.createTokenVolumePatch(patch, &pod, netResources.tokenAudiences, mountContainers)
+				if err != nil {
+					endSpan(patchSpan, err)
+					wh.respondWithError(w, ar, pod, podLogger, err)
+					return
+				}
+			}
+			if wh.controlSwitches.IsInjectHugepageVolumeEnabled() && !windowsPod {
+				patch = wh.createHugepageVolumePatch(patch, &pod, pod.Spec.Containers, containersPath, hugepageVolumePrefix)
+				if wh.controlSwitches.IsInjectIntoInitContainersEnabled() {
+					patch = wh.createHugepageVolumePatch(patch, &pod, pod.Spec.InitContainers, initContainersPath, hugepageVolumePrefix+"-init")
 				}
 			}
-			patch = createVolPatch(patch, hugepageResourceList, &pod)
-			patch = appendUserDefinedPatch(patch, pod, userDefinedPatch)
+			patch = appendUserDefinedPatch(patch, pod, annotationsPatch)
+			if finalizer := wh.controlSwitches.GetInjectionFinalizer(); finalizer != "" {
+				patch = createFinalizerPatch(patch, pod.ObjectMeta.Finalizers, finalizer)
+			}
+			/* nodes providing the resources are often tainted */
+			patch = createTolerationsPatch(patch, pod.Spec.Tolerations, wh.controlSwitches.GetTolerations())
 		}
-		patch = createNodeSelectorPatch(patch, pod.Spec.NodeSelector, desiredNsMap)
-		glog.Infof("patch after all mutations: %v for pod %s/%s", patch, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		/* CPU and memory of networks not targeting containers go where their device resources would go by default */
+		defaultTarget := runtimeClassOverride.TargetContainers
+		if container, found := wh.imageTargetContainer(pod.Spec.Containers); defaultTarget == "" && found {
+			defaultTarget = regexp.QuoteMeta(container)
+		}
+		var computeAnnotationPatch []types.JsonPatchOperation
+		patch, computeAnnotationPatch = wh.createComputeResourcePatch(patch, &pod,
+			assignComputeResources(pod.Spec.Containers, netResources.computeReqs, defaultTarget))
+		patch = mergeAnnotationsPatch(patch, pod, computeAnnotationPatch)
+		patch, err = wh.createNodeSelectorPatch(patch, pod.Spec.NodeSelector, netResources.nsMap)
+		if err != nil {
+			endSpan(patchSpan, err)
+			wh.respondWithError(w, ar, pod, podLogger, err)
+			return
+		}
+		if err := wh.checkNodeSelectorRequirements(pod.Spec.NodeSelector, netResources.nodeAffinity); err != nil {
+			endSpan(patchSpan, err)
+			wh.respondWithError(w, ar, pod, podLogger, err)
+			return
+		}
+		patch = createNodeAffinityPatch(patch, pod.Spec.Affinity, netResources.nodeAffinity, netResources.injectedAffinity)
+		podLogger.Infof("patch after all mutations: %v for pod %s/%s", patch, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
 
-		patchBytes, _ := json.Marshal(patch)
-		ar.Response.Patch = patchBytes
-		ar.Response.PatchType = func() *admissionv1.PatchType {
-			pt := admissionv1.PatchTypeJSONPatch
-			return &pt
-		}()
-	} else {
-		/* network annotation not provided or empty */
-		glog.Infof("pod %s/%s spec doesn't have network annotations. Skipping...", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
-		err = prepareAdmissionReviewResponse(true, "Pod spec doesn't have network annotations. Skipping...", ar)
+		patch = prefixPatchPaths(patch, patchPrefix)
+
+		err = wh.setResponsePatch(ar, patch, podLogger)
+		patchSpan.SetAttributes(attribute.Int("patch.operations", len(patch)))
+		endSpan(patchSpan, err)
 		if err != nil {
-			glog.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
-				pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			wh.respondWithError(w, ar, pod, podLogger, err)
 			return
 		}
+	} else {
+		/* network annotation not provided or empty */
+		wh.allowWithoutInjection(w, ar, podLogger, skipNoNetworkAnnotations)
+		return
 	}
 
 	writeResponse(w, ar)
@@ -943,19 +4211,72 @@ func MutateHandler(w http.ResponseWriter, req *http.Request) {
 
 // SetNetAttachDefCache sets up the net attach def cache service
 func SetNetAttachDefCache(cache netcache.NetAttachDefCacheService) {
-	nadCache = cache
+	defaultWebhook.SetNetAttachDefCache(cache)
+}
+
+// SetOwnerCache sets up the pod owner cache service
+func SetOwnerCache(cache netcache.OwnerCacheService) {
+	defaultWebhook.SetOwnerCache(cache)
+}
+
+// SetNamespaceCache sets up the namespace cache service
+func SetNamespaceCache(cache netcache.NamespaceCacheService) {
+	defaultWebhook.SetNamespaceCache(cache)
+}
+
+const (
+	// DefaultClientQPS - queries per second the API client is allowed to send on average
+	DefaultClientQPS = 50
+	// DefaultClientBurst - queries the API client is allowed to send at once above its QPS
+	DefaultClientBurst = 100
+)
+
+// protobufContentTypes are content types accepted by the API client when protobuf is enabled, custom resources
+// like net-attach-defs are not served as protobuf, so JSON is accepted as well
+const protobufContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
+
+// ClientOptions tune the API client for the load of the admission webhook
+type ClientOptions struct {
+	QPS      float32
+	Burst    int
+	Protobuf bool
+}
+
+// Validate returns error when the client options can't be applied
+func (o ClientOptions) Validate() error {
+	if o.QPS <= 0 {
+		return fmt.Errorf("API client QPS must be positive, got %v", o.QPS)
+	}
+	if o.Burst < 1 {
+		return fmt.Errorf("API client burst must be at least 1, got %d", o.Burst)
+	}
+	if float32(o.Burst) < o.QPS {
+		return fmt.Errorf("API client burst %d must not be lower than its QPS %v", o.Burst, o.QPS)
+	}
+	return nil
+}
+
+// apply sets the client options to the rest config
+func (o ClientOptions) apply(config *rest.Config) {
+	config.QPS = o.QPS
+	config.Burst = o.Burst
+	if o.Protobuf {
+		config.ContentType = runtime.ContentTypeProtobuf
+		config.AcceptContentTypes = protobufContentTypes
+	}
 }
 
 // SetupInClusterClient setups K8s client to communicate with the API server
-func SetupInClusterClient() kubernetes.Interface {
+func SetupInClusterClient(options ClientOptions) kubernetes.Interface {
 	/* setup Kubernetes API client */
 	config, err := rest.InClusterConfig()
 	if err != nil {
-		glog.Fatal(err)
+		logger.Fatalf("%v", err)
 	}
-	clientset, err = kubernetes.NewForConfig(config)
+	options.apply(config)
+	defaultWebhook.clientset, err = kubernetes.NewForConfig(config)
 	if err != nil {
-		glog.Fatal(err)
+		logger.Fatalf("%v", err)
 	}
-	return clientset
+	return defaultWebhook.clientset
 }
@@ -0,0 +1,92 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"testing"
+)
+
+var networkSelectionSeeds = []string{
+	"",
+	"net1",
+	"ns1/net1@eth0",
+	"ns1/net1,net2",
+	"a,,b",
+	"ns1/",
+	"/net1",
+	"net1@",
+	"@eth0",
+	"ns1/net1/if1",
+	"net1@if1@if2",
+	`[{"name": "net1"},{"name": "net2", "namespace": "ns1"}]`,
+	`[{"name": "net1", "interface": "eth0"}]`,
+	`[null]`,
+	`[{}]`,
+	`null`,
+	`[]`,
+	`{"name": "net1"}`,
+	`[{"name": "net1"}, 5]`,
+}
+
+// FuzzParsePodNetworkSelections checks that network selection annotation either parses into complete elements or
+// fails without returning any element
+func FuzzParsePodNetworkSelections(f *testing.F) {
+	for _, seed := range networkSelectionSeeds {
+		f.Add(seed, "default")
+	}
+	f.Fuzz(func(t *testing.T, podNetworks, defaultNamespace string) {
+		networks, err := parsePodNetworkSelections(podNetworks, defaultNamespace)
+		if err != nil {
+			if networks != nil {
+				t.Fatalf("'%s' failed with error %v, but returned networks %v", podNetworks, err, networks)
+			}
+			return
+		}
+		for i, network := range networks {
+			if network == nil {
+				t.Fatalf("'%s' returned null network %d", podNetworks, i)
+			}
+			if network.Name == "" {
+				t.Fatalf("'%s' returned network %d without name", podNetworks, i)
+			}
+			if network.Namespace == "" {
+				t.Fatalf("'%s' returned network %d without namespace", podNetworks, i)
+			}
+		}
+	})
+}
+
+// FuzzParsePodNetworkSelectionElement checks that comma separated network selection element either parses into
+// element with name and namespace or fails without returning the element
+func FuzzParsePodNetworkSelectionElement(f *testing.F) {
+	for _, seed := range networkSelectionSeeds {
+		f.Add(seed, "default")
+	}
+	f.Fuzz(func(t *testing.T, selection, defaultNamespace string) {
+		if defaultNamespace == "" {
+			t.Skip("namespace of the pod is always known when single element is parsed")
+		}
+		network, err := parsePodNetworkSelectionElement(selection, defaultNamespace)
+		if err != nil {
+			if network != nil {
+				t.Fatalf("'%s' failed with error %v, but returned network %v", selection, err, network)
+			}
+			return
+		}
+		if network.Name == "" || network.Namespace == "" {
+			t.Fatalf("'%s' returned incomplete network %v", selection, network)
+		}
+	})
+}
@@ -16,20 +16,49 @@ package webhook
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
 	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/logging"
+	netcache "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/tools"
 	nritypes "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/userdefinedinjections"
 )
 
 func createBool(value bool) *bool {
@@ -40,6 +69,234 @@ func createString(value string) *string {
 	return &value
 }
 
+// newControlSwitches initializes control switches with all features in default state and then
+// applies given features state the same way as it is done by nri-control-switches ConfigMap
+func newControlSwitches(features map[string]bool) *controlswitches.ControlSwitches {
+	structure := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+	structure.InitControlSwitches()
+	if len(features) > 0 {
+		featuresJSON, _ := json.Marshal(map[string]interface{}{"features": features})
+		structure.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{
+			Data: map[string]string{nritypes.ConfigMapMainFileKey: string(featuresJSON)},
+		})
+	}
+	return structure
+}
+
+// setupControlSwitches sets up the default webhook with control switches of given features state
+func setupControlSwitches(features map[string]bool) *controlswitches.ControlSwitches {
+	structure := newControlSwitches(features)
+	SetControlSwitches(structure)
+	return structure
+}
+
+// newTestWebhook returns webhook independent of the default one, with control switches of given
+// features state and cache serving the net-attach-def annotations
+func newTestWebhook(features map[string]bool, annotations map[string]map[string]string) *Webhook {
+	wh := NewWebhook(nil, newControlSwitches(features))
+	wh.SetNetAttachDefCache(fakeNetAttachDefCache{annotations: annotations})
+	return wh
+}
+
+// fakeOwnerCache serves namespaces of pod owners from a static map keyed by kind/uid
+type fakeOwnerCache map[string]string
+
+func (fakeOwnerCache) Start() {}
+
+func (fakeOwnerCache) Stop() {}
+
+func (c fakeOwnerCache) GetNamespace(kind string, uid k8stypes.UID) (string, bool) {
+	namespace, exists := c[kind+"/"+string(uid)]
+	return namespace, exists
+}
+
+// fakeNamespaceCache serves namespace labels from a static map
+type fakeNamespaceCache map[string]map[string]string
+
+func (fakeNamespaceCache) Start() {}
+
+func (fakeNamespaceCache) Stop() {}
+
+func (c fakeNamespaceCache) Get(namespace string) (map[string]string, bool) {
+	labels, exists := c[namespace]
+	return labels, exists
+}
+
+// fakeNetAttachDefCache serves net-attach-def annotations and configs from static maps
+type fakeNetAttachDefCache struct {
+	annotations map[string]map[string]string
+	configs     map[string]string
+	references  map[string][]string
+	resyncErr   error
+}
+
+func (fakeNetAttachDefCache) Start() {}
+
+func (fakeNetAttachDefCache) Stop() {}
+
+func (c fakeNetAttachDefCache) Get(namespace, networkName string) map[string]string {
+	return c.annotations[namespace+"/"+networkName]
+}
+
+func (c fakeNetAttachDefCache) GetConfig(namespace, networkName string) string {
+	return c.configs[namespace+"/"+networkName]
+}
+
+func (c fakeNetAttachDefCache) Resolve(namespace, reference string) []string {
+	return c.references[namespace+"/"+reference]
+}
+
+func (c fakeNetAttachDefCache) Resync(ctx context.Context) error {
+	return c.resyncErr
+}
+
+func (c fakeNetAttachDefCache) List() []netcache.NetAttachDefCacheEntry {
+	keys := make([]string, 0, len(c.annotations))
+	for key := range c.annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := []netcache.NetAttachDefCacheEntry{}
+	for _, key := range keys {
+		names := strings.SplitN(key, "/", 2)
+		entries = append(entries, netcache.NetAttachDefCacheEntry{Namespace: names[0], Name: names[1],
+			Annotations: c.annotations[key], Config: c.configs[key]})
+	}
+	return entries
+}
+
+// mutate sends AdmissionReview with the given object to the MutateHandler and returns the response
+func mutate(kind metav1.GroupVersionKind, object interface{}) *admissionv1.AdmissionResponse {
+	return mutateWith(MutateHandler, kind, object)
+}
+
+func mutateWith(handler http.HandlerFunc, kind metav1.GroupVersionKind, object interface{}) *admissionv1.AdmissionResponse {
+	raw, err := json.Marshal(object)
+	Expect(err).NotTo(HaveOccurred())
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "test",
+			Kind:      kind,
+			Namespace: "default",
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	req := httptest.NewRequest("POST", "https://fakewebhook/mutate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	Expect(w.Code).To(Equal(http.StatusOK))
+
+	ar := admissionv1.AdmissionReview{}
+	Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+	return ar.Response
+}
+
+// useNetAttachDefs sets up the default webhook with cache serving the net-attach-def annotations and without user
+// defined injections
+func useNetAttachDefs(annotations map[string]map[string]string) {
+	SetNetAttachDefCache(fakeNetAttachDefCache{annotations: annotations})
+	SetUserInjectionStructure(userdefinedinjections.CreateUserInjectionsStructure())
+}
+
+// resetWebhook restores the default webhook to the default control switches and no net-attach-def cache
+func resetWebhook() {
+	SetNetAttachDefCache(nil)
+	setupControlSwitches(nil)
+}
+
+// podKind is the kind of pods sent in AdmissionReview requests
+var podKind = metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}
+
+// podWithAnnotations returns pod default/test with a single app container and the given annotations
+func podWithAnnotations(annotations map[string]string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: annotations},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+}
+
+// podWithNetworks returns pod default/test with a single app container selecting the networks, pod without network
+// annotation when networks are empty
+func podWithNetworks(networks string) corev1.Pod {
+	if networks == "" {
+		return podWithAnnotations(nil)
+	}
+	return podWithAnnotations(map[string]string{"k8s.v1.cni.cncf.io/networks": networks})
+}
+
+// rawPodWithNetworks returns pod selecting the networks as raw JSON, so its app container has empty resources object
+func rawPodWithNetworks(networks string) json.RawMessage {
+	return json.RawMessage(`{"metadata": {"name": "test", "namespace": "default",
+		"annotations": {"k8s.v1.cni.cncf.io/networks": "` + networks + `"}}, "spec": {"containers": [{"name": "app", "resources": {}}]}}`)
+}
+
+// patchOf returns JSON patch of the response admitting the object
+func patchOf(response *admissionv1.AdmissionResponse) []nritypes.JsonPatchOperation {
+	Expect(response.Allowed).To(BeTrue())
+	var patch []nritypes.JsonPatchOperation
+	Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+	return patch
+}
+
+// patchValues returns values of JSON patch operations of the response admitting the object under their paths
+func patchValues(response *admissionv1.AdmissionResponse) map[string]interface{} {
+	Expect(response.Allowed).To(BeTrue())
+	values := map[string]interface{}{}
+	if response.Patch == nil {
+		return values
+	}
+	for _, operation := range patchOf(response) {
+		values[operation.Path] = operation.Value
+	}
+	return values
+}
+
+// annotationsOf returns annotations added by JSON patch of the response, nil when no annotation is added
+func annotationsOf(response *admissionv1.AdmissionResponse) map[string]interface{} {
+	var patch []nritypes.JsonPatchOperation
+	Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+	for _, operation := range patch {
+		if operation.Path == "/metadata/annotations" {
+			return operation.Value.(map[string]interface{})
+		}
+	}
+	return nil
+}
+
+// injectedResources returns injected resources annotation added by JSON patch of the response admitting the pod
+func injectedResources(response *admissionv1.AdmissionResponse) interface{} {
+	Expect(response.Allowed).To(BeTrue())
+	return annotationsOf(response)["network-resources-injector.io/injected-resources"]
+}
+
+// applyPatch returns pod patched by JSON patch of the response admitting it
+func applyPatch(pod corev1.Pod, response *admissionv1.AdmissionResponse) corev1.Pod {
+	Expect(response.Allowed).To(BeTrue())
+	raw, err := json.Marshal(pod)
+	Expect(err).NotTo(HaveOccurred())
+	patch, err := jsonpatch.DecodePatch(response.Patch)
+	Expect(err).NotTo(HaveOccurred())
+	raw, err = patch.Apply(raw)
+	Expect(err).NotTo(HaveOccurred())
+	patched := corev1.Pod{}
+	Expect(json.Unmarshal(raw, &patched)).To(Succeed())
+	return patched
+}
+
+// patchedPod returns pod patched by the webhook
+func patchedPod(pod corev1.Pod) corev1.Pod {
+	return applyPatch(pod, mutate(podKind, pod))
+}
+
+// patchedPodBy returns the pod patched by the given webhook
+func patchedPodBy(wh *Webhook, pod corev1.Pod) corev1.Pod {
+	return applyPatch(pod, mutateWith(wh.MutateHandler, podKind, pod))
+}
+
 var _ = Describe("Webhook", func() {
 	Describe("Preparing Admission Review Response", func() {
 		Context("Admission Review Request is nil", func() {
@@ -69,7 +326,30 @@ var _ = Describe("Webhook", func() {
 				_, err := deserializeAdmissionReview(body)
 				Expect(err).To(HaveOccurred())
 			})
+
+			It("should return an error for other kind", func() {
+				body := []byte(`{"kind": "Pod", "apiVersion": "v1"}`)
+				_, err := deserializeAdmissionReview(body)
+				Expect(err).To(HaveOccurred())
+			})
 		})
+
+		DescribeTable("supported versions",
+			func(apiVersion string) {
+				body := []byte(fmt.Sprintf(`{"kind": "AdmissionReview", "apiVersion": "%s", "request": {"uid": "fake-uid"}}`, apiVersion))
+				ar, err := deserializeAdmissionReview(body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ar.APIVersion).To(Equal(apiVersion))
+				Expect(ar.Request.UID).To(Equal(k8stypes.UID("fake-uid")))
+
+				Expect(prepareAdmissionReviewResponse(true, "allowed", ar)).To(Succeed())
+				Expect(ar.Kind).To(Equal("AdmissionReview"))
+				Expect(ar.APIVersion).To(Equal(apiVersion))
+				Expect(ar.Response.UID).To(Equal(k8stypes.UID("fake-uid")))
+			},
+			Entry("v1", "admission.k8s.io/v1"),
+			Entry("v1beta1", "admission.k8s.io/v1beta1"),
+		)
 	})
 
 	Describe("Deserializing Network Attachment Definition", func() {
@@ -88,10 +368,152 @@ var _ = Describe("Webhook", func() {
 			It("should return an error", func() {
 				ar := &admissionv1.AdmissionReview{}
 				ar.Request = &admissionv1.AdmissionRequest{}
-				_, err := deserializePod(ar)
+				_, err := defaultWebhook.deserializePod(context.Background(), ar)
 				Expect(err).To(HaveOccurred())
 			})
 		})
+
+		Context("Request is empty", func() {
+			var wh *Webhook
+
+			BeforeEach(func() {
+				wh = newTestWebhook(nil, nil)
+			})
+
+			It("should return an error when request is missing", func() {
+				_, err := wh.deserializePod(context.Background(), &admissionv1.AdmissionReview{})
+				Expect(err).To(MatchError("received empty AdmissionReview request"))
+			})
+
+			It("should return an error when request carries no object", func() {
+				ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{UID: "test"}}
+				_, err := wh.deserializePod(context.Background(), ar)
+				Expect(err).To(MatchError("received AdmissionReview request without object"))
+			})
+
+			It("should respond with bad request to AdmissionReview without request", func() {
+				req := httptest.NewRequest("POST", "https://fakewebhook/mutate",
+					strings.NewReader(`{"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1"}`))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				Expect(func() { wh.MutateHandler(w, req) }).NotTo(Panic())
+				Expect(w.Code).To(Equal(http.StatusBadRequest))
+				Expect(w.Body.String()).To(ContainSubstring("received empty AdmissionReview request"))
+			})
+
+			It("should deny AdmissionReview request without object", func() {
+				req := httptest.NewRequest("POST", "https://fakewebhook/mutate", strings.NewReader(
+					`{"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1", "request": {"uid": "test", "kind": {"version": "v1", "kind": "Pod"}}}`))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				wh.MutateHandler(w, req)
+				Expect(w.Code).To(Equal(http.StatusOK))
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+				Expect(ar.Response.UID).To(BeEquivalentTo("test"))
+				Expect(ar.Response.Allowed).To(BeFalse())
+				Expect(ar.Response.Result.Message).To(Equal("received AdmissionReview request without object"))
+			})
+		})
+
+		Context("Namespace cannot be determined", func() {
+			podRequest := func(pod corev1.Pod, namespace string) *admissionv1.AdmissionReview {
+				raw, err := json.Marshal(pod)
+				Expect(err).NotTo(HaveOccurred())
+				return &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+					Namespace: namespace,
+					Object:    runtime.RawExtension{Raw: raw},
+				}}
+			}
+			workflowPod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "test",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Workflow", Name: "workflow", UID: "uid"}},
+			}}
+
+			It("should prefer request namespace over owner reference", func() {
+				wh := newTestWebhook(nil, nil)
+				pod, err := wh.deserializePod(context.Background(), podRequest(workflowPod, "request-ns"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pod.ObjectMeta.Namespace).To(Equal("request-ns"))
+			})
+
+			It("should use fallback namespace for unsupported owner kind", func() {
+				wh := newTestWebhook(nil, nil)
+				wh.controlSwitches.SetFallbackNamespaceUnitTests("fallback-ns")
+				pod, err := wh.deserializePod(context.Background(), podRequest(workflowPod, ""))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pod.ObjectMeta.Namespace).To(Equal("fallback-ns"))
+			})
+
+			It("should use default namespace when fallback is not configured", func() {
+				wh := newTestWebhook(nil, nil)
+				pod, err := wh.deserializePod(context.Background(), podRequest(corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test"}}, ""))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pod.ObjectMeta.Namespace).To(Equal("default"))
+			})
+
+			It("should deny pod when enabled", func() {
+				wh := newTestWebhook(map[string]bool{"denyUnresolvedNamespace": true}, nil)
+				_, err := wh.deserializePod(context.Background(), podRequest(workflowPod, ""))
+				Expect(err).To(MatchError(ContainSubstring("could not be determined")))
+				Expect(err).To(BeAssignableToTypeOf(namespaceError{}))
+			})
+
+			It("should leave namespace empty when pod should be skipped", func() {
+				wh := newTestWebhook(map[string]bool{"skipUnresolvedNamespace": true}, nil)
+				pod, err := wh.deserializePod(context.Background(), podRequest(workflowPod, ""))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pod.ObjectMeta.Namespace).To(BeEmpty())
+			})
+
+			It("should prefer denial over skipping", func() {
+				wh := newTestWebhook(map[string]bool{"denyUnresolvedNamespace": true, "skipUnresolvedNamespace": true}, nil)
+				_, err := wh.deserializePod(context.Background(), podRequest(workflowPod, ""))
+				Expect(err).To(BeAssignableToTypeOf(namespaceError{}))
+			})
+
+			It("should resolve namespace of pod template the same way", func() {
+				deployment := appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app"}}
+				raw, err := json.Marshal(deployment)
+				Expect(err).NotTo(HaveOccurred())
+				ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{Object: runtime.RawExtension{Raw: raw}}}
+
+				wh := newTestWebhook(nil, nil)
+				wh.controlSwitches.SetFallbackNamespaceUnitTests("fallback-ns")
+				pod, err := wh.deserializePodTemplate(ar)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pod.ObjectMeta.Namespace).To(Equal("fallback-ns"))
+
+				wh = newTestWebhook(map[string]bool{"denyUnresolvedNamespace": true}, nil)
+				_, err = wh.deserializePodTemplate(ar)
+				Expect(err).To(BeAssignableToTypeOf(namespaceError{}))
+			})
+
+			It("should admit pod without injection when namespace is unresolved", func() {
+				wh := newTestWebhook(map[string]bool{"skipUnresolvedNamespace": true, "enableSkipReasonWarnings": true}, nil)
+				pod := workflowPod
+				pod.ObjectMeta.Annotations = map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"}
+				raw, err := json.Marshal(pod)
+				Expect(err).NotTo(HaveOccurred())
+				body, err := json.Marshal(admissionv1.AdmissionReview{
+					TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+					Request: &admissionv1.AdmissionRequest{UID: "test", Kind: podKind,
+						Object: runtime.RawExtension{Raw: raw}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				req := httptest.NewRequest("POST", "https://fakewebhook/mutate", bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				wh.MutateHandler(w, req)
+				Expect(w.Code).To(Equal(http.StatusOK))
+
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+				Expect(ar.Response.Allowed).To(BeTrue())
+				Expect(ar.Response.Patch).To(BeNil())
+				Expect(ar.Response.Warnings).To(ConsistOf(ContainSubstring("NamespaceUnresolved")))
+			})
+		})
 	})
 
 	Describe("Writing a response", func() {
@@ -212,6 +634,17 @@ var _ = Describe("Webhook", func() {
 	)
 
 	var emptyList []*types.NetworkSelectionElement
+	It("should fail to parse network without namespace when pod namespace is unknown", func() {
+		for _, selections := range []string{"sriov-net", `[{"name": "sriov-net"}]`} {
+			networks, err := parsePodNetworkSelections(selections, "")
+			Expect(err).To(MatchError(ContainSubstring("could not be determined")), selections)
+			Expect(networks).To(BeNil())
+		}
+		networks, err := parsePodNetworkSelections("ns1/sriov-net", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(networks[0].Namespace).To(Equal("ns1"))
+	})
+
 	DescribeTable("Network selection elements parsing",
 
 		func(in string, out []*types.NetworkSelectionElement, shouldFail bool) {
@@ -316,6 +749,60 @@ var _ = Describe("Webhook", func() {
 			emptyList,
 			true,
 		),
+		Entry(
+			"csv - empty element",
+			"net1,,net2",
+			emptyList,
+			true,
+		),
+		Entry(
+			"csv - empty namespace",
+			"/net1",
+			emptyList,
+			true,
+		),
+		Entry(
+			"csv - empty name",
+			"ns1/@eth0",
+			emptyList,
+			true,
+		),
+		Entry(
+			"csv - empty interface",
+			"net1@",
+			emptyList,
+			true,
+		),
+		Entry(
+			"json - null",
+			`null`,
+			emptyList,
+			true,
+		),
+		Entry(
+			"json - null element",
+			`[{"name": "net1"}, null]`,
+			emptyList,
+			true,
+		),
+		Entry(
+			"json - element without name",
+			`[{"namespace": "ns1"}]`,
+			emptyList,
+			true,
+		),
+		Entry(
+			"json - invalid namespace",
+			`[{"name": "net1", "namespace": "ns1/ns2"}]`,
+			emptyList,
+			true,
+		),
+		Entry(
+			"json - partially decoded array",
+			`[{"name": "net1"}, 5]`,
+			emptyList,
+			true,
+		),
 		Entry(
 			"json - correct example",
 			`[{"name": "net1"},{"name": "net2", "namespace": "ns1"}]`,
@@ -334,4 +821,4978 @@ var _ = Describe("Webhook", func() {
 			false,
 		),
 	)
+	DescribeTable("Node selector parsing",
+
+		func(in string, outNsMap map[string]string, outAffinity []corev1.NodeSelectorRequirement, shouldFail bool) {
+			nsMap := make(map[string]string)
+			affinity, err := parseNodeSelector(in, nsMap, nil)
+			if shouldFail {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nsMap).To(Equal(outNsMap))
+			Expect(affinity).To(Equal(outAffinity))
+		},
+		Entry(
+			"equality",
+			"kubernetes.io/hostname=kind-worker2",
+			map[string]string{"kubernetes.io/hostname": "kind-worker2"},
+			nil,
+			false,
+		),
+		Entry(
+			"inequality",
+			"kubernetes.io/hostname!=kind-worker2",
+			map[string]string{},
+			[]corev1.NodeSelectorRequirement{
+				{Key: "kubernetes.io/hostname", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"kind-worker2"}},
+			},
+			false,
+		),
+		Entry(
+			"in operator",
+			"zone in (a, b)",
+			map[string]string{},
+			[]corev1.NodeSelectorRequirement{
+				{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}},
+			},
+			false,
+		),
+		Entry(
+			"notin operator",
+			"zone notin (a,b)",
+			map[string]string{},
+			[]corev1.NodeSelectorRequirement{
+				{Key: "zone", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"a", "b"}},
+			},
+			false,
+		),
+		Entry(
+			"exists operator",
+			"feature.node.kubernetes.io/sriov",
+			map[string]string{},
+			[]corev1.NodeSelectorRequirement{
+				{Key: "feature.node.kubernetes.io/sriov", Operator: corev1.NodeSelectorOpExists, Values: []string{}},
+			},
+			false,
+		),
+		Entry(
+			"does not exist operator",
+			"!feature.node.kubernetes.io/sriov",
+			map[string]string{},
+			[]corev1.NodeSelectorRequirement{
+				{Key: "feature.node.kubernetes.io/sriov", Operator: corev1.NodeSelectorOpDoesNotExist, Values: []string{}},
+			},
+			false,
+		),
+		Entry(
+			"invalid syntax",
+			"zone in (a,b",
+			nil,
+			nil,
+			true,
+		),
+		Entry(
+			"multiple labels",
+			"zone=a,rack=b",
+			map[string]string{"zone": "a", "rack": "b"},
+			nil,
+			false,
+		),
+		Entry(
+			"multiple labels with different operators",
+			"zone=a, rack in (b,c), feature.node.kubernetes.io/sriov",
+			map[string]string{"zone": "a"},
+			[]corev1.NodeSelectorRequirement{
+				{Key: "feature.node.kubernetes.io/sriov", Operator: corev1.NodeSelectorOpExists, Values: []string{}},
+				{Key: "rack", Operator: corev1.NodeSelectorOpIn, Values: []string{"b", "c"}},
+			},
+			false,
+		),
+		Entry(
+			"same label required twice",
+			"zone=a,zone==a",
+			map[string]string{"zone": "a"},
+			nil,
+			false,
+		),
+		Entry(
+			"conflicting values of the same label",
+			"zone=a,zone=b",
+			nil,
+			nil,
+			true,
+		),
+		Entry(
+			"value containing '='",
+			"zone=us-east-1=primary",
+			nil,
+			nil,
+			true,
+		),
+	)
+
+	Describe("Node selector patch", func() {
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, nil)
+		})
+
+		It("should merge labels of multiple networks with existing node selector", func() {
+			nsMap := make(map[string]string)
+			_, err := parseNodeSelector("zone=a,rack=b", nsMap, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = parseNodeSelector("nic=eno3", nsMap, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			patch, err := wh.createNodeSelectorPatch(nil, map[string]string{"disk": "ssd", "zone": "c"}, nsMap)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch).To(ConsistOf(nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/spec/nodeSelector",
+				Value:     map[string]string{"disk": "ssd", "zone": "a", "rack": "b", "nic": "eno3"},
+			}))
+		})
+
+		It("should reject label required with other value by selector of another network", func() {
+			nsMap := make(map[string]string)
+			_, err := parseNodeSelector("zone=a,rack=b", nsMap, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = parseNodeSelector("zone=c", nsMap, nil)
+			Expect(err).To(MatchError("node selector 'zone=c' requires value 'c' of label 'zone', other network requires 'a'"))
+			Expect(nsMap).To(Equal(map[string]string{"zone": "a", "rack": "b"}))
+		})
+
+		It("should not patch when there are no labels", func() {
+			Expect(wh.createNodeSelectorPatch(nil, nil, map[string]string{})).To(BeEmpty())
+		})
+
+		Context("with label set by pod excluded by match expression", func() {
+			desired := []corev1.NodeSelectorRequirement{
+				{Key: "zone", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"b"}},
+				{Key: "feature.node.kubernetes.io/sriov", Operator: corev1.NodeSelectorOpExists, Values: []string{}},
+			}
+
+			It("should accept pod value satisfying the match expressions", func() {
+				wh.controlSwitches.SetNodeSelectorConflictActionUnitTests(controlswitches.NodeSelectorConflictDeny)
+				Expect(wh.checkNodeSelectorRequirements(map[string]string{"zone": "a", "feature.node.kubernetes.io/sriov": "true"},
+					desired)).To(Succeed())
+			})
+
+			It("should only warn by default", func() {
+				Expect(wh.checkNodeSelectorRequirements(map[string]string{"zone": "b"}, desired)).To(Succeed())
+			})
+
+			It("should deny pod naming the conflicting label", func() {
+				wh.controlSwitches.SetNodeSelectorConflictActionUnitTests(controlswitches.NodeSelectorConflictDeny)
+				Expect(wh.checkNodeSelectorRequirements(map[string]string{"zone": "b"}, desired)).To(
+					MatchError("pod node selector requires label 'zone' to be 'b', its networks require 'zone notin (b)'"))
+			})
+
+			It("should deny admission request of pod with conflicting label", func() {
+				wh.SetNetAttachDefCache(fakeNetAttachDefCache{annotations: map[string]map[string]string{
+					"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov", "k8s.v1.cni.cncf.io/nodeSelector": "zone notin (b)"},
+				}})
+				wh.controlSwitches.SetNodeSelectorConflictActionUnitTests(controlswitches.NodeSelectorConflictDeny)
+				pod := podWithNetworks("sriov-net")
+				pod.Spec.NodeSelector = map[string]string{"zone": "b"}
+				response := mutateWith(wh.MutateHandler, podKind, pod)
+				Expect(response.Allowed).To(BeFalse())
+				Expect(response.Result.Message).To(ContainSubstring("label 'zone' to be 'b', its networks require 'zone notin (b)'"))
+			})
+		})
+
+		Context("with label set by pod to other value", func() {
+			existing := map[string]string{"zone": "b", "disk": "ssd"}
+			desired := map[string]string{"zone": "a", "nic": "eno3"}
+
+			It("should override the pod value by default", func() {
+				patch, err := wh.createNodeSelectorPatch(nil, existing, desired)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(patch).To(ConsistOf(HaveField("Value", map[string]string{"zone": "a", "disk": "ssd", "nic": "eno3"})))
+			})
+
+			It("should preserve the pod value", func() {
+				wh.controlSwitches.SetNodeSelectorConflictActionUnitTests(controlswitches.NodeSelectorConflictPreserve)
+				patch, err := wh.createNodeSelectorPatch(nil, existing, desired)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(patch).To(ConsistOf(HaveField("Value", map[string]string{"zone": "b", "disk": "ssd", "nic": "eno3"})))
+			})
+
+			It("should deny pod naming the conflicting label", func() {
+				wh.controlSwitches.SetNodeSelectorConflictActionUnitTests(controlswitches.NodeSelectorConflictDeny)
+				_, err := wh.createNodeSelectorPatch(nil, existing, desired)
+				Expect(err).To(MatchError("pod node selector requires label 'zone' to be 'b', its networks require 'a'"))
+			})
+
+			It("should not deny pod setting the same value", func() {
+				wh.controlSwitches.SetNodeSelectorConflictActionUnitTests(controlswitches.NodeSelectorConflictDeny)
+				patch, err := wh.createNodeSelectorPatch(nil, map[string]string{"zone": "a"}, desired)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(patch).To(ConsistOf(HaveField("Value", map[string]string{"zone": "a", "nic": "eno3"})))
+			})
+
+			It("should deny admission request of pod with conflicting label", func() {
+				wh.SetNetAttachDefCache(fakeNetAttachDefCache{annotations: map[string]map[string]string{
+					"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov", "k8s.v1.cni.cncf.io/nodeSelector": "zone=a"},
+				}})
+				wh.controlSwitches.SetNodeSelectorConflictActionUnitTests(controlswitches.NodeSelectorConflictDeny)
+				pod := podWithNetworks("sriov-net")
+				pod.Spec.NodeSelector = map[string]string{"zone": "b"}
+				response := mutateWith(wh.MutateHandler, podKind, pod)
+				Expect(response.Allowed).To(BeFalse())
+				Expect(response.Result.Message).To(ContainSubstring("label 'zone' to be 'b'"))
+			})
+		})
+	})
+
+	Describe("Node affinity patch", func() {
+		desired := []corev1.NodeSelectorRequirement{
+			{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}},
+		}
+
+		It("should not patch when no match expressions are desired", func() {
+			Expect(createNodeAffinityPatch(nil, nil, nil, nil)).To(BeEmpty())
+		})
+
+		It("should add affinity when pod has none", func() {
+			patch := createNodeAffinityPatch(nil, nil, desired, nil)
+			Expect(patch).To(HaveLen(1))
+			Expect(patch[0].Path).To(Equal("/spec/affinity"))
+			affinity := patch[0].Value.(corev1.Affinity)
+			Expect(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(Equal(
+				[]corev1.NodeSelectorTerm{{MatchExpressions: desired}}))
+		})
+
+		It("should AND desired expressions into every existing term", func() {
+			existing := &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "rack", Operator: corev1.NodeSelectorOpExists}}},
+							{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "row", Operator: corev1.NodeSelectorOpExists}}},
+						},
+					},
+				},
+			}
+			patch := createNodeAffinityPatch(nil, existing, desired, nil)
+			Expect(patch).To(HaveLen(1))
+			Expect(patch[0].Path).To(Equal("/spec/affinity/nodeAffinity"))
+			terms := patch[0].Value.(*corev1.NodeAffinity).RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+			Expect(terms).To(HaveLen(2))
+			Expect(terms[0].MatchExpressions).To(ContainElement(desired[0]))
+			Expect(terms[1].MatchExpressions).To(ContainElement(desired[0]))
+			// user affinity must not be modified in place
+			Expect(existing.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions).To(HaveLen(1))
+		})
+	})
+	Describe("User defined sysctls patch", func() {
+		userDefinedPatch := []nritypes.JsonPatchOperation{
+			{
+				Operation: "add",
+				Path:      "/spec/securityContext/sysctls",
+				Value: []interface{}{
+					map[string]interface{}{"name": "net.ipv4.conf.all.arp_filter", "value": "1"},
+					map[string]interface{}{"name": "net.ipv4.conf.all.arp_ignore", "value": "1"},
+				},
+			},
+		}
+
+		It("should add security context when pod has none", func() {
+			patch := appendUserDefinedPatch(nil, corev1.Pod{}, userDefinedPatch)
+			Expect(patch).To(HaveLen(1))
+			Expect(patch[0].Path).To(Equal("/spec/securityContext"))
+			Expect(patch[0].Value.(corev1.PodSecurityContext).Sysctls).To(Equal([]corev1.Sysctl{
+				{Name: "net.ipv4.conf.all.arp_filter", Value: "1"},
+				{Name: "net.ipv4.conf.all.arp_ignore", Value: "1"},
+			}))
+		})
+
+		It("should merge with existing sysctls without duplicates", func() {
+			pod := corev1.Pod{
+				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{
+						Sysctls: []corev1.Sysctl{{Name: "net.ipv4.conf.all.arp_filter", Value: "0"}},
+					},
+				},
+			}
+			patch := appendUserDefinedPatch(nil, pod, userDefinedPatch)
+			Expect(patch).To(HaveLen(1))
+			Expect(patch[0].Path).To(Equal("/spec/securityContext/sysctls"))
+			Expect(patch[0].Value).To(Equal([]corev1.Sysctl{
+				{Name: "net.ipv4.conf.all.arp_filter", Value: "0"},
+				{Name: "net.ipv4.conf.all.arp_ignore", Value: "1"},
+			}))
+		})
+
+		It("should not patch when all sysctls are already present", func() {
+			pod := corev1.Pod{
+				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{
+						Sysctls: []corev1.Sysctl{
+							{Name: "net.ipv4.conf.all.arp_filter", Value: "1"},
+							{Name: "net.ipv4.conf.all.arp_ignore", Value: "1"},
+						},
+					},
+				},
+			}
+			Expect(appendUserDefinedPatch(nil, pod, userDefinedPatch)).To(BeEmpty())
+		})
+	})
+	Describe("Init containers resource patch", func() {
+		resourceRequests := map[string]int64{"intel.com/sriov": 2}
+
+		It("should inject resources into every init container", func() {
+			setupControlSwitches(map[string]bool{"injectIntoInitContainers": true})
+			initContainers := []corev1.Container{{Name: "setup"}, {Name: "config"}}
+			patch := defaultWebhook.createInitContainersResourcePatch(nil, initContainers, resourceRequests)
+			Expect(patch).To(ConsistOf(
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/initContainers/0/resources/requests", Value: corev1.ResourceList{}},
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/initContainers/0/resources/limits", Value: corev1.ResourceList{}},
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/initContainers/0/resources/requests/intel.com~1sriov", Value: *resource.NewQuantity(2, resource.DecimalSI)},
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/initContainers/0/resources/limits/intel.com~1sriov", Value: *resource.NewQuantity(2, resource.DecimalSI)},
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/initContainers/1/resources/requests", Value: corev1.ResourceList{}},
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/initContainers/1/resources/limits", Value: corev1.ResourceList{}},
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/initContainers/1/resources/requests/intel.com~1sriov", Value: *resource.NewQuantity(2, resource.DecimalSI)},
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/initContainers/1/resources/limits/intel.com~1sriov", Value: *resource.NewQuantity(2, resource.DecimalSI)},
+			))
+			// app containers computation must still see all requested resources
+			Expect(resourceRequests).To(HaveKey("intel.com/sriov"))
+		})
+
+		It("should skip init container that already requests the resource", func() {
+			setupControlSwitches(map[string]bool{"injectIntoInitContainers": true})
+			initContainers := []corev1.Container{{
+				Name: "setup",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{"intel.com/sriov": *resource.NewQuantity(1, resource.DecimalSI)},
+					Limits:   corev1.ResourceList{"intel.com/sriov": *resource.NewQuantity(1, resource.DecimalSI)},
+				},
+			}}
+			Expect(defaultWebhook.createInitContainersResourcePatch(nil, initContainers, resourceRequests)).To(BeEmpty())
+		})
+
+		It("should not dedup app containers against init containers", func() {
+			setupControlSwitches(map[string]bool{"injectIntoInitContainers": true})
+			initContainers := []corev1.Container{{
+				Name: "setup",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{"intel.com/sriov": *resource.NewQuantity(2, resource.DecimalSI)},
+					Limits:   corev1.ResourceList{"intel.com/sriov": *resource.NewQuantity(2, resource.DecimalSI)},
+				},
+			}}
+			requests := map[string]int64{"intel.com/sriov": 2}
+			patch := defaultWebhook.createInitContainersResourcePatch(nil, initContainers, requests)
+			patch, err := defaultWebhook.createResourcePatch(patch, []corev1.Container{{Name: "app"}}, requests)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch).To(ContainElement(nritypes.JsonPatchOperation{
+				Operation: "add", Path: "/spec/containers/0/resources/requests/intel.com~1sriov", Value: *resource.NewQuantity(2, resource.DecimalSI),
+			}))
+		})
+
+		It("should add container name env var to init containers requesting hugepages", func() {
+			initContainers := []corev1.Container{{
+				Name: "setup",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{"hugepages-2Mi": resource.MustParse("512Mi")},
+				},
+			}}
+			patch, hugepages := processHugepagesForDownwardAPI(nil, initContainers, initContainersPath, nil)
+			Expect(hugepages).To(HaveLen(1))
+			Expect(hugepages[0].ContainerName).To(Equal("setup"))
+			Expect(patch).To(ConsistOf(nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/spec/initContainers/0/env",
+				Value:     []corev1.EnvVar{{Name: nritypes.EnvNameContainerName, Value: "setup"}},
+			}))
+		})
+	})
+	Describe("Downward API volume on reinvocation", func() {
+		BeforeEach(func() {
+			setupControlSwitches(nil)
+		})
+
+		podnetinfoMount := corev1.VolumeMount{Name: "podnetinfo", ReadOnly: true, MountPath: nritypes.DownwardAPIMountPath}
+
+		It("should mount volume into containers without the mount", func() {
+			containers := []corev1.Container{{Name: "first"}, {Name: "second", VolumeMounts: []corev1.VolumeMount{{Name: "data"}}}}
+			patch := addVolumeMount(nil, containers, containersPath, "podnetinfo", nritypes.DownwardAPIMountPath, nil)
+			Expect(patch).To(Equal([]nritypes.JsonPatchOperation{
+				{Operation: "add", Path: "/spec/containers/0/volumeMounts", Value: []corev1.VolumeMount{}},
+				{Operation: "add", Path: "/spec/containers/0/volumeMounts/-", Value: podnetinfoMount},
+				{Operation: "add", Path: "/spec/containers/1/volumeMounts/-", Value: podnetinfoMount},
+			}))
+		})
+
+		It("should not duplicate mount and volume already applied by previous invocation", func() {
+			pod := corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "first", VolumeMounts: []corev1.VolumeMount{podnetinfoMount}},
+						{Name: "second", VolumeMounts: []corev1.VolumeMount{{Name: "data"}, podnetinfoMount}},
+					},
+					Volumes: []corev1.Volume{{
+						Name:         "podnetinfo",
+						VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{}},
+					}},
+				},
+			}
+			Expect(defaultWebhook.createVolPatch(nil, nil, &pod, "podnetinfo", nil)).To(BeEmpty())
+		})
+	})
+	Describe("Extended resource patch mode", func() {
+		quantity := *resource.NewQuantity(2, resource.DecimalSI)
+		requestsPatch := nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/containers/0/resources/requests/intel.com~1sriov", Value: quantity}
+		limitsPatch := nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/containers/0/resources/limits/intel.com~1sriov", Value: quantity}
+
+		setupPatchMode := func(mode string) {
+			setupControlSwitches(nil).SetExtendedResourcePatchModeUnitTests(mode)
+		}
+
+		AfterEach(resetWebhook)
+
+		It("should inject both requests and limits by default", func() {
+			setupControlSwitches(nil)
+			patch := defaultWebhook.appendResource(nil, containerPath(containersPath, 0), corev1.ResourceRequirements{}, "intel.com/sriov", quantity, quantity)
+			Expect(patch).To(ConsistOf(requestsPatch, limitsPatch))
+		})
+
+		It("should inject only limits in limits-only mode", func() {
+			setupPatchMode(controlswitches.ExtendedResourcePatchModeLimitsOnly)
+			patch := defaultWebhook.appendResource(nil, containerPath(containersPath, 0), corev1.ResourceRequirements{}, "intel.com/sriov", quantity, quantity)
+			Expect(patch).To(ConsistOf(limitsPatch))
+		})
+
+		It("should keep requests equal to limits in limits-only mode when request is already set", func() {
+			setupPatchMode(controlswitches.ExtendedResourcePatchModeLimitsOnly)
+			existing := corev1.ResourceRequirements{Requests: corev1.ResourceList{"intel.com/sriov": *resource.NewQuantity(1, resource.DecimalSI)}}
+			patch := defaultWebhook.appendResource(nil, containerPath(containersPath, 0), existing, "intel.com/sriov", quantity, quantity)
+			Expect(patch).To(ConsistOf(requestsPatch, limitsPatch))
+		})
+
+		It("should inject only requests in requests-only mode for overcommitable resources", func() {
+			setupPatchMode(controlswitches.ExtendedResourcePatchModeRequestsOnly)
+			patch := defaultWebhook.appendResource(nil, containerPath(containersPath, 0), corev1.ResourceRequirements{}, "memory", quantity, quantity)
+			Expect(patch).To(ConsistOf(nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/containers/0/resources/requests/memory", Value: quantity}))
+		})
+
+		It("should inject limits as well in requests-only mode for extended resources", func() {
+			setupPatchMode(controlswitches.ExtendedResourcePatchModeRequestsOnly)
+			patch := defaultWebhook.appendResource(nil, containerPath(containersPath, 0), corev1.ResourceRequirements{}, "intel.com/sriov", quantity, quantity)
+			Expect(patch).To(ConsistOf(requestsPatch, limitsPatch))
+		})
+
+		It("should apply mode to the whole container resource patch", func() {
+			setupPatchMode(controlswitches.ExtendedResourcePatchModeLimitsOnly)
+			patch, err := defaultWebhook.createResourcePatch(nil, []corev1.Container{{Name: "app"}}, map[string]int64{"intel.com/sriov": 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch).To(ContainElement(limitsPatch))
+			Expect(patch).NotTo(ContainElement(requestsPatch))
+		})
+	})
+	Describe("CNI type of network requesting resources", func() {
+		network := &types.NetworkSelectionElement{Namespace: "default", Name: "sriov-net"}
+
+		AfterEach(resetWebhook)
+
+		It("should accept any CNI type when allowed types are not configured", func() {
+			setupControlSwitches(nil)
+			Expect(defaultWebhook.validateCNIType(network, `{"cniVersion": "0.3.1", "type": "macvlan"}`)).To(Succeed())
+		})
+
+		It("should accept matching CNI type", func() {
+			setupControlSwitches(nil).SetAllowedCNITypesUnitTests([]string{"sriov", "host-device"})
+			Expect(defaultWebhook.validateCNIType(network, `{"cniVersion": "0.3.1", "type": "sriov", "vlan": 100}`)).To(Succeed())
+		})
+
+		It("should accept matching CNI type of the first plugin in configuration list", func() {
+			setupControlSwitches(nil).SetAllowedCNITypesUnitTests([]string{"sriov", "host-device"})
+			Expect(defaultWebhook.validateCNIType(network, `{"cniVersion": "0.3.1", "plugins": [{"type": "host-device"}, {"type": "tuning"}]}`)).To(Succeed())
+		})
+
+		It("should reject mismatching CNI type", func() {
+			setupControlSwitches(nil).SetAllowedCNITypesUnitTests([]string{"sriov", "host-device"})
+			err := defaultWebhook.validateCNIType(network, `{"cniVersion": "0.3.1", "type": "macvlan"}`)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("macvlan"))
+		})
+
+		It("should reject network without CNI config", func() {
+			setupControlSwitches(nil).SetAllowedCNITypesUnitTests([]string{"sriov"})
+			Expect(defaultWebhook.validateCNIType(network, "")).NotTo(Succeed())
+		})
+	})
+	Describe("Namespace injection opt-in", func() {
+		BeforeEach(func() {
+			SetNamespaceCache(fakeNamespaceCache{
+				"enabled":   {"network-resources-injector": "enabled"},
+				"disabled":  {"network-resources-injector": "disabled"},
+				"unlabeled": {},
+			})
+		})
+
+		AfterEach(func() {
+			SetNamespaceCache(nil)
+			setupControlSwitches(nil)
+		})
+
+		It("should inject in every namespace when namespace label is not configured", func() {
+			setupControlSwitches(nil)
+			Expect(defaultWebhook.isInjectionEnabledForNamespace(context.Background(), "unlabeled")).To(BeTrue())
+		})
+
+		DescribeTable("should follow namespace label when configured",
+			func(namespace string, expected bool) {
+				setupControlSwitches(nil).SetNamespaceLabelUnitTests("network-resources-injector")
+				Expect(defaultWebhook.isInjectionEnabledForNamespace(context.Background(), namespace)).To(Equal(expected))
+			},
+			Entry("label enabled", "enabled", true),
+			Entry("label disabled", "disabled", false),
+			Entry("label absent", "unlabeled", false),
+		)
+	})
+	Describe("Conflicting podnetinfo volume", func() {
+		conflictingPod := corev1.Pod{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:         "app",
+					VolumeMounts: []corev1.VolumeMount{{Name: "podnetinfo", MountPath: "/data"}},
+				}},
+				Volumes: []corev1.Volume{{
+					Name:         "podnetinfo",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+				}},
+			},
+		}
+
+		AfterEach(resetWebhook)
+
+		It("should keep the volume name when existing podnetinfo volume is a Downward API volume", func() {
+			setupControlSwitches(nil)
+			pod := corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				Name:         "podnetinfo",
+				VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{}},
+			}}}}
+			Expect(defaultWebhook.getDownwardAPIVolumeName(&pod)).To(Equal("podnetinfo"))
+		})
+
+		It("should rename injected volume by default", func() {
+			setupControlSwitches(nil)
+			volumeName, err := defaultWebhook.getDownwardAPIVolumeName(&conflictingPod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volumeName).To(Equal("podnetinfo-nri"))
+
+			patch := defaultWebhook.createVolPatch(nil, nil, &conflictingPod, volumeName, nil)
+			Expect(patch).To(ContainElement(nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/spec/containers/0/volumeMounts/-",
+				Value:     corev1.VolumeMount{Name: "podnetinfo-nri", ReadOnly: true, MountPath: nritypes.DownwardAPIMountPath},
+			}))
+			Expect(patch).To(ContainElement(nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/spec/volumes/-",
+				Value: corev1.Volume{
+					Name:         "podnetinfo-nri",
+					VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{Items: []corev1.DownwardAPIVolumeFile{}}},
+				},
+			}))
+		})
+
+		It("should deny pod when configured", func() {
+			setupControlSwitches(nil).SetPodNetInfoConflictUnitTests(controlswitches.PodNetInfoConflictDeny)
+			_, err := defaultWebhook.getDownwardAPIVolumeName(&conflictingPod)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("not a Downward API volume"))
+		})
+
+		It("should not mount volume into container already using the mount path", func() {
+			containers := []corev1.Container{{
+				Name:         "app",
+				VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: nritypes.DownwardAPIMountPath}},
+			}}
+			Expect(addVolumeMount(nil, containers, containersPath, "podnetinfo-nri", nritypes.DownwardAPIMountPath, nil)).To(BeEmpty())
+		})
+		It("should not inject volume when configured to skip", func() {
+			setupControlSwitches(nil).SetPodNetInfoConflictUnitTests(controlswitches.PodNetInfoConflictSkip)
+			volumeName, err := defaultWebhook.getDownwardAPIVolumeName(&conflictingPod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volumeName).To(BeEmpty())
+		})
+
+		It("should use configured volume name and mount path", func() {
+			setupControlSwitches(nil).SetDownwardAPIVolumeUnitTests("netinfo", "/var/run/netinfo")
+			Expect(defaultWebhook.getDownwardAPIVolumeName(&conflictingPod)).To(Equal("netinfo"))
+
+			pod := corev1.Pod{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}},
+				Volumes:    []corev1.Volume{{Name: "netinfo", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+			}}
+			volumeName, err := defaultWebhook.getDownwardAPIVolumeName(&pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(volumeName).To(Equal("netinfo-nri"))
+			Expect(defaultWebhook.createVolPatch(nil, nil, &pod, volumeName, nil)).To(ContainElement(nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/spec/containers/0/volumeMounts/-",
+				Value:     corev1.VolumeMount{Name: "netinfo-nri", ReadOnly: true, MountPath: "/var/run/netinfo"},
+			}))
+		})
+	})
+	Describe("Hugepages Downward API paths", func() {
+		hugepagesResources := corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{"hugepages-1Gi": resource.MustParse("2Gi"), "hugepages-2Mi": resource.MustParse("512Mi")},
+			Limits:   corev1.ResourceList{"hugepages-1Gi": resource.MustParse("2Gi"), "hugepages-2Mi": resource.MustParse("512Mi")},
+		}
+
+		It("should produce distinct file names for 1Gi and 2Mi hugepages", func() {
+			containers := []corev1.Container{{Name: "app", Resources: hugepagesResources}}
+			_, hugepages := processHugepagesForDownwardAPI(nil, containers, containersPath, nil)
+			Expect(hugepages).To(ConsistOf(
+				hugepageResourceData{ResourceName: "requests.hugepages-1Gi", ContainerName: "app", Path: "hugepages_1G_request_app"},
+				hugepageResourceData{ResourceName: "limits.hugepages-1Gi", ContainerName: "app", Path: "hugepages_1G_limit_app"},
+				hugepageResourceData{ResourceName: "requests.hugepages-2Mi", ContainerName: "app", Path: "hugepages_2M_request_app"},
+				hugepageResourceData{ResourceName: "limits.hugepages-2Mi", ContainerName: "app", Path: "hugepages_2M_limit_app"},
+			))
+		})
+
+		It("should not collide for container names differing only by a dash", func() {
+			containers := []corev1.Container{
+				{Name: "app-1", Resources: hugepagesResources},
+				{Name: "app1", Resources: hugepagesResources},
+			}
+			_, hugepages := processHugepagesForDownwardAPI(nil, containers, containersPath, nil)
+			paths := map[string]bool{}
+			for _, hugepage := range hugepages {
+				paths[hugepage.Path] = true
+			}
+			Expect(paths).To(HaveLen(8))
+			Expect(paths).To(HaveKey("hugepages_1G_request_app-1"))
+			Expect(paths).To(HaveKey("hugepages_1G_request_app1"))
+		})
+
+		It("should sanitize container name and keep paths unique", func() {
+			Expect(sanitizeDownwardAPIPathElement("app/../x")).To(Equal("app_.._x"))
+
+			existing := []hugepageResourceData{{ResourceName: "requests.hugepages-1Gi", ContainerName: "app_x", Path: "hugepages_1G_request_app_x"}}
+			Expect(hugepageDownwardAPIPath(existing, nritypes.Hugepages1GRequestPath, "app x")).To(Equal("hugepages_1G_request_app_x_1"))
+			Expect(hugepageDownwardAPIPath(existing, nritypes.Hugepages1GRequestPath, "app_x")).To(Equal("hugepages_1G_request_app_x"))
+		})
+	})
+	Describe("Hugepages Downward API resource references", func() {
+		hugepageItems := func(patch []nritypes.JsonPatchOperation) []string {
+			resources := []string{}
+			for _, operation := range patch {
+				if volume, ok := operation.Value.(corev1.Volume); ok && volume.DownwardAPI != nil {
+					for _, item := range volume.DownwardAPI.Items {
+						if item.ResourceFieldRef != nil {
+							resources = append(resources, item.ResourceFieldRef.ContainerName+"/"+item.ResourceFieldRef.Resource)
+						}
+					}
+				}
+			}
+			return resources
+		}
+
+		DescribeTable("should reference only values set on the container",
+			func(resources corev1.ResourceRequirements, expected []string) {
+				pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: resources}}}}
+				_, hugepages := processHugepagesForDownwardAPI(nil, pod.Spec.Containers, containersPath, nil)
+				patch := addVolDownwardAPI(nil, hugepages, pod, "podnetinfo")
+				Expect(hugepageItems(patch)).To(ConsistOf(expected))
+			},
+			Entry("request only",
+				corev1.ResourceRequirements{Requests: corev1.ResourceList{"hugepages-1Gi": resource.MustParse("1Gi")}},
+				[]string{"app/requests.hugepages-1Gi"}),
+			Entry("limit only",
+				corev1.ResourceRequirements{Limits: corev1.ResourceList{"hugepages-2Mi": resource.MustParse("512Mi")}},
+				[]string{"app/limits.hugepages-2Mi"}),
+			Entry("request and limit",
+				corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{"hugepages-1Gi": resource.MustParse("1Gi")},
+					Limits:   corev1.ResourceList{"hugepages-1Gi": resource.MustParse("1Gi")},
+				},
+				[]string{"app/requests.hugepages-1Gi", "app/limits.hugepages-1Gi"}),
+		)
+
+		It("should skip references to values missing on the container", func() {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "setup", Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{"hugepages-2Mi": resource.MustParse("512Mi")},
+				}}},
+				Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{"hugepages-1Gi": resource.MustParse("0")},
+				}}},
+			}}
+			hugepages := []hugepageResourceData{
+				{ResourceName: "limits.hugepages-2Mi", ContainerName: "setup", Path: "hugepages_2M_limit_setup"},
+				{ResourceName: "requests.hugepages-2Mi", ContainerName: "setup", Path: "hugepages_2M_request_setup"},
+				{ResourceName: "requests.hugepages-1Gi", ContainerName: "app", Path: "hugepages_1G_request_app"},
+				{ResourceName: "limits.hugepages-1Gi", ContainerName: "missing", Path: "hugepages_1G_limit_missing"},
+			}
+			patch := addVolDownwardAPI(nil, hugepages, pod, "podnetinfo")
+			Expect(hugepageItems(patch)).To(ConsistOf("setup/limits.hugepages-2Mi"))
+		})
+	})
+	Describe("Resource name override", func() {
+		podWithOverride := func(overrides string) corev1.Pod {
+			return corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				Annotations: map[string]string{"k8s.v1.cni.cncf.io/resourceNameOverride": overrides},
+			}}
+		}
+
+		It("should replace resource name resolved from net-attach-def", func() {
+			reqs := map[string]int64{"intel.com/sriov": 2, "intel.com/other": 1}
+			result, err := applyResourceNameOverride(podWithOverride("intel.com/sriov=intel.com/sriov_new_pool"), reqs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(map[string]int64{"intel.com/sriov_new_pool": 2, "intel.com/other": 1}))
+			// original requests are not modified
+			Expect(reqs).To(HaveKey("intel.com/sriov"))
+		})
+
+		It("should merge count when override targets already requested resource", func() {
+			reqs := map[string]int64{"intel.com/sriov": 2, "intel.com/other": 1}
+			result, err := applyResourceNameOverride(podWithOverride("intel.com/other=intel.com/sriov"), reqs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(map[string]int64{"intel.com/sriov": 3}))
+		})
+
+		It("should ignore override of resource which is not requested", func() {
+			reqs := map[string]int64{"intel.com/sriov": 1}
+			result, err := applyResourceNameOverride(podWithOverride("intel.com/other=intel.com/new"), reqs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(reqs))
+		})
+
+		It("should not modify requests when annotation is missing", func() {
+			reqs := map[string]int64{"intel.com/sriov": 1}
+			result, err := applyResourceNameOverride(corev1.Pod{}, reqs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(reqs))
+		})
+
+		It("should fail on malformed annotation", func() {
+			_, err := applyResourceNameOverride(podWithOverride("intel.com/sriov"), map[string]int64{"intel.com/sriov": 1})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should be gated by control switch", func() {
+			Expect(setupControlSwitches(nil).IsResourceNameOverrideEnabled()).To(BeFalse())
+			Expect(setupControlSwitches(map[string]bool{"enableResourceNameOverride": true}).IsResourceNameOverrideEnabled()).To(BeTrue())
+			setupControlSwitches(nil)
+		})
+	})
+	Describe("Reading large requests", func() {
+		var largeBody []byte
+
+		BeforeEach(func() {
+			pod := corev1.Pod{
+				TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "large",
+					Namespace:   "default",
+					Annotations: map[string]string{"example.com/data": strings.Repeat("x", 2<<20)},
+				},
+			}
+			podBytes, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			ar := admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+				Request: &admissionv1.AdmissionRequest{
+					UID:    "large-request",
+					Object: runtime.RawExtension{Raw: podBytes},
+				},
+			}
+			largeBody, err = json.Marshal(ar)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(resetWebhook)
+
+		newRequest := func(body []byte) *http.Request {
+			req := httptest.NewRequest("POST", "https://fakewebhook/mutate", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			return req
+		}
+
+		It("should reject body above the default limit", func() {
+			setupControlSwitches(nil)
+			_, status, err := defaultWebhook.readAdmissionReview(newRequest(largeBody), httptest.NewRecorder())
+			Expect(err).To(HaveOccurred())
+			Expect(status).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should decode large but valid body as a stream", func() {
+			setupControlSwitches(nil).SetRequestBodyStreamingUnitTests(true, controlswitches.DefaultStreamedRequestBodyLimit)
+			ar, status, err := defaultWebhook.readAdmissionReview(newRequest(largeBody), httptest.NewRecorder())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(string(ar.Request.UID)).To(Equal("large-request"))
+
+			pod, err := defaultWebhook.deserializePod(context.Background(), ar)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Annotations["example.com/data"]).To(HaveLen(2 << 20))
+		})
+
+		It("should reject streamed body above the streamed limit", func() {
+			setupControlSwitches(nil).SetRequestBodyStreamingUnitTests(true, controlswitches.DefaultRequestBodyLimit)
+			_, status, err := defaultWebhook.readAdmissionReview(newRequest(largeBody), httptest.NewRecorder())
+			Expect(err).To(HaveOccurred())
+			Expect(status).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should reject streamed body which is not an AdmissionReview", func() {
+			setupControlSwitches(nil).SetRequestBodyStreamingUnitTests(true, controlswitches.DefaultStreamedRequestBodyLimit)
+			_, status, err := defaultWebhook.readAdmissionReview(newRequest([]byte(`{"kind": "Pod"}`)), httptest.NewRecorder())
+			Expect(err).To(HaveOccurred())
+			Expect(status).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should decode streamed v1beta1 AdmissionReview", func() {
+			setupControlSwitches(nil).SetRequestBodyStreamingUnitTests(true, controlswitches.DefaultStreamedRequestBodyLimit)
+			body := []byte(`{"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": {"uid": "fake-uid"}}`)
+			ar, status, err := defaultWebhook.readAdmissionReview(newRequest(body), httptest.NewRecorder())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(ar.APIVersion).To(Equal("admission.k8s.io/v1beta1"))
+		})
+
+		It("should reject streamed AdmissionReview of unsupported version", func() {
+			setupControlSwitches(nil).SetRequestBodyStreamingUnitTests(true, controlswitches.DefaultStreamedRequestBodyLimit)
+			body := []byte(`{"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v2", "request": {"uid": "fake-uid"}}`)
+			_, status, err := defaultWebhook.readAdmissionReview(newRequest(body), httptest.NewRecorder())
+			Expect(err).To(HaveOccurred())
+			Expect(status).To(Equal(http.StatusBadRequest))
+		})
+	})
+	Describe("Workload controllers", func() {
+		deploymentKind := metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+		deployment := appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+				},
+			},
+		}
+
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should not mutate controller when disabled", func() {
+			setupControlSwitches(nil)
+			response := mutate(deploymentKind, deployment)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeEmpty())
+		})
+
+		It("should patch pod template of the controller", func() {
+			setupControlSwitches(map[string]bool{"enableWorkloadControllers": true})
+			response := mutate(deploymentKind, deployment)
+			Expect(response.Allowed).To(BeTrue())
+
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			paths := []string{}
+			for _, operation := range patch {
+				paths = append(paths, operation.Path)
+			}
+			Expect(paths).To(ContainElement("/spec/template/spec/containers/0/resources/requests/intel.com~1sriov"))
+			Expect(paths).To(ContainElement("/spec/template/spec/containers/0/resources/limits/intel.com~1sriov"))
+			Expect(paths).To(ContainElement("/spec/template/spec/volumes"))
+			for _, path := range paths {
+				Expect(path).To(HavePrefix("/spec/template/"))
+			}
+		})
+
+		It("should keep patch paths of pods unchanged", func() {
+			setupControlSwitches(map[string]bool{"enableWorkloadControllers": true})
+			pod := corev1.Pod{
+				ObjectMeta: deployment.Spec.Template.ObjectMeta,
+				Spec:       deployment.Spec.Template.Spec,
+			}
+			response := mutate(podKind, pod)
+			Expect(response.Allowed).To(BeTrue())
+
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			Expect(patch).To(ContainElement(HaveField("Path", "/spec/containers/0/resources/requests/intel.com~1sriov")))
+		})
+	})
+	Describe("Net-attach-def lookup retries", func() {
+		var server *httptest.Server
+		var attempts int
+		var failures []int
+
+		BeforeEach(func() {
+			attempts = 0
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.Header().Set("Content-Type", "application/json")
+				if attempts <= len(failures) {
+					w.WriteHeader(failures[attempts-1])
+					w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure"}`))
+					return
+				}
+				w.Write([]byte(`{"kind": "NetworkAttachmentDefinition", "apiVersion": "k8s.cni.cncf.io/v1",
+					"metadata": {"name": "sriov-net", "namespace": "default"}}`))
+			}))
+			var err error
+			defaultWebhook.clientset, err = kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+			setupControlSwitches(nil).SetNadLookupRetriesUnitTests(3, time.Millisecond)
+		})
+
+		AfterEach(func() {
+			server.Close()
+			defaultWebhook.clientset = nil
+			failures = nil
+			setupControlSwitches(nil)
+		})
+
+		It("should retry transient errors", func() {
+			failures = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+			nad, err := defaultWebhook.getNetworkAttachmentDefinition(context.Background(), "default", "sriov-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nad.Name).To(Equal("sriov-net"))
+			Expect(attempts).To(Equal(3))
+		})
+
+		It("should give up after configured number of retries", func() {
+			failures = []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}
+			_, err := defaultWebhook.getNetworkAttachmentDefinition(context.Background(), "default", "sriov-net")
+			Expect(err).To(HaveOccurred())
+			Expect(attempts).To(Equal(4))
+		})
+
+		It("should fail fast on not found", func() {
+			failures = []int{http.StatusNotFound}
+			_, err := defaultWebhook.getNetworkAttachmentDefinition(context.Background(), "default", "sriov-net")
+			Expect(err).To(HaveOccurred())
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("should report exhausted transient errors as server errors", func() {
+			failures = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+			_, err := defaultWebhook.getNetworkAttachmentDefinition(context.Background(), "default", "sriov-net")
+			Expect(isServerError(err)).To(BeTrue())
+		})
+
+		It("should not report missing net-attach-def as server error", func() {
+			failures = []int{http.StatusNotFound}
+			_, err := defaultWebhook.getNetworkAttachmentDefinition(context.Background(), "default", "sriov-net")
+			Expect(isServerError(err)).To(BeFalse())
+		})
+
+		It("should record lookup latency including retries", func() {
+			failures = []int{http.StatusServiceUnavailable}
+			ctx, latency := withLookupLatency(context.Background())
+			_, err := defaultWebhook.getNetworkAttachmentDefinition(ctx, "default", "sriov-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(latency.slowest).To(Equal("default/sriov-net"))
+			Expect(latency.total).To(BeNumerically(">=", time.Millisecond))
+		})
+
+		It("should not retry past the deadline", func() {
+			failures = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+			setupControlSwitches(nil).SetNadLookupRetriesUnitTests(3, time.Minute)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err := defaultWebhook.getNetworkAttachmentDefinition(ctx, "default", "sriov-net")
+			Expect(err).To(HaveOccurred())
+			Expect(attempts).To(Equal(1))
+		})
+	})
+
+	DescribeTable("API lookup timeout",
+		func(url string, configured, expected time.Duration) {
+			setupControlSwitches(nil).SetLookupTimeoutUnitTests(configured)
+			defer setupControlSwitches(nil)
+			Expect(defaultWebhook.apiLookupTimeout(httptest.NewRequest("POST", url, nil))).To(Equal(expected))
+		},
+		Entry("default webhook timeout", "https://fakewebhook/mutate", time.Duration(0), 9*time.Second),
+		Entry("timeout sent by API server", "https://fakewebhook/mutate?timeout=5s", time.Duration(0), 4*time.Second),
+		Entry("short timeout", "https://fakewebhook/mutate?timeout=1s", time.Duration(0), 500*time.Millisecond),
+		Entry("shorter configured timeout", "https://fakewebhook/mutate?timeout=5s", 2*time.Second, 2*time.Second),
+		Entry("longer configured timeout", "https://fakewebhook/mutate?timeout=5s", 8*time.Second, 4*time.Second),
+	)
+	Describe("Companion resources", func() {
+		BeforeEach(func() {
+			SetNetAttachDefCache(fakeNetAttachDefCache{annotations: map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/other-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/other"},
+			}})
+			setupControlSwitches(nil).SetCompanionResourcesUnitTests(map[string][]controlswitches.CompanionResource{
+				"intel.com/sriov": {{ResourceName: "intel.com/mgmt", Ratio: 2}},
+			})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should request companion resource alongside the resource", func() {
+			netResources := newNetworkResources()
+			for _, name := range []string{"sriov-net", "sriov-net", "other-net"} {
+				err := defaultWebhook.parseNetworkAttachDefinition(context.Background(), &types.NetworkSelectionElement{Namespace: "default", Name: name}, 1,
+					netResources)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(netResources.reqs).To(Equal(map[string]int64{"intel.com/sriov": 2, "intel.com/mgmt": 4, "intel.com/other": 1}))
+		})
+	})
+	Describe("Network resources computation", func() {
+		nadAnnotations := map[string]map[string]string{
+			"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			"default/other-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/other", "k8s.v1.cni.cncf.io/nodeSelector": "nic=other"},
+			"default/zone-net":  {"k8s.v1.cni.cncf.io/nodeSelector": "zone=a,rack in (r1,r2)"},
+			"default/plain-net": {},
+		}
+		network := func(name string) *types.NetworkSelectionElement {
+			return &types.NetworkSelectionElement{Namespace: "default", Name: name}
+		}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, nil)
+		})
+
+		DescribeTable("should compute resources and node selectors of the networks",
+			func(names []string, expectedReqs map[string]int64, expectedNsMap map[string]string, expectedAffinity int) {
+				networks := []*types.NetworkSelectionElement{}
+				for _, name := range names {
+					networks = append(networks, network(name))
+				}
+				reqs, nsMap, nodeAffinity, _, err := wh.computeNetworkResources(networks, nil, nadAnnotations, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reqs).To(Equal(expectedReqs))
+				Expect(nsMap).To(Equal(expectedNsMap))
+				Expect(nodeAffinity).To(HaveLen(expectedAffinity))
+			},
+			Entry("no networks", []string{}, map[string]int64{}, map[string]string{}, 0),
+			Entry("network without resource", []string{"plain-net"}, map[string]int64{}, map[string]string{}, 0),
+			Entry("single network", []string{"sriov-net"}, map[string]int64{"intel.com/sriov": 1}, map[string]string{}, 0),
+			Entry("repeated network", []string{"sriov-net", "sriov-net"}, map[string]int64{"intel.com/sriov": 2}, map[string]string{}, 0),
+			Entry("networks with node selectors", []string{"sriov-net", "other-net", "zone-net"},
+				map[string]int64{"intel.com/sriov": 1, "intel.com/other": 1}, map[string]string{"nic": "other", "zone": "a"}, 1),
+		)
+
+		It("should not modify the annotations of net-attach-defs", func() {
+			_, nsMap, _, _, err := wh.computeNetworkResources([]*types.NetworkSelectionElement{network("other-net")}, nil, nadAnnotations, nil)
+			Expect(err).NotTo(HaveOccurred())
+			nsMap["nic"] = "changed"
+			Expect(nadAnnotations["default/other-net"]).To(HaveKeyWithValue("k8s.v1.cni.cncf.io/nodeSelector", "nic=other"))
+		})
+
+		It("should fail when annotations of net-attach-def are missing", func() {
+			_, _, _, _, err := wh.computeNetworkResources([]*types.NetworkSelectionElement{network("missing-net")}, nil, nadAnnotations, nil)
+			Expect(err).To(MatchError("could not find network attachment definition 'default/missing-net'"))
+		})
+
+		It("should request resource once when more resource name keys of net-attach-def carry it", func() {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false),
+				createString("k8s.v1.cni.cncf.io/resourceName,example.com/resourceName"))
+			switches.InitControlSwitches()
+			wh = NewWebhook(nil, switches)
+			annotations := map[string]map[string]string{
+				"default/dual-net":  {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov", "example.com/resourceName": "intel.com/sriov"},
+				"default/split-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov", "example.com/resourceName": "intel.com/other"},
+			}
+			reqs, _, _, _, err := wh.computeNetworkResources([]*types.NetworkSelectionElement{network("dual-net")}, nil, annotations, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reqs).To(Equal(map[string]int64{"intel.com/sriov": 1}))
+
+			reqs, _, _, _, err = wh.computeNetworkResources([]*types.NetworkSelectionElement{network("dual-net"), network("split-net")}, nil, annotations, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reqs).To(Equal(map[string]int64{"intel.com/sriov": 2, "intel.com/other": 1}))
+		})
+
+		DescribeTable("should request every resource of comma separated resource name annotation",
+			func(resourceName string, expectedReqs map[string]int64) {
+				annotations := map[string]map[string]string{"default/multi-net": {"k8s.v1.cni.cncf.io/resourceName": resourceName}}
+				reqs, _, _, _, err := wh.computeNetworkResources([]*types.NetworkSelectionElement{network("multi-net")}, nil, annotations, nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reqs).To(Equal(expectedReqs))
+			},
+			Entry("single resource", "intel.com/sriov_a", map[string]int64{"intel.com/sriov_a": 1}),
+			Entry("two resources", "intel.com/sriov_a,intel.com/sriov_b", map[string]int64{"intel.com/sriov_a": 1, "intel.com/sriov_b": 1}),
+			Entry("spaces around resources", " intel.com/sriov_a , intel.com/sriov_b ", map[string]int64{"intel.com/sriov_a": 1, "intel.com/sriov_b": 1}),
+			Entry("repeated resource", "intel.com/sriov_a,intel.com/sriov_a", map[string]int64{"intel.com/sriov_a": 1}),
+			Entry("empty items", ",intel.com/sriov_a,,", map[string]int64{"intel.com/sriov_a": 1}),
+			Entry("empty annotation", "", map[string]int64{}),
+			Entry("whitespace annotation", " , ", map[string]int64{}),
+		)
+
+		It("should request resources of comma separated resource name annotation replicas times", func() {
+			annotations := map[string]map[string]string{
+				"default/multi-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov_a,intel.com/sriov_b"},
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			}
+			multiNet := network("multi-net")
+			reqs, _, _, _, err := wh.computeNetworkResources([]*types.NetworkSelectionElement{multiNet, network("sriov-net")},
+				networkReplicas{multiNet: 2}, annotations, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reqs).To(Equal(map[string]int64{"intel.com/sriov_a": 2, "intel.com/sriov_b": 2, "intel.com/sriov": 1}))
+		})
+
+		It("should fail when resource name annotation holds invalid resource name", func() {
+			annotations := map[string]map[string]string{"default/multi-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov_a,intel.com/sriov b"}}
+			_, _, _, _, err := wh.computeNetworkResources([]*types.NetworkSelectionElement{network("multi-net")}, nil, annotations, nil)
+			Expect(err).To(MatchError(ContainSubstring(
+				"invalid annotation 'k8s.v1.cni.cncf.io/resourceName' of net-attach-def 'default/multi-net': invalid resource name 'intel.com/sriov b'")))
+		})
+	})
+	Describe("Injected resources annotation", func() {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net,sriov-net"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should not annotate pod when disabled", func() {
+			setupControlSwitches(map[string]bool{"enableIdempotency": false})
+			Expect(annotationsOf(mutate(podKind, pod))).To(BeNil())
+		})
+
+		It("should annotate pod with injected resources", func() {
+			setupControlSwitches(map[string]bool{"enableInjectedResourcesAnnotation": true})
+			annotations := annotationsOf(mutate(podKind, pod))
+			Expect(annotations).To(HaveKeyWithValue("network-resources-injector.io/injected-resources", `{"intel.com/sriov":2}`))
+			Expect(annotations).To(HaveKeyWithValue("k8s.v1.cni.cncf.io/networks", "sriov-net,sriov-net"))
+		})
+
+		It("should merge with user defined annotations", func() {
+			setupControlSwitches(nil)
+			annotation, err := defaultWebhook.injectedResourcesAnnotation(map[string]int64{"intel.com/sriov": 1})
+			Expect(err).NotTo(HaveOccurred())
+			userDefinedPatch := []nritypes.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/metadata/annotations",
+				Value:     map[string]interface{}{"example.com/key": "value", "network-resources-injector.io/injected-resources": "user"},
+			}}
+			patch := appendAddAnnotPatch(nil, pod, append([]nritypes.JsonPatchOperation{annotation}, userDefinedPatch...))
+			Expect(patch).To(HaveLen(1))
+			Expect(patch[0].Value).To(Equal(map[string]string{
+				"network-resources-injector.io/injected-resources": `{"intel.com/sriov":1}`,
+				"example.com/key":             "value",
+				"k8s.v1.cni.cncf.io/networks": "sriov-net,sriov-net",
+			}))
+		})
+	})
+	Describe("Skip reasons", func() {
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/plain-net": {"description": "network without resources"},
+			})
+		})
+
+		AfterEach(func() {
+			SetNetAttachDefCache(nil)
+			SetNamespaceCache(nil)
+			setupControlSwitches(nil)
+		})
+
+		It("should not return warnings when disabled", func() {
+			setupControlSwitches(nil)
+			response := mutate(podKind, podWithNetworks(""))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Result.Message).To(ContainSubstring("doesn't have network annotations"))
+			Expect(response.Warnings).To(BeEmpty())
+		})
+
+		DescribeTable("should return reason of each skip path",
+			func(setup func(), kind metav1.GroupVersionKind, object interface{}, reason skipReason) {
+				setupControlSwitches(map[string]bool{"enableSkipReasonWarnings": true})
+				if setup != nil {
+					setup()
+				}
+				response := mutate(kind, object)
+				Expect(response.Allowed).To(BeTrue())
+				Expect(response.Warnings).To(ConsistOf(ContainSubstring(string(reason))))
+			},
+			Entry("no network annotations", nil, podKind, podWithNetworks(""), skipNoNetworkAnnotations),
+			Entry("namespace not enabled", func() {
+				defaultWebhook.controlSwitches.SetNamespaceLabelUnitTests("network-resources-injector")
+				SetNamespaceCache(fakeNamespaceCache{"default": {}})
+			}, podKind, podWithNetworks("plain-net"), skipNamespaceNotEnabled),
+			Entry("workload controllers disabled", nil, metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+				appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}, skipWorkloadControllersDisabled),
+			Entry("no network resources", nil, podKind, podWithNetworks("plain-net"), skipNoNetworkResources),
+		)
+	})
+	Describe("Injection finalizer", func() {
+		podWithFinalizers := func(networks string, finalizers ...string) corev1.Pod {
+			pod := podWithNetworks(networks)
+			pod.ObjectMeta.Finalizers = finalizers
+			return pod
+		}
+		finalizerPatchOf := func(response *admissionv1.AdmissionResponse) []nritypes.JsonPatchOperation {
+			var patch, finalizers []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			for _, operation := range patch {
+				if strings.HasPrefix(operation.Path, "/metadata/finalizers") {
+					finalizers = append(finalizers, operation)
+				}
+			}
+			return finalizers
+		}
+
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/plain-net": {"description": "network without resources"},
+			})
+			setupControlSwitches(nil)
+			defaultWebhook.controlSwitches.SetInjectionFinalizerUnitTests("example.com/network-cleanup")
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should not add finalizer when disabled", func() {
+			defaultWebhook.controlSwitches.SetInjectionFinalizerUnitTests("")
+			Expect(finalizerPatchOf(mutate(podKind, podWithFinalizers("sriov-net")))).To(BeEmpty())
+		})
+
+		It("should add finalizer to injected pod", func() {
+			Expect(finalizerPatchOf(mutate(podKind, podWithFinalizers("sriov-net")))).To(ConsistOf(nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/metadata/finalizers",
+				Value:     []interface{}{"example.com/network-cleanup"},
+			}))
+		})
+
+		It("should append finalizer to existing finalizers", func() {
+			Expect(finalizerPatchOf(mutate(podKind, podWithFinalizers("sriov-net", "example.com/other")))).To(ConsistOf(nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/metadata/finalizers/-",
+				Value:     "example.com/network-cleanup",
+			}))
+		})
+
+		It("should not duplicate existing finalizer", func() {
+			pod := podWithFinalizers("sriov-net", "example.com/other", "example.com/network-cleanup")
+			Expect(finalizerPatchOf(mutate(podKind, pod))).To(BeEmpty())
+		})
+
+		It("should not add finalizer to pod without injected resources", func() {
+			Expect(finalizerPatchOf(mutate(podKind, podWithFinalizers("plain-net")))).To(BeEmpty())
+		})
+	})
+	Describe("Topology hints", func() {
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/numa-net-a": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov_a", "k8s.v1.cni.cncf.io/topologyAware": "true"},
+				"default/numa-net-b": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov_b", "k8s.v1.cni.cncf.io/topologyAware": "true"},
+				"default/sriov-net":  {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/plain-net":  {"k8s.v1.cni.cncf.io/topologyAware": "false"},
+				"default/bad-net":    {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov", "k8s.v1.cni.cncf.io/topologyAware": "yes please"},
+			})
+			setupControlSwitches(map[string]bool{"enableTopologyHints": true})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should not inject hint when disabled", func() {
+			setupControlSwitches(map[string]bool{"enableIdempotency": false})
+			Expect(annotationsOf(mutate(podKind, podWithNetworks("numa-net-a")))).To(BeNil())
+		})
+
+		It("should inject hint when all networks requesting resources are topology aware", func() {
+			annotations := annotationsOf(mutate(podKind, podWithNetworks("numa-net-a,numa-net-b,plain-net")))
+			Expect(annotations).To(HaveKeyWithValue("network-resources-injector.io/topology-aware", "true"))
+			Expect(annotations).To(HaveKeyWithValue("k8s.v1.cni.cncf.io/networks", "numa-net-a,numa-net-b,plain-net"))
+		})
+
+		It("should not inject hint when networks disagree on topology awareness", func() {
+			setupControlSwitches(map[string]bool{"enableTopologyHints": true, "enableIdempotency": false})
+			Expect(annotationsOf(mutate(podKind, podWithNetworks("numa-net-a,sriov-net")))).To(BeNil())
+		})
+
+		It("should deny pod when net-attach-def topology awareness is invalid", func() {
+			response := mutate(podKind, podWithNetworks("bad-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("invalid topology awareness"))
+		})
+
+		DescribeTable("topology hint annotation",
+			func(topologyAware map[string]bool, expected bool) {
+				_, ok := defaultWebhook.topologyHintAnnotation(topologyAware)
+				Expect(ok).To(Equal(expected))
+			},
+			Entry("no networks requesting resources", map[string]bool{}, false),
+			Entry("all networks topology aware", map[string]bool{"default/a": true, "default/b": true}, true),
+			Entry("networks disagree", map[string]bool{"default/a": true, "default/b": false}, false),
+			Entry("no network topology aware", map[string]bool{"default/a": false}, false),
+		)
+	})
+	Describe("Honor existing resources policy", func() {
+		containersWith := func(quantities ...int64) []corev1.Container {
+			var containers []corev1.Container
+			for i, quantity := range quantities {
+				resources := corev1.ResourceList{"intel.com/sriov": *resource.NewQuantity(quantity, resource.DecimalSI)}
+				containers = append(containers, corev1.Container{
+					Name:      fmt.Sprintf("app%d", i),
+					Resources: corev1.ResourceRequirements{Requests: resources, Limits: resources},
+				})
+			}
+			return containers
+		}
+		injected := func(patch []nritypes.JsonPatchOperation) []string {
+			var values []string
+			for _, operation := range patch {
+				if strings.HasSuffix(operation.Path, "intel.com~1sriov") {
+					quantity := operation.Value.(resource.Quantity)
+					values = append(values, operation.Path+"="+quantity.String())
+				}
+			}
+			return values
+		}
+
+		AfterEach(resetWebhook)
+
+		DescribeTable("should combine injected resources with existing ones of three containers",
+			func(policy string, count int64, expected int) {
+				setupControlSwitches(map[string]bool{"enableHonorExistingResources": true})
+				defaultWebhook.controlSwitches.SetHonorResourcesPolicyUnitTests(policy)
+				patch := defaultWebhook.updateResourcePatch(nil, containersWith(2, 5, 1), map[string]int64{"intel.com/sriov": count})
+				if expected == 0 {
+					Expect(injected(patch)).To(BeEmpty())
+					return
+				}
+				Expect(injected(patch)).To(ConsistOf(
+					fmt.Sprintf("/spec/containers/0/resources/requests/intel.com~1sriov=%d", expected),
+					fmt.Sprintf("/spec/containers/0/resources/limits/intel.com~1sriov=%d", expected),
+				))
+			},
+			Entry("sum adds to the target container", controlswitches.HonorResourcesPolicySum, int64(3), 5),
+			Entry("sum adds to the target container requesting more", controlswitches.HonorResourcesPolicySum, int64(1), 3),
+			Entry("max keeps larger injected quantity", controlswitches.HonorResourcesPolicyMax, int64(3), 3),
+			Entry("max keeps larger existing quantity", controlswitches.HonorResourcesPolicyMax, int64(1), 0),
+			Entry("topup skips when containers request enough", controlswitches.HonorResourcesPolicyTopUp, int64(8), 0),
+			Entry("topup adds missing quantity to the target container", controlswitches.HonorResourcesPolicyTopUp, int64(10), 4),
+		)
+
+		It("should add resource missing in all containers", func() {
+			setupControlSwitches(map[string]bool{"enableHonorExistingResources": true})
+			defaultWebhook.controlSwitches.SetHonorResourcesPolicyUnitTests(controlswitches.HonorResourcesPolicyTopUp)
+			patch := defaultWebhook.updateResourcePatch(nil, containersWith(2, 5, 1), map[string]int64{"intel.com/other": 2})
+			Expect(patch).To(ContainElement(nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/spec/containers/0/resources/requests/intel.com~1other",
+				Value:     *resource.NewQuantity(2, resource.DecimalSI),
+			}))
+		})
+	})
+	Describe("Network deduplication", func() {
+		requestedDevices := func(networks string) string {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": networks},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(mutate(podKind, pod).Patch, &patch)).To(Succeed())
+			for _, operation := range patch {
+				if operation.Path == "/spec/containers/0/resources/requests/intel.com~1sriov" {
+					return operation.Value.(string)
+				}
+			}
+			return ""
+		}
+
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"other/sriov-net":   {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should count every selection when disabled", func() {
+			setupControlSwitches(nil)
+			Expect(requestedDevices("sriov-net,sriov-net")).To(Equal("2"))
+		})
+
+		DescribeTable("should request resources once for identical selections when enabled",
+			func(networks, expected string) {
+				setupControlSwitches(map[string]bool{"enableNetworkDeduplication": true})
+				Expect(requestedDevices(networks)).To(Equal(expected))
+			},
+			Entry("same network", "sriov-net,sriov-net", "1"),
+			Entry("same network with explicit namespace", "sriov-net,default/sriov-net", "1"),
+			Entry("same network and interface", "sriov-net@net1,sriov-net@net1", "1"),
+			Entry("same network with different interfaces", "sriov-net@net1,sriov-net@net2", "2"),
+			Entry("same name in different namespaces", "sriov-net,other/sriov-net", "2"),
+		)
+	})
+	Describe("Owner reference namespace", func() {
+		var server *httptest.Server
+		var requests []*http.Request
+		var wh *Webhook
+		ownerRef := metav1.OwnerReference{Kind: "ReplicaSet", Name: "app-rs", UID: "rs-uid"}
+
+		BeforeEach(func() {
+			requests = nil
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"kind": "ReplicaSetList", "apiVersion": "apps/v1", "items": [
+					{"metadata": {"name": "app-rs", "namespace": "other", "uid": "other-uid"}},
+					{"metadata": {"name": "app-rs", "namespace": "apps", "uid": "rs-uid"}}]}`))
+			}))
+			wh = newTestWebhook(nil, nil)
+			var err error
+			wh.clientset, err = kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should resolve namespace from owner cache", func() {
+			wh.SetOwnerCache(fakeOwnerCache{"ReplicaSet/rs-uid": "cached"})
+			namespace, err := wh.getNamespaceFromOwnerReference(context.Background(), ownerRef)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namespace).To(Equal("cached"))
+			Expect(requests).To(BeEmpty())
+		})
+
+		It("should list owners by name on cache miss", func() {
+			wh.SetOwnerCache(fakeOwnerCache{})
+			namespace, err := wh.getNamespaceFromOwnerReference(context.Background(), ownerRef)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namespace).To(Equal("apps"))
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].URL.Query().Get("fieldSelector")).To(Equal("metadata.name=app-rs"))
+		})
+
+		It("should fail when no owner matches the UID", func() {
+			_, err := wh.getNamespaceFromOwnerReference(context.Background(), metav1.OwnerReference{Kind: "ReplicaSet", Name: "app-rs", UID: "unknown"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should list Jobs owning pods", func() {
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"kind": "JobList", "apiVersion": "batch/v1", "items": [
+					{"metadata": {"name": "backup-28000000", "namespace": "ops", "uid": "job-uid"}}]}`))
+			})
+			namespace, err := wh.getNamespaceFromOwnerReference(context.Background(),
+				metav1.OwnerReference{Kind: "Job", Name: "backup-28000000", UID: "job-uid"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namespace).To(Equal("ops"))
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].URL.Path).To(Equal("/apis/batch/v1/jobs"))
+		})
+
+		It("should not resolve namespace from owner kind which is not enabled", func() {
+			wh.controlSwitches.SetOwnerKindsUnitTests("DaemonSet")
+			wh.SetOwnerCache(fakeOwnerCache{"ReplicaSet/rs-uid": "cached"})
+			namespace, err := wh.getNamespaceFromOwnerReference(context.Background(), ownerRef)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namespace).To(BeEmpty())
+			Expect(requests).To(BeEmpty())
+		})
+
+		It("should list owners of every supported kind", func() {
+			for _, kind := range controlswitches.SupportedOwnerKinds {
+				Expect(ownerListers).To(HaveKey(kind))
+			}
+		})
+	})
+	Describe("Allowed resource prefixes", func() {
+		parse := func(names ...string) (map[string]int64, error) {
+			netResources := newNetworkResources()
+			for _, name := range names {
+				err := defaultWebhook.parseNetworkAttachDefinition(context.Background(), &types.NetworkSelectionElement{Namespace: "default", Name: name}, 1,
+					netResources)
+				if err != nil {
+					return netResources.reqs, err
+				}
+			}
+			return netResources.reqs, nil
+		}
+
+		BeforeEach(func() {
+			SetNetAttachDefCache(fakeNetAttachDefCache{annotations: map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/rogue-net": {"k8s.v1.cni.cncf.io/resourceName": "example.com/anything"},
+			}})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should allow any resource by default", func() {
+			setupControlSwitches(nil)
+			reqs, err := parse("sriov-net", "rogue-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reqs).To(Equal(map[string]int64{"intel.com/sriov": 1, "example.com/anything": 1}))
+		})
+
+		It("should skip resource outside of allowed prefixes", func() {
+			setupControlSwitches(nil).SetAllowedResourcePrefixesUnitTests([]string{"openshift.io/", "intel.com/"},
+				controlswitches.DisallowedResourceSkip)
+			reqs, err := parse("sriov-net", "rogue-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reqs).To(Equal(map[string]int64{"intel.com/sriov": 1}))
+		})
+
+		It("should deny resource outside of allowed prefixes when configured", func() {
+			setupControlSwitches(nil).SetAllowedResourcePrefixesUnitTests([]string{"intel.com/"}, controlswitches.DisallowedResourceDeny)
+			_, err := parse("sriov-net", "rogue-net")
+			Expect(err).To(MatchError(ContainSubstring("'example.com/anything' of network attachment definition 'default/rogue-net' is not allowed")))
+		})
+	})
+	Describe("User defined volumes", func() {
+		hugepageVolume := corev1.Volume{Name: "hugepage", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: "HugePages"}}}
+		socketVolume := corev1.Volume{Name: "dp-socket", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/lib/kubelet"}}}
+		userDefinedPatch := []nritypes.JsonPatchOperation{{
+			Operation: "add",
+			Path:      "/spec/volumes",
+			Value: []interface{}{
+				map[string]interface{}{"name": "hugepage", "emptyDir": map[string]interface{}{"medium": "HugePages"}, "mountPath": "/hugepages"},
+				map[string]interface{}{"name": "dp-socket", "hostPath": map[string]interface{}{"path": "/var/lib/kubelet"}},
+				map[string]interface{}{"name": "podnetinfo", "emptyDir": map[string]interface{}{}, "mountPath": "/podnetinfo"},
+			},
+		}}
+
+		BeforeEach(func() {
+			setupControlSwitches(nil)
+		})
+
+		It("should add volumes next to Downward API volume and mount them into every container", func() {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app"},
+				{Name: "sidecar", VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/hugepages"}}},
+			}}}
+			patch := defaultWebhook.createVolPatch(nil, nil, &pod, "podnetinfo", nil)
+			existing := len(patch)
+			patch = appendUserDefinedPatch(patch, pod, userDefinedPatch)
+
+			hugepageMount := corev1.VolumeMount{Name: "hugepage", MountPath: "/hugepages"}
+			Expect(patch[existing:]).To(Equal([]nritypes.JsonPatchOperation{
+				{Operation: "add", Path: "/spec/volumes/-", Value: hugepageVolume},
+				{Operation: "add", Path: "/spec/volumes/-", Value: socketVolume},
+				{Operation: "add", Path: "/spec/containers/0/volumeMounts/-", Value: hugepageMount},
+			}))
+		})
+
+		It("should create volumes and mounts lists when pod has none", func() {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+			patch := appendUserDefinedPatch(nil, pod, userDefinedPatch)
+
+			Expect(patch).To(Equal([]nritypes.JsonPatchOperation{
+				{Operation: "add", Path: "/spec/volumes", Value: []corev1.Volume{}},
+				{Operation: "add", Path: "/spec/volumes/-", Value: hugepageVolume},
+				{Operation: "add", Path: "/spec/volumes/-", Value: socketVolume},
+				{Operation: "add", Path: "/spec/volumes/-", Value: corev1.Volume{Name: "podnetinfo",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+				{Operation: "add", Path: "/spec/containers/0/volumeMounts", Value: []corev1.VolumeMount{}},
+				{Operation: "add", Path: "/spec/containers/0/volumeMounts/-", Value: corev1.VolumeMount{Name: "hugepage", MountPath: "/hugepages"}},
+				{Operation: "add", Path: "/spec/containers/0/volumeMounts/-", Value: corev1.VolumeMount{Name: "podnetinfo", MountPath: "/podnetinfo"}},
+			}))
+		})
+
+		It("should ignore volume colliding with pod volume", func() {
+			pod := corev1.Pod{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app"}},
+				Volumes:    []corev1.Volume{{Name: "hugepage"}, {Name: "dp-socket"}, {Name: "podnetinfo"}},
+			}}
+			Expect(appendUserDefinedPatch(nil, pod, userDefinedPatch)).To(BeEmpty())
+		})
+	})
+	Describe("Partial resources", func() {
+		quantity := *resource.NewQuantity(2, resource.DecimalSI)
+		limitOnly := []corev1.Container{
+			{Name: "app"},
+			{Name: "sidecar", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{"intel.com/sriov": quantity}}},
+		}
+		requestOnly := []corev1.Container{
+			{Name: "app", Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{"intel.com/sriov": quantity},
+				Limits:   corev1.ResourceList{"cpu": quantity},
+			}},
+		}
+
+		AfterEach(resetWebhook)
+
+		It("should complete missing request by default", func() {
+			setupControlSwitches(nil)
+			patch, err := defaultWebhook.createResourcePatch(nil, limitOnly, map[string]int64{"intel.com/sriov": 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch).To(ContainElements(
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/containers/1/resources/requests", Value: corev1.ResourceList{}},
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/containers/1/resources/requests/intel.com~1sriov", Value: quantity},
+			))
+			Expect(patch).NotTo(ContainElement(HaveField("Path", "/spec/containers/0/resources/requests/intel.com~1sriov")))
+		})
+
+		It("should complete missing limit into existing limits", func() {
+			setupControlSwitches(nil)
+			patch, err := defaultWebhook.createResourcePatch(nil, requestOnly, map[string]int64{"intel.com/sriov": 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch).To(ConsistOf(
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/containers/0/resources/limits/intel.com~1sriov", Value: quantity},
+			))
+		})
+
+		It("should not complete limit only resource in limits-only patch mode", func() {
+			setupControlSwitches(nil).SetExtendedResourcePatchModeUnitTests(controlswitches.ExtendedResourcePatchModeLimitsOnly)
+			patch, err := defaultWebhook.createResourcePatch(nil, limitOnly, map[string]int64{"intel.com/sriov": 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch).NotTo(ContainElement(HaveField("Path", "/spec/containers/1/resources/requests/intel.com~1sriov")))
+		})
+
+		It("should deny partial resource when configured", func() {
+			setupControlSwitches(nil).SetPartialResourcesActionUnitTests(controlswitches.PartialResourcesDeny)
+			_, err := defaultWebhook.createResourcePatch(nil, limitOnly, map[string]int64{"intel.com/sriov": 2})
+			Expect(err).To(MatchError(ContainSubstring("container 'sidecar' sets only limits of resource 'intel.com/sriov'")))
+		})
+
+		It("should ignore resources which are not requested by networks", func() {
+			setupControlSwitches(nil).SetPartialResourcesActionUnitTests(controlswitches.PartialResourcesDeny)
+			_, err := defaultWebhook.createResourcePatch(nil, limitOnly, map[string]int64{"intel.com/other": 1})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	Describe("Resource count cap", func() {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net,sriov-net,sriov-net"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should not cap resources by default", func() {
+			setupControlSwitches(nil)
+			requests, err := defaultWebhook.capResourceRequests(map[string]int64{"intel.com/sriov": 500})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requests).To(Equal(map[string]int64{"intel.com/sriov": 500}))
+		})
+
+		It("should clamp resources above the cap", func() {
+			setupControlSwitches(nil).SetMaxResourceCountUnitTests(2, controlswitches.ResourceCapClamp)
+			requests, err := defaultWebhook.capResourceRequests(map[string]int64{"intel.com/sriov": 3, "intel.com/other": 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requests).To(Equal(map[string]int64{"intel.com/sriov": 2, "intel.com/other": 1}))
+
+			response := mutate(podKind, pod)
+			Expect(response.Allowed).To(BeTrue())
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			Expect(patch).To(ContainElement(nritypes.JsonPatchOperation{
+				Operation: "add", Path: "/spec/containers/0/resources/limits/intel.com~1sriov", Value: "2",
+			}))
+		})
+
+		It("should deny pod requesting resource above the cap", func() {
+			setupControlSwitches(nil).SetMaxResourceCountUnitTests(2, controlswitches.ResourceCapDeny)
+			response := mutate(podKind, pod)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(Equal("resource 'intel.com/sriov' is requested 3 times, at most 2 is allowed"))
+		})
+	})
+	Describe("Denial events", func() {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net,sriov-net"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+		var recorder *record.FakeRecorder
+
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+			recorder = record.NewFakeRecorder(10)
+			SetEventRecorder(recorder)
+		})
+
+		AfterEach(func() {
+			SetEventRecorder(nil)
+			SetNetAttachDefCache(nil)
+			setupControlSwitches(nil)
+		})
+
+		It("should not emit event when disabled", func() {
+			setupControlSwitches(nil).SetMaxResourceCountUnitTests(1, controlswitches.ResourceCapDeny)
+			Expect(mutate(podKind, pod).Allowed).To(BeFalse())
+			Expect(recorder.Events).To(BeEmpty())
+		})
+
+		It("should emit warning event when pod is denied", func() {
+			setupControlSwitches(map[string]bool{"enableDenialEvents": true}).SetMaxResourceCountUnitTests(1, controlswitches.ResourceCapDeny)
+			Expect(mutate(podKind, pod).Allowed).To(BeFalse())
+			Expect(recorder.Events).To(Receive(Equal(
+				"Warning NetworkResourcesInjectionDenied network resources injection denied: resource 'intel.com/sriov' is requested 2 times, at most 1 is allowed")))
+		})
+
+		It("should not emit event when pod is allowed", func() {
+			setupControlSwitches(map[string]bool{"enableDenialEvents": true})
+			Expect(mutate(podKind, pod).Allowed).To(BeTrue())
+			Expect(recorder.Events).To(BeEmpty())
+		})
+
+		It("should attach event to the pod", func() {
+			ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{Kind: podKind}}
+			Expect(denialEventReference(ar, pod)).To(Equal(&corev1.ObjectReference{
+				APIVersion: "v1", Kind: "Pod", Name: "test", Namespace: "default",
+			}))
+
+			generated := corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "test-", Namespace: "default"}}
+			Expect(denialEventReference(ar, generated).Name).To(Equal("test-"))
+		})
+
+		It("should attach event to the pod controller", func() {
+			ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{Kind: podKind}}
+			controller := true
+			owned := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "app-",
+				Namespace:    "default",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-5d4f", UID: "rs-uid", Controller: &controller},
+				},
+			}}
+			Expect(denialEventReference(ar, owned)).To(Equal(&corev1.ObjectReference{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-5d4f", UID: "rs-uid", Namespace: "default",
+			}))
+		})
+
+		It("should attach event to the mutated workload controller", func() {
+			ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+				Kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+				Name: "app",
+			}}
+			Expect(denialEventReference(ar, pod)).To(Equal(&corev1.ObjectReference{
+				APIVersion: "apps/v1", Kind: "Deployment", Name: "app", Namespace: "default",
+			}))
+		})
+	})
+	Describe("Ephemeral containers", func() {
+		downwardAPIVolume := corev1.Volume{
+			Name:         "podnetinfo",
+			VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{}},
+		}
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name: "app",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{"hugepages-1Gi": *resource.NewQuantity(1<<30, resource.BinarySI)},
+						Limits:   corev1.ResourceList{"hugepages-1Gi": *resource.NewQuantity(1<<30, resource.BinarySI)},
+					},
+				}},
+				Volumes: []corev1.Volume{downwardAPIVolume},
+				EphemeralContainers: []corev1.EphemeralContainer{{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger"},
+					TargetContainerName:      "app",
+				}},
+			},
+		}
+		volumeMountPatch := nritypes.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/spec/ephemeralContainers/0/volumeMounts/-",
+			Value:     corev1.VolumeMount{Name: "podnetinfo", ReadOnly: true, MountPath: "/etc/podnetinfo"},
+		}
+
+		updateEphemeralContainers := func(pod corev1.Pod) *admissionv1.AdmissionResponse {
+			raw, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			body, err := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+				Request: &admissionv1.AdmissionRequest{
+					UID:         "test",
+					Kind:        podKind,
+					Operation:   admissionv1.Update,
+					SubResource: "ephemeralcontainers",
+					Namespace:   "default",
+					Object:      runtime.RawExtension{Raw: raw},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			req := httptest.NewRequest("POST", "https://fakewebhook/mutate", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			MutateHandler(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			ar := admissionv1.AdmissionReview{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+			return ar.Response
+		}
+
+		AfterEach(resetWebhook)
+
+		It("should admit ephemeral containers unchanged when disabled", func() {
+			setupControlSwitches(nil)
+			response := updateEphemeralContainers(pod)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeEmpty())
+		})
+
+		It("should mount Downward API volume into ephemeral containers", func() {
+			setupControlSwitches(map[string]bool{"injectIntoEphemeralContainers": true})
+			response := updateEphemeralContainers(pod)
+			Expect(response.Allowed).To(BeTrue())
+
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			for _, operation := range patch {
+				Expect(operation.Path).To(HavePrefix("/spec/ephemeralContainers/0/"))
+			}
+			patch, ok := defaultWebhook.createEphemeralContainersPatch(pod)
+			Expect(ok).To(BeTrue())
+			Expect(patch).To(ContainElement(volumeMountPatch))
+			Expect(patch).NotTo(ContainElement(HaveField("Path", HaveSuffix("/env"))))
+		})
+
+		It("should expose target container name when hugepages are exposed via Downward API", func() {
+			setupControlSwitches(map[string]bool{"injectIntoEphemeralContainers": true, "enableHugePageDownApi": true})
+			patch, ok := defaultWebhook.createEphemeralContainersPatch(pod)
+			Expect(ok).To(BeTrue())
+			Expect(patch).To(ContainElements(volumeMountPatch, nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/spec/ephemeralContainers/0/env",
+				Value:     []corev1.EnvVar{{Name: "CONTAINER_NAME", Value: "app"}},
+			}))
+		})
+
+		It("should mount renamed Downward API volume", func() {
+			setupControlSwitches(map[string]bool{"injectIntoEphemeralContainers": true})
+			renamed := *pod.DeepCopy()
+			renamed.Spec.Volumes = []corev1.Volume{
+				{Name: "podnetinfo", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "podnetinfo-nri", VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{}}},
+			}
+			patch, ok := defaultWebhook.createEphemeralContainersPatch(renamed)
+			Expect(ok).To(BeTrue())
+			Expect(patch).To(ContainElement(HaveField("Value", HaveField("Name", "podnetinfo-nri"))))
+		})
+
+		It("should skip pod without Downward API volume", func() {
+			setupControlSwitches(map[string]bool{"injectIntoEphemeralContainers": true})
+			plain := *pod.DeepCopy()
+			plain.Spec.Volumes = nil
+			response := updateEphemeralContainers(plain)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeEmpty())
+			Expect(response.Result.Message).To(ContainSubstring("no Downward API volume"))
+		})
+	})
+	Describe("Strategic merge patch", func() {
+		It("should render strategic merge patch of JSON patch with test operations", func() {
+			original := []byte(`{"spec": {"containers": [{"name": "app", "resources": {}}]}}`)
+			patchBytes, err := json.Marshal([]nritypes.JsonPatchOperation{
+				{Operation: "test", Path: "/spec/containers/0/resources/limits", Value: absentValue},
+				{Operation: "add", Path: "/spec/containers/0/resources/limits", Value: corev1.ResourceList{}},
+				{Operation: "add", Path: "/spec/containers/0/resources/limits/intel.com~1sriov", Value: resource.MustParse("1")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			smp, err := strategicMergePatch(original, patchBytes, corev1.Pod{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(smp).To(MatchJSON(`{"spec": {"$setElementOrder/containers": [{"name": "app"}],
+				"containers": [{"name": "app", "resources": {"limits": {"intel.com/sriov": "1"}}}]}}`))
+		})
+
+		It("should fail to render strategic merge patch of JSON patch not applying", func() {
+			patchBytes, err := json.Marshal([]nritypes.JsonPatchOperation{
+				{Operation: "add", Path: "/spec/containers/1/env", Value: []corev1.EnvVar{}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = strategicMergePatch([]byte(`{"spec": {"containers": [{"name": "app"}]}}`), patchBytes, corev1.Pod{})
+			Expect(err).To(MatchError(ContainSubstring("unable to apply JSON patch")))
+		})
+
+		Context("mutating pod", func() {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+
+			BeforeEach(func() {
+				useNetAttachDefs(map[string]map[string]string{
+					"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				})
+			})
+
+			AfterEach(resetWebhook)
+
+			It("should not render strategic merge patch by default", func() {
+				setupControlSwitches(nil)
+				response := mutate(podKind, pod)
+				Expect(*response.PatchType).To(Equal(admissionv1.PatchTypeJSONPatch))
+				Expect(response.AuditAnnotations).To(BeEmpty())
+			})
+
+			It("should return strategic merge patch as audit annotation", func() {
+				setupControlSwitches(map[string]bool{"enableStrategicMergePatch": true})
+				response := mutate(podKind, pod)
+				Expect(*response.PatchType).To(Equal(admissionv1.PatchTypeJSONPatch))
+				Expect(response.AuditAnnotations).To(HaveKey("strategic-merge-patch"))
+
+				var smp map[string]interface{}
+				Expect(json.Unmarshal([]byte(response.AuditAnnotations["strategic-merge-patch"]), &smp)).To(Succeed())
+				Expect(smp).To(HaveKeyWithValue("spec", HaveKeyWithValue("containers", ContainElement(And(
+					HaveKeyWithValue("name", "app"),
+					HaveKeyWithValue("resources", HaveKeyWithValue("limits", HaveKeyWithValue("intel.com/sriov", "1"))),
+				)))))
+				Expect(smp).To(HaveKeyWithValue("spec", HaveKeyWithValue("volumes", ContainElement(HaveKeyWithValue("name", "podnetinfo")))))
+			})
+		})
+	})
+	Describe("Additional network annotation keys", func() {
+
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/other-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/other"},
+			})
+			setupControlSwitches(map[string]bool{"enableInjectedResourcesAnnotation": true}).
+				SetAdditionalNetworkAnnotationKeysUnitTests([]string{"example.com/networks"})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should inject resources of networks selected by additional annotation", func() {
+			pod := podWithAnnotations(map[string]string{"example.com/networks": "sriov-net,other-net"})
+			Expect(injectedResources(mutate(podKind, pod))).To(Equal(`{"intel.com/other":1,"intel.com/sriov":1}`))
+		})
+
+		It("should request resources of network selected by more annotations once", func() {
+			pod := podWithAnnotations(map[string]string{
+				"k8s.v1.cni.cncf.io/networks": "sriov-net,sriov-net",
+				"example.com/networks":        "sriov-net,other-net,other-net",
+			})
+			Expect(injectedResources(mutate(podKind, pod))).To(Equal(`{"intel.com/other":2,"intel.com/sriov":2}`))
+		})
+
+		It("should ignore annotation keys which are not configured", func() {
+			setupControlSwitches(map[string]bool{"enableInjectedResourcesAnnotation": true})
+			pod := podWithAnnotations(map[string]string{"example.com/networks": "sriov-net"})
+			Expect(mutate(podKind, pod).Patch).To(BeEmpty())
+		})
+	})
+	Describe("Default network", func() {
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/other-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/other"},
+			})
+		})
+
+		podWith := func(defaultNetwork string) corev1.Pod {
+			return podWithAnnotations(map[string]string{"v1.multus-cni.io/default-network": defaultNetwork})
+		}
+
+		It("should inject resources of the default network", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith("sriov-net"))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).To(ContainSubstring(`"path":"/spec/containers/0/resources/requests/intel.com~1sriov","value":"1"`))
+		})
+
+		It("should deny default network annotation selecting more networks", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith("sriov-net,other-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(Equal("annotation v1.multus-cni.io/default-network must select exactly one network, 2 selected"))
+		})
+
+		It("should admit default network annotation selecting no networks without injection", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith(`[]`))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).NotTo(ContainSubstring("/resources/requests"))
+		})
+
+		It("should inject resources of default network selected as JSON array", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith(`[{"name":"sriov-net"}]`))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).To(ContainSubstring(`"path":"/spec/containers/0/resources/requests/intel.com~1sriov","value":"1"`))
+			Expect(response.Warnings).To(BeEmpty())
+		})
+
+		It("should deny default network annotation selecting more networks as JSON array", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith(`[{"name":"sriov-net"},{"name":"other-net"}]`))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(Equal("annotation v1.multus-cni.io/default-network must select exactly one network, 2 selected"))
+		})
+
+		It("should inject resources of the first default network with warning when configured", func() {
+			wh.controlSwitches.SetDefaultNetworksActionUnitTests(controlswitches.DefaultNetworksFirst)
+
+			response := mutateWith(wh.MutateHandler, podKind, podWith(`[{"name":"sriov-net"},{"name":"other-net"}]`))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).To(ContainSubstring(`"path":"/spec/containers/0/resources/requests/intel.com~1sriov","value":"1"`))
+			Expect(string(response.Patch)).NotTo(ContainSubstring("intel.com~1other"))
+			Expect(response.Warnings).To(ConsistOf(
+				"network-resources-injector: annotation v1.multus-cni.io/default-network selects 2 networks, resources are injected for the first network default/sriov-net only"))
+		})
+	})
+	Describe("Webhook instances", func() {
+		pod := podWithNetworks("sriov-net")
+
+		newWebhook := func(patchMode string) *Webhook {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			switches.SetExtendedResourcePatchModeUnitTests(patchMode)
+			wh := NewWebhook(nil, switches)
+			wh.SetNetAttachDefCache(fakeNetAttachDefCache{annotations: map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			}})
+			return wh
+		}
+
+		It("should mutate pods according to configuration of each instance", func() {
+			limitsOnly := newWebhook(controlswitches.ExtendedResourcePatchModeLimitsOnly)
+			both := newWebhook(controlswitches.ExtendedResourcePatchModeBoth)
+
+			patches := make([]string, 2)
+			var wg sync.WaitGroup
+			for i, wh := range []*Webhook{limitsOnly, both} {
+				wg.Add(1)
+				go func(i int, wh *Webhook) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					patches[i] = string(mutateWith(wh.MutateHandler, podKind, pod).Patch)
+				}(i, wh)
+			}
+			wg.Wait()
+
+			Expect(patches[0]).To(ContainSubstring("/resources/limits/intel.com~1sriov"))
+			Expect(patches[0]).NotTo(ContainSubstring("/resources/requests/intel.com~1sriov"))
+			Expect(patches[1]).To(ContainSubstring("/resources/requests/intel.com~1sriov"))
+			Expect(patches[1]).To(ContainSubstring("/resources/limits/intel.com~1sriov"))
+		})
+	})
+	Describe("Source net-attach-defs annotation", func() {
+		podWith := func(networks string, labels map[string]string) corev1.Pod {
+			pod := podWithNetworks(networks)
+			pod.ObjectMeta.Labels = labels
+			return pod
+		}
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/other-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/other"},
+				"default/plain-net": {},
+			})
+			setupControlSwitches(map[string]bool{"enableSourceNadsAnnotation": true})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should list net-attach-defs which contributed resources once", func() {
+			annotations := annotationsOf(mutate(podKind, podWith("sriov-net,plain-net,sriov-net,other-net", nil)))
+			Expect(annotations).To(HaveKeyWithValue("network-resources-injector.io/source-nads", "default/sriov-net,default/other-net"))
+		})
+
+		It("should not annotate pod when disabled", func() {
+			setupControlSwitches(map[string]bool{"enableIdempotency": false})
+			Expect(annotationsOf(mutate(podKind, podWith("sriov-net", nil)))).To(BeNil())
+		})
+
+		It("should keep user defined annotations", func() {
+			injections := userdefinedinjections.CreateUserInjectionsStructure()
+			injections.Patchs["inject"] = nritypes.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/metadata/annotations",
+				Value: map[string]interface{}{
+					"example.com/key": "value",
+					"network-resources-injector.io/source-nads": "user",
+				},
+			}
+			SetUserInjectionStructure(injections)
+			annotations := annotationsOf(mutate(podKind, podWith("sriov-net", map[string]string{"inject": "true"})))
+			Expect(annotations).To(HaveKeyWithValue("example.com/key", "value"))
+			Expect(annotations).To(HaveKeyWithValue("network-resources-injector.io/source-nads", "user"))
+		})
+	})
+	Describe("Lookup rate limiting", func() {
+		It("should throttle lookups of every source separately", func() {
+			limiters := sourceLimiters{}
+			Expect(limiters.allow("ns1", 0.001, 2)).To(BeTrue())
+			Expect(limiters.allow("ns1", 0.001, 2)).To(BeTrue())
+			Expect(limiters.allow("ns1", 0.001, 2)).To(BeFalse())
+			Expect(limiters.allow("ns2", 0.001, 2)).To(BeTrue())
+		})
+
+		It("should not throttle lookups when limit is 0", func() {
+			limiters := sourceLimiters{}
+			for i := 0; i < 100; i++ {
+				Expect(limiters.allow("ns1", 0, 1)).To(BeTrue())
+			}
+		})
+
+		It("should refuse lookups of source backing off until the backoff expires", func() {
+			limiters := sourceLimiters{}
+			Expect(limiters.allow("ns1", 1000, 1)).To(BeTrue())
+			Expect(limiters.allow("ns1", 1000, 1)).To(BeFalse())
+			Expect(limiters.limiters["ns1"].throttled).To(Equal(1))
+
+			limiters.limiters["ns1"].backoffUntil = time.Now().Add(time.Hour)
+			time.Sleep(5 * time.Millisecond)
+			Expect(limiters.allow("ns1", 1000, 1)).To(BeFalse())
+			Expect(limiters.allow("ns2", 1000, 1)).To(BeTrue())
+
+			limiters.limiters["ns1"].backoffUntil = time.Now()
+			Expect(limiters.allow("ns1", 1000, 1)).To(BeTrue())
+			Expect(limiters.limiters["ns1"].throttled).To(BeZero())
+		})
+
+		It("should double the backoff with every throttled lookup in a row", func() {
+			Expect(lookupBackoff(10, 1)).To(Equal(100 * time.Millisecond))
+			Expect(lookupBackoff(10, 2)).To(Equal(200 * time.Millisecond))
+			Expect(lookupBackoff(10, 4)).To(Equal(800 * time.Millisecond))
+			Expect(lookupBackoff(10, 100)).To(Equal(maxLookupBackoff))
+		})
+
+		It("should key lookups by the request namespace, or the user when namespace is not set", func() {
+			ctx := withLookupSource(context.Background(), &admissionv1.AdmissionRequest{Namespace: "apps"})
+			Expect(lookupSource(ctx, "other")).To(Equal("apps"))
+			ctx = withLookupSource(context.Background(), &admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: "system:serviceaccount:kube-system:replicaset-controller"}})
+			Expect(lookupSource(ctx, "other")).To(Equal("user/system:serviceaccount:kube-system:replicaset-controller"))
+			Expect(lookupSource(context.Background(), "other")).To(Equal("other"))
+		})
+
+		It("should ignore owner missing in cache when owner lookup is throttled", func() {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			switches.SetLookupRateLimitUnitTests(0.001, 1)
+			wh := NewWebhook(nil, switches)
+			Expect(wh.allowLookup("default")).To(BeTrue())
+
+			ctx := withLookupSource(context.Background(), &admissionv1.AdmissionRequest{Namespace: "default"})
+			owner, err := wh.getOwnerMeta(ctx, "default", metav1.OwnerReference{Kind: "Deployment", Name: "app", UID: "app-uid"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(owner).To(BeNil())
+		})
+
+		It("should use fallback namespace when owner lookup is throttled", func() {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			switches.SetLookupRateLimitUnitTests(0.001, 1)
+			/* API client is not set up, so the webhook must not reach for it */
+			wh := NewWebhook(nil, switches)
+			user := "system:serviceaccount:kube-system:replicaset-controller"
+			Expect(wh.allowLookup("user/" + user)).To(BeTrue())
+
+			pod := podWithNetworks("sriov-net")
+			pod.ObjectMeta.Namespace = ""
+			pod.ObjectMeta.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app-rs", UID: "rs-uid"}}
+			raw, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			ar := &admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: user}, Object: runtime.RawExtension{Raw: raw}}}
+
+			ctx := withLookupSource(context.Background(), ar.Request)
+			_, err = wh.getNamespaceFromOwnerReference(ctx, pod.ObjectMeta.OwnerReferences[0])
+			Expect(errors.Is(err, errLookupThrottled)).To(BeTrue())
+
+			deserialized, err := wh.deserializePod(ctx, ar)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deserialized.ObjectMeta.Namespace).To(Equal(controlswitches.DefaultFallbackNamespace))
+		})
+
+		It("should deny pod with net-attach-def missing in cache when lookups are throttled", func() {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			switches.SetLookupRateLimitUnitTests(0.001, 1)
+			/* API client is not set up, so the webhook must not reach for it */
+			wh := NewWebhook(nil, switches)
+			wh.SetNetAttachDefCache(fakeNetAttachDefCache{})
+			Expect(wh.allowLookup("default")).To(BeTrue())
+
+			pod := podWithNetworks("sriov-net")
+			response := mutateWith(wh.MutateHandler, podKind, pod)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("network attachment definition 'default/sriov-net' is not found in cache and API server lookups are throttled"))
+		})
+	})
+	Describe("Skipping injection", func() {
+		podWith := func(annotations map[string]string, owners ...metav1.OwnerReference) corev1.Pod {
+			annotations["k8s.v1.cni.cncf.io/networks"] = "sriov-net"
+			pod := podWithAnnotations(annotations)
+			pod.ObjectMeta.OwnerReferences = owners
+			return pod
+		}
+		agent := metav1.OwnerReference{Kind: "DaemonSet", Name: "node-agent"}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(map[string]bool{"enableSkipReasonWarnings": true}, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		It("should skip pod annotated to skip injection", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{"network-resources-injector.io/skip": "true"}))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeEmpty())
+			Expect(response.Warnings).To(ConsistOf(ContainSubstring("SkipRequested")))
+		})
+
+		It("should inject pod annotated not to skip injection", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{"network-resources-injector.io/skip": "false"}))
+			Expect(response.Patch).NotTo(BeEmpty())
+		})
+
+		DescribeTable("should skip pods of excluded owners",
+			func(skippedOwners []string, skipped bool) {
+				wh.controlSwitches.SetSkippedOwnersUnitTests(skippedOwners)
+				response := mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{}, agent))
+				Expect(response.Allowed).To(BeTrue())
+				if skipped {
+					Expect(response.Patch).To(BeEmpty())
+					Expect(response.Warnings).To(ConsistOf(ContainSubstring("OwnerExcluded")))
+				} else {
+					Expect(response.Patch).NotTo(BeEmpty())
+				}
+			},
+			Entry("no excluded owners", nil, false),
+			Entry("excluded kind", []string{"Job", "DaemonSet"}, true),
+			Entry("excluded kind and name", []string{"DaemonSet/node-agent"}, true),
+			Entry("other name of the kind", []string{"DaemonSet/other-agent"}, false),
+		)
+
+		DescribeTable("should handle pod without containers without panic",
+			func(action string, allowed bool) {
+				wh.controlSwitches.SetNoContainersActionUnitTests(action)
+				pod := podWith(map[string]string{})
+				pod.Spec.Containers = nil
+				var response *admissionv1.AdmissionResponse
+				Expect(func() { response = mutateWith(wh.MutateHandler, podKind, pod) }).NotTo(Panic())
+				Expect(response.Allowed).To(Equal(allowed))
+				Expect(response.Patch).To(BeEmpty())
+				if allowed {
+					Expect(response.Warnings).To(ConsistOf(ContainSubstring("NoContainers")))
+				} else {
+					Expect(response.Result.Message).To(ContainSubstring("pod has no containers"))
+				}
+			},
+			Entry("allowed", "allow", true),
+			Entry("denied", "deny", false),
+		)
+
+		DescribeTable("should skip pods targeting virtual nodes",
+			func(modify func(*corev1.Pod), skipped bool) {
+				Expect(wh.controlSwitches.SetVirtualNodeSignatureUnitTests("type=virtual-kubelet",
+					"virtual-kubelet.io/provider=azure:NoSchedule", []string{"virtual-kubelet"})).To(Succeed())
+				pod := podWith(map[string]string{})
+				modify(&pod)
+				response := mutateWith(wh.MutateHandler, podKind, pod)
+				Expect(response.Allowed).To(BeTrue())
+				if skipped {
+					Expect(response.Patch).To(BeEmpty())
+					Expect(response.Warnings).To(ConsistOf(ContainSubstring("VirtualNode")))
+				} else {
+					Expect(response.Patch).NotTo(BeEmpty())
+				}
+			},
+			Entry("physical node pod", func(pod *corev1.Pod) {}, false),
+			Entry("bound to virtual node", func(pod *corev1.Pod) { pod.Spec.NodeName = "virtual-kubelet-aci" }, true),
+			Entry("bound to physical node", func(pod *corev1.Pod) { pod.Spec.NodeName = "worker-0" }, false),
+			Entry("selecting virtual nodes", func(pod *corev1.Pod) {
+				pod.Spec.NodeSelector = map[string]string{"type": "virtual-kubelet", "kubernetes.io/os": "linux"}
+			}, true),
+			Entry("selecting other nodes", func(pod *corev1.Pod) {
+				pod.Spec.NodeSelector = map[string]string{"type": "worker"}
+			}, false),
+			Entry("tolerating virtual node taint", func(pod *corev1.Pod) {
+				pod.Spec.Tolerations = []corev1.Toleration{{Key: "virtual-kubelet.io/provider", Operator: corev1.TolerationOpExists}}
+			}, true),
+			Entry("tolerating virtual node taint of the provider", func(pod *corev1.Pod) {
+				pod.Spec.Tolerations = []corev1.Toleration{{Key: "virtual-kubelet.io/provider", Operator: corev1.TolerationOpEqual,
+					Value: "azure", Effect: corev1.TaintEffectNoSchedule}}
+			}, true),
+			Entry("tolerating taint of other provider", func(pod *corev1.Pod) {
+				pod.Spec.Tolerations = []corev1.Toleration{{Key: "virtual-kubelet.io/provider", Operator: corev1.TolerationOpEqual, Value: "aws"}}
+			}, false),
+			Entry("tolerating other effect", func(pod *corev1.Pod) {
+				pod.Spec.Tolerations = []corev1.Toleration{{Key: "virtual-kubelet.io/provider", Operator: corev1.TolerationOpExists,
+					Effect: corev1.TaintEffectNoExecute}}
+			}, false),
+		)
+
+		DescribeTable("should skip pods of ignored namespaces",
+			func(ignoredNamespaces []string, skipped bool) {
+				wh.controlSwitches.SetIgnoredNamespacesUnitTests(ignoredNamespaces)
+				response := mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{"network-resources-injector.io/inject": "true"}))
+				Expect(response.Allowed).To(BeTrue())
+				if skipped {
+					Expect(response.Patch).To(BeEmpty())
+					Expect(response.Warnings).To(ConsistOf(ContainSubstring("NamespaceIgnored")))
+				} else {
+					Expect(response.Patch).NotTo(BeEmpty())
+				}
+			},
+			Entry("no ignored namespaces", nil, false),
+			Entry("pod namespace ignored", []string{"kube-system", "default"}, true),
+			Entry("other namespaces ignored", []string{"kube-system"}, false),
+		)
+
+		DescribeTable("should inject only pods opted into injection when opt-in is required",
+			func(annotations map[string]string, reason string) {
+				wh.controlSwitches = newControlSwitches(map[string]bool{"enableSkipReasonWarnings": true, "requireOptIn": true})
+				response := mutateWith(wh.MutateHandler, podKind, podWith(annotations))
+				Expect(response.Allowed).To(BeTrue())
+				if reason != "" {
+					Expect(response.Patch).To(BeEmpty())
+					Expect(response.Warnings).To(ConsistOf(ContainSubstring(reason)))
+				} else {
+					Expect(response.Patch).NotTo(BeEmpty())
+				}
+			},
+			Entry("without opt-in annotation", map[string]string{}, "OptInMissing"),
+			Entry("opted out", map[string]string{"network-resources-injector.io/inject": "false"}, "OptInMissing"),
+			Entry("opted in", map[string]string{"network-resources-injector.io/inject": "true"}, ""),
+			Entry("opted in and annotated to skip", map[string]string{"network-resources-injector.io/inject": "true",
+				"network-resources-injector.io/skip": "true"}, "SkipRequested"),
+		)
+
+		It("should ignore opt-in annotation when opt-in is not required", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{"network-resources-injector.io/inject": "false"}))
+			Expect(response.Patch).NotTo(BeEmpty())
+		})
+
+		DescribeTable("should skip pods matching skip field selector",
+			func(selector string, spec func(*corev1.PodSpec), skipped bool) {
+				wh.controlSwitches.SetSkipFieldSelectorUnitTests(fields.ParseSelectorOrDie(selector))
+				pod := podWith(map[string]string{})
+				spec(&pod.Spec)
+				response := mutateWith(wh.MutateHandler, podKind, pod)
+				Expect(response.Allowed).To(BeTrue())
+				if skipped {
+					Expect(response.Patch).To(BeEmpty())
+					Expect(response.Warnings).To(ConsistOf(ContainSubstring("FieldSelectorMatched")))
+				} else {
+					Expect(response.Patch).NotTo(BeEmpty())
+				}
+			},
+			Entry("matching restart policy", "spec.restartPolicy=Never",
+				func(spec *corev1.PodSpec) { spec.RestartPolicy = corev1.RestartPolicyNever }, true),
+			Entry("other restart policy", "spec.restartPolicy=Never",
+				func(spec *corev1.PodSpec) { spec.RestartPolicy = corev1.RestartPolicyAlways }, false),
+			Entry("matching runtime class", "spec.runtimeClassName=kata",
+				func(spec *corev1.PodSpec) { runtimeClass := "kata"; spec.RuntimeClassName = &runtimeClass }, true),
+			Entry("missing runtime class", "spec.runtimeClassName=kata", func(spec *corev1.PodSpec) {}, false),
+			Entry("host network", "spec.hostNetwork=true", func(spec *corev1.PodSpec) { spec.HostNetwork = true }, true),
+		)
+
+		It("should refer to opt-in annotation of the configured domain", func() {
+			wh.controlSwitches = newControlSwitches(map[string]bool{"enableSkipReasonWarnings": true, "requireOptIn": true})
+			wh.controlSwitches.SetAnnotationDomainUnitTests("example.com")
+			response := mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{"network-resources-injector.io/inject": "true"}))
+			Expect(response.Patch).To(BeEmpty())
+			Expect(response.Warnings).To(ConsistOf(ContainSubstring("example.com/inject")))
+			response = mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{"example.com/inject": "true"}))
+			Expect(response.Patch).NotTo(BeEmpty())
+		})
+	})
+	Describe("Network compute resources", func() {
+		podWith := func(networks string, resources corev1.ResourceRequirements) corev1.Pod {
+			pod := podWithNetworks(networks)
+			pod.Spec.Containers[0].Resources = resources
+			return pod
+		}
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/dpdk-net": {
+					"k8s.v1.cni.cncf.io/resourceName":  "intel.com/sriov",
+					"k8s.v1.cni.cncf.io/cpuRequest":    "500m",
+					"k8s.v1.cni.cncf.io/memoryRequest": "64Mi",
+				},
+				"default/sidecar-net":  {"k8s.v1.cni.cncf.io/cpuRequest": "250m"},
+				"default/worker-net":   {"k8s.v1.cni.cncf.io/cpuRequest": "250m", "k8s.v1.cni.cncf.io/targetContainers": "worker"},
+				"default/broken-net":   {"k8s.v1.cni.cncf.io/memoryRequest": "lots"},
+				"default/negative-net": {"k8s.v1.cni.cncf.io/cpuRequest": "-1"},
+			})
+			setupControlSwitches(nil)
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should add CPU and memory of all networks to the requests", func() {
+			values := patchValues(mutate(podKind, podWith("dpdk-net,sidecar-net", corev1.ResourceRequirements{})))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/cpu", "750m"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/memory", "64Mi"))
+			Expect(values).NotTo(HaveKey("/spec/containers/0/resources/limits/cpu"))
+		})
+
+		It("should inject CPU of network without device resources", func() {
+			values := patchValues(mutate(podKind, podWith("sidecar-net", corev1.ResourceRequirements{})))
+			Expect(values).To(HaveKey("/spec/containers/0/resources/requests"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/cpu", "250m"))
+		})
+
+		It("should add CPU on top of existing requests and limits", func() {
+			values := patchValues(mutate(podKind, podWith("sidecar-net", corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+			})))
+			Expect(values).NotTo(HaveKey("/spec/containers/0/resources/requests"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/cpu", "1250m"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/limits/cpu", "2250m"))
+		})
+
+		It("should inject CPU into the container targeted by the network", func() {
+			pod := podWith("worker-net,sidecar-net", corev1.ResourceRequirements{})
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "worker"})
+			values := patchValues(mutate(podKind, pod))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/cpu", "250m"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/1/resources/requests/cpu", "250m"))
+		})
+
+		It("should keep the same requests when invoked again for the patched pod", func() {
+			setupControlSwitches(map[string]bool{"enableIdempotency": false})
+			patched := patchedPod(podWith("sidecar-net", corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+			}))
+			Expect(patched.Spec.Containers[0].Resources.Requests.Cpu().String()).To(Equal("1250m"))
+			Expect(patched.Spec.Containers[0].Resources.Limits.Cpu().String()).To(Equal("2250m"))
+
+			again := patchedPod(patched)
+			Expect(again.Spec.Containers[0].Resources).To(Equal(patched.Spec.Containers[0].Resources))
+		})
+
+		It("should replace CPU injected before with CPU of the current networks", func() {
+			pod := podWith("dpdk-net", corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1250m")},
+			})
+			pod.ObjectMeta.Annotations["network-resources-injector.io/injected-compute"] = `{"app":{"cpu":"250m"}}`
+			values := patchValues(mutate(podKind, pod))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/cpu", "1500m"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/memory", "64Mi"))
+			Expect(annotationsOf(mutate(podKind, pod))).To(HaveKeyWithValue("network-resources-injector.io/injected-compute",
+				`{"app":{"cpu":"500m","memory":"64Mi"}}`))
+		})
+
+		DescribeTable("should deny pod with invalid quantity",
+			func(network, message string) {
+				response := mutate(podKind, podWith(network, corev1.ResourceRequirements{}))
+				Expect(response.Allowed).To(BeFalse())
+				Expect(response.Result.Message).To(ContainSubstring(message))
+			},
+			Entry("unparsable quantity", "broken-net",
+				"invalid annotation 'k8s.v1.cni.cncf.io/memoryRequest' of net-attach-def 'default/broken-net' with value 'lots'"),
+			Entry("negative quantity", "negative-net",
+				"invalid annotation 'k8s.v1.cni.cncf.io/cpuRequest' of net-attach-def 'default/negative-net', quantity '-1' must not be negative"),
+		)
+	})
+	Describe("Patch validation", func() {
+		DescribeTable("validating JSON patch",
+			func(patch string, valid bool) {
+				err := validatePatch([]byte(`{"metadata": {}, "spec": {"volumes": []}}`), []byte(patch))
+				if valid {
+					Expect(err).NotTo(HaveOccurred())
+				} else {
+					Expect(err).To(HaveOccurred())
+				}
+			},
+			Entry("add key", `[{"op": "add", "path": "/metadata/labels", "value": {"a": "b"}}]`, true),
+			Entry("append to list", `[{"op": "add", "path": "/spec/volumes/-", "value": {"name": "a"}}]`, true),
+			Entry("missing parent", `[{"op": "add", "path": "/spec/containers/0/env", "value": []}]`, false),
+			Entry("index out of range", `[{"op": "add", "path": "/spec/volumes/1", "value": {"name": "a"}}]`, false),
+			Entry("malformed patch", `{"op": "add"}`, false),
+		)
+
+		Context("mutating pod", func() {
+			/* container without resources field, which the resources patch does not create */
+			pod := json.RawMessage(`{"metadata": {"name": "test", "namespace": "default",
+				"annotations": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}},
+				"spec": {"containers": [{"name": "app"}]}}`)
+
+			BeforeEach(func() {
+				useNetAttachDefs(map[string]map[string]string{
+					"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				})
+			})
+
+			AfterEach(resetWebhook)
+
+			It("should return patch without validation by default", func() {
+				setupControlSwitches(nil)
+				response := mutate(podKind, pod)
+				Expect(response.Allowed).To(BeTrue())
+				Expect(response.Patch).NotTo(BeEmpty())
+			})
+
+			It("should deny pod when patch does not apply", func() {
+				setupControlSwitches(map[string]bool{"enableValidatePatch": true})
+				response := mutate(podKind, pod)
+				Expect(response.Allowed).To(BeFalse())
+				Expect(response.Patch).To(BeEmpty())
+				Expect(response.Result.Message).To(ContainSubstring("network resources injector created invalid patch of Pod"))
+			})
+
+			It("should allow pod when patch applies", func() {
+				setupControlSwitches(map[string]bool{"enableValidatePatch": true})
+				response := mutate(podKind, podWithNetworks("sriov-net"))
+				Expect(response.Allowed).To(BeTrue())
+				Expect(response.Patch).NotTo(BeEmpty())
+			})
+		})
+	})
+	Describe("Resource claims", func() {
+		claimName, templateName, unmappedName := "sriov-claim", "other-template", "gpu-template"
+		podWith := func(annotations map[string]string) corev1.Pod {
+			pod := podWithAnnotations(annotations)
+			pod.Spec.ResourceClaims = []corev1.PodResourceClaim{
+				{Name: "sriov", Source: corev1.ClaimSource{ResourceClaimName: &claimName}},
+				{Name: "other", Source: corev1.ClaimSource{ResourceClaimTemplateName: &templateName}},
+				{Name: "gpu", Source: corev1.ClaimSource{ResourceClaimTemplateName: &unmappedName}},
+			}
+			return pod
+		}
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"infra/other-net":   {"k8s.v1.cni.cncf.io/resourceName": "intel.com/other"},
+			})
+			setupControlSwitches(map[string]bool{"enableInjectedResourcesAnnotation": true, "enableResourceClaims": true}).
+				SetResourceClaimNetworksUnitTests(map[string]string{"sriov-claim": "sriov-net", "other-template": "infra/other-net"})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should inject resources of networks mapped to resource claims", func() {
+			Expect(injectedResources(mutate(podKind, podWith(nil)))).To(Equal(`{"intel.com/other":1,"intel.com/sriov":1}`))
+		})
+
+		It("should request resources of network selected also by annotation once", func() {
+			pod := podWith(map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"})
+			Expect(injectedResources(mutate(podKind, pod))).To(Equal(`{"intel.com/other":1,"intel.com/sriov":1}`))
+		})
+
+		It("should ignore resource claims when disabled", func() {
+			setupControlSwitches(map[string]bool{"enableInjectedResourcesAnnotation": true}).
+				SetResourceClaimNetworksUnitTests(map[string]string{"sriov-claim": "sriov-net"})
+			Expect(mutate(podKind, podWith(nil)).Patch).To(BeEmpty())
+		})
+	})
+	Describe("TLS configuration", func() {
+		DescribeTable("parsing minimal TLS version",
+			func(name string, expected uint16, valid bool) {
+				version, err := ParseTLSMinVersion(name)
+				if valid {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(version).To(Equal(expected))
+				} else {
+					Expect(err).To(HaveOccurred())
+				}
+			},
+			Entry("TLS 1.2", "1.2", uint16(tls.VersionTLS12), true),
+			Entry("TLS 1.3", "1.3", uint16(tls.VersionTLS13), true),
+			Entry("TLS 1.1", "1.1", uint16(0), false),
+			Entry("unknown version", "tls12", uint16(0), false),
+		)
+
+		It("should return default cipher suites when none is given", func() {
+			Expect(ParseTLSCipherSuites("")).To(Equal(DefaultTLSCipherSuites))
+		})
+
+		It("should parse cipher suites", func() {
+			Expect(ParseTLSCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256")).To(Equal(
+				[]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}))
+		})
+
+		DescribeTable("rejecting cipher suites",
+			func(names, message string) {
+				_, err := ParseTLSCipherSuites(names)
+				Expect(err).To(MatchError(ContainSubstring(message)))
+			},
+			Entry("unknown cipher suite", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_FOO", "unknown TLS cipher suite 'TLS_FOO'"),
+			Entry("insecure cipher suite", "TLS_RSA_WITH_RC4_128_SHA", "insecure TLS cipher suite 'TLS_RSA_WITH_RC4_128_SHA'"),
+			Entry("TLS 1.3 cipher suite", "TLS_AES_128_GCM_SHA256", "only TLS 1.2 cipher suites are"),
+		)
+	})
+	Describe("Downward API volume injection", func() {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default",
+				Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{"hugepages-1Gi": resource.MustParse("2Gi")},
+					Limits:   corev1.ResourceList{"hugepages-1Gi": resource.MustParse("2Gi")},
+				},
+			}}},
+		}
+		patchPaths := func(response *admissionv1.AdmissionResponse) []string {
+			Expect(response.Allowed).To(BeTrue())
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			var paths []string
+			for _, operation := range patch {
+				paths = append(paths, operation.Path)
+			}
+			return paths
+		}
+
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should inject Downward API volume by default", func() {
+			setupControlSwitches(map[string]bool{"enableHugePageDownApi": true})
+			paths := patchPaths(mutate(podKind, pod))
+			Expect(paths).To(ContainElement("/spec/volumes/-"))
+			Expect(paths).To(ContainElement("/spec/containers/0/volumeMounts"))
+			Expect(paths).To(ContainElement("/spec/containers/0/env"))
+		})
+
+		It("should inject only resources when disabled", func() {
+			setupControlSwitches(map[string]bool{"injectDownwardAPIVolume": false})
+			paths := patchPaths(mutate(podKind, pod))
+			Expect(paths).To(ContainElement("/spec/containers/0/resources/requests/intel.com~1sriov"))
+			Expect(paths).NotTo(ContainElement(HavePrefix("/spec/volumes")))
+			Expect(paths).NotTo(ContainElement(HavePrefix("/spec/containers/0/volumeMounts")))
+		})
+
+		It("should not expose hugepages when disabled", func() {
+			setupControlSwitches(map[string]bool{"injectDownwardAPIVolume": false, "enableHugePageDownApi": true})
+			paths := patchPaths(mutate(podKind, pod))
+			Expect(paths).To(ContainElement("/spec/containers/0/resources/requests/intel.com~1sriov"))
+			Expect(paths).NotTo(ContainElement(HavePrefix("/spec/volumes")))
+			Expect(paths).NotTo(ContainElement(HavePrefix("/spec/containers/0/env")))
+		})
+	})
+	Describe("Resource name from CNI config", func() {
+		network := &types.NetworkSelectionElement{Namespace: "default", Name: "sriov-net"}
+
+		AfterEach(resetWebhook)
+
+		DescribeTable("getting resource name from CNI config",
+			func(config, expected string, valid bool) {
+				resourceName, err := getConfigResourceName(config)
+				if valid {
+					Expect(err).NotTo(HaveOccurred())
+				} else {
+					Expect(err).To(HaveOccurred())
+				}
+				Expect(resourceName).To(Equal(expected))
+			},
+			Entry("plugin config", `{"type": "sriov", "resourceName": "intel.com/sriov"}`, "intel.com/sriov", true),
+			Entry("plugins list", `{"plugins": [{"type": "tuning"}, {"type": "sriov", "resourceName": "intel.com/sriov"}]}`,
+				"intel.com/sriov", true),
+			Entry("no resource name", `{"type": "macvlan", "master": "eth0"}`, "", true),
+			Entry("malformed config", `{"type": "sriov", `, "", false),
+		)
+
+		DescribeTable("computing resources of network without resource name annotation",
+			func(features map[string]bool, annotations map[string]string, config string, expected map[string]int64) {
+				setupControlSwitches(features)
+				nadAnnotations := map[string]map[string]string{"default/sriov-net": annotations}
+				reqs, _, _, _, err := defaultWebhook.computeNetworkResources([]*types.NetworkSelectionElement{network}, nil,
+					nadAnnotations, map[string]string{"default/sriov-net": config})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(reqs).To(Equal(expected))
+				Expect(nadAnnotations["default/sriov-net"]).To(Equal(annotations))
+			},
+			Entry("disabled", nil, map[string]string{},
+				`{"type": "sriov", "resourceName": "intel.com/sriov"}`, map[string]int64{}),
+			Entry("taken from config", map[string]bool{"enableConfigResourceName": true}, map[string]string{},
+				`{"type": "sriov", "resourceName": "intel.com/sriov"}`, map[string]int64{"intel.com/sriov": 1}),
+			Entry("annotation takes precedence", map[string]bool{"enableConfigResourceName": true},
+				map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/other"},
+				`{"type": "sriov", "resourceName": "intel.com/sriov"}`, map[string]int64{"intel.com/other": 1}),
+			Entry("malformed config", map[string]bool{"enableConfigResourceName": true}, map[string]string{},
+				`{"type": "sriov", `, map[string]int64{}),
+		)
+	})
+	Describe("Idempotency", func() {
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/plain-net": {},
+			})
+			setupControlSwitches(nil)
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should mark pod as injected in the patch with resources", func() {
+			response := mutate(podKind, podWithAnnotations(map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"}))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).To(ContainSubstring("/spec/containers/0/resources/requests"))
+			Expect(annotationsOf(response)).To(HaveKeyWithValue("network-resources-injector.io/status", "injected"))
+		})
+
+		It("should add only the marker when other injector annotations are disabled", func() {
+			response := mutate(podKind, podWithNetworks("sriov-net"))
+			Expect(annotationsOf(response)).To(Equal(map[string]interface{}{
+				"k8s.v1.cni.cncf.io/networks":          "sriov-net",
+				"network-resources-injector.io/status": "injected",
+			}))
+		})
+
+		It("should not mark pod without injected resources", func() {
+			response := mutate(podKind, podWithAnnotations(map[string]string{"k8s.v1.cni.cncf.io/networks": "plain-net"}))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).NotTo(ContainSubstring("network-resources-injector.io/status"))
+		})
+
+		It("should skip pod marked as injected", func() {
+			response := mutate(podKind, podWithAnnotations(map[string]string{
+				"k8s.v1.cni.cncf.io/networks":          "sriov-net",
+				"network-resources-injector.io/status": "injected",
+			}))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeEmpty())
+			Expect(response.Result.Message).To(ContainSubstring("already marked as injected"))
+		})
+
+		It("should inject resources again when disabled", func() {
+			setupControlSwitches(map[string]bool{"enableIdempotency": false})
+			response := mutate(podKind, podWithAnnotations(map[string]string{
+				"k8s.v1.cni.cncf.io/networks":          "sriov-net",
+				"network-resources-injector.io/status": "injected",
+			}))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).To(ContainSubstring("/spec/containers/0/resources/requests"))
+		})
+	})
+	Describe("Target containers", func() {
+		podWith := func(networks string, containers ...corev1.Container) corev1.Pod {
+			pod := podWithNetworks(networks)
+			pod.Spec.Containers = containers
+			return pod
+		}
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, map[string]map[string]string{
+				"default/plain-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/dpdk-net": {
+					"k8s.v1.cni.cncf.io/resourceName":     "intel.com/sriov",
+					"k8s.v1.cni.cncf.io/targetContainers": "dpdk-.*",
+				},
+				"default/broken-net": {
+					"k8s.v1.cni.cncf.io/resourceName":     "intel.com/sriov",
+					"k8s.v1.cni.cncf.io/targetContainers": "dpdk-(",
+				},
+			})
+		})
+
+		It("should inject resource into the matching container", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("dpdk-net", corev1.Container{Name: "app"},
+				corev1.Container{Name: "dpdk-worker"})))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/1/resources/requests/intel.com~1sriov", "1"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/1/resources/limits/intel.com~1sriov", "1"))
+			Expect(values).NotTo(HaveKey("/spec/containers/0/resources/requests"))
+		})
+
+		It("should split resources between targeted and the first container", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("plain-net,dpdk-net,dpdk-net", corev1.Container{Name: "app"},
+				corev1.Container{Name: "dpdk-worker"})))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/1/resources/requests/intel.com~1sriov", "2"))
+		})
+
+		It("should inject resource into the first container when no container matches", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("dpdk-net", corev1.Container{Name: "app"},
+				corev1.Container{Name: "sidecar-dpdk"})))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+			Expect(values).NotTo(HaveKey("/spec/containers/1/resources/requests/intel.com~1sriov"))
+		})
+
+		It("should deny pod with invalid target expression", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith("broken-net", corev1.Container{Name: "app"}))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring(
+				"invalid annotation 'k8s.v1.cni.cncf.io/targetContainers' of net-attach-def 'default/broken-net' with value 'dpdk-('"))
+		})
+
+		DescribeTable("assigning targeted resources",
+			func(containers []string, requests map[string]int64, targeted map[string]map[string]int64,
+				expectedRemaining map[string]int64, expectedAssigned map[int]map[string]int64) {
+				var podContainers []corev1.Container
+				for _, name := range containers {
+					podContainers = append(podContainers, corev1.Container{Name: name})
+				}
+				remaining, assigned := assignTargetedResources(podContainers, requests, targeted)
+				Expect(remaining).To(Equal(expectedRemaining))
+				Expect(assigned).To(Equal(expectedAssigned))
+			},
+			Entry("nothing targeted", []string{"app", "worker"}, map[string]int64{"intel.com/sriov": 2},
+				map[string]map[string]int64{}, map[string]int64{"intel.com/sriov": 2}, map[int]map[string]int64{}),
+			Entry("whole name has to match", []string{"app", "worker-1"}, map[string]int64{"intel.com/sriov": 2},
+				map[string]map[string]int64{"worker": {"intel.com/sriov": 1}},
+				map[string]int64{"intel.com/sriov": 2}, map[int]map[string]int64{}),
+			Entry("first matching container", []string{"app", "worker-1", "worker-2"}, map[string]int64{"intel.com/sriov": 2},
+				map[string]map[string]int64{"worker-[0-9]": {"intel.com/sriov": 1}},
+				map[string]int64{"intel.com/sriov": 1}, map[int]map[string]int64{1: {"intel.com/sriov": 1}}),
+			Entry("target matching the first container", []string{"app", "worker"}, map[string]int64{"intel.com/sriov": 1},
+				map[string]map[string]int64{"app|worker": {"intel.com/sriov": 1}},
+				map[string]int64{"intel.com/sriov": 1}, map[int]map[string]int64{}),
+			Entry("clamped request", []string{"app", "worker"}, map[string]int64{"intel.com/sriov": 1},
+				map[string]map[string]int64{"worker": {"intel.com/sriov": 3}},
+				map[string]int64{}, map[int]map[string]int64{1: {"intel.com/sriov": 1}}),
+		)
+
+		It("should mount Downward API volume into all containers by default", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("dpdk-net", corev1.Container{Name: "app"},
+				corev1.Container{Name: "dpdk-worker"}, corev1.Container{Name: "sidecar"})))
+			Expect(values).To(HaveKey("/spec/containers/0/volumeMounts"))
+			Expect(values).To(HaveKey("/spec/containers/1/volumeMounts"))
+			Expect(values).To(HaveKey("/spec/containers/2/volumeMounts"))
+		})
+
+		It("should mount Downward API volume only into targeted container", func() {
+			wh.controlSwitches = newControlSwitches(map[string]bool{"downwardAPIMountResourceContainersOnly": true})
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("dpdk-net", corev1.Container{Name: "app"},
+				corev1.Container{Name: "dpdk-worker"}, corev1.Container{Name: "sidecar"})))
+			Expect(values).To(HaveKey("/spec/volumes/-"))
+			Expect(values).NotTo(HaveKey("/spec/containers/0/volumeMounts"))
+			Expect(values).To(HaveKey("/spec/containers/1/volumeMounts"))
+			Expect(values).NotTo(HaveKey("/spec/containers/2/volumeMounts"))
+		})
+
+		It("should mount Downward API volume into the first and targeted containers", func() {
+			wh.controlSwitches = newControlSwitches(map[string]bool{"downwardAPIMountResourceContainersOnly": true})
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("plain-net,dpdk-net", corev1.Container{Name: "app"},
+				corev1.Container{Name: "sidecar"}, corev1.Container{Name: "dpdk-worker"})))
+			Expect(values).To(HaveKey("/spec/containers/0/volumeMounts"))
+			Expect(values).NotTo(HaveKey("/spec/containers/1/volumeMounts"))
+			Expect(values).To(HaveKey("/spec/containers/2/volumeMounts"))
+		})
+
+		It("should mount Downward API volume into all init containers", func() {
+			wh.controlSwitches = newControlSwitches(map[string]bool{"downwardAPIMountResourceContainersOnly": true, "injectIntoInitContainers": true})
+			pod := podWith("plain-net", corev1.Container{Name: "app"}, corev1.Container{Name: "sidecar"})
+			pod.Spec.InitContainers = []corev1.Container{{Name: "init"}}
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, pod))
+			Expect(values).To(HaveKey("/spec/containers/0/volumeMounts"))
+			Expect(values).NotTo(HaveKey("/spec/containers/1/volumeMounts"))
+			Expect(values).To(HaveKey("/spec/initContainers/0/volumeMounts"))
+		})
+	})
+	Describe("Error responses", func() {
+		var server *httptest.Server
+		var wh *Webhook
+		mutateStatus := func(pod corev1.Pod) int {
+			raw, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			body, err := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+				Request: &admissionv1.AdmissionRequest{UID: "test", Kind: podKind, Namespace: "default",
+					Object: runtime.RawExtension{Raw: raw}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			req := httptest.NewRequest("POST", "https://fakewebhook/mutate", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			wh.MutateHandler(w, req)
+			return w.Code
+		}
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if strings.HasSuffix(r.URL.Path, "/missing-net") {
+					w.WriteHeader(http.StatusNotFound)
+				} else {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+				w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure"}`))
+			}))
+			wh = newTestWebhook(nil, nil)
+			wh.controlSwitches.SetNadLookupRetriesUnitTests(0, time.Millisecond)
+			var err error
+			wh.clientset, err = kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		DescribeTable("classifying errors",
+			func(err error, expected bool) {
+				Expect(isServerError(err)).To(Equal(expected))
+			},
+			Entry("plain error", errors.New("invalid annotation"), false),
+			Entry("server error", serverError{errors.New("lookup failed")}, true),
+			Entry("wrapped server error", errors.Wrap(serverError{errors.New("lookup failed")}, "could not find"), true),
+			Entry("namespace error caused by server", namespaceError{serverError{errors.New("lookup failed")}}, true),
+		)
+
+		It("should deny pod with invalid network annotation", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks(`[{"name": `))
+			Expect(response.Allowed).To(BeFalse())
+		})
+
+		It("should deny pod with invalid network annotation when server errors fail the call", func() {
+			wh.controlSwitches.SetServerErrorActionUnitTests(controlswitches.ServerErrorFail)
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks(`[{"name": `))
+			Expect(response.Allowed).To(BeFalse())
+		})
+
+		It("should deny pod referencing missing net-attach-def when server errors fail the call", func() {
+			wh.controlSwitches.SetServerErrorActionUnitTests(controlswitches.ServerErrorFail)
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks("missing-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("could not find network attachment definition 'default/missing-net'"))
+		})
+
+		It("should deny pod referencing missing net-attach-def with configured message", func() {
+			Expect(wh.controlSwitches.SetNadNotFoundMessageUnitTests(
+				"network '{{.Name}}' does not exist in namespace '{{.Namespace}}', see https://example.com/networks")).To(Succeed())
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks("missing-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(Equal("network 'missing-net' does not exist in namespace 'default', see https://example.com/networks"))
+		})
+
+		It("should not use configured net-attach-def not found message for other lookup errors", func() {
+			Expect(wh.controlSwitches.SetNadNotFoundMessageUnitTests("network '{{.Name}}' does not exist")).To(Succeed())
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks("sriov-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("could not find network attachment definition 'default/sriov-net'"))
+		})
+
+		It("should deny pod when API server is unavailable", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks("sriov-net"))
+			Expect(response.Allowed).To(BeFalse())
+		})
+
+		It("should fail the call when API server is unavailable and server errors fail the call", func() {
+			wh.controlSwitches.SetServerErrorActionUnitTests(controlswitches.ServerErrorFail)
+			Expect(mutateStatus(podWithNetworks("sriov-net"))).To(Equal(http.StatusInternalServerError))
+		})
+	})
+	Describe("Node affinity annotation", func() {
+		rackTerm := corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: "rack", Operator: corev1.NodeSelectorOpIn, Values: []string{"r1"}}}}
+		nicTerm := func(nic string) corev1.NodeSelectorTerm {
+			return corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: "nic", Operator: corev1.NodeSelectorOpIn, Values: []string{nic}}}}
+		}
+		affinityPatch := func(response *admissionv1.AdmissionResponse) *corev1.NodeAffinity {
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			for _, operation := range patch {
+				if operation.Path == "/spec/affinity" {
+					raw, _ := json.Marshal(operation.Value)
+					affinity := corev1.Affinity{}
+					Expect(json.Unmarshal(raw, &affinity)).To(Succeed())
+					return affinity.NodeAffinity
+				}
+			}
+			return nil
+		}
+
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {
+					"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov",
+					"k8s.v1.cni.cncf.io/nodeAffinity": `{"requiredDuringSchedulingIgnoredDuringExecution": {"nodeSelectorTerms": [
+						{"matchExpressions": [{"key": "nic", "operator": "In", "values": ["e810"]}]},
+						{"matchExpressions": [{"key": "nic", "operator": "In", "values": ["cx6"]}]}]},
+						"preferredDuringSchedulingIgnoredDuringExecution": [{"weight": 10, "preference":
+						{"matchExpressions": [{"key": "numa", "operator": "Exists"}]}}]}`,
+				},
+				"default/broken-net": {"k8s.v1.cni.cncf.io/nodeAffinity": `{"required": {}}`},
+			})
+			setupControlSwitches(map[string]bool{"enableNodeAffinityAnnotation": true})
+		})
+
+		AfterEach(resetWebhook)
+
+		DescribeTable("parsing node affinity",
+			func(value string, valid bool) {
+				_, err := parseNodeAffinity(value)
+				if valid {
+					Expect(err).NotTo(HaveOccurred())
+				} else {
+					Expect(err).To(HaveOccurred())
+				}
+			},
+			Entry("required terms", `{"requiredDuringSchedulingIgnoredDuringExecution": {"nodeSelectorTerms": [{"matchFields": [{"key": "metadata.name", "operator": "In", "values": ["node1"]}]}]}}`, true),
+			Entry("preferred terms", `{"preferredDuringSchedulingIgnoredDuringExecution": [{"weight": 100, "preference": {"matchExpressions": [{"key": "nic", "operator": "Exists"}]}}]}`, true),
+			Entry("not JSON", "nic=e810", false),
+			Entry("unknown field", `{"required": {}}`, false),
+			Entry("no required terms", `{"requiredDuringSchedulingIgnoredDuringExecution": {"nodeSelectorTerms": []}}`, false),
+			Entry("empty required term", `{"requiredDuringSchedulingIgnoredDuringExecution": {"nodeSelectorTerms": [{}]}}`, false),
+			Entry("weight out of range", `{"preferredDuringSchedulingIgnoredDuringExecution": [{"weight": 0, "preference": {"matchExpressions": [{"key": "nic", "operator": "Exists"}]}}]}`, false),
+		)
+
+		It("should combine every required term of both node affinities", func() {
+			dst := &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{rackTerm}}}
+			mergeNodeAffinity(dst, &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{nicTerm("e810"), nicTerm("cx6")}}})
+			Expect(dst.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{
+				{MatchExpressions: append(append([]corev1.NodeSelectorRequirement{}, rackTerm.MatchExpressions...), nicTerm("e810").MatchExpressions...)},
+				{MatchExpressions: append(append([]corev1.NodeSelectorRequirement{}, rackTerm.MatchExpressions...), nicTerm("cx6").MatchExpressions...)},
+			}))
+		})
+
+		It("should keep user defined affinity", func() {
+			existing := &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{rackTerm}}},
+				PodAntiAffinity: &corev1.PodAntiAffinity{},
+			}
+			injected := &corev1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{
+				{Weight: 10, Preference: nicTerm("e810")}}}
+			patch := createNodeAffinityPatch(nil, existing, nil, injected)
+			Expect(patch).To(HaveLen(1))
+			Expect(patch[0].Path).To(Equal("/spec/affinity/nodeAffinity"))
+			nodeAffinity := patch[0].Value.(*corev1.NodeAffinity)
+			Expect(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(Equal([]corev1.NodeSelectorTerm{rackTerm}))
+			Expect(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution).To(HaveLen(1))
+		})
+
+		It("should inject node affinity of the network", func() {
+			nodeAffinity := affinityPatch(mutate(podKind, podWithNetworks("sriov-net")))
+			Expect(nodeAffinity).NotTo(BeNil())
+			Expect(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(Equal(
+				[]corev1.NodeSelectorTerm{nicTerm("e810"), nicTerm("cx6")}))
+			Expect(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution).To(HaveLen(1))
+		})
+
+		It("should ignore node affinity when disabled", func() {
+			setupControlSwitches(nil)
+			Expect(affinityPatch(mutate(podKind, podWithNetworks("sriov-net")))).To(BeNil())
+		})
+
+		It("should deny pod with invalid node affinity", func() {
+			response := mutate(podKind, podWithNetworks("broken-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring(
+				"invalid annotation 'k8s.v1.cni.cncf.io/nodeAffinity' of net-attach-def 'default/broken-net'"))
+		})
+	})
+	Describe("Annotation domain", func() {
+		BeforeEach(func() {
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+			setupControlSwitches(map[string]bool{"enableInjectedResourcesAnnotation": true, "enableSourceNadsAnnotation": true}).
+				SetAnnotationDomainUnitTests("nri.example.com")
+		})
+
+		AfterEach(resetWebhook)
+
+		It("should write injector annotations under the configured domain", func() {
+			annotations := annotationsOf(mutate(podKind, podWithAnnotations(map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"})))
+			Expect(annotations).To(HaveKeyWithValue("nri.example.com/status", "injected"))
+			Expect(annotations).To(HaveKeyWithValue("nri.example.com/injected-resources", `{"intel.com/sriov":1}`))
+			Expect(annotations).To(HaveKeyWithValue("nri.example.com/source-nads", "default/sriov-net"))
+			Expect(annotations).NotTo(HaveKey("network-resources-injector.io/status"))
+		})
+
+		It("should skip pod by annotation under the configured domain", func() {
+			response := mutate(podKind, podWithAnnotations(map[string]string{
+				"k8s.v1.cni.cncf.io/networks": "sriov-net",
+				"nri.example.com/skip":        "true",
+			}))
+			Expect(response.Patch).To(BeEmpty())
+			Expect(response.Result.Message).To(ContainSubstring("nri.example.com/skip"))
+		})
+
+		It("should ignore annotation under the default domain", func() {
+			response := mutate(podKind, podWithAnnotations(map[string]string{
+				"k8s.v1.cni.cncf.io/networks":        "sriov-net",
+				"network-resources-injector.io/skip": "true",
+			}))
+			Expect(string(response.Patch)).To(ContainSubstring("/spec/containers/0/resources/requests"))
+		})
+	})
+	Describe("Tracing", func() {
+		var exporter *tracetest.InMemoryExporter
+		var previousProvider trace.TracerProvider
+		var previousPropagator propagation.TextMapPropagator
+
+		/* trace and parent span IDs sent by the API server */
+		const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		const parentSpanID = "00f067aa0ba902b7"
+
+		mutateTraced := func(traceparent string, annotations map[string]string) {
+			raw, err := json.Marshal(corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			body, err := json.Marshal(admissionv1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+				Request: &admissionv1.AdmissionRequest{
+					UID:       "test",
+					Kind:      podKind,
+					Namespace: "default",
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			req := httptest.NewRequest("POST", "https://fakewebhook/mutate", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("traceparent", traceparent)
+			w := httptest.NewRecorder()
+			MutateHandler(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+		}
+
+		spanByName := func(name string) tracetest.SpanStub {
+			for _, span := range exporter.GetSpans() {
+				if span.Name == name {
+					return span
+				}
+			}
+			Fail(fmt.Sprintf("span %s is not recorded", name))
+			return tracetest.SpanStub{}
+		}
+
+		BeforeEach(func() {
+			previousProvider = otel.GetTracerProvider()
+			previousPropagator = otel.GetTextMapPropagator()
+			exporter = tracetest.NewInMemoryExporter()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter),
+				sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample()))))
+			otel.SetTextMapPropagator(propagation.TraceContext{})
+
+			useNetAttachDefs(map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+			setupControlSwitches(nil)
+		})
+
+		AfterEach(func() {
+			otel.SetTracerProvider(previousProvider)
+			otel.SetTextMapPropagator(previousPropagator)
+			SetNetAttachDefCache(nil)
+		})
+
+		It("should continue trace sent in the request headers", func() {
+			mutateTraced("00-"+traceID+"-"+parentSpanID+"-01", map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"})
+
+			admission := spanByName(admissionSpanName)
+			Expect(admission.SpanContext.TraceID().String()).To(Equal(traceID))
+			Expect(admission.Parent.SpanID().String()).To(Equal(parentSpanID))
+			Expect(admission.Parent.IsRemote()).To(BeTrue())
+			for _, name := range []string{namespaceLookupSpanName, nadLookupSpanName, patchSpanName} {
+				Expect(spanByName(name).Parent.SpanID()).To(Equal(admission.SpanContext.SpanID()), name)
+			}
+		})
+
+		It("should mark failed net-attach-def lookup", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound"}`))
+			}))
+			defer server.Close()
+			var err error
+			defaultWebhook.clientset, err = kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { defaultWebhook.clientset = nil }()
+
+			mutateTraced("00-"+traceID+"-"+parentSpanID+"-01", map[string]string{"k8s.v1.cni.cncf.io/networks": "missing-net"})
+
+			lookup := spanByName(nadLookupSpanName)
+			Expect(lookup.Status.Code).To(Equal(codes.Error))
+		})
+
+		It("should not record requests not sampled by the API server", func() {
+			mutateTraced("00-"+traceID+"-"+parentSpanID+"-00", map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"})
+			Expect(exporter.GetSpans()).To(BeEmpty())
+		})
+	})
+	Describe("Lookup cancellation", func() {
+		var server *httptest.Server
+		var release chan struct{}
+		var block bool
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			block = true
+			/* API server answers only after the lookup is cancelled, unless it is asked not to block */
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if block {
+					select {
+					case <-r.Context().Done():
+					case <-release:
+					}
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			var err error
+			defaultWebhook.clientset, err = kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+			useNetAttachDefs(nil)
+			setupControlSwitches(nil).SetLookupTimeoutUnitTests(100 * time.Millisecond)
+		})
+
+		AfterEach(func() {
+			close(release)
+			server.Close()
+			defaultWebhook.clientset = nil
+			SetNetAttachDefCache(nil)
+			setupControlSwitches(nil)
+		})
+
+		It("should deny pod when net-attach-def lookup exceeds lookup timeout", func() {
+			start := time.Now()
+			response := mutate(podKind, podWithNetworks("sriov-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("sriov-net"))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+
+		It("should cancel owner lookup with the request context", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err := defaultWebhook.getNamespaceFromOwnerReference(ctx, metav1.OwnerReference{Kind: "ReplicaSet", Name: "app-rs"})
+			Expect(err).To(HaveOccurred())
+			Expect(isRetryableError(err)).To(BeTrue())
+		})
+
+		It("should cancel namespace lookup with the request context", func() {
+			defaultWebhook.controlSwitches.SetNamespaceLabelUnitTests("network-resources-injector")
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err := defaultWebhook.isInjectionEnabledForNamespace(ctx, "default")
+			Expect(err).To(HaveOccurred())
+			Expect(isServerError(err)).To(BeTrue())
+		})
+
+		It("should stop waiting for retry when the request context is done", func() {
+			defaultWebhook.controlSwitches.SetNadLookupRetriesUnitTests(3, time.Minute)
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(200*time.Millisecond, cancel)
+			block = false
+			start := time.Now()
+			_, err := defaultWebhook.getNetworkAttachmentDefinition(ctx, "default", "sriov-net")
+			Expect(err).To(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+	})
+	Describe("Preflight", func() {
+		var server *httptest.Server
+		var crdInstalled bool
+		var nads string
+		var configMap *corev1.ConfigMap
+
+		BeforeEach(func() {
+			crdInstalled = true
+			nads = `{"items": [{"metadata": {"name": "sriov-net", "namespace": "default",
+				"annotations": {"k8s.v1.cni.cncf.io/targetContainers": "app-.*"}}}]}`
+			configMap = &corev1.ConfigMap{Data: map[string]string{"config.json": `{"features": {"enableIdempotency": true},
+				"user-defined-injections": {"nri": {"op": "add", "path": "/metadata/annotations", "value": {"nri": "true"}}}}`}}
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.URL.Path == "/version":
+					w.Write([]byte(`{"major": "1", "minor": "28", "gitVersion": "v1.28.3"}`))
+				case r.URL.Path == "/apis/k8s.cni.cncf.io/v1" && crdInstalled:
+					w.Write([]byte(`{"kind": "APIResourceList", "groupVersion": "k8s.cni.cncf.io/v1",
+						"resources": [{"name": "network-attachment-definitions", "namespaced": true, "kind": "NetworkAttachmentDefinition", "verbs": ["get", "list"]}]}`))
+				case r.URL.Path == "/apis/k8s.cni.cncf.io/v1/network-attachment-definitions" && crdInstalled:
+					w.Write([]byte(nads))
+				case r.URL.Path == "/api/v1/namespaces/kube-system/configmaps/nri-control-switches" && configMap != nil:
+					json.NewEncoder(w).Encode(configMap)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`))
+				}
+			}))
+			var err error
+			defaultWebhook.clientset, err = kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+			setupControlSwitches(nil)
+		})
+
+		AfterEach(func() {
+			server.Close()
+			defaultWebhook.clientset = nil
+			setupControlSwitches(nil)
+		})
+
+		failedChecks := func(results []PreflightResult) map[string]string {
+			failed := make(map[string]string)
+			for _, result := range results {
+				if result.Err != nil {
+					failed[result.Check] = result.Err.Error()
+				}
+			}
+			return failed
+		}
+
+		It("should pass when webhook is configured correctly", func() {
+			report, passed := PreflightReport(Preflight(context.Background(), "kube-system", "nri-control-switches"))
+			Expect(passed).To(BeTrue(), report)
+			Expect(report).To(ContainSubstring("PASS net-attach-def CRD"))
+		})
+
+		It("should pass when config map does not exist", func() {
+			configMap = nil
+			Expect(failedChecks(Preflight(context.Background(), "kube-system", "nri-control-switches"))).To(BeEmpty())
+		})
+
+		It("should report invalid target containers of net-attach-defs", func() {
+			nads = `{"items": [{"metadata": {"name": "bad-net", "namespace": "ns1",
+				"annotations": {"k8s.v1.cni.cncf.io/targetContainers": "app-("}}}]}`
+			failed := failedChecks(Preflight(context.Background(), "kube-system", "nri-control-switches"))
+			Expect(failed).To(HaveLen(1))
+			Expect(failed["net-attach-def target containers"]).To(ContainSubstring("ns1/bad-net"))
+		})
+
+		It("should report invalid config map", func() {
+			configMap.Data["config.json"] = `{"features": {"enableIdempotence": true}}`
+			failed := failedChecks(Preflight(context.Background(), "kube-system", "nri-control-switches"))
+			Expect(failed["config map kube-system/nri-control-switches"]).To(ContainSubstring("enableIdempotence"))
+
+			configMap.Data["config.json"] = `{"user-defined-injections": {"nri": {"op": "add", "path": "/spec/nodeName", "value": "node1"}}}`
+			failed = failedChecks(Preflight(context.Background(), "kube-system", "nri-control-switches"))
+			Expect(failed["config map kube-system/nri-control-switches"]).To(ContainSubstring("invalid user-defined injections"))
+		})
+
+		It("should report missing net-attach-def CRD", func() {
+			crdInstalled = false
+			failed := failedChecks(Preflight(context.Background(), "kube-system", "nri-control-switches"))
+			Expect(failed).To(HaveKey("net-attach-def CRD"))
+			Expect(failed).To(HaveKey("net-attach-def target containers"))
+		})
+
+		It("should report all checks when API server is not reachable", func() {
+			server.Close()
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName,"))
+			switches.InitControlSwitches()
+			SetControlSwitches(switches)
+			report, passed := PreflightReport(Preflight(context.Background(), "kube-system", "nri-control-switches"))
+			Expect(passed).To(BeFalse())
+			Expect(report).To(ContainSubstring("FAIL resource name keys"))
+			Expect(report).To(ContainSubstring("FAIL API server"))
+			Expect(report).To(ContainSubstring("FAIL config map kube-system/nri-control-switches: API server is not reachable"))
+			Expect(report).To(ContainSubstring("PASS control switches"))
+		})
+	})
+	Describe("Network replicas", func() {
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		DescribeTable("should parse replicas of network selection elements",
+			func(selections string, expected []int64, valid bool) {
+				networks, err := parsePodNetworkSelections(selections, "default")
+				Expect(err).NotTo(HaveOccurred())
+				replicas := networkReplicas{}
+				err = parseNetworkReplicas(selections, networks, replicas)
+				if !valid {
+					Expect(err).To(HaveOccurred())
+					return
+				}
+				Expect(err).NotTo(HaveOccurred())
+				counts := []int64{}
+				for _, network := range networks {
+					counts = append(counts, replicas.get(network))
+				}
+				Expect(counts).To(Equal(expected))
+			},
+			Entry("comma separated list", "sriov-net@net1,sriov-net@net2", []int64{1, 1}, true),
+			Entry("JSON without replicas", `[{"name": "sriov-net"}]`, []int64{1}, true),
+			Entry("JSON with replicas", `[{"name": "sriov-net", "replicas": 4}, {"name": "sriov-net"}]`, []int64{4, 1}, true),
+			Entry("zero replicas", `[{"name": "sriov-net", "replicas": 0}]`, nil, false),
+			Entry("negative replicas", `[{"name": "sriov-net", "replicas": -2}]`, nil, false),
+			Entry("fractional replicas", `[{"name": "sriov-net", "replicas": 1.5}]`, nil, false),
+			Entry("replicas as string", `[{"name": "sriov-net", "replicas": "2"}]`, nil, false),
+		)
+
+		It("should multiply resources and companion resources by replicas", func() {
+			wh.controlSwitches.SetCompanionResourcesUnitTests(map[string][]controlswitches.CompanionResource{
+				"intel.com/sriov": {{ResourceName: "intel.com/rdma", Ratio: 2}},
+			})
+			network := &types.NetworkSelectionElement{Namespace: "default", Name: "sriov-net"}
+			reqs, _, _, _, err := wh.computeNetworkResources([]*types.NetworkSelectionElement{network}, networkReplicas{network: 3},
+				map[string]map[string]string{"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"}}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reqs).To(Equal(map[string]int64{"intel.com/sriov": 3, "intel.com/rdma": 6}))
+		})
+
+		It("should inject resources requested by replicas", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks(`[{"name": "sriov-net", "replicas": 3}, {"name": "sriov-net", "interface": "net2"}]`))
+			Expect(response.Allowed).To(BeTrue())
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			Expect(patch).To(ContainElement(nritypes.JsonPatchOperation{
+				Operation: "add", Path: "/spec/containers/0/resources/limits/intel.com~1sriov", Value: "4",
+			}))
+		})
+
+		It("should deny pod with invalid replicas", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks(`[{"name": "sriov-net", "replicas": 0}]`))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(Equal("network selection element 0 has invalid replicas '0', positive integer is expected"))
+		})
+
+		It("should cap replicas with the maximal resource count", func() {
+			wh.controlSwitches.SetMaxResourceCountUnitTests(2, controlswitches.ResourceCapDeny)
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks(`[{"name": "sriov-net", "replicas": 100}]`))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(Equal("network 'default/sriov-net' requests 100 replicas, at most 2 is allowed"))
+
+			wh.controlSwitches.SetMaxResourceCountUnitTests(2, controlswitches.ResourceCapClamp)
+			response = mutateWith(wh.MutateHandler, podKind, podWithNetworks(`[{"name": "sriov-net", "replicas": 100}]`))
+			Expect(response.Allowed).To(BeTrue())
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			Expect(patch).To(ContainElement(nritypes.JsonPatchOperation{
+				Operation: "add", Path: "/spec/containers/0/resources/limits/intel.com~1sriov", Value: "2",
+			}))
+		})
+	})
+	Describe("Runtime class overrides", func() {
+		podWith := func(runtimeClass, networks string) corev1.Pod {
+			pod := podWithNetworks(networks)
+			pod.Spec.Containers = []corev1.Container{{Name: "app"}, {Name: "vm-worker"}, {Name: "dpdk-worker"}}
+			if runtimeClass != "" {
+				pod.Spec.RuntimeClassName = &runtimeClass
+			}
+			return pod
+		}
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, map[string]map[string]string{
+				"default/plain-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/dpdk-net": {
+					"k8s.v1.cni.cncf.io/resourceName":     "intel.com/sriov",
+					"k8s.v1.cni.cncf.io/targetContainers": "dpdk-.*",
+				},
+			})
+			wh.controlSwitches.SetRuntimeClassOverridesUnitTests(map[string]controlswitches.RuntimeClassOverride{
+				"kata":   {SkipDownwardAPIVolume: true, TargetContainers: "vm-.*"},
+				"gvisor": {SkipDownwardAPIVolume: true},
+			})
+		})
+
+		It("should keep the default behavior for pod without overridden runtime class", func() {
+			for _, runtimeClass := range []string{"", "runc"} {
+				values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith(runtimeClass, "plain-net")))
+				Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+				Expect(values).To(HaveKey("/spec/volumes"))
+			}
+		})
+
+		It("should not inject Downward API volume for the runtime class", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("gvisor", "plain-net")))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+			Expect(values).NotTo(HaveKey("/spec/volumes"))
+			Expect(values).NotTo(HaveKey("/spec/containers/0/volumeMounts"))
+		})
+
+		It("should inject resources of networks without target into containers of the runtime class", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("kata", "plain-net,plain-net,dpdk-net")))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/1/resources/requests/intel.com~1sriov", "2"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/2/resources/requests/intel.com~1sriov", "1"))
+			Expect(values).NotTo(HaveKey("/spec/containers/0/resources/requests"))
+			Expect(values).NotTo(HaveKey("/spec/volumes"))
+		})
+	})
+	Describe("Net-attach-def cache inspection", func() {
+		var wh *Webhook
+
+		list := func(query string) (int, []CachedNetAttachDef) {
+			w := httptest.NewRecorder()
+			wh.CacheHandler(w, httptest.NewRequest("GET", "https://fakewebhook/cache/net-attach-defs"+query, nil))
+			var nads []CachedNetAttachDef
+			if w.Code == http.StatusOK {
+				Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+				Expect(json.Unmarshal(w.Body.Bytes(), &nads)).To(Succeed())
+			}
+			return w.Code, nads
+		}
+
+		BeforeEach(func() {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false),
+				createString("k8s.v1.cni.cncf.io/resourceName,example.com/resourceName"))
+			switches.InitControlSwitches()
+			wh = NewWebhook(nil, switches)
+			wh.SetNetAttachDefCache(fakeNetAttachDefCache{annotations: map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov", "k8s.v1.cni.cncf.io/nodeSelector": "nic=e810"},
+				"default/plain-net": {"description": "no resources"},
+				"infra/dual-net":    {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov", "example.com/resourceName": "example.com/mgmt"},
+			}})
+		})
+
+		It("should list cached net-attach-defs with resource names and node selectors", func() {
+			code, nads := list("")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(nads).To(Equal([]CachedNetAttachDef{
+				{Namespace: "default", Name: "plain-net"},
+				{Namespace: "default", Name: "sriov-net", ResourceNames: map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+					NodeSelector: "nic=e810"},
+				{Namespace: "infra", Name: "dual-net", ResourceNames: map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov",
+					"example.com/resourceName": "example.com/mgmt"}},
+			}))
+		})
+
+		It("should filter net-attach-defs by namespace and name", func() {
+			_, nads := list("?namespace=default")
+			Expect(nads).To(HaveLen(2))
+			_, nads = list("?namespace=default&name=sriov-net")
+			Expect(nads).To(HaveLen(1))
+			Expect(nads[0].Name).To(Equal("sriov-net"))
+			code, nads := list("?namespace=missing")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(nads).To(BeEmpty())
+		})
+
+		It("should reject other methods and missing cache", func() {
+			w := httptest.NewRecorder()
+			wh.CacheHandler(w, httptest.NewRequest("POST", "https://fakewebhook/cache/net-attach-defs", nil))
+			Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+
+			wh.SetNetAttachDefCache(nil)
+			code, _ := list("")
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+	Describe("JSON patch paths of resource names", func() {
+		/* patch is applied the way API server applies it */
+		applyPatch := func(pod corev1.Pod, patch []nritypes.JsonPatchOperation) corev1.Pod {
+			original, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			patchBytes, err := json.Marshal(patch)
+			Expect(err).NotTo(HaveOccurred())
+			decoded, err := jsonpatch.DecodePatch(patchBytes)
+			Expect(err).NotTo(HaveOccurred())
+			patched, err := decoded.Apply(original)
+			Expect(err).NotTo(HaveOccurred())
+			var patchedPod corev1.Pod
+			Expect(json.Unmarshal(patched, &patchedPod)).To(Succeed())
+			return patchedPod
+		}
+		expectResource := func(resources corev1.ResourceRequirements, resourceName string, count int64) {
+			Expect(resources.Requests).To(HaveLen(1))
+			Expect(resources.Limits).To(HaveLen(1))
+			quantity := resources.Requests[corev1.ResourceName(resourceName)]
+			Expect(quantity.Value()).To(Equal(count))
+			quantity = resources.Limits[corev1.ResourceName(resourceName)]
+			Expect(quantity.Value()).To(Equal(count))
+		}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, nil)
+		})
+
+		DescribeTable("should add resource under its exact name",
+			func(resourceName string) {
+				By("injecting into app container without resources")
+				pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+				patch, err := wh.createResourcePatch(nil, pod.Spec.Containers, map[string]int64{resourceName: 2})
+				Expect(err).NotTo(HaveOccurred())
+				expectResource(applyPatch(pod, patch).Spec.Containers[0].Resources, resourceName, 2)
+
+				By("completing resource set only in limits")
+				pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{corev1.ResourceName(resourceName): resource.MustParse("3")}
+				patch, err = wh.createResourcePatch(nil, pod.Spec.Containers, map[string]int64{resourceName: 2})
+				Expect(err).NotTo(HaveOccurred())
+				expectResource(applyPatch(pod, patch).Spec.Containers[0].Resources, resourceName, 3)
+
+				By("injecting into init container")
+				pod = corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}},
+					InitContainers: []corev1.Container{{Name: "init"}}}}
+				patch = wh.createInitContainersResourcePatch(nil, pod.Spec.InitContainers, map[string]int64{resourceName: 1})
+				expectResource(applyPatch(pod, patch).Spec.InitContainers[0].Resources, resourceName, 1)
+			},
+			Entry("domain and name", "intel.com/sriov"),
+			Entry("subdomain", "nic.example.com/vf"),
+			Entry("dots", "example.com/sriov.vf.1"),
+			Entry("dashes and underscores", "example.com/sriov_vf-1"),
+		)
+
+		/* names which cannot be escaped back from the patch path never reach it, resource names have to be qualified names */
+		DescribeTable("should reject resource name which is not qualified name",
+			func(resourceName string) {
+				_, err := parseResourceNames(resourceName)
+				Expect(err).To(MatchError(HavePrefix("invalid resource name '" + resourceName + "'")))
+				_, err = parseResourceMap(`{"` + resourceName + `": 1}`)
+				Expect(err).To(MatchError(HavePrefix("invalid resource name '" + resourceName + "'")))
+			},
+			Entry("more slashes", "example.com/nic/vf"),
+			Entry("leading and trailing slash", "/example.com/sriov/"),
+			Entry("tilde", "example.com/vf~1"),
+			Entry("tilde followed by slash", "example.com/a~/b"),
+			Entry("unicode", "example.com/réseau-网络"),
+		)
+
+		It("should add resources into pod template of workload controller", func() {
+			deployment := appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}}}
+			patch, err := wh.createResourcePatch(nil, deployment.Spec.Template.Spec.Containers, map[string]int64{"nic.example.com/vf": 1})
+			Expect(err).NotTo(HaveOccurred())
+			original, err := json.Marshal(deployment)
+			Expect(err).NotTo(HaveOccurred())
+			patchBytes, err := json.Marshal(prefixPatchPaths(patch, podTemplatePath))
+			Expect(err).NotTo(HaveOccurred())
+			decoded, err := jsonpatch.DecodePatch(patchBytes)
+			Expect(err).NotTo(HaveOccurred())
+			patched, err := decoded.Apply(original)
+			Expect(err).NotTo(HaveOccurred())
+			var patchedDeployment appsv1.Deployment
+			Expect(json.Unmarshal(patched, &patchedDeployment)).To(Succeed())
+			expectResource(patchedDeployment.Spec.Template.Spec.Containers[0].Resources, "nic.example.com/vf", 1)
+		})
+
+		DescribeTable("should escape and unescape JSON pointer reference token",
+			func(key, token string) {
+				Expect(toSafeJsonPatchKey(key)).To(Equal(token))
+				Expect(fromSafeJsonPatchKey(token)).To(Equal(key))
+			},
+			Entry("plain", "sriov", "sriov"),
+			Entry("slash", "intel.com/sriov", "intel.com~1sriov"),
+			Entry("tilde", "a~b", "a~0b"),
+			Entry("escaped slash literal", "a~1b", "a~01b"),
+			Entry("escaped tilde literal", "a~0b", "a~00b"),
+			Entry("tilde and slash", "~/", "~0~1"),
+			Entry("unicode", "réseau/网络", "réseau~1网络"),
+		)
+	})
+	Describe("Owner network annotation", func() {
+		var server *httptest.Server
+		var requests []string
+		isController := true
+		podOwnedBy := func(kind, name, uid string, annotations map[string]string) corev1.Pod {
+			pod := podWithAnnotations(annotations)
+			pod.ObjectMeta.OwnerReferences = []metav1.OwnerReference{{Kind: kind, Name: name, UID: k8stypes.UID(uid), Controller: &isController}}
+			return pod
+		}
+		var wh *Webhook
+
+		BeforeEach(func() {
+			requests = nil
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.URL.Path)
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/apis/apps/v1/namespaces/default/replicasets/annotated-rs":
+					w.Write([]byte(`{"kind": "ReplicaSet", "apiVersion": "apps/v1", "metadata": {"name": "annotated-rs",
+						"namespace": "default", "uid": "annotated-rs-uid", "annotations": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}}}`))
+				case "/apis/apps/v1/namespaces/default/replicasets/app-rs":
+					w.Write([]byte(`{"kind": "ReplicaSet", "apiVersion": "apps/v1", "metadata": {"name": "app-rs",
+						"namespace": "default", "uid": "app-rs-uid", "ownerReferences": [{"apiVersion": "apps/v1",
+						"kind": "Deployment", "name": "app", "uid": "app-uid", "controller": true}]}}`))
+				case "/apis/apps/v1/namespaces/default/deployments/app":
+					w.Write([]byte(`{"kind": "Deployment", "apiVersion": "apps/v1", "metadata": {"name": "app",
+						"namespace": "default", "uid": "app-uid", "annotations": {"k8s.v1.cni.cncf.io/networks": "sriov-net,sriov-net"}}}`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`))
+				}
+			}))
+			wh = newTestWebhook(map[string]bool{"ownerNetworkAnnotation": true}, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+			var err error
+			wh.clientset, err = kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should inject resources of networks annotated on the owning ReplicaSet", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podOwnedBy("ReplicaSet", "annotated-rs", "annotated-rs-uid", nil)))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+			Expect(values).To(HaveKeyWithValue("/metadata/annotations",
+				HaveKeyWithValue("k8s.v1.cni.cncf.io/networks", "sriov-net")))
+		})
+
+		It("should inject resources of networks annotated on the Deployment owning the ReplicaSet", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podOwnedBy("ReplicaSet", "app-rs", "app-rs-uid",
+				map[string]string{"description": "kept"})))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "2"))
+			Expect(values).To(HaveKeyWithValue("/metadata/annotations", And(
+				HaveKeyWithValue("k8s.v1.cni.cncf.io/networks", "sriov-net,sriov-net"),
+				HaveKeyWithValue("description", "kept"))))
+			Expect(requests).To(Equal([]string{"/apis/apps/v1/namespaces/default/replicasets/app-rs",
+				"/apis/apps/v1/namespaces/default/deployments/app"}))
+		})
+
+		It("should cache owner lookups", func() {
+			pod := podOwnedBy("ReplicaSet", "app-rs", "app-rs-uid", nil)
+			patchValues(mutateWith(wh.MutateHandler, podKind, pod))
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, pod))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "2"))
+			Expect(requests).To(HaveLen(2))
+		})
+
+		It("should prefer network annotation of the pod", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podOwnedBy("ReplicaSet", "app-rs", "app-rs-uid",
+				map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"})))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+			Expect(requests).To(BeEmpty())
+		})
+
+		It("should not read owner annotation when disabled", func() {
+			wh.controlSwitches = newControlSwitches(nil)
+			response := mutateWith(wh.MutateHandler, podKind, podOwnedBy("ReplicaSet", "annotated-rs", "annotated-rs-uid", nil))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeNil())
+			Expect(requests).To(BeEmpty())
+		})
+
+		It("should ignore owner with different UID", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podOwnedBy("ReplicaSet", "annotated-rs", "recreated-uid", nil))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeNil())
+		})
+
+		It("should ignore owner which does not exist", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podOwnedBy("ReplicaSet", "deleted-rs", "deleted-uid", nil))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeNil())
+			Expect(requests).To(HaveLen(1))
+		})
+	})
+	Describe("Hugepage volume", func() {
+		hugepages := func(quantities map[corev1.ResourceName]string) corev1.ResourceRequirements {
+			resources := corev1.ResourceRequirements{Limits: corev1.ResourceList{}}
+			for resourceName, quantity := range quantities {
+				resources.Limits[resourceName] = resource.MustParse(quantity)
+			}
+			return resources
+		}
+		podWith := func(containers ...corev1.Container) corev1.Pod {
+			pod := podWithNetworks("sriov-net")
+			pod.Spec.Containers = containers
+			return pod
+		}
+		hugepageVolume := func(name, medium, sizeLimit string) corev1.Volume {
+			quantity := resource.MustParse(sizeLimit)
+			return corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{
+				Medium: corev1.StorageMedium(medium), SizeLimit: &quantity}}}
+		}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(map[string]bool{"injectHugepageVolume": true}, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		It("should inject hugepage volume into containers requesting hugepages", func() {
+			pod := patchedPodBy(wh, podWith(
+				corev1.Container{Name: "app", Resources: hugepages(map[corev1.ResourceName]string{"hugepages-1Gi": "2Gi"})},
+				corev1.Container{Name: "sidecar"}))
+			Expect(pod.Spec.Volumes).To(ContainElement(hugepageVolume("hugepages-1gi-0", "HugePages-1Gi", "2Gi")))
+			Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "hugepages-1gi-0", MountPath: "/hugepages"}))
+			Expect(pod.Spec.Containers[1].VolumeMounts).NotTo(ContainElement(HaveField("Name", HavePrefix("hugepages"))))
+			Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(HaveField("Name", "podnetinfo")))
+		})
+
+		It("should inject volume of every hugepage size under size suffixed path", func() {
+			pod := patchedPodBy(wh, podWith(corev1.Container{Name: "app"}, corev1.Container{Name: "dpdk",
+				Resources: hugepages(map[corev1.ResourceName]string{"hugepages-1Gi": "1Gi", "hugepages-2Mi": "512Mi"})}))
+			Expect(pod.Spec.Volumes).To(ContainElements(hugepageVolume("hugepages-1gi-1", "HugePages-1Gi", "1Gi"),
+				hugepageVolume("hugepages-2mi-1", "HugePages-2Mi", "512Mi")))
+			Expect(pod.Spec.Containers[1].VolumeMounts).To(ContainElements(
+				corev1.VolumeMount{Name: "hugepages-1gi-1", MountPath: "/hugepages-1Gi"},
+				corev1.VolumeMount{Name: "hugepages-2mi-1", MountPath: "/hugepages-2Mi"}))
+		})
+
+		It("should mount hugepage volume at the configured path", func() {
+			wh.controlSwitches.SetHugepageMountPathUnitTests("/dev/hugepages")
+			pod := patchedPodBy(wh, podWith(corev1.Container{Name: "app", Resources: hugepages(map[corev1.ResourceName]string{"hugepages-2Mi": "64Mi"})}))
+			Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "hugepages-2mi-0", MountPath: "/dev/hugepages"}))
+		})
+
+		It("should not inject hugepage volume when pod defines one", func() {
+			pod := podWith(corev1.Container{Name: "app", Resources: hugepages(map[corev1.ResourceName]string{"hugepages-2Mi": "64Mi"}),
+				VolumeMounts: []corev1.VolumeMount{{Name: "hp", MountPath: "/mnt/huge"}}})
+			pod.Spec.Volumes = []corev1.Volume{{Name: "hp", VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumHugePages}}}}
+			patched := patchedPodBy(wh, pod)
+			Expect(patched.Spec.Volumes).To(HaveLen(2))
+			Expect(patched.Spec.Containers[0].VolumeMounts).NotTo(ContainElement(HaveField("MountPath", "/hugepages")))
+		})
+
+		It("should not mount hugepage volume over other mount of the container", func() {
+			pod := patchedPodBy(wh, podWith(corev1.Container{Name: "app", Resources: hugepages(map[corev1.ResourceName]string{"hugepages-2Mi": "64Mi"}),
+				VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/hugepages"}}}))
+			Expect(pod.Spec.Volumes).NotTo(ContainElement(HaveField("Name", HavePrefix("hugepages"))))
+		})
+
+		It("should inject hugepage volume into init containers", func() {
+			wh.controlSwitches = newControlSwitches(map[string]bool{"injectHugepageVolume": true, "injectIntoInitContainers": true})
+			pod := podWith(corev1.Container{Name: "app"})
+			pod.Spec.InitContainers = []corev1.Container{{Name: "init", Resources: hugepages(map[corev1.ResourceName]string{"hugepages-2Mi": "64Mi"})}}
+			patched := patchedPodBy(wh, pod)
+			Expect(patched.Spec.Volumes).To(ContainElement(hugepageVolume("hugepages-init-2mi-0", "HugePages-2Mi", "64Mi")))
+			Expect(patched.Spec.InitContainers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "hugepages-init-2mi-0", MountPath: "/hugepages"}))
+		})
+
+		It("should not inject hugepage volume when disabled", func() {
+			wh.controlSwitches = newControlSwitches(nil)
+			pod := patchedPodBy(wh, podWith(corev1.Container{Name: "app", Resources: hugepages(map[corev1.ResourceName]string{"hugepages-2Mi": "64Mi"})}))
+			Expect(pod.Spec.Volumes).NotTo(ContainElement(HaveField("Name", HavePrefix("hugepages"))))
+		})
+	})
+	Describe("Target container images", func() {
+		podWith := func(runtimeClass, networks string) corev1.Pod {
+			pod := podWithNetworks(networks)
+			pod.Spec.Containers = []corev1.Container{
+				{Name: "app", Image: "registry.example.com/app:1.0"},
+				{Name: "vm-worker", Image: "registry.example.com/vm:1.0"},
+				{Name: "worker", Image: "registry.example.com/dpdk/testpmd:22.11"},
+				{Name: "sidecar", Image: "registry.example.com/dpdk/testpmd:22.11"},
+			}
+			if runtimeClass != "" {
+				pod.Spec.RuntimeClassName = &runtimeClass
+			}
+			return pod
+		}
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, map[string]map[string]string{
+				"default/plain-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/app-net": {
+					"k8s.v1.cni.cncf.io/resourceName":     "intel.com/sriov",
+					"k8s.v1.cni.cncf.io/targetContainers": "app",
+				},
+			})
+			Expect(wh.controlSwitches.SetTargetContainerImagesUnitTests("registry.example.com/dpdk/.*")).To(Succeed())
+			wh.controlSwitches.SetRuntimeClassOverridesUnitTests(map[string]controlswitches.RuntimeClassOverride{
+				"kata": {TargetContainers: "vm-.*"},
+			})
+		})
+
+		It("should inject resources into the first container running target image", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("", "plain-net,plain-net")))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/2/resources/requests/intel.com~1sriov", "2"))
+			Expect(values).NotTo(HaveKey("/spec/containers/0/resources/requests"))
+			Expect(values).NotTo(HaveKey("/spec/containers/3/resources/requests"))
+		})
+
+		It("should keep target of networks targeting containers", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("", "plain-net,app-net")))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/2/resources/requests/intel.com~1sriov", "1"))
+		})
+
+		It("should prefer target containers of the runtime class", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("kata", "plain-net")))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/1/resources/requests/intel.com~1sriov", "1"))
+			Expect(values).NotTo(HaveKey("/spec/containers/2/resources/requests"))
+		})
+
+		It("should inject resources into the first container when no image matches", func() {
+			pod := podWith("", "plain-net")
+			pod.Spec.Containers = pod.Spec.Containers[:2]
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, pod))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+		})
+
+		It("should match the whole image", func() {
+			Expect(wh.controlSwitches.SetTargetContainerImagesUnitTests("dpdk/.*")).To(Succeed())
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith("", "plain-net")))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+		})
+	})
+	Describe("Label selector networks", func() {
+		podWith := func(podLabels, annotations map[string]string) corev1.Pod {
+			pod := podWithAnnotations(annotations)
+			pod.ObjectMeta.Labels = podLabels
+			return pod
+		}
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"infra/dpdk-net":    {"k8s.v1.cni.cncf.io/resourceName": "intel.com/dpdk"},
+				"default/other-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/other"},
+			})
+			Expect(wh.controlSwitches.SetLabelSelectorNetworksUnitTests(
+				"network=dataplane:sriov-net; network=dataplane,tier in (dpdk):infra/dpdk-net;network in (dataplane):sriov-net")).To(Succeed())
+		})
+
+		It("should inject resources of networks selected by pod labels", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{"network": "dataplane", "tier": "dpdk"}, nil)))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1dpdk", "1"))
+			Expect(values).To(HaveKeyWithValue("/metadata/annotations",
+				HaveKeyWithValue("k8s.v1.cni.cncf.io/networks", "sriov-net,infra/dpdk-net")))
+		})
+
+		It("should prefer network annotation of the pod", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{"network": "dataplane"},
+				map[string]string{"k8s.v1.cni.cncf.io/networks": "other-net"})))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1other", "1"))
+			Expect(values).NotTo(HaveKey("/spec/containers/0/resources/requests/intel.com~1sriov"))
+		})
+
+		It("should not inject resources into pod not matching any selector", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, podWith(map[string]string{"network": "management"}, nil)))
+			Expect(values).To(BeEmpty())
+		})
+	})
+	Describe("Resource removal", func() {
+		podWith := func(removed string) corev1.Pod {
+			return corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default",
+					Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net",
+						"k8s.v1.cni.cncf.io/removeResources": removed}},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "init", Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{"intel.com/legacy": resource.MustParse("1")},
+					}}},
+					Containers: []corev1.Container{
+						{Name: "app", Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{"intel.com/legacy": resource.MustParse("1"), "intel.com/sriov": resource.MustParse("1")},
+							Limits:   corev1.ResourceList{"intel.com/legacy": resource.MustParse("1"), "intel.com/sriov": resource.MustParse("1")},
+						}},
+						{Name: "sidecar"},
+					},
+				},
+			}
+		}
+		removed := func(patch []nritypes.JsonPatchOperation) []string {
+			var paths []string
+			for _, operation := range patch {
+				if operation.Operation == "remove" {
+					paths = append(paths, operation.Path)
+				}
+			}
+			return paths
+		}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(map[string]bool{"removeResources": true, "enableValidatePatch": true, "enableStrategicMergePatch": true}, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		It("should remove annotated resources defined by containers", func() {
+			patch := patchOf(mutateWith(wh.MutateHandler, podKind, podWith("intel.com/legacy, intel.com/missing")))
+			Expect(removed(patch)).To(Equal([]string{
+				"/spec/initContainers/0/resources/limits/intel.com~1legacy",
+				"/spec/containers/0/resources/requests/intel.com~1legacy",
+				"/spec/containers/0/resources/limits/intel.com~1legacy",
+			}))
+		})
+
+		It("should inject removed resource requested by pod networks", func() {
+			patch := patchOf(mutateWith(wh.MutateHandler, podKind, podWith("intel.com/sriov")))
+			Expect(removed(patch)).To(Equal([]string{
+				"/spec/containers/0/resources/requests/intel.com~1sriov",
+				"/spec/containers/0/resources/limits/intel.com~1sriov",
+			}))
+			Expect(patch).To(ContainElement(nritypes.JsonPatchOperation{Operation: "add",
+				Path: "/spec/containers/0/resources/requests/intel.com~1sriov", Value: "1"}))
+		})
+
+		It("should render removal in strategic merge patch", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith("intel.com/legacy"))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.AuditAnnotations["strategic-merge-patch"]).To(ContainSubstring(`"intel.com/legacy":null`))
+		})
+
+		It("should not remove resources when switch is disabled", func() {
+			wh.controlSwitches = newControlSwitches(nil)
+			patch := patchOf(mutateWith(wh.MutateHandler, podKind, podWith("intel.com/legacy")))
+			Expect(removed(patch)).To(BeEmpty())
+		})
+
+		It("should deny pod with invalid resource name in the annotation", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith("intel.com/legacy,not valid"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("k8s.v1.cni.cncf.io/removeResources"))
+		})
+	})
+	Describe("Pod-level resources", func() {
+		podWith := func(resources string) json.RawMessage {
+			spec := `"containers": [{"name": "app", "resources": {}}, {"name": "sidecar", "resources": {}}]`
+			if resources != "" {
+				spec += `, "resources": ` + resources
+			}
+			return json.RawMessage(`{"metadata": {"name": "test", "namespace": "default",
+				"annotations": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}}, "spec": {` + spec + `}}`)
+		}
+		paths := func(patch []nritypes.JsonPatchOperation) []string {
+			var result []string
+			for _, operation := range patch {
+				result = append(result, operation.Path)
+			}
+			return result
+		}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(map[string]bool{"podLevelResources": true, "enableValidatePatch": true}, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		It("should inject resources at pod level of pod defining pod-level resources", func() {
+			patch := patchOf(mutateWith(wh.MutateHandler, podKind, podWith(`{"requests": {"cpu": "2"}, "limits": {"cpu": "2"}}`)))
+			Expect(patch).To(ContainElements(
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/resources/requests/intel.com~1sriov", Value: "1"},
+				nritypes.JsonPatchOperation{Operation: "add", Path: "/spec/resources/limits/intel.com~1sriov", Value: "1"},
+			))
+			Expect(paths(patch)).NotTo(ContainElement(HavePrefix("/spec/containers/0/resources")))
+		})
+
+		It("should add empty pod-level requests and limits first", func() {
+			patch := patchOf(mutateWith(wh.MutateHandler, podKind, podWith(`{}`)))
+			Expect(paths(patch)).To(ContainElements("/spec/resources/requests", "/spec/resources/limits",
+				"/spec/resources/requests/intel.com~1sriov", "/spec/resources/limits/intel.com~1sriov"))
+		})
+
+		It("should keep resource defined at pod level or by a container", func() {
+			patch := patchOf(mutateWith(wh.MutateHandler, podKind, podWith(`{"limits": {"intel.com/sriov": "2"}}`)))
+			Expect(paths(patch)).NotTo(ContainElement(ContainSubstring("intel.com~1sriov")))
+
+			raw := json.RawMessage(`{"metadata": {"name": "test", "namespace": "default",
+				"annotations": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}}, "spec": {"resources": {},
+				"containers": [{"name": "app"}, {"name": "sidecar", "resources": {"limits": {"intel.com/sriov": "1"}}}]}}`)
+			patch = patchOf(mutateWith(wh.MutateHandler, podKind, raw))
+			Expect(paths(patch)).NotTo(ContainElement(HavePrefix("/spec/resources")))
+		})
+
+		It("should inject resources into the first container of pod without pod-level resources", func() {
+			patch := patchOf(mutateWith(wh.MutateHandler, podKind, podWith("")))
+			Expect(paths(patch)).To(ContainElement("/spec/containers/0/resources/requests/intel.com~1sriov"))
+			Expect(paths(patch)).NotTo(ContainElement(HavePrefix("/spec/resources")))
+		})
+
+		It("should inject resources into the first container when switch is disabled", func() {
+			wh.controlSwitches = newControlSwitches(nil)
+			patch := patchOf(mutateWith(wh.MutateHandler, podKind, podWith(`{"requests": {"cpu": "2"}}`)))
+			Expect(paths(patch)).To(ContainElement("/spec/containers/0/resources/requests/intel.com~1sriov"))
+			Expect(paths(patch)).NotTo(ContainElement(HavePrefix("/spec/resources")))
+		})
+
+		It("should inject resources at pod level of pod template", func() {
+			wh.controlSwitches = newControlSwitches(map[string]bool{"podLevelResources": true, "enableWorkloadControllers": true})
+			raw := json.RawMessage(`{"metadata": {"name": "app", "namespace": "default"}, "spec": {"selector": {},
+				"template": {"metadata": {"annotations": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}},
+				"spec": {"containers": [{"name": "app"}], "resources": {"requests": {"cpu": "2"}}}}}}`)
+			patch := patchOf(mutateWith(wh.MutateHandler, metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, raw))
+			Expect(paths(patch)).To(ContainElements("/spec/template/spec/resources/limits",
+				"/spec/template/spec/resources/requests/intel.com~1sriov"))
+		})
+	})
+	Describe("Tolerations", func() {
+		podWith := func(network string, tolerations string) json.RawMessage {
+			spec := `"containers": [{"name": "app", "resources": {}}]`
+			if tolerations != "" {
+				spec += `, "tolerations": ` + tolerations
+			}
+			return json.RawMessage(`{"metadata": {"name": "test", "namespace": "default",
+				"annotations": {"k8s.v1.cni.cncf.io/networks": "` + network + `"}}, "spec": {` + spec + `}}`)
+		}
+		tolerationsPatch := func(response *admissionv1.AdmissionResponse) []nritypes.JsonPatchOperation {
+			Expect(response.Allowed).To(BeTrue())
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			var result []nritypes.JsonPatchOperation
+			for _, operation := range patch {
+				if strings.HasPrefix(operation.Path, "/spec/tolerations") {
+					result = append(result, operation)
+				}
+			}
+			return result
+		}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(map[string]bool{"enableValidatePatch": true}, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/plain-net": {},
+			})
+			Expect(wh.controlSwitches.SetTolerationsUnitTests("sriov=true:NoSchedule,example.com/nic:NoExecute")).To(Succeed())
+		})
+
+		It("should add tolerations to pod without tolerations", func() {
+			patch := tolerationsPatch(mutateWith(wh.MutateHandler, podKind, podWith("sriov-net", "")))
+			Expect(patch).To(Equal([]nritypes.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/tolerations",
+				Value: []interface{}{
+					map[string]interface{}{"key": "sriov", "operator": "Equal", "value": "true", "effect": "NoSchedule"},
+					map[string]interface{}{"key": "example.com/nic", "operator": "Exists", "effect": "NoExecute"},
+				},
+			}}))
+		})
+
+		It("should append only tolerations the pod does not define", func() {
+			patch := tolerationsPatch(mutateWith(wh.MutateHandler, podKind, podWith("sriov-net",
+				`[{"key": "sriov", "operator": "Equal", "value": "true", "effect": "NoSchedule"}, {"key": "dedicated", "operator": "Exists"}]`)))
+			Expect(patch).To(Equal([]nritypes.JsonPatchOperation{{
+				Operation: "add",
+				Path:      "/spec/tolerations/-",
+				Value:     map[string]interface{}{"key": "example.com/nic", "operator": "Exists", "effect": "NoExecute"},
+			}}))
+		})
+
+		It("should not add tolerations the pod already defines", func() {
+			patch := tolerationsPatch(mutateWith(wh.MutateHandler, podKind, podWith("sriov-net",
+				`[{"key": "example.com/nic", "operator": "Exists", "effect": "NoExecute", "tolerationSeconds": 60},
+				{"key": "sriov", "operator": "Equal", "value": "true", "effect": "NoSchedule"}]`)))
+			Expect(patch).To(BeEmpty())
+		})
+
+		It("should not add tolerations to pod without network resources", func() {
+			patch := tolerationsPatch(mutateWith(wh.MutateHandler, podKind, podWith("plain-net", "")))
+			Expect(patch).To(BeEmpty())
+		})
+
+		It("should not add tolerations when none are configured", func() {
+			wh.controlSwitches = newControlSwitches(nil)
+			patch := tolerationsPatch(mutateWith(wh.MutateHandler, podKind, podWith("sriov-net", "")))
+			Expect(patch).To(BeEmpty())
+		})
+	})
+	Describe("All containers injection", func() {
+		podWith := func(sidecarResources string) json.RawMessage {
+			return json.RawMessage(`{"metadata": {"name": "test", "namespace": "default",
+				"annotations": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}}, "spec": {"containers": [
+				{"name": "primary", "resources": {}},
+				{"name": "secondary", "resources": ` + sidecarResources + `},
+				{"name": "tertiary", "resources": {}}]}}`)
+		}
+		resourcePatch := func(response *admissionv1.AdmissionResponse) []nritypes.JsonPatchOperation {
+			Expect(response.Allowed).To(BeTrue())
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			var result []nritypes.JsonPatchOperation
+			for _, operation := range patch {
+				if strings.HasSuffix(operation.Path, "intel.com~1sriov") {
+					result = append(result, operation)
+				}
+			}
+			return result
+		}
+		resourceOperation := func(containerIndex int, field string, value string) nritypes.JsonPatchOperation {
+			return nritypes.JsonPatchOperation{Operation: "add",
+				Path: fmt.Sprintf("/spec/containers/%d/resources/%s/intel.com~1sriov", containerIndex, field), Value: value}
+		}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(map[string]bool{"injectIntoAllContainers": true, "enableValidatePatch": true}, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		It("should inject resources into every container", func() {
+			patch := resourcePatch(mutateWith(wh.MutateHandler, podKind, podWith(`{}`)))
+			Expect(patch).To(ConsistOf(
+				resourceOperation(0, "requests", "1"), resourceOperation(0, "limits", "1"),
+				resourceOperation(1, "requests", "1"), resourceOperation(1, "limits", "1"),
+				resourceOperation(2, "requests", "1"), resourceOperation(2, "limits", "1"),
+			))
+		})
+
+		It("should keep resource defined by one container and inject it into the others", func() {
+			patch := resourcePatch(mutateWith(wh.MutateHandler, podKind, podWith(`{"requests": {"intel.com/sriov": "2"}, "limits": {"intel.com/sriov": "2"}}`)))
+			Expect(patch).To(ConsistOf(
+				resourceOperation(0, "requests", "1"), resourceOperation(0, "limits", "1"),
+				resourceOperation(2, "requests", "1"), resourceOperation(2, "limits", "1"),
+			))
+		})
+
+		It("should honor existing resources of every container on its own", func() {
+			wh.controlSwitches = newControlSwitches(map[string]bool{"injectIntoAllContainers": true, "enableValidatePatch": true, "enableHonorExistingResources": true})
+			patch := resourcePatch(mutateWith(wh.MutateHandler, podKind, podWith(`{"requests": {"intel.com/sriov": "2"}, "limits": {"intel.com/sriov": "2"}}`)))
+			Expect(patch).To(ConsistOf(
+				resourceOperation(0, "requests", "1"), resourceOperation(0, "limits", "1"),
+				resourceOperation(1, "requests", "3"), resourceOperation(1, "limits", "3"),
+				resourceOperation(2, "requests", "1"), resourceOperation(2, "limits", "1"),
+			))
+		})
+
+		It("should inject resources into the first container when switch is disabled", func() {
+			wh.controlSwitches = newControlSwitches(map[string]bool{"enableValidatePatch": true})
+			patch := resourcePatch(mutateWith(wh.MutateHandler, podKind, podWith(`{}`)))
+			Expect(patch).To(ConsistOf(resourceOperation(0, "requests", "1"), resourceOperation(0, "limits", "1")))
+		})
+	})
+	Describe("User-defined injection failure", func() {
+		pod := json.RawMessage(`{"metadata": {"name": "test", "namespace": "default",
+			"annotations": {"k8s.v1.cni.cncf.io/networks": "sriov-net"}}, "spec": {"containers": [{"name": "app", "resources": {}}]}}`)
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+			/* patch cannot be created without user-defined injections structure */
+			wh.SetUserInjectionStructure(nil)
+		})
+
+		It("should inject resources without user-defined injections by default", func() {
+			response := mutateWith(wh.MutateHandler, podKind, pod)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).To(ContainSubstring("intel.com~1sriov"))
+		})
+
+		It("should deny pod when switch is enabled", func() {
+			wh.controlSwitches = newControlSwitches(map[string]bool{"denyUserDefinedInjectionFailure": true})
+			response := mutateWith(wh.MutateHandler, podKind, pod)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(Equal("could not create user-defined injection patch: user-defined injections are not initialized"))
+		})
+	})
+	Describe("Net-attach-def cache resync", func() {
+		var wh *Webhook
+
+		resync := func(method string, verified bool) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(method, "https://fakewebhook/cache/net-attach-defs/resync", nil)
+			if verified {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+			}
+			wh.CacheResyncHandler(w, req)
+			return w
+		}
+		cache := fakeNetAttachDefCache{annotations: map[string]map[string]string{
+			"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			"default/plain-net": {},
+		}}
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, nil)
+			wh.SetNetAttachDefCache(cache)
+		})
+
+		It("should resync the cache and respond with count of cached net-attach-defs", func() {
+			w := resync("POST", true)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+			var result CacheResyncResult
+			Expect(json.Unmarshal(w.Body.Bytes(), &result)).To(Succeed())
+			Expect(result.NetAttachDefs).To(Equal(2))
+		})
+
+		It("should require verified client certificate", func() {
+			Expect(resync("POST", false).Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("should reject other methods", func() {
+			Expect(resync("GET", true).Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("should report failed resync and missing cache", func() {
+			failing := cache
+			failing.resyncErr = errors.New("net-attach-def informers did not sync")
+			wh.SetNetAttachDefCache(failing)
+			w := resync("POST", true)
+			Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(w.Body.String()).To(ContainSubstring("net-attach-def informers did not sync"))
+
+			wh.SetNetAttachDefCache(nil)
+			Expect(resync("POST", true).Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+	Describe("Best effort injection", func() {
+		var server *httptest.Server
+		injectionErrors := func(values map[string]interface{}) map[string]string {
+			annotations, ok := values["/metadata/annotations"].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			value, ok := annotations["network-resources-injector.io/injection-errors"].(string)
+			Expect(ok).To(BeTrue())
+			var result map[string]string
+			Expect(json.Unmarshal([]byte(value), &result)).To(Succeed())
+			return result
+		}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`))
+			}))
+			wh = newTestWebhook(map[string]bool{"bestEffortInjection": true}, map[string]map[string]string{
+				"default/sriov-net":   {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/invalid-net": {"k8s.v1.cni.cncf.io/nodeSelector": "nic in (e810"},
+			})
+			wh.controlSwitches.SetNadLookupRetriesUnitTests(0, time.Millisecond)
+			var err error
+			wh.clientset, err = kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should inject resources of resolved networks and record the missing ones", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, rawPodWithNetworks("sriov-net,missing-net,infra/other-net")))
+			Expect(values).To(HaveKeyWithValue("/spec/containers/0/resources/requests/intel.com~1sriov", "1"))
+			errs := injectionErrors(values)
+			Expect(errs).To(HaveLen(2))
+			Expect(errs).To(HaveKeyWithValue("default/missing-net", ContainSubstring("could not find network attachment definition 'default/missing-net'")))
+			Expect(errs).To(HaveKey("infra/other-net"))
+		})
+
+		It("should record the missing networks when no network resolves", func() {
+			values := patchValues(mutateWith(wh.MutateHandler, podKind, rawPodWithNetworks("missing-net")))
+			Expect(values).NotTo(HaveKey("/spec/containers/0/resources/requests/intel.com~1sriov"))
+			Expect(injectionErrors(values)).To(HaveKey("default/missing-net"))
+		})
+
+		It("should deny pod selecting net-attach-def with invalid annotation", func() {
+			response := mutateWith(wh.MutateHandler, podKind, rawPodWithNetworks("sriov-net,invalid-net"))
+			Expect(response.Allowed).To(BeFalse())
+		})
+
+		It("should deny pod selecting missing net-attach-def when switch is disabled", func() {
+			wh.controlSwitches = newControlSwitches(nil)
+			wh.controlSwitches.SetNadLookupRetriesUnitTests(0, time.Millisecond)
+			response := mutateWith(wh.MutateHandler, podKind, rawPodWithNetworks("sriov-net,missing-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("could not find network attachment definition 'default/missing-net'"))
+		})
+	})
+	Describe("Resource map", func() {
+		requests := func(response *admissionv1.AdmissionResponse) map[string]interface{} {
+			Expect(response.Allowed).To(BeTrue())
+			var patch []nritypes.JsonPatchOperation
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			values := make(map[string]interface{})
+			for _, operation := range patch {
+				if name := strings.TrimPrefix(operation.Path, "/spec/containers/0/resources/requests/"); name != operation.Path {
+					values[name] = operation.Value
+				}
+			}
+			return values
+		}
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(map[string]bool{"enableValidatePatch": true}, map[string]map[string]string{
+				"default/map-net": {"k8s.v1.cni.cncf.io/resourceMap": `{"intel.com/sriov": 2, "intel.com/mgmt": 1}`},
+				"default/both-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov,intel.com/aux",
+					"k8s.v1.cni.cncf.io/resourceMap": `{"intel.com/sriov": 3}`},
+				"default/invalid-net": {"k8s.v1.cni.cncf.io/resourceMap": `{"intel.com/sriov": 0}`},
+			})
+		})
+
+		It("should inject counts of the resource map", func() {
+			Expect(requests(mutateWith(wh.MutateHandler, podKind, rawPodWithNetworks("map-net")))).To(Equal(map[string]interface{}{
+				"intel.com~1sriov": "2", "intel.com~1mgmt": "1"}))
+		})
+
+		It("should request counts of the resource map for every selection of the network", func() {
+			Expect(requests(mutateWith(wh.MutateHandler, podKind, rawPodWithNetworks("map-net,map-net")))).To(Equal(map[string]interface{}{
+				"intel.com~1sriov": "4", "intel.com~1mgmt": "2"}))
+		})
+
+		It("should take count of the resource map over the resource name annotation", func() {
+			Expect(requests(mutateWith(wh.MutateHandler, podKind, rawPodWithNetworks("both-net")))).To(Equal(map[string]interface{}{
+				"intel.com~1sriov": "3", "intel.com~1aux": "1"}))
+		})
+
+		It("should deny pod selecting net-attach-def with invalid resource map", func() {
+			response := mutateWith(wh.MutateHandler, podKind, rawPodWithNetworks("invalid-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("invalid annotation 'k8s.v1.cni.cncf.io/resourceMap' of net-attach-def 'default/invalid-net'"))
+		})
+
+		DescribeTable("parsing resource map",
+			func(value string, expected map[string]int64, valid bool) {
+				resourceMap, err := parseResourceMap(value)
+				if !valid {
+					Expect(err).To(HaveOccurred())
+					return
+				}
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resourceMap).To(Equal(expected))
+			},
+			Entry("resource counts", `{"intel.com/sriov": 2, "intel.com/mgmt": 1}`, map[string]int64{"intel.com/sriov": 2, "intel.com/mgmt": 1}, true),
+			Entry("empty map", `{}`, map[string]int64{}, true),
+			Entry("malformed JSON", `{"intel.com/sriov": 2`, nil, false),
+			Entry("not an object", `["intel.com/sriov"]`, nil, false),
+			Entry("fractional count", `{"intel.com/sriov": 1.5}`, nil, false),
+			Entry("string count", `{"intel.com/sriov": "2"}`, nil, false),
+			Entry("zero count", `{"intel.com/sriov": 0}`, nil, false),
+			Entry("negative count", `{"intel.com/sriov": -1}`, nil, false),
+			Entry("invalid resource name", `{"intel.com/sriov vf": 1}`, nil, false),
+		)
+	})
+
+	Describe("Lookup latency", func() {
+		var (
+			out     *bytes.Buffer
+			ctx     context.Context
+			latency *lookupLatency
+		)
+
+		BeforeEach(func() {
+			out = &bytes.Buffer{}
+			ctx, latency = withLookupLatency(context.Background())
+		})
+
+		It("should warn with the slowest lookup when lookups approach the webhook timeout", func() {
+			recordLookupLatency(ctx, "default/fast-net", time.Second)
+			recordLookupLatency(ctx, "default/slow-net", 5*time.Second)
+			latency.warnIfSlow(logging.NewJSONLogger(out).WithFields(logging.Fields{"pod": "test-pod"}), 10*time.Second, 0.5)
+			entry := map[string]interface{}{}
+			Expect(json.Unmarshal(out.Bytes(), &entry)).To(Succeed())
+			Expect(entry).To(HaveKeyWithValue("level", "warning"))
+			Expect(entry).To(HaveKeyWithValue("pod", "test-pod"))
+			Expect(entry).To(HaveKeyWithValue("slowest_nad", "default/slow-net"))
+			Expect(entry).To(HaveKeyWithValue("slowest_ms", float64(5000)))
+			Expect(entry).To(HaveKeyWithValue("lookup_ms", float64(6000)))
+			Expect(entry).To(HaveKeyWithValue("budget_ms", float64(10000)))
+		})
+
+		It("should not warn about lookups within the fraction of the webhook timeout", func() {
+			recordLookupLatency(ctx, "default/sriov-net", 4*time.Second)
+			latency.warnIfSlow(logging.NewJSONLogger(out), 10*time.Second, 0.5)
+			Expect(out.Len()).To(BeZero())
+		})
+
+		It("should not warn when disabled", func() {
+			recordLookupLatency(ctx, "default/sriov-net", 10*time.Second)
+			latency.warnIfSlow(logging.NewJSONLogger(out), 10*time.Second, 0)
+			Expect(out.Len()).To(BeZero())
+		})
+
+		It("should ignore lookups made without tracked context", func() {
+			recordLookupLatency(context.Background(), "default/sriov-net", 10*time.Second)
+			latency.warnIfSlow(logging.NewJSONLogger(out), 10*time.Second, 0.5)
+			Expect(out.Len()).To(BeZero())
+		})
+
+		DescribeTable("should use webhook timeout sent by API server as the budget",
+			func(url string, expected time.Duration) {
+				Expect(webhookTimeout(httptest.NewRequest("POST", url, nil))).To(Equal(expected))
+			},
+			Entry("default webhook timeout", "https://fakewebhook/mutate", 10*time.Second),
+			Entry("timeout sent by API server", "https://fakewebhook/mutate?timeout=5s", 5*time.Second),
+			Entry("invalid timeout", "https://fakewebhook/mutate?timeout=five", 10*time.Second),
+		)
+	})
+
+	Describe("Windows pods", func() {
+		windowsNodes := corev1.NodeSelectorRequirement{Key: corev1.LabelOSStable, Operator: corev1.NodeSelectorOpIn,
+			Values: []string{"windows"}}
+		linuxNodes := corev1.NodeSelectorRequirement{Key: corev1.LabelOSStable, Operator: corev1.NodeSelectorOpIn,
+			Values: []string{"linux"}}
+		affinity := func(terms ...corev1.NodeSelectorTerm) *corev1.Affinity {
+			return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms}}}
+		}
+		windowsPod := func() corev1.Pod {
+			pod := podWithNetworks("sriov-net")
+			pod.Spec.OS = &corev1.PodOS{Name: corev1.Windows}
+			pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{"hugepages-2Mi": resource.MustParse("512Mi")}
+			return pod
+		}
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(map[string]bool{"enableHugePageDownApi": true, "injectHugepageVolume": true}, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		DescribeTable("should detect pods scheduled to Windows nodes",
+			func(spec corev1.PodSpec, expected bool) {
+				Expect(isWindowsPod(&corev1.Pod{Spec: spec})).To(Equal(expected))
+			},
+			Entry("pod without OS", corev1.PodSpec{}, false),
+			Entry("Windows OS", corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Windows}}, true),
+			Entry("Linux OS", corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Linux},
+				NodeSelector: map[string]string{corev1.LabelOSStable: "windows"}}, false),
+			Entry("Windows node selector", corev1.PodSpec{NodeSelector: map[string]string{corev1.LabelOSStable: "windows"}}, true),
+			Entry("Linux node selector", corev1.PodSpec{NodeSelector: map[string]string{corev1.LabelOSStable: "linux"}}, false),
+			Entry("Windows node affinity", corev1.PodSpec{Affinity: affinity(corev1.NodeSelectorTerm{
+				MatchExpressions: []corev1.NodeSelectorRequirement{windowsNodes}})}, true),
+			Entry("node affinity allowing Linux nodes", corev1.PodSpec{Affinity: affinity(
+				corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{windowsNodes}},
+				corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{linuxNodes}})}, false),
+			Entry("node affinity allowing any OS", corev1.PodSpec{Affinity: affinity(corev1.NodeSelectorTerm{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{Key: corev1.LabelOSStable, Operator: corev1.NodeSelectorOpIn,
+					Values: []string{"linux", "windows"}}}})}, false),
+		)
+
+		It("should mount Downward API volume at Windows path and skip hugepages", func() {
+			pod := patchedPodBy(wh, windowsPod())
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(HaveKey(corev1.ResourceName("intel.com/sriov")))
+			Expect(pod.Spec.Containers[0].VolumeMounts).To(Equal([]corev1.VolumeMount{
+				{Name: "podnetinfo", ReadOnly: true, MountPath: nritypes.WindowsDownwardAPIPath}}))
+			Expect(pod.Spec.Containers[0].Env).To(BeEmpty())
+			Expect(pod.Spec.Volumes).To(HaveLen(1))
+			for _, item := range pod.Spec.Volumes[0].DownwardAPI.Items {
+				Expect(item.ResourceFieldRef).To(BeNil())
+			}
+		})
+
+		It("should mount Downward API volume at the configured Windows path", func() {
+			wh.controlSwitches = newControlSwitches(nil)
+			wh.controlSwitches.SetWindowsDownwardAPIMountPathUnitTests(`D:\podnetinfo`)
+			pod := patchedPodBy(wh, windowsPod())
+			Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(HaveField("MountPath", `D:\podnetinfo`)))
+		})
+
+		It("should leave Linux pods unchanged", func() {
+			pod := windowsPod()
+			pod.Spec.OS = nil
+			pod = patchedPodBy(wh, pod)
+			Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElements(
+				corev1.VolumeMount{Name: "podnetinfo", ReadOnly: true, MountPath: nritypes.DownwardAPIMountPath},
+				HaveField("Name", HavePrefix("hugepages"))))
+			Expect(pod.Spec.Containers[0].Env).To(ContainElement(HaveField("Name", nritypes.EnvNameContainerName)))
+		})
+	})
+
+	Describe("Create resources if absent", func() {
+		sriov := *resource.NewQuantity(2, resource.DecimalSI)
+
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(map[string]bool{"createResourcesIfAbsent": true, "enableValidatePatch": true}, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
+		})
+
+		It("should precede every added resource field with test operation", func() {
+			patch, err := wh.createIfAbsentResourcePatch(nil, []corev1.Container{{Name: "app"}},
+				map[string]int64{"intel.com/sriov": 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch).To(Equal([]nritypes.JsonPatchOperation{
+				{Operation: "test", Path: "/spec/containers/0/resources/requests", Value: absentValue},
+				{Operation: "add", Path: "/spec/containers/0/resources/requests", Value: corev1.ResourceList{}},
+				{Operation: "test", Path: "/spec/containers/0/resources/limits", Value: absentValue},
+				{Operation: "add", Path: "/spec/containers/0/resources/limits", Value: corev1.ResourceList{}},
+				{Operation: "test", Path: "/spec/containers/0/resources/requests/intel.com~1sriov", Value: absentValue},
+				{Operation: "add", Path: "/spec/containers/0/resources/requests/intel.com~1sriov", Value: sriov},
+				{Operation: "test", Path: "/spec/containers/0/resources/limits/intel.com~1sriov", Value: absentValue},
+				{Operation: "add", Path: "/spec/containers/0/resources/limits/intel.com~1sriov", Value: sriov},
+			}))
+		})
+
+		It("should encode null value of test operation", func() {
+			encoded, err := json.Marshal(nritypes.JsonPatchOperation{Operation: "test", Path: "/spec/containers/0/resources/requests", Value: absentValue})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(encoded)).To(Equal(`{"op":"test","path":"/spec/containers/0/resources/requests","value":null}`))
+		})
+
+		It("should not guard operations created before", func() {
+			existing := []nritypes.JsonPatchOperation{{Operation: "add", Path: "/spec/containers/1/resources/requests/intel.com~1other", Value: sriov}}
+			patch, err := wh.createIfAbsentResourcePatch(existing, []corev1.Container{{Name: "app",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{"cpu": sriov}, Limits: corev1.ResourceList{"cpu": sriov}}}},
+				map[string]int64{"intel.com/sriov": 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch[0]).To(Equal(existing[0]))
+			Expect(patch[1:]).To(HaveLen(4))
+		})
+
+		It("should not inject resource defined by any container", func() {
+			patch, err := wh.createIfAbsentResourcePatch(nil, []corev1.Container{
+				{Name: "app", Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{"cpu": sriov}, Limits: corev1.ResourceList{"cpu": sriov}}},
+				{Name: "sidecar", Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{"intel.com/sriov": sriov}, Limits: corev1.ResourceList{"intel.com/sriov": sriov}}},
+			}, map[string]int64{"intel.com/sriov": 2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch).To(BeEmpty())
+		})
+
+		It("should create patch which applies only while the resource is absent", func() {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default",
+					Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{"cpu": sriov}, Limits: corev1.ResourceList{"cpu": sriov}}}}},
+			}
+			response := mutateWith(wh.MutateHandler, podKind, pod)
+			Expect(response.Allowed).To(BeTrue())
+			decoded, err := jsonpatch.DecodePatch(response.Patch)
+			Expect(err).NotTo(HaveOccurred())
+
+			original, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			patched, err := decoded.Apply(original)
+			Expect(err).NotTo(HaveOccurred())
+			var patchedPod corev1.Pod
+			Expect(json.Unmarshal(patched, &patchedPod)).To(Succeed())
+			Expect(patchedPod.Spec.Containers[0].Resources.Limits).To(HaveKey(corev1.ResourceName("intel.com/sriov")))
+
+			/* resource set by another mutation after the webhook was called */
+			pod.Spec.Containers[0].Resources.Limits["intel.com/sriov"] = *resource.NewQuantity(1, resource.DecimalSI)
+			mutated, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = decoded.Apply(mutated)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Resource quantity format", func() {
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, nil)
+		})
+
+		DescribeTable("should render quantity in the configured format",
+			func(format string, count int64, expected string) {
+				Expect(wh.controlSwitches.SetResourceQuantityFormatUnitTests(format)).To(Succeed())
+				rendered, err := json.Marshal(wh.newQuantity("intel.com/sriov", count))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(rendered)).To(Equal(`"` + expected + `"`))
+			},
+			Entry("DecimalSI", "DecimalSI", int64(1000), "1k"),
+			Entry("DecimalSI of power of two", "DecimalSI", int64(1024), "1024"),
+			Entry("BinarySI of power of ten", "BinarySI", int64(1000), "1k"),
+			Entry("BinarySI of power of two", "BinarySI", int64(1024), "1Ki"),
+			Entry("DecimalExponent", "DecimalExponent", int64(1000), "1e3"),
+			Entry("DecimalExponent of power of two", "DecimalExponent", int64(1024), "1024"),
+			Entry("small count in any format", "BinarySI", int64(2), "2"),
+			Entry("format of the resource", "DecimalSI,intel.com/sriov=BinarySI", int64(1024), "1Ki"),
+			Entry("format of other resource", "BinarySI,intel.com/mgmt=DecimalSI", int64(1024), "1Ki"),
+		)
+
+		It("should inject resources in the configured format", func() {
+			wh.SetNetAttachDefCache(fakeNetAttachDefCache{annotations: map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			}})
+			Expect(wh.controlSwitches.SetResourceQuantityFormatUnitTests("DecimalExponent")).To(Succeed())
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default",
+					Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": strings.TrimSuffix(strings.Repeat("sriov-net,", 1000), ",")}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+			response := mutateWith(wh.MutateHandler, podKind, pod)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).To(ContainSubstring(`"path":"/spec/containers/0/resources/limits/intel.com~1sriov","value":"1e3"`))
+		})
+	})
+	Describe("API client options", func() {
+		DescribeTable("should validate client options",
+			func(options ClientOptions, valid bool) {
+				if valid {
+					Expect(options.Validate()).To(Succeed())
+				} else {
+					Expect(options.Validate()).To(HaveOccurred())
+				}
+			},
+			Entry("defaults", ClientOptions{QPS: DefaultClientQPS, Burst: DefaultClientBurst}, true),
+			Entry("burst equal to QPS", ClientOptions{QPS: 20, Burst: 20}, true),
+			Entry("zero QPS", ClientOptions{QPS: 0, Burst: 10}, false),
+			Entry("negative QPS", ClientOptions{QPS: -1, Burst: 10}, false),
+			Entry("zero burst", ClientOptions{QPS: 0.5, Burst: 0}, false),
+			Entry("burst lower than QPS", ClientOptions{QPS: 50, Burst: 10}, false),
+		)
+
+		It("should set QPS and burst of the rest config", func() {
+			config := &rest.Config{}
+			ClientOptions{QPS: 50, Burst: 100}.apply(config)
+			Expect(config.QPS).To(Equal(float32(50)))
+			Expect(config.Burst).To(Equal(100))
+			Expect(config.ContentType).To(BeEmpty())
+			Expect(config.AcceptContentTypes).To(BeEmpty())
+		})
+
+		It("should request protobuf and accept JSON when protobuf is enabled", func() {
+			config := &rest.Config{}
+			ClientOptions{QPS: 50, Burst: 100, Protobuf: true}.apply(config)
+			Expect(config.ContentType).To(Equal("application/vnd.kubernetes.protobuf"))
+			Expect(config.AcceptContentTypes).To(Equal("application/vnd.kubernetes.protobuf,application/json"))
+		})
+	})
+	Describe("Network aliases", func() {
+		var server *httptest.Server
+		var wh *Webhook
+
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`))
+			}))
+			wh = newTestWebhook(map[string]bool{"resolveNetworkAliases": true}, nil)
+			wh.controlSwitches.SetNadLookupRetriesUnitTests(0, time.Millisecond)
+			var err error
+			wh.clientset, err = kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+			wh.SetNetAttachDefCache(fakeNetAttachDefCache{
+				annotations: map[string]map[string]string{
+					"default/sriov-net-v2": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+					"default/dpdk-net-a":   {"k8s.v1.cni.cncf.io/resourceName": "intel.com/dpdk"},
+					"default/dpdk-net-b":   {"k8s.v1.cni.cncf.io/resourceName": "intel.com/dpdk"},
+				},
+				references: map[string][]string{
+					"default/sriov-net":                            {"sriov-net-v2"},
+					"default/0b4c1f9e-6f2a-4f61-9d3c-1a2b3c4d5e6f": {"sriov-net-v2"},
+					"default/dpdk-net":                             {"dpdk-net-a", "dpdk-net-b"},
+				},
+			})
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		DescribeTable("should inject resources of net-attach-def selected by reference",
+			func(networks string) {
+				response := mutateWith(wh.MutateHandler, podKind, podWithNetworks(networks))
+				Expect(response.Allowed).To(BeTrue())
+				Expect(string(response.Patch)).To(ContainSubstring(`"path":"/spec/containers/0/resources/requests/intel.com~1sriov","value":"1"`))
+			},
+			Entry("by name", "sriov-net-v2"),
+			Entry("by alias", "sriov-net"),
+			Entry("by UID", "0b4c1f9e-6f2a-4f61-9d3c-1a2b3c4d5e6f"),
+		)
+
+		It("should deny pod selecting alias shared by more net-attach-defs", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks("dpdk-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("network 'default/dpdk-net' is ambiguous, it is alias of net-attach-defs dpdk-net-a, dpdk-net-b"))
+		})
+
+		It("should look up network by name only when switch is disabled", func() {
+			wh.controlSwitches = newControlSwitches(nil)
+			wh.controlSwitches.SetNadLookupRetriesUnitTests(0, time.Millisecond)
+			response := mutateWith(wh.MutateHandler, podKind, podWithNetworks("sriov-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("'default/sriov-net'"))
+		})
+	})
+	Describe("Service account token volume", func() {
+		podWith := func(networks string, volumes ...corev1.Volume) corev1.Pod {
+			pod := podWithNetworks(networks)
+			pod.Spec.Volumes = volumes
+			return pod
+		}
+		var wh *Webhook
+
+		BeforeEach(func() {
+			wh = newTestWebhook(nil, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov",
+					"k8s.v1.cni.cncf.io/serviceAccountTokenAudience": "sriov-controller"},
+				"default/other-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/other",
+					"k8s.v1.cni.cncf.io/serviceAccountTokenAudience": "https://ctrl.example.com"},
+				"default/plain-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/plain"},
+				"default/empty-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/plain",
+					"k8s.v1.cni.cncf.io/serviceAccountTokenAudience": " "},
+			})
+		})
+
+		It("should inject token of every audience requested by the networks", func() {
+			pod := podWith("sriov-net,other-net,plain-net")
+			patched := applyPatch(pod, mutateWith(wh.MutateHandler, podKind, pod))
+			Expect(patched.Spec.Volumes).To(HaveLen(2))
+			volume := patched.Spec.Volumes[1]
+			Expect(volume.Name).To(Equal("cni-token"))
+			Expect(volume.Projected).NotTo(BeNil())
+			expiration := int64(3600)
+			Expect(volume.Projected.Sources).To(Equal([]corev1.VolumeProjection{
+				{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Audience: "https://ctrl.example.com",
+					ExpirationSeconds: &expiration, Path: "https___ctrl.example.com"}},
+				{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Audience: "sriov-controller",
+					ExpirationSeconds: &expiration, Path: "sriov-controller"}},
+			}))
+			Expect(patched.Spec.Containers[0].VolumeMounts).To(ConsistOf(
+				corev1.VolumeMount{Name: "podnetinfo", ReadOnly: true, MountPath: "/etc/podnetinfo"},
+				corev1.VolumeMount{Name: "cni-token", ReadOnly: true, MountPath: "/var/run/secrets/cni"},
+			))
+		})
+
+		It("should mount token volume at the configured path", func() {
+			wh.controlSwitches.SetTokenMountPathUnitTests("/var/run/secrets/sriov")
+			pod := podWith("sriov-net")
+			patched := applyPatch(pod, mutateWith(wh.MutateHandler, podKind, pod))
+			Expect(patched.Spec.Containers[0].VolumeMounts).To(ContainElement(
+				corev1.VolumeMount{Name: "cni-token", ReadOnly: true, MountPath: "/var/run/secrets/sriov"}))
+		})
+
+		It("should not inject token volume when no network requests it", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith("plain-net"))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).NotTo(ContainSubstring("cni-token"))
+		})
+
+		It("should keep projected volume already defined by the pod", func() {
+			existing := corev1.Volume{Name: "cni-token", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{}}}
+			pod := podWith("sriov-net", existing)
+			patched := applyPatch(pod, mutateWith(wh.MutateHandler, podKind, pod))
+			Expect(patched.Spec.Volumes).To(ContainElement(existing))
+			Expect(patched.Spec.Containers[0].VolumeMounts).To(ContainElement(
+				corev1.VolumeMount{Name: "cni-token", ReadOnly: true, MountPath: "/var/run/secrets/cni"}))
+		})
+
+		It("should deny pod defining other volume with the token volume name", func() {
+			existing := corev1.Volume{Name: "cni-token", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+			response := mutateWith(wh.MutateHandler, podKind, podWith("sriov-net", existing))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("pod defines volume 'cni-token' which is not a projected volume"))
+		})
+
+		It("should deny pod selecting net-attach-def with empty token audience", func() {
+			response := mutateWith(wh.MutateHandler, podKind, podWith("empty-net"))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("annotation 'k8s.v1.cni.cncf.io/serviceAccountTokenAudience' of net-attach-def 'default/empty-net' is empty"))
+		})
+	})
+	Describe("Admission concurrency limiting", func() {
+		pod := podWithNetworks("sriov-net")
+
+		newWebhook := func(limit int, timeout time.Duration) *Webhook {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			switches.SetAdmissionConcurrencyUnitTests(limit, timeout)
+			wh := NewWebhook(nil, switches)
+			wh.SetNetAttachDefCache(fakeNetAttachDefCache{annotations: map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			}})
+			return wh
+		}
+
+		It("should reject requests above the limit at once without queue timeout", func() {
+			limiter := admissionLimiter{}
+			Expect(limiter.acquire(context.Background(), 2, 0)).To(BeTrue())
+			Expect(limiter.acquire(context.Background(), 2, 0)).To(BeTrue())
+			Expect(limiter.acquire(context.Background(), 2, 0)).To(BeFalse())
+			inFlight, rejected := limiter.stats()
+			Expect(inFlight).To(Equal(2))
+			Expect(rejected).To(BeEquivalentTo(1))
+
+			limiter.release()
+			Expect(limiter.acquire(context.Background(), 2, 0)).To(BeTrue())
+		})
+
+		It("should count requests in flight when limit is 0", func() {
+			limiter := admissionLimiter{}
+			for i := 0; i < 100; i++ {
+				Expect(limiter.acquire(context.Background(), 0, 0)).To(BeTrue())
+			}
+			inFlight, rejected := limiter.stats()
+			Expect(inFlight).To(Equal(100))
+			Expect(rejected).To(BeZero())
+		})
+
+		It("should admit queued request when running one completes", func() {
+			limiter := admissionLimiter{}
+			Expect(limiter.acquire(context.Background(), 1, 0)).To(BeTrue())
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				limiter.release()
+			}()
+			Expect(limiter.acquire(context.Background(), 1, 5*time.Second)).To(BeTrue())
+			inFlight, rejected := limiter.stats()
+			Expect(inFlight).To(Equal(1))
+			Expect(rejected).To(BeZero())
+		})
+
+		It("should reject queued request when queue timeout expires or request is cancelled", func() {
+			limiter := admissionLimiter{}
+			Expect(limiter.acquire(context.Background(), 1, 0)).To(BeTrue())
+			Expect(limiter.acquire(context.Background(), 1, 20*time.Millisecond)).To(BeFalse())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			Expect(limiter.acquire(ctx, 1, 5*time.Second)).To(BeFalse())
+			_, rejected := limiter.stats()
+			Expect(rejected).To(BeEquivalentTo(2))
+		})
+
+		It("should deny pod as retryable when the limit is reached", func() {
+			wh := newWebhook(1, 0)
+			Expect(wh.admissions.acquire(context.Background(), 1, 0)).To(BeTrue())
+
+			response := mutateWith(wh.MutateHandler, podKind, pod)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Code).To(BeEquivalentTo(http.StatusTooManyRequests))
+			Expect(response.Result.Reason).To(Equal(metav1.StatusReasonTooManyRequests))
+			Expect(response.Result.Details.RetryAfterSeconds).To(BeEquivalentTo(1))
+			Expect(response.Patch).To(BeNil())
+
+			wh.admissions.release()
+			response = mutateWith(wh.MutateHandler, podKind, pod)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(string(response.Patch)).To(ContainSubstring("/resources/requests/intel.com~1sriov"))
+			inFlight, _ := wh.admissions.stats()
+			Expect(inFlight).To(BeZero())
+		})
+
+		It("should report requests in flight and rejected requests as metrics", func() {
+			wh := newWebhook(1, 0)
+			Expect(wh.admissions.acquire(context.Background(), 1, 0)).To(BeTrue())
+			mutateWith(wh.MutateHandler, podKind, pod)
+
+			w := httptest.NewRecorder()
+			wh.MetricsHandler(w, httptest.NewRequest("GET", "https://fakewebhook/metrics", nil))
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(ContainSubstring("# TYPE nri_admissions_in_flight gauge\nnri_admissions_in_flight 1\n"))
+			Expect(w.Body.String()).To(ContainSubstring("# TYPE nri_admissions_rejected_total counter\nnri_admissions_rejected_total 1\n"))
+
+			w = httptest.NewRecorder()
+			wh.MetricsHandler(w, httptest.NewRequest("POST", "https://fakewebhook/metrics", nil))
+			Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
 })
@@ -0,0 +1,67 @@
+// Copyright (c) 2026 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// isWindowsPod returns true when pod is scheduled to Windows nodes. OS set in the pod spec takes precedence over node
+// selector and required node affinity, affinity selects Windows only when every of its terms requires Windows nodes.
+func isWindowsPod(pod *corev1.Pod) bool {
+	if pod.Spec.OS != nil {
+		return pod.Spec.OS.Name == corev1.Windows
+	}
+	if os, exists := pod.Spec.NodeSelector[corev1.LabelOSStable]; exists {
+		return os == string(corev1.Windows)
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil ||
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for _, term := range terms {
+		if !requiresWindowsNodes(term) {
+			return false
+		}
+	}
+	return len(terms) > 0
+}
+
+// requiresWindowsNodes returns true when the node selector term matches only Windows nodes
+func requiresWindowsNodes(term corev1.NodeSelectorTerm) bool {
+	for _, requirement := range term.MatchExpressions {
+		if requirement.Key != corev1.LabelOSStable || requirement.Operator != corev1.NodeSelectorOpIn || len(requirement.Values) == 0 {
+			continue
+		}
+		windowsOnly := true
+		for _, value := range requirement.Values {
+			windowsOnly = windowsOnly && value == string(corev1.Windows)
+		}
+		if windowsOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// downwardAPIMountPath returns path at which the Downward API volume is mounted into containers of the pod, Windows
+// containers do not accept Linux paths
+func (wh *Webhook) downwardAPIMountPath(pod *corev1.Pod) string {
+	if isWindowsPod(pod) {
+		return wh.controlSwitches.GetWindowsDownwardAPIMountPath()
+	}
+	return wh.controlSwitches.GetDownwardAPIMountPath()
+}
@@ -29,5 +29,11 @@ export GOBIN=${PWD}/bin
 export CGO_ENABLED=1
 export GO15VENDOREXPERIMENT=1
 
-go install -ldflags "-s -w" "$@" ${REPO_PATH}/cmd/installer
-go install -ldflags "-s -w" "$@" ${REPO_PATH}/cmd/webhook
+# build information can be overridden when git metadata is not available, e.g. in container builds
+VERSION=${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}
+GIT_COMMIT=${GIT_COMMIT:-$(git rev-parse HEAD 2>/dev/null || echo unknown)}
+BUILD_DATE=${BUILD_DATE:-$(date -u +%Y-%m-%dT%H:%M:%SZ)}
+LDFLAGS="-s -w -X ${REPO_PATH}/pkg/version.Version=${VERSION} -X ${REPO_PATH}/pkg/version.GitCommit=${GIT_COMMIT} -X ${REPO_PATH}/pkg/version.BuildDate=${BUILD_DATE}"
+
+go install -ldflags "${LDFLAGS}" "$@" ${REPO_PATH}/cmd/installer
+go install -ldflags "${LDFLAGS}" "$@" ${REPO_PATH}/cmd/webhook